@@ -3,12 +3,17 @@ package app
 
 import (
 	"fmt"
-	"path"
+	"os"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/climech/grit/db"
 	"github.com/climech/grit/multitree"
+	"github.com/climech/grit/store"
+	"github.com/climech/grit/textstore"
 
 	"github.com/kirsle/configdir"
 	sqlite "github.com/mattn/go-sqlite3"
@@ -23,26 +28,187 @@ var (
 )
 
 type App struct {
-	Database *db.Database
+	Store store.Store
 }
 
-func New() (*App, error) {
-	configPath := configdir.LocalConfig(AppName)
+// Options control which database the App is hooked up to. The zero value
+// selects the active workspace in the default config directory.
+type Options struct {
+	// DatabasePath is the path of the database file. It takes precedence over
+	// Workspace.
+	DatabasePath string
+
+	// Workspace is the name of the workspace to use instead of the active one.
+	Workspace string
+
+	// ConfigPath is the directory holding the workspace registry and the
+	// default database. Defaults to DefaultConfigPath().
+	ConfigPath string
+
+	// TrashRetention, if non-zero, is the age after which trash entries are
+	// purged automatically.
+	TrashRetention time.Duration
+
+	// SyncRecurrences, if true, creates the due occurrences of the recurrence
+	// rules when the App is created. See SyncRecurrences.
+	SyncRecurrences bool
+
+	// BusyTimeout is the time to wait for another process to release the
+	// database. Defaults to db.DefaultBusyTimeout.
+	BusyTimeout time.Duration
+
+	// Backend is the storage backend, BackendSQLite or BackendText. If empty,
+	// it's detected from the database path.
+	Backend string
+}
+
+// Storage backends.
+const (
+	BackendSQLite = "sqlite"
+	BackendText   = "text"
+)
+
+// OpenStore opens the store at path using the backend. If the backend is
+// empty, directories are opened as text stores, and files as SQLite
+// databases.
+func OpenStore(backend, path string, opts Options) (store.Store, error) {
+	if backend == "" {
+		backend = BackendSQLite
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			backend = BackendText
+		}
+	}
+	switch backend {
+	case BackendSQLite:
+		return db.NewWithOptions(path, db.Options{BusyTimeout: opts.BusyTimeout})
+	case BackendText:
+		return textstore.Open(path)
+	default:
+		return nil, fmt.Errorf("unknown backend: %s", backend)
+	}
+}
+
+// DefaultConfigPath returns grit's directory in the user's local config
+// directory.
+func DefaultConfigPath() string {
+	return configdir.LocalConfig(AppName)
+}
+
+// ResolveDatabasePath resolves the options to a database path.
+func (o Options) ResolveDatabasePath() (string, error) {
+	if o.DatabasePath != "" {
+		return o.DatabasePath, nil
+	}
+	configPath := o.ConfigPath
+	if configPath == "" {
+		configPath = DefaultConfigPath()
+	}
 	if err := configdir.MakePath(configPath); err != nil {
-		return nil, err
+		return "", err
+	}
+	ws, err := LoadWorkspaces(configPath)
+	if err != nil {
+		return "", err
 	}
+	name := o.Workspace
+	if name == "" {
+		name = ws.ActiveName()
+	}
+	return ws.Path(name)
+}
 
-	dbPath := path.Join(configPath, "graph.db")
-	d, err := db.New(dbPath)
+func New(opts Options) (*App, error) {
+	dbPath, err := opts.ResolveDatabasePath()
+	if err != nil {
+		return nil, err
+	}
+	s, err := OpenStore(opts.Backend, dbPath, opts)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't initialize db: %v", err)
 	}
+	if t, ok := s.(store.Trash); ok && opts.TrashRetention > 0 {
+		if _, err := t.EmptyTrash(opts.TrashRetention); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("couldn't purge trash: %v", err)
+		}
+	}
+	a := &App{Store: s}
+	if _, ok := s.(store.Recurrer); ok && opts.SyncRecurrences {
+		if _, err := a.SyncRecurrences(); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("couldn't create recurring tasks: %v", err)
+		}
+	}
+	return a, nil
+}
 
-	return &App{Database: d}, nil
+// NewWithStore returns an App using the given store, e.g. an in-memory one.
+func NewWithStore(s store.Store) *App {
+	return &App{Store: s}
 }
 
 func (a *App) Close() {
-	a.Database.Close()
+	a.Store.Close()
+}
+
+func errNotSupported(feature string) error {
+	return NewError(ErrNotSupported,
+		fmt.Sprintf("%s is not supported by this store", feature))
+}
+
+func (a *App) journal() (store.Journal, error) {
+	if j, ok := a.Store.(store.Journal); ok {
+		return j, nil
+	}
+	return nil, errNotSupported("undo")
+}
+
+func (a *App) history() (store.History, error) {
+	if h, ok := a.Store.(store.History); ok {
+		return h, nil
+	}
+	return nil, errNotSupported("history")
+}
+
+// HasTrash returns true if the store keeps removed nodes in the trash.
+func (a *App) HasTrash() bool {
+	_, ok := a.Store.(store.Trash)
+	return ok
+}
+
+func (a *App) trash() (store.Trash, error) {
+	if t, ok := a.Store.(store.Trash); ok {
+		return t, nil
+	}
+	return nil, errNotSupported("trash")
+}
+
+func (a *App) checker() (store.Checker, error) {
+	if c, ok := a.Store.(store.Checker); ok {
+		return c, nil
+	}
+	return nil, errNotSupported("fsck")
+}
+
+func (a *App) searcher() (store.Searcher, error) {
+	if s, ok := a.Store.(store.Searcher); ok {
+		return s, nil
+	}
+	return nil, errNotSupported("search")
+}
+
+func (a *App) merger() (store.Merger, error) {
+	if m, ok := a.Store.(store.Merger); ok {
+		return m, nil
+	}
+	return nil, errNotSupported("merge")
+}
+
+func (a *App) backuper() (store.Backuper, error) {
+	if b, ok := a.Store.(store.Backuper); ok {
+		return b, nil
+	}
+	return nil, errNotSupported("backup")
 }
 
 // AddNode creates a root and returns it as a member of its multitree.
@@ -54,11 +220,11 @@ func (a *App) AddRoot(name string) (*multitree.Node, error) {
 		return nil, NewError(ErrInvalidName,
 			fmt.Sprintf("%v is a reserved name", name))
 	}
-	nodeID, err := a.Database.CreateNode(name, 0)
+	nodeID, err := a.Store.CreateNode(name, 0)
 	if err != nil {
 		return nil, err
 	}
-	return a.Database.GetGraph(nodeID)
+	return a.Store.GetGraph(nodeID)
 }
 
 // AddChild creates a new node and links an existing node to it. A
@@ -79,9 +245,9 @@ func (a *App) AddChild(name string, parent interface{}) (*multitree.Node, error)
 	var nodeID int64
 	var nodeErr error
 	if parentID == 0 {
-		nodeID, nodeErr = a.Database.CreateChildOfDateNode(parent.(string), name)
+		nodeID, nodeErr = a.Store.CreateChildOfDateNode(parent.(string), name)
 	} else {
-		nodeID, nodeErr = a.Database.CreateNode(name, parentID)
+		nodeID, nodeErr = a.Store.CreateNode(name, parentID)
 	}
 
 	if nodeErr != nil {
@@ -92,7 +258,7 @@ func (a *App) AddChild(name string, parent interface{}) (*multitree.Node, error)
 		}
 	}
 
-	return a.Database.GetGraph(nodeID)
+	return a.Store.GetGraph(nodeID)
 }
 
 func validateTree(root *multitree.Node) error {
@@ -113,7 +279,7 @@ func (a *App) AddRootTree(tree *multitree.Node) (int64, error) {
 	if err := validateTree(tree); err != nil {
 		return 0, err
 	}
-	return a.Database.CreateTree(tree, 0)
+	return a.Store.CreateTree(tree, 0)
 }
 
 // AddChildTree creates a new tree and links parent to its root. It returns the
@@ -131,9 +297,9 @@ func (a *App) AddChildTree(tree *multitree.Node, parent interface{}) (int64, err
 	var rootID int64
 	var createErr error
 	if parentID == 0 {
-		rootID, createErr = a.Database.CreateTreeAsChildOfDateNode(parent.(string), tree)
+		rootID, createErr = a.Store.CreateTreeAsChildOfDateNode(parent.(string), tree)
 	} else {
-		rootID, createErr = a.Database.CreateTree(tree, parentID)
+		rootID, createErr = a.Store.CreateTree(tree, parentID)
 	}
 
 	if createErr != nil {
@@ -162,7 +328,7 @@ func (a *App) RenameNode(selector interface{}, name string) error {
 		return NewError(ErrInvalidName, err.Error())
 	}
 
-	node, err := a.Database.GetNode(id)
+	node, err := a.Store.GetNode(id)
 	if err != nil {
 		return err
 	}
@@ -173,7 +339,7 @@ func (a *App) RenameNode(selector interface{}, name string) error {
 		return NewError(ErrForbidden, "date nodes cannot be renamed")
 	}
 
-	if err := a.Database.RenameNode(node.ID, name); err != nil {
+	if err := a.Store.RenameNode(node.ID, name); err != nil {
 		return err
 	}
 	return nil
@@ -191,7 +357,7 @@ func (a *App) GetGraph(selector interface{}) (*multitree.Node, error) {
 		}
 		return nil, NewError(ErrNotFound, "node does not exist")
 	}
-	return a.Database.GetGraph(id)
+	return a.Store.GetGraph(id)
 }
 
 func (a *App) GetNode(selector interface{}) (*multitree.Node, error) {
@@ -203,14 +369,14 @@ func (a *App) GetNode(selector interface{}) (*multitree.Node, error) {
 		// Return mock d-node.
 		return multitree.NewNode(selector.(string)), nil
 	}
-	return a.Database.GetNode(id)
+	return a.Store.GetNode(id)
 }
 func (a *App) GetNodeByName(name string) (*multitree.Node, error) {
-	return a.Database.GetNodeByName(name)
+	return a.Store.GetNodeByName(name)
 }
 
 func (a *App) GetNodeByAlias(alias string) (*multitree.Node, error) {
-	return a.Database.GetNodeByAlias(alias)
+	return a.Store.GetNodeByAlias(alias)
 }
 
 // LinkNodes creates a new link connecting two nodes. D-nodes are implicitly
@@ -228,15 +394,15 @@ func (a *App) LinkNodes(origin, dest interface{}) (*multitree.Link, error) {
 	var linkID int64
 	var errCreate error
 	if originID == 0 {
-		linkID, errCreate = a.Database.CreateLinkFromDateNode(origin.(string), destID)
+		linkID, errCreate = a.Store.CreateLinkFromDateNode(origin.(string), destID)
 	} else {
-		linkID, errCreate = a.Database.CreateLink(originID, destID)
+		linkID, errCreate = a.Store.CreateLink(originID, destID)
 	}
 	if errCreate != nil {
 		return nil, errCreate
 	}
 
-	return a.Database.GetLink(linkID)
+	return a.Store.GetLink(linkID)
 }
 
 // UnlinkNodes removes the link connecting the given nodes.
@@ -253,16 +419,63 @@ func (a *App) UnlinkNodes(origin, dest interface{}) error {
 		// Assuming there can't be an link from/to an empty d-node.
 		return NewError(ErrNotFound, "link does not exist")
 	}
-	if err := a.Database.DeleteLinkByEndpoints(originID, destID); err != nil {
+	if err := a.Store.DeleteLinkByEndpoints(originID, destID); err != nil {
 		return err
 	}
 	return nil
 }
 
+// MoveNode moves the node from one of its parents to another node, in a single
+// step. If from is nil, the node is moved from its only parent. D-nodes are
+// implicitly created as needed.
+func (a *App) MoveNode(selector, from, to interface{}) error {
+	node, err := a.GetGraph(selector)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		return NewError(ErrNotFound, "node does not exist")
+	}
+
+	var fromID int64
+	if from == nil {
+		parents := node.Parents()
+		if len(parents) != 1 {
+			return NewError(ErrForbidden,
+				fmt.Sprintf("node has %d parents, the one to move it from must be given",
+					len(parents)))
+		}
+		fromID = parents[0].ID
+	} else {
+		if fromID, err = a.selectorToID(from); err != nil {
+			return NewError(ErrInvalidSelector, err.Error())
+		}
+		if fromID == 0 {
+			return NewError(ErrNotFound, "link does not exist")
+		}
+	}
+
+	toID, err := a.selectorToID(to)
+	if err != nil {
+		return NewError(ErrInvalidSelector, err.Error())
+	}
+	if toID == fromID {
+		return NewError(ErrForbidden, "old and new parent are the same")
+	}
+	if toID == 0 {
+		date, _ := to.(string)
+		if multitree.ValidateDateNodeName(date) != nil {
+			return NewError(ErrNotFound, "new parent does not exist")
+		}
+		return a.Store.MoveNodeToDateNode(node.ID, fromID, date)
+	}
+	return a.Store.MoveNode(node.ID, fromID, toID)
+}
+
 func (a *App) SetAlias(id int64, alias string) error {
-	err := a.Database.SetAlias(id, alias)
+	err := a.Store.SetAlias(id, alias)
 	if err != nil {
-		if e, ok := err.(sqlite.Error); ok && e.ExtendedCode == sqlite.ErrConstraintUnique {
+		if err == store.ErrAliasExists {
 			return NewError(ErrForbidden, "alias already exists")
 		}
 		return err
@@ -270,6 +483,121 @@ func (a *App) SetAlias(id int64, alias string) error {
 	return nil
 }
 
+// SetNotes replaces the notes of the node, or removes them if notes is empty.
+func (a *App) SetNotes(selector interface{}, notes string) error {
+	id, err := a.selectorToID(selector)
+	if err != nil {
+		return NewError(ErrInvalidSelector, err.Error())
+	}
+	if id == 0 {
+		return NewError(ErrNotFound, "node does not exist")
+	}
+	return a.Store.SetNotes(id, notes)
+}
+
+// AppendNote adds text to the end of the node's notes, on a new line.
+func (a *App) AppendNote(selector interface{}, text string) error {
+	node, err := a.GetNode(selector)
+	if err != nil {
+		return err
+	}
+	if node == nil || node.ID == 0 {
+		return NewError(ErrNotFound, "node does not exist")
+	}
+	notes := text
+	if node.Notes != "" {
+		notes = strings.TrimRight(node.Notes, "\n") + "\n" + text
+	}
+	return a.Store.SetNotes(node.ID, notes)
+}
+
+// SetDue sets the date the node must be completed by, in the format
+// "YYYY-MM-DD", or removes it if due is empty. The deadline applies to the
+// node's descendants that don't have one of their own.
+func (a *App) SetDue(selector interface{}, due string) error {
+	if due != "" {
+		if err := multitree.ValidateDueDate(due); err != nil {
+			return NewError(ErrInvalidValue, err.Error())
+		}
+	}
+	node, err := a.GetNode(selector)
+	if err != nil {
+		return err
+	}
+	if node == nil || node.ID == 0 {
+		return NewError(ErrNotFound, "node does not exist")
+	}
+	if multitree.ValidateDateNodeName(node.Name) == nil {
+		return NewError(ErrForbidden, "date nodes cannot have due dates")
+	}
+	return a.Store.SetDue(node.ID, due)
+}
+
+// GetDeadlines returns the unresolved nodes whose deadlines fall between from
+// and to, inclusive. Either date may be empty to leave the range open. Nodes
+// that inherit their deadline from an ancestor are only included if inherited
+// is true. The nodes are returned as members of their multitrees, sorted by
+// deadline, then by ID.
+func (a *App) GetDeadlines(from, to string, inherited bool) ([]*multitree.Node, error) {
+	roots, err := a.Store.GetRoots()
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []*multitree.Node
+	seen := make(map[int64]bool)
+	for _, r := range roots {
+		if seen[r.ID] {
+			continue
+		}
+		g, err := a.Store.GetGraph(r.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range g.All() {
+			seen[n.ID] = true
+			deadline := n.Deadline()
+			if deadline == "" || n.IsResolved() || (n.Due == "" && !inherited) {
+				continue
+			}
+			if (from == "" || deadline >= from) && (to == "" || deadline <= to) {
+				nodes = append(nodes, n)
+			}
+		}
+	}
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if di, dj := nodes[i].Deadline(), nodes[j].Deadline(); di != dj {
+			return di < dj
+		}
+		return nodes[i].ID < nodes[j].ID
+	})
+	return nodes, nil
+}
+
+// SetEstimate sets the node's effort estimate, given in points or as a
+// duration (see multitree.ParseEstimate), or removes it if estimate is empty.
+func (a *App) SetEstimate(selector interface{}, estimate string) error {
+	var points float64
+	if estimate != "" {
+		var err error
+		if points, err = multitree.ParseEstimate(estimate); err != nil {
+			return NewError(ErrInvalidValue, err.Error())
+		}
+	}
+	node, err := a.GetNode(selector)
+	if err != nil {
+		return err
+	}
+	if node == nil || node.ID == 0 {
+		return NewError(ErrNotFound, "node does not exist")
+	}
+	if multitree.ValidateDateNodeName(node.Name) == nil {
+		return NewError(ErrForbidden, "date nodes cannot have estimates")
+	}
+	return a.Store.SetEstimate(node.ID, points)
+}
+
 // RemoveNode deletes the node and returns its orphaned children.
 func (a *App) RemoveNode(selector interface{}) ([]*multitree.Node, error) {
 	id, err := a.selectorToID(selector)
@@ -279,7 +607,7 @@ func (a *App) RemoveNode(selector interface{}) ([]*multitree.Node, error) {
 	if id == 0 {
 		return nil, NewError(ErrNotFound, "node does not exist")
 	}
-	orphaned, err := a.Database.DeleteNode(id)
+	orphaned, err := a.Store.DeleteNode(id)
 	if err != nil {
 		return nil, err
 	}
@@ -296,34 +624,350 @@ func (a *App) RemoveNodeRecursive(selector interface{}) ([]*multitree.Node, erro
 	if id == 0 {
 		return nil, NewError(ErrNotFound, "node does not exist")
 	}
-	deleted, err := a.Database.DeleteNodeRecursive(id)
+	deleted, err := a.Store.DeleteNodeRecursive(id)
 	if err != nil {
 		return nil, err
 	}
 	return deleted, nil
 }
 
-func (a *App) checkNode(selector interface{}, value bool) error {
+// TrashNode moves the node to the trash and returns its orphaned children.
+func (a *App) TrashNode(selector interface{}) ([]*multitree.Node, error) {
 	id, err := a.selectorToID(selector)
 	if err != nil {
-		return NewError(ErrInvalidSelector, err.Error())
+		return nil, NewError(ErrInvalidSelector, err.Error())
+	}
+	if id == 0 {
+		return nil, NewError(ErrNotFound, "node does not exist")
 	}
-	if value {
-		return a.Database.CheckNode(id)
+	t, err := a.trash()
+	if err != nil {
+		return nil, err
 	}
-	return a.Database.UncheckNode(id)
+	return t.TrashNode(id)
+}
+
+// TrashNodeRecursive moves the node and all its tree descendants to the trash.
+// Nodes that have multiple parents are only unlinked from the current tree.
+func (a *App) TrashNodeRecursive(selector interface{}) ([]*multitree.Node, error) {
+	id, err := a.selectorToID(selector)
+	if err != nil {
+		return nil, NewError(ErrInvalidSelector, err.Error())
+	}
+	if id == 0 {
+		return nil, NewError(ErrNotFound, "node does not exist")
+	}
+	t, err := a.trash()
+	if err != nil {
+		return nil, err
+	}
+	return t.TrashNodeRecursive(id)
+}
+
+func (a *App) GetTrash() ([]*store.TrashEntry, error) {
+	t, err := a.trash()
+	if err != nil {
+		return nil, err
+	}
+	return t.GetTrash()
+}
+
+// RestoreNode brings back a trashed node along with its links, and returns it
+// as a member of its multitree.
+func (a *App) RestoreNode(id int64) (*multitree.Node, error) {
+	t, err := a.trash()
+	if err != nil {
+		return nil, err
+	}
+	restoredID, err := t.RestoreNode(id)
+	if err != nil {
+		return nil, err
+	}
+	return a.Store.GetGraph(restoredID)
+}
+
+// EmptyTrash purges trash entries older than age, or all entries if age is
+// zero. It returns the number of purged entries.
+func (a *App) EmptyTrash(age time.Duration) (int64, error) {
+	if age < 0 {
+		return 0, NewError(ErrInvalidValue, "age cannot be negative")
+	}
+	t, err := a.trash()
+	if err != nil {
+		return 0, err
+	}
+	return t.EmptyTrash(age)
 }
 
 func (a *App) CheckNode(selector interface{}) error {
-	return a.checkNode(selector, true)
+	return a.CheckNodeAt(selector, time.Now())
+}
+
+// CheckNodeAt marks the node and its descendants as completed at the given
+// time, which can't be in the future.
+func (a *App) CheckNodeAt(selector interface{}, t time.Time) error {
+	id, err := a.selectorToID(selector)
+	if err != nil {
+		return NewError(ErrInvalidSelector, err.Error())
+	}
+	if t.After(time.Now()) {
+		return NewError(ErrForbidden, "completion time is in the future")
+	}
+	return a.Store.CheckNodeAt(id, t.Unix())
+}
+
+// checkTimeOnDate returns the completion time used by CheckNodeOnDate at the
+// time now.
+func checkTimeOnDate(date string, now time.Time) (time.Time, error) {
+	start, err := multitree.DayStart(date)
+	if err != nil {
+		return time.Time{}, NewError(ErrInvalidValue,
+			fmt.Sprintf("invalid date: %s", date))
+	}
+	switch {
+	case date > multitree.DateOf(now):
+		return time.Time{}, NewError(ErrForbidden,
+			fmt.Sprintf("%s hasn't started yet", date))
+	case now.Before(start.Add(24 * time.Hour)):
+		return now, nil
+	}
+	return start.Add(12 * time.Hour), nil
+}
+
+// CheckNodeOnDate marks the node and its descendants as completed on the date
+// ("YYYY-MM-DD"), taking into account that days start at
+// multitree.DayOffset. The completion time is now if the day is under way, or
+// the middle of the day if it's over. Days that haven't started yet, including
+// the calendar date between midnight and the start of the day, are rejected.
+func (a *App) CheckNodeOnDate(selector interface{}, date string) error {
+	id, err := a.selectorToID(selector)
+	if err != nil {
+		return NewError(ErrInvalidSelector, err.Error())
+	}
+	t, err := checkTimeOnDate(date, time.Now())
+	if err != nil {
+		return err
+	}
+	return a.Store.CheckNodeAt(id, t.Unix())
 }
 
 func (a *App) UncheckNode(selector interface{}) error {
-	return a.checkNode(selector, false)
+	id, err := a.selectorToID(selector)
+	if err != nil {
+		return NewError(ErrInvalidSelector, err.Error())
+	}
+	return a.Store.UncheckNode(id)
+}
+
+// CancelNode marks the node as cancelled, along with its descendants that
+// aren't resolved yet.
+func (a *App) CancelNode(selector interface{}) error {
+	node, err := a.GetNode(selector)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		return NewError(ErrNotFound, "node does not exist")
+	}
+	if node.IsDateNode() {
+		return NewError(ErrForbidden, "date nodes cannot be cancelled")
+	}
+	if node.IsCompleted() {
+		return NewError(ErrForbidden, "completed nodes cannot be cancelled")
+	}
+	return a.Store.CancelNode(node.ID)
+}
+
+// UncancelNode reverts the cancellation of the node and its descendants.
+func (a *App) UncancelNode(selector interface{}) error {
+	id, err := a.selectorToID(selector)
+	if err != nil {
+		return NewError(ErrInvalidSelector, err.Error())
+	}
+	if id == 0 {
+		return NewError(ErrNotFound, "node does not exist")
+	}
+	return a.Store.UncancelNode(id)
+}
+
+// Search finds the nodes whose names contain all the words in the query, or
+// words starting with them. If ancestor is non-nil, only its descendants are
+// searched. The nodes are returned as members of their multitrees, ordered by
+// relevance.
+func (a *App) Search(query string, ancestor interface{}, incomplete bool) ([]*multitree.Node, error) {
+	s, err := a.searcher()
+	if err != nil {
+		return nil, err
+	}
+
+	filter := store.SearchFilter{Incomplete: incomplete}
+	if ancestor != nil {
+		id, err := a.selectorToID(ancestor)
+		if err != nil {
+			return nil, NewError(ErrInvalidSelector, err.Error())
+		}
+		if id == 0 {
+			return nil, NewError(ErrNotFound, "node does not exist")
+		}
+		filter.AncestorID = id
+	}
+
+	results, err := s.Search(query, filter)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*multitree.Node
+	for _, r := range results {
+		node, err := a.Store.GetGraph(r.ID)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// Fsck checks the database for inconsistencies. If repair is true, the
+// fixable problems are repaired.
+func (a *App) Fsck(repair bool) ([]*store.Problem, error) {
+	c, err := a.checker()
+	if err != nil {
+		return nil, err
+	}
+	if repair {
+		return c.Repair()
+	}
+	return c.Fsck()
+}
+
+// Merge merges the changes made in another database since the last merge.
+// Conflicts are resolved as specified by resolve; if it's store.MergeAbort,
+// nothing is changed and store.ErrConflicts is returned along with the
+// conflicts found.
+func (a *App) Merge(path, resolve string) (*store.MergeResult, error) {
+	m, err := a.merger()
+	if err != nil {
+		return nil, err
+	}
+	switch resolve {
+	case store.MergeAbort, store.MergeOurs, store.MergeTheirs:
+	default:
+		return nil, NewError(ErrInvalidValue,
+			fmt.Sprintf("invalid conflict resolution: %s", resolve))
+	}
+	return m.Merge(path, resolve)
+}
+
+// Backup backs up the store, and returns the path of the backup. If path is
+// empty or a directory, a timestamped backup is created in the default backup
+// directory or the given one, and the oldest backups in it are removed, so
+// that at most keep remain.
+func (a *App) Backup(path string, keep int) (string, error) {
+	b, err := a.backuper()
+	if err != nil {
+		return "", err
+	}
+	if keep < 0 {
+		return "", NewError(ErrInvalidValue, "number of backups to keep can't be negative")
+	}
+	return b.Backup(path, keep)
+}
+
+// RestoreBackup replaces the contents of the store with the backup at path.
+// It returns the path of the backup of the replaced contents.
+func (a *App) RestoreBackup(path string) (string, error) {
+	b, err := a.backuper()
+	if err != nil {
+		return "", err
+	}
+	return b.Restore(path)
+}
+
+// Undo reverts the last n changes made to the graph, and returns the journal
+// entries describing them.
+func (a *App) Undo(n int) ([]*store.JournalEntry, error) {
+	j, err := a.journal()
+	if err != nil {
+		return nil, err
+	}
+	if n < 1 {
+		return nil, NewError(ErrInvalidValue, "number of changes must be positive")
+	}
+	entries, err := j.Undo(n)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, NewError(ErrNotFound, "nothing to undo")
+	}
+	return entries, nil
+}
+
+// Redo reapplies the last n undone changes, and returns the journal entries
+// describing them.
+func (a *App) Redo(n int) ([]*store.JournalEntry, error) {
+	j, err := a.journal()
+	if err != nil {
+		return nil, err
+	}
+	if n < 1 {
+		return nil, NewError(ErrInvalidValue, "number of changes must be positive")
+	}
+	entries, err := j.Redo(n)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, NewError(ErrNotFound, "nothing to redo")
+	}
+	return entries, nil
+}
+
+// GetEvents returns the history of the selected node, or of the whole
+// database if selector is nil. The events can be narrowed down by type and
+// time range; zero values of since and until are ignored.
+func (a *App) GetEvents(selector interface{}, types []string, since, until time.Time) ([]*store.Event, error) {
+	h, err := a.history()
+	if err != nil {
+		return nil, err
+	}
+
+	var filter store.EventFilter
+	if selector != nil {
+		id, err := a.selectorToID(selector)
+		if err != nil {
+			return nil, NewError(ErrInvalidSelector, err.Error())
+		}
+		if id == 0 {
+			return nil, NewError(ErrNotFound, "node does not exist")
+		}
+		filter.NodeID = id
+	}
+	for _, t := range types {
+		valid := false
+		for _, known := range store.EventTypes {
+			if t == known {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, NewError(ErrInvalidValue,
+				fmt.Sprintf("unknown event type: %s", t))
+		}
+	}
+	filter.Types = types
+	if !since.IsZero() {
+		filter.Since = since.Unix()
+	}
+	if !until.IsZero() {
+		filter.Until = until.Unix()
+	}
+	return h.GetEvents(filter)
 }
 
 func (a *App) GetRoots() ([]*multitree.Node, error) {
-	roots, err := a.Database.GetRoots()
+	roots, err := a.Store.GetRoots()
 	if err != nil {
 		return nil, err
 	}
@@ -342,7 +986,7 @@ func (a *App) GetRoots() ([]*multitree.Node, error) {
 }
 
 func (a *App) GetDateNodes() ([]*multitree.Node, error) {
-	roots, err := a.Database.GetRoots()
+	roots, err := a.Store.GetRoots()
 	if err != nil {
 		return nil, err
 	}
@@ -4,31 +4,71 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/climech/grit/db"
+	"github.com/climech/grit/memstore"
+	"github.com/climech/grit/multitree"
+	"github.com/climech/grit/store"
+	"github.com/climech/grit/textstore"
 )
 
-// setupApp creates a new App and hooks it up to a test database.
-func setupApp(t *testing.T) *App {
-	tmpfile, err := ioutil.TempFile("", "grit_test_db")
-	if err != nil {
-		t.Fatalf("couldn't create temp file: %v", err)
-	}
-	tmpfile.Close() // We only want the name.
-	d, err := db.New(tmpfile.Name())
-	if err != nil {
-		t.Fatalf("couldn't create db: %v", err)
-	}
-	return &App{Database: d}
+// backends lists the stores that the tests are run against. Each function
+// returns a new, empty store and a function that disposes of it.
+var backends = []struct {
+	name string
+	open func(t *testing.T) (store.Store, func())
+}{
+	{"sqlite", func(t *testing.T) (store.Store, func()) {
+		tmpfile, err := ioutil.TempFile("", "grit_test_db")
+		if err != nil {
+			t.Fatalf("couldn't create temp file: %v", err)
+		}
+		tmpfile.Close() // We only want the name.
+		d, err := db.New(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("couldn't create db: %v", err)
+		}
+		return d, func() {
+			if err := os.Remove(d.Filename); err != nil {
+				t.Fatalf("error removing file: %v", err)
+			}
+		}
+	}},
+	{"memory", func(t *testing.T) (store.Store, func()) {
+		return memstore.New(), func() {}
+	}},
+	{"text", func(t *testing.T) (store.Store, func()) {
+		dir, err := ioutil.TempDir("", "grit_test_text")
+		if err != nil {
+			t.Fatalf("couldn't create temp dir: %v", err)
+		}
+		s, err := textstore.Open(dir)
+		if err != nil {
+			t.Fatalf("couldn't open text store: %v", err)
+		}
+		return s, func() { os.RemoveAll(dir) }
+	}},
 }
 
-func tearApp(t *testing.T, a *App) {
-	a.Close()
-	if err := os.Remove(a.Database.Filename); err != nil {
-		t.Fatalf("error removing file: %v", err)
+// forEachBackend runs the test function as a subtest for each backend, with
+// a new App hooked up to an empty store.
+func forEachBackend(t *testing.T, f func(t *testing.T, a *App)) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			s, cleanup := b.open(t)
+			a := NewWithStore(s)
+			defer func() {
+				a.Close()
+				cleanup()
+			}()
+			f(t, a)
+		})
 	}
 }
 
@@ -42,211 +82,1203 @@ func ptrValueToString(ptr interface{}) string {
 
 // TestLoop fails if it's able to create a loop.
 func TestLoop(t *testing.T) {
-	a := setupApp(t)
-	defer tearApp(t, a)
-
-	node, err := a.AddRoot("test")
-	if err != nil {
-		t.Fatal("couldn't create node (1)")
-	}
+	forEachBackend(t, func(t *testing.T, a *App) {
+		node, err := a.AddRoot("test")
+		if err != nil {
+			t.Fatal("couldn't create node (1)")
+		}
 
-	if _, err := a.LinkNodes(node.ID, node.ID); err == nil {
-		t.Fatal("loop created and no error returned")
-	}
+		if _, err := a.LinkNodes(node.ID, node.ID); err == nil {
+			t.Fatal("loop created and no error returned")
+		}
+	})
 }
 
 // TestBackEdge fails if it's able to create a back edge.
 func TestBackEdge(t *testing.T) {
-	a := setupApp(t)
-	defer tearApp(t, a)
-
-	// Create the graph:
-	//
-	//   [ ] test (1)
-	//    └──[ ] test (2)
-	//        └──[ ] test (3)
-	//
-	node1, err := a.AddRoot("test")
-	if err != nil {
-		t.Fatal("couldn't create node (1)")
-	}
-	node2, err := a.AddChild("test", node1.ID)
-	if err != nil {
-		t.Fatal("couldn't create node (2)")
-	}
-	node3, err := a.AddChild("test", node2.ID)
-	if err != nil {
-		t.Fatal("couldn't create node (3)")
-	}
+	forEachBackend(t, func(t *testing.T, a *App) {
+		// Create the graph:
+		//
+		//   [ ] test (1)
+		//    └──[ ] test (2)
+		//        └──[ ] test (3)
+		//
+		node1, err := a.AddRoot("test")
+		if err != nil {
+			t.Fatal("couldn't create node (1)")
+		}
+		node2, err := a.AddChild("test", node1.ID)
+		if err != nil {
+			t.Fatal("couldn't create node (2)")
+		}
+		node3, err := a.AddChild("test", node2.ID)
+		if err != nil {
+			t.Fatal("couldn't create node (3)")
+		}
 
-	// To make a cycle, link (3) to (1).
-	if _, err := a.LinkNodes(node3.ID, node1.ID); err == nil {
-		t.Fatal("a back edge was created")
-	}
+		// To make a cycle, link (3) to (1).
+		if _, err := a.LinkNodes(node3.ID, node1.ID); err == nil {
+			t.Fatal("a back edge was created")
+		}
+	})
 }
 
 // TestForwardEdge fails if it's able to create a forward edge.
 func TestForwardEdge(t *testing.T) {
-	a := setupApp(t)
-	defer tearApp(t, a)
-
-	// Create the graph:
-	//
-	//   [ ] test (1)
-	//    └──[ ] test (2)
-	//        └──[ ] test (3)
-	//
-	node1, err := a.AddRoot("test")
-	if err != nil {
-		t.Fatal("couldn't create node (1)")
-	}
-	node2, err := a.AddChild("test", node1.ID)
-	if err != nil {
-		t.Fatal("couldn't create node (2)")
-	}
-	node3, err := a.AddChild("test", node2.ID)
-	if err != nil {
-		t.Fatal("couldn't create node (3)")
-	}
+	forEachBackend(t, func(t *testing.T, a *App) {
+		// Create the graph:
+		//
+		//   [ ] test (1)
+		//    └──[ ] test (2)
+		//        └──[ ] test (3)
+		//
+		node1, err := a.AddRoot("test")
+		if err != nil {
+			t.Fatal("couldn't create node (1)")
+		}
+		node2, err := a.AddChild("test", node1.ID)
+		if err != nil {
+			t.Fatal("couldn't create node (2)")
+		}
+		node3, err := a.AddChild("test", node2.ID)
+		if err != nil {
+			t.Fatal("couldn't create node (3)")
+		}
 
-	// To make a forward edge, link (1) to (3).
-	if _, err := a.LinkNodes(node1.ID, node3.ID); err == nil {
-		t.Fatal("forward edge successfully created")
-	}
+		// To make a forward edge, link (1) to (3).
+		if _, err := a.LinkNodes(node1.ID, node3.ID); err == nil {
+			t.Fatal("forward edge successfully created")
+		}
+	})
 }
 
 // TestCrossEdge fails if it cannot create a cross edge.
 func TestCrossEdge(t *testing.T) {
-	a := setupApp(t)
-	defer tearApp(t, a)
-
-	// Create the nodes:
-	//
-	//   [ ] test (1)
-	//    └──[ ] test (2)
-	//
-	//   [ ] test (3)
-	//
-	root1, err := a.AddRoot("test")
+	forEachBackend(t, func(t *testing.T, a *App) {
+		// Create the nodes:
+		//
+		//   [ ] test (1)
+		//    └──[ ] test (2)
+		//
+		//   [ ] test (3)
+		//
+		root1, err := a.AddRoot("test")
+		if err != nil {
+			t.Fatal("couldn't create node (1)")
+		}
+		succ, err := a.AddChild("test", root1.ID)
+		if err != nil {
+			t.Fatal("couldn't create node (2)")
+		}
+		root2, err := a.AddRoot("test")
+		if err != nil {
+			t.Fatal("couldn't create node (3)")
+		}
+
+		// To make a cross edge, link (3) to (2).
+		if _, err := a.LinkNodes(root2.ID, succ.ID); err != nil {
+			t.Fatalf("couldn't create a cross edge: %v", err)
+		}
+	})
+}
+
+func TestStatusChange(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *App) {
+		// Create the graph:
+		//
+		//   [ ] test (1)
+		//    ├──[ ] test (2)
+		//    └──[ ] test (3)
+		//        └──[ ] test (4)
+		//
+		node1, err := a.AddRoot("test")
+		if err != nil {
+			t.Fatal("couldn't create node (1)")
+		}
+		node2, err := a.AddChild("test", node1.ID)
+		if err != nil {
+			t.Fatal("couldn't create node (2)")
+		}
+		node3, err := a.AddChild("test", node1.ID)
+		if err != nil {
+			t.Fatal("couldn't create node (3)")
+		}
+		node4, err := a.AddChild("test", node3.ID)
+		if err != nil {
+			t.Fatal("couldn't create node (4)")
+		}
+
+		// Checking (3) and (2) should cause (1) and (4) to be checked as well.
+		//
+		//   [x] test (1)
+		//    ├──[x] test (2)
+		//    └──[x] test (3)
+		//        └──[x] test (4)
+		//
+		if err := a.CheckNode(node3.ID); err != nil {
+			t.Fatalf("couldn't check successor (3): %v", err)
+		}
+		time.Sleep(1 * time.Second) // to make the timestamps different
+		if err := a.CheckNode(node2.ID); err != nil {
+			t.Fatalf("couldn't check successor (2): %v", err)
+		}
+
+		if root, err := a.GetGraph(node1.ID); err != nil {
+			t.Fatalf("couldn't get graph: %v", err)
+		} else {
+			if n := root.Get(node2.ID); !n.IsCompleted() {
+				t.Errorf("checking node had no effect: %s", n)
+			}
+			if n := root.Get(node3.ID); !n.IsCompleted() {
+				t.Errorf("checking node had no effect: %s", n)
+			}
+			if !root.IsCompleted() {
+				t.Error("checked all successors of root, but root.IsCompleted() = false")
+			}
+			if !root.Get(node4.ID).IsCompleted() {
+				t.Error("node checked, but successor is still unchecked")
+			}
+
+			c1 := root.Completed
+			c2 := root.Get(node2.ID).Completed
+			c3 := root.Get(node3.ID).Completed
+			c4 := root.Get(node4.ID).Completed
+
+			if !reflect.DeepEqual(c1, c2) {
+				t.Errorf("backpropped completion time should be the same as in "+
+					"last checked successor; want %v, got %v",
+					ptrValueToString(c2), ptrValueToString(c1))
+			}
+			if !reflect.DeepEqual(c3, c4) {
+				t.Errorf("successor should inherit the completion time from its checked "+
+					"predecessor; want %v, got %v", ptrValueToString(c3),
+					ptrValueToString(c4))
+			}
+		}
+
+		// Unchecking (3) should cause (1) and (4) to be unchecked as well; (2) should
+		// be left unchanged.
+		//
+		//   [ ] test (1)
+		//    ├──[x] test (2)
+		//    └──[ ] test (3)
+		//        └──[ ] test (4)
+		//
+		if err := a.UncheckNode(node3.ID); err != nil {
+			t.Fatalf("couldn't uncheck successor (3): %v", err)
+		}
+		if root, err := a.GetGraph(node1.ID); err != nil {
+			t.Fatalf("couldn't get graph: %v", err)
+		} else {
+			if n := root.Get(node3.ID); n.IsCompleted() {
+				t.Fatalf("unchecking node had no effect: %s", n)
+			}
+			if root.IsCompleted() {
+				t.Fatal("node unchecked, but predecessor is checked")
+			}
+			if n := root.Get(node2.ID); !n.IsCompleted() {
+				t.Fatalf("unchecking node changed node's sibling(!): %s", n)
+			}
+			if n := root.Get(node4.ID); n.IsCompleted() {
+				t.Fatalf("node unchecked, but successor is checked: %s", n)
+			}
+		}
+	})
+}
+
+func TestWorkspaces(t *testing.T) {
+	configPath, err := ioutil.TempDir("", "grit_test_config")
 	if err != nil {
-		t.Fatal("couldn't create node (1)")
+		t.Fatalf("couldn't create temp dir: %v", err)
 	}
-	succ, err := a.AddChild("test", root1.ID)
+	defer os.RemoveAll(configPath)
+
+	ws, err := LoadWorkspaces(configPath)
 	if err != nil {
-		t.Fatal("couldn't create node (2)")
+		t.Fatalf("couldn't load workspaces: %v", err)
+	}
+	if _, err := ws.Create("work", ""); err != nil {
+		t.Fatalf("couldn't create workspace: %v", err)
 	}
-	root2, err := a.AddRoot("test")
+	if _, err := ws.Create("work", ""); err == nil {
+		t.Error("created duplicate workspace")
+	}
+	if _, err := ws.Create("bad name", ""); err == nil {
+		t.Error("created workspace with invalid name")
+	}
+	if err := ws.Use("work"); err != nil {
+		t.Fatalf("couldn't switch workspace: %v", err)
+	}
+	if err := ws.Save(); err != nil {
+		t.Fatalf("couldn't save workspaces: %v", err)
+	}
+
+	// The active workspace should be remembered.
+	opts := Options{ConfigPath: configPath}
+	got, err := opts.ResolveDatabasePath()
 	if err != nil {
-		t.Fatal("couldn't create node (3)")
+		t.Fatalf("couldn't resolve database path: %v", err)
+	}
+	if want := filepath.Join(configPath, "work.db"); got != want {
+		t.Errorf("got database path %q, want %q", got, want)
 	}
 
-	// To make a cross edge, link (3) to (2).
-	if _, err := a.LinkNodes(root2.ID, succ.ID); err != nil {
-		t.Fatalf("couldn't create a cross edge: %v", err)
+	// Explicit workspace overrides the active one.
+	opts.Workspace = DefaultWorkspace
+	got, _ = opts.ResolveDatabasePath()
+	if want := filepath.Join(configPath, "graph.db"); got != want {
+		t.Errorf("got database path %q, want %q", got, want)
 	}
-}
 
-func TestStatusChange(t *testing.T) {
-	a := setupApp(t)
-	defer tearApp(t, a)
-
-	// Create the graph:
-	//
-	//   [ ] test (1)
-	//    ├──[ ] test (2)
-	//    └──[ ] test (3)
-	//        └──[ ] test (4)
-	//
-	node1, err := a.AddRoot("test")
-	if err != nil {
-		t.Fatal("couldn't create node (1)")
+	// Explicit path overrides everything.
+	opts.DatabasePath = "/tmp/other.db"
+	if got, _ = opts.ResolveDatabasePath(); got != opts.DatabasePath {
+		t.Errorf("got database path %q, want %q", got, opts.DatabasePath)
 	}
-	node2, err := a.AddChild("test", node1.ID)
-	if err != nil {
-		t.Fatal("couldn't create node (2)")
+
+	if _, err := ws.Remove(DefaultWorkspace); err == nil {
+		t.Error("removed the default workspace")
 	}
-	node3, err := a.AddChild("test", node1.ID)
-	if err != nil {
-		t.Fatal("couldn't create node (3)")
+	if _, err := ws.Remove("work"); err != nil {
+		t.Fatalf("couldn't remove workspace: %v", err)
 	}
-	node4, err := a.AddChild("test", node3.ID)
-	if err != nil {
-		t.Fatal("couldn't create node (4)")
+	if ws.ActiveName() != DefaultWorkspace {
+		t.Errorf("removing active workspace didn't reset it to default")
+	}
+}
+
+// TestImportInvalidDump fails if a dump containing a cycle or a diamond is
+// imported.
+func TestImportInvalidDump(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *App) {
+		nodes := []*store.DumpNode{
+			{ID: 1, Name: "a"},
+			{ID: 2, Name: "b"},
+			{ID: 3, Name: "c"},
+		}
+		dumps := map[string]*store.Dump{
+			"cycle": {Nodes: nodes, Links: []*store.DumpLink{
+				{ID: 1, OriginID: 1, DestID: 2},
+				{ID: 2, OriginID: 2, DestID: 3},
+				{ID: 3, OriginID: 3, DestID: 1},
+			}},
+			"diamond": {Nodes: nodes, Links: []*store.DumpLink{
+				{ID: 1, OriginID: 1, DestID: 2},
+				{ID: 2, OriginID: 2, DestID: 3},
+				{ID: 3, OriginID: 1, DestID: 3},
+			}},
+			"dangling link": {Nodes: nodes, Links: []*store.DumpLink{
+				{ID: 1, OriginID: 1, DestID: 4},
+			}},
+			"dependency cycle": {Nodes: nodes, Dependencies: []*store.DumpDependency{
+				{ID: 1, NodeID: 1, PrereqID: 2},
+				{ID: 2, NodeID: 2, PrereqID: 3},
+				{ID: 3, NodeID: 3, PrereqID: 1},
+			}},
+			"dangling dependency": {Nodes: nodes, Dependencies: []*store.DumpDependency{
+				{ID: 1, NodeID: 1, PrereqID: 4},
+			}},
+			"property on date node": {
+				Nodes: []*store.DumpNode{{ID: 1, Name: "2020-01-01"}},
+				Properties: []*store.DumpProperty{
+					{NodeID: 1, Key: "owner", Value: "alice"},
+				},
+			},
+			"duplicate date node": {
+				Nodes: []*store.DumpNode{
+					{ID: 1, Name: "2020-01-01"},
+					{ID: 2, Name: "2020-01-01"},
+				},
+			},
+			"date node with parent": {
+				Nodes: []*store.DumpNode{
+					{ID: 1, Name: "a"},
+					{ID: 2, Name: "2020-01-01"},
+				},
+				Links: []*store.DumpLink{{ID: 1, OriginID: 1, DestID: 2}},
+			},
+			"dependency on child": {
+				Nodes: nodes,
+				Links: []*store.DumpLink{{ID: 1, OriginID: 1, DestID: 2}},
+				Dependencies: []*store.DumpDependency{
+					{ID: 1, NodeID: 1, PrereqID: 2},
+				},
+			},
+		}
+
+		for name, dump := range dumps {
+			if _, err := a.Import(dump); err == nil {
+				t.Errorf("%s imported and no error returned", name)
+			}
+		}
+		if roots, _ := a.GetRoots(); len(roots) != 0 {
+			t.Errorf("got %d roots after failed imports, want 0", len(roots))
+		}
+	})
+}
+
+func TestImportDependencies(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *App) {
+		dump := &store.Dump{
+			Nodes: []*store.DumpNode{
+				{ID: 1, Name: "a"},
+				{ID: 2, Name: "b"},
+			},
+			Dependencies: []*store.DumpDependency{
+				{ID: 1, NodeID: 1, PrereqID: 2},
+			},
+		}
+
+		if _, ok := a.Store.(store.DependencyTracker); !ok {
+			if _, err := a.Import(dump); err == nil {
+				t.Errorf("dependencies imported into a store without dependencies")
+			}
+			if roots, _ := a.GetRoots(); len(roots) != 0 {
+				t.Errorf("got %d roots after failed import, want 0", len(roots))
+			}
+			return
+		}
+
+		if _, err := a.Import(dump); err != nil {
+			t.Fatalf("couldn't import: %v", err)
+		}
+		ids, err := a.Import(dump)
+		if err != nil {
+			t.Fatalf("couldn't import: %v", err)
+		}
+		prereqs, _ := a.GetPrerequisites(ids[1])
+		if len(prereqs) != 1 || prereqs[0].ID != ids[2] {
+			t.Errorf("got prerequisites %v, want (%d)", prereqs, ids[2])
+		}
+	})
+}
+
+func TestNotes(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *App) {
+		tree, err := multitree.ImportTrees(strings.NewReader(
+			"root\n\t> imported note\n\tchild\n"))
+		if err != nil {
+			t.Fatalf("couldn't parse tree: %v", err)
+		}
+		rootID, err := a.AddRootTree(tree[0])
+		if err != nil {
+			t.Fatalf("couldn't create tree: %v", err)
+		}
+		if err := a.AppendNote(rootID, "appended"); err != nil {
+			t.Fatalf("couldn't append note: %v", err)
+		}
+		node, err := a.GetNode(rootID)
+		if err != nil {
+			t.Fatalf("couldn't get node: %v", err)
+		}
+		if want := "imported note\nappended"; node.Notes != want {
+			t.Errorf("got notes %q, want %q", node.Notes, want)
+		}
+
+		dump, err := a.Export()
+		if err != nil {
+			t.Fatalf("couldn't export: %v", err)
+		}
+		if dump.Nodes[0].Notes != node.Notes {
+			t.Errorf("notes weren't exported")
+		}
+
+		if err := a.SetNotes(rootID, ""); err != nil {
+			t.Fatalf("couldn't remove notes: %v", err)
+		}
+		if node, _ := a.GetNode(rootID); node.Notes != "" {
+			t.Errorf("notes weren't removed")
+		}
+		if err := a.AppendNote("2020-01-01", "note"); err == nil {
+			t.Errorf("note added to a nonexistent date node")
+		}
+	})
+}
+
+func TestDeadlines(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *App) {
+		root, _ := a.AddRoot("root")
+		child, _ := a.AddChild("child", root.ID)
+		other, _ := a.AddRoot("other")
+		done, _ := a.AddRoot("done")
+
+		if err := a.SetDue(root.ID, "2020-01-10"); err != nil {
+			t.Fatalf("couldn't set due date: %v", err)
+		}
+		a.SetDue(other.ID, "2020-01-05")
+		a.SetDue(done.ID, "2020-01-01")
+		a.CheckNode(done.ID)
+
+		if err := a.SetDue(root.ID, "soon"); err == nil {
+			t.Errorf("invalid due date set")
+		}
+		a.AddChild("today", "2020-01-01")
+		if err := a.SetDue("2020-01-01", "2020-01-02"); err == nil {
+			t.Errorf("due date set on a date node")
+		}
+
+		ids := func(nodes []*multitree.Node) []int64 {
+			var ids []int64
+			for _, n := range nodes {
+				ids = append(ids, n.ID)
+			}
+			return ids
+		}
+		tests := []struct {
+			from, to  string
+			inherited bool
+			want      []int64
+		}{
+			{"", "", false, []int64{other.ID, root.ID}},
+			{"", "", true, []int64{other.ID, root.ID, child.ID}},
+			{"2020-01-06", "2020-01-10", true, []int64{root.ID, child.ID}},
+			{"", "2020-01-04", true, nil},
+		}
+		for _, test := range tests {
+			nodes, err := a.GetDeadlines(test.from, test.to, test.inherited)
+			if err != nil {
+				t.Fatalf("couldn't get deadlines: %v", err)
+			}
+			if got := ids(nodes); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("%s..%s (inherited: %v): got %v, want %v", test.from,
+					test.to, test.inherited, got, test.want)
+			}
+		}
+	})
+}
+
+func TestEstimates(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *App) {
+		root, _ := a.AddRoot("root")
+		small, _ := a.AddChild("small", root.ID)
+		big, _ := a.AddChild("big", root.ID)
+
+		if err := a.SetEstimate(small.ID, "30m"); err != nil {
+			t.Fatalf("couldn't set estimate: %v", err)
+		}
+		a.SetEstimate(big.ID, "1.5d")
+		for _, value := range []string{"0", "-1", "soon"} {
+			err := a.SetEstimate(root.ID, value)
+			if e, ok := err.(*AppError); !ok || e.Code != ErrInvalidValue {
+				t.Errorf("got %v for invalid estimate %s, want invalid value", err, value)
+			}
+		}
+		a.CheckNode(small.ID)
+
+		g, _ := a.GetGraph(root.ID)
+		if total, done := g.Effort(); total != 12.5 || done != 0.5 {
+			t.Errorf("got effort %v/%v, want 0.5/12.5", done, total)
+		}
+
+		if err := a.SetEstimate(big.ID, ""); err != nil {
+			t.Fatalf("couldn't remove estimate: %v", err)
+		}
+		if n, _ := a.GetNode(big.ID); n.Estimate != 0 {
+			t.Errorf("estimate wasn't removed")
+		}
+	})
+}
+
+func TestTimeReport(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *App) {
+		tracker, ok := a.Store.(store.TimeTracker)
+		if !ok {
+			if _, err := a.GetTimeReport(nil, time.Time{}, time.Time{}); err == nil {
+				t.Errorf("got report from a store without time tracking")
+			}
+			return
+		}
+
+		root, _ := a.AddRoot("root")
+		child, _ := a.AddChild("child", root.ID)
+		grandchild, _ := a.AddChild("grandchild", child.ID)
+		other, _ := a.AddRoot("other")
+		// The shared node is reachable from the root through the child only.
+		a.LinkNodes(other.ID, grandchild.ID)
+
+		at := func(day, hour, min int) int64 {
+			return time.Date(2020, 11, day, hour, min, 0, 0, time.Local).Unix()
+		}
+		timers := []struct {
+			id          int64
+			start, stop int64
+		}{
+			{root.ID, at(14, 9, 0), at(14, 9, 30)},
+			{child.ID, at(14, 10, 0), at(14, 11, 0)},
+			// Times before multitree.DayOffset count towards the previous day.
+			{grandchild.ID, at(14, 23, 0), at(15, 5, 0)},
+			{other.ID, at(15, 8, 0), at(15, 8, 15)},
+		}
+		for _, tm := range timers {
+			if _, err := tracker.StartTimer(tm.id, tm.start, ""); err != nil {
+				t.Fatalf("couldn't start timer: %v", err)
+			}
+			if _, err := tracker.StopTimer(tm.stop); err != nil {
+				t.Fatalf("couldn't stop timer: %v", err)
+			}
+		}
+
+		format := func(days []*TimeDay) string {
+			var lines []string
+			for _, d := range days {
+				line := fmt.Sprintf("%s %v:", d.Date, d.Total)
+				for _, n := range d.Nodes {
+					line += fmt.Sprintf(" %s=%v", n.NodeName, n.Time)
+				}
+				lines = append(lines, line)
+			}
+			return strings.Join(lines, "\n")
+		}
+		tests := []struct {
+			selector     interface{}
+			since, until time.Time
+			want         string
+		}{
+			{nil, time.Time{}, time.Time{},
+				"2020-11-14 6h30m0s: root=6h30m0s\n" +
+					"2020-11-15 1h15m0s: root=1h0m0s other=15m0s"},
+			{root.ID, time.Time{}, time.Time{},
+				"2020-11-14 6h30m0s: child=6h0m0s root=30m0s\n" +
+					"2020-11-15 1h0m0s: child=1h0m0s"},
+			{other.ID, time.Unix(at(15, 0, 30), 0), time.Time{},
+				"2020-11-14 3h30m0s: grandchild=3h30m0s\n" +
+					"2020-11-15 1h15m0s: grandchild=1h0m0s other=15m0s"},
+		}
+		for _, test := range tests {
+			days, err := a.GetTimeReport(test.selector, test.since, test.until)
+			if err != nil {
+				t.Fatalf("couldn't get report: %v", err)
+			}
+			if got := format(days); got != test.want {
+				t.Errorf("report for %v:\ngot:\n%s\nwant:\n%s", test.selector, got,
+					test.want)
+			}
+		}
+
+		// Starting a timer stops the running one.
+		a.StartTimer(root.ID, false)
+		_, stopped, err := a.StartTimer(child.ID, true)
+		if err != nil {
+			t.Fatalf("couldn't start timer: %v", err)
+		}
+		if stopped == nil || stopped.NodeID != root.ID {
+			t.Errorf("running timer wasn't stopped")
+		}
+		today := multitree.Today()
+		if g, _ := a.GetGraph(child.ID); !isReachableFrom(g, today) {
+			t.Errorf("node wasn't linked from today's date node")
+		}
+		if _, _, err := a.StartTimer(today, false); err == nil {
+			t.Errorf("timer started on a date node")
+		}
+		if e, _ := a.StopTimer(); e == nil || e.NodeID != child.ID {
+			t.Errorf("got stopped entry %+v, want node %d", e, child.ID)
+		}
+		if e, _ := a.GetRunningTimer(); e != nil {
+			t.Errorf("timer is still running")
+		}
+	})
+}
+
+func TestRecurrenceRules(t *testing.T) {
+	start := time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC) // Friday
+	tests := []struct {
+		rule, canonical string
+		want            []string // first matches from the start
+	}{
+		{"daily", "daily", []string{"2020-01-31", "2020-02-01", "2020-02-02"}},
+		{"weekdays", "weekdays", []string{"2020-01-31", "2020-02-03", "2020-02-04"}},
+		{"every 3 days", "every 3 days", []string{"2020-01-31", "2020-02-03", "2020-02-06"}},
+		{"weekly", "weekly on fri", []string{"2020-01-31", "2020-02-07", "2020-02-14"}},
+		{"Weekly on Tuesday,sun", "weekly on tue,sun", []string{"2020-02-02", "2020-02-04", "2020-02-09"}},
+		{"monthly", "monthly on 31", []string{"2020-01-31", "2020-02-29", "2020-03-31"}},
+		{"monthly on day 2", "monthly on 2", []string{"2020-02-02", "2020-03-02", "2020-04-02"}},
+	}
+	for _, test := range tests {
+		r, err := parseRecurrenceRule(test.rule, start)
+		if err != nil {
+			t.Errorf("couldn't parse %q: %v", test.rule, err)
+			continue
+		}
+		if r.String() != test.canonical {
+			t.Errorf("%q: got canonical form %q, want %q", test.rule, r.String(),
+				test.canonical)
+		}
+		var got []string
+		date := start.AddDate(0, 0, -1)
+		for len(got) < len(test.want) {
+			date = r.next(date, start)
+			got = append(got, date.Format("2006-01-02"))
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v, want %v", test.rule, got, test.want)
+		}
 	}
 
-	// Checking (3) and (2) should cause (1) and (4) to be checked as well.
-	//
-	//   [x] test (1)
-	//    ├──[x] test (2)
-	//    └──[x] test (3)
-	//        └──[x] test (4)
-	//
-	if err := a.CheckNode(node3.ID); err != nil {
-		t.Fatalf("couldn't check successor (3): %v", err)
+	for _, rule := range []string{"", "hourly", "daily on mon", "every 0 days",
+		"every day", "weekly on funday", "weekly mon", "monthly on 32", "monthly on"} {
+		if _, err := parseRecurrenceRule(rule, start); err == nil {
+			t.Errorf("invalid rule %q parsed", rule)
+		}
 	}
-	time.Sleep(1 * time.Second) // to make the timestamps different
-	if err := a.CheckNode(node2.ID); err != nil {
-		t.Fatalf("couldn't check successor (2): %v", err)
+}
+
+func TestSyncRecurrences(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *App) {
+		template, _ := a.AddRoot("review")
+		a.AddChild("inbox", template.ID)
+		a.CheckNode(template.ID)
+
+		from := multitree.DateOf(time.Now().AddDate(0, 0, -2))
+		if _, ok := a.Store.(store.Recurrer); !ok {
+			if _, err := a.AddRecurrence(template.ID, "daily", from); err == nil {
+				t.Errorf("recurrence added to a store without recurrence")
+			}
+			return
+		}
+
+		if _, err := a.AddRecurrence(template.ID, "daily", from); err != nil {
+			t.Fatalf("couldn't add recurrence: %v", err)
+		}
+		if _, err := a.AddRecurrence(template.ID, "weekly on someday", ""); err == nil {
+			t.Errorf("invalid rule added")
+		}
+
+		// The occurrence of today already exists, e.g. from another rule.
+		today := multitree.Today()
+		a.AddChild("review", today)
+
+		created, err := a.SyncRecurrences()
+		if err != nil {
+			t.Fatalf("couldn't sync: %v", err)
+		}
+		if len(created) != 2 {
+			t.Fatalf("got %d created trees, want 2", len(created))
+		}
+		for _, n := range created {
+			if n.Name != "review" || n.IsCompleted() || len(n.Children()) != 1 {
+				t.Errorf("occurrence isn't a fresh copy of the template")
+			}
+		}
+		if created, _ := a.SyncRecurrences(); len(created) != 0 {
+			t.Errorf("got %d created trees on second sync, want 0", len(created))
+		}
+
+		recurrences, _ := a.GetRecurrences()
+		if len(recurrences) != 1 || recurrences[0].Synced != today {
+			t.Fatalf("got recurrences %+v, want one synced today", recurrences)
+		}
+
+		// Another process may sync the same rule in the meantime.
+		rec := a.Store.(store.Recurrer)
+		if id, err := rec.CreateOccurrence(recurrences[0].ID, today, freshCopy(template)); err != nil || id != 0 {
+			t.Errorf("occurrence created again on a synced date (%v)", err)
+		}
+		rec.SetRecurrenceSynced(recurrences[0].ID, from)
+		if id, err := rec.CreateOccurrence(recurrences[0].ID, today, freshCopy(template)); err != nil || id != 0 {
+			t.Errorf("occurrence created on a date that already has it (%v)", err)
+		}
+		if err := a.RemoveRecurrence(recurrences[0].ID); err != nil {
+			t.Fatalf("couldn't remove recurrence: %v", err)
+		}
+		if recurrences, _ := a.GetRecurrences(); len(recurrences) != 0 {
+			t.Errorf("recurrence wasn't removed")
+		}
+
+		// Occurrences missed long ago are skipped.
+		chore, _ := a.AddRoot("water plants")
+		from = multitree.DateOf(time.Now().AddDate(0, 0, -30))
+		if _, err := a.AddRecurrence(chore.ID, "daily", from); err != nil {
+			t.Fatalf("couldn't add recurrence: %v", err)
+		}
+		created, err = a.SyncRecurrences()
+		if err != nil {
+			t.Fatalf("couldn't sync: %v", err)
+		}
+		if len(created) != RecurrenceCatchUpDays {
+			t.Errorf("got %d created trees, want %d", len(created), RecurrenceCatchUpDays)
+		}
+	})
+}
+
+func TestTemplates(t *testing.T) {
+	configPath, err := ioutil.TempDir("", "grit_test_config")
+	if err != nil {
+		t.Fatalf("couldn't create temp dir: %v", err)
 	}
+	defer os.RemoveAll(configPath)
+	templates := NewTemplates(configPath)
+
+	forEachBackend(t, func(t *testing.T, a *App) {
+		root, _ := a.AddRoot("Release {{version}}")
+		child, _ := a.AddChild("Tag {{ version }} on {{date}}", root.ID)
+		a.AddChild("Announce", root.ID)
+		// Names that look like notes are escaped.
+		a.AddChild("> changelog", child.ID)
+		a.SetNotes(child.ID, "git tag v{{version}}")
+		a.CheckNode(child.ID)
 
-	if root, err := a.GetGraph(node1.ID); err != nil {
-		t.Fatalf("couldn't get graph: %v", err)
-	} else {
-		if n := root.Get(node2.ID); !n.IsCompleted() {
-			t.Errorf("checking node had no effect: %s", n)
+		g, _ := a.GetGraph(root.ID)
+		if err := templates.Save("release", g, true); err != nil {
+			t.Fatalf("couldn't save template: %v", err)
 		}
-		if n := root.Get(node3.ID); !n.IsCompleted() {
-			t.Errorf("checking node had no effect: %s", n)
+		if err := templates.Save("release", g, false); err == nil {
+			t.Errorf("existing template replaced")
 		}
-		if !root.IsCompleted() {
-			t.Error("checked all successors of root, but root.IsCompleted() = false")
+		if err := templates.Save("bad name", g, false); err == nil {
+			t.Errorf("template saved with invalid name")
 		}
-		if !root.Get(node4.ID).IsCompleted() {
-			t.Error("node checked, but successor is still unchecked")
+		if names, _ := templates.List(); !reflect.DeepEqual(names, []string{"release"}) {
+			t.Errorf("got templates %v, want [release]", names)
 		}
 
-		c1 := root.Completed
-		c2 := root.Get(node2.ID).Completed
-		c3 := root.Get(node3.ID).Completed
-		c4 := root.Get(node4.ID).Completed
+		if _, err := templates.Instantiate("release", nil, nil); err == nil {
+			t.Errorf("template instantiated with undefined variable")
+		}
+		tree, err := templates.Instantiate("release", nil,
+			map[string]string{"version": "1.2", "date": "2020-01-01"})
+		if err != nil {
+			t.Fatalf("couldn't instantiate template: %v", err)
+		}
+		id, err := a.AddRootTree(tree)
+		if err != nil {
+			t.Fatalf("couldn't create tree: %v", err)
+		}
+		instance, _ := a.GetGraph(id)
+		if instance.Name != "Release 1.2" || len(instance.Children()) != 2 {
+			t.Fatalf("got %q with %d children, want %q with 2", instance.Name,
+				len(instance.Children()), "Release 1.2")
+		}
+		tag := instance.GetByName("Tag 1.2 on 2020-01-01")
+		if tag == nil || tag.IsCompleted() || tag.Notes != "git tag v1.2" {
+			t.Errorf("node wasn't instantiated as a fresh copy: %+v", tag)
+		}
+		if tag != nil && (len(tag.Children()) != 1 || tag.Children()[0].Name != "> changelog") {
+			t.Errorf("escaped name wasn't read back")
+		}
 
-		if !reflect.DeepEqual(c1, c2) {
-			t.Errorf("backpropped completion time should be the same as in "+
-				"last checked successor; want %v, got %v",
-				ptrValueToString(c2), ptrValueToString(c1))
+		// The date defaults to the date the tree is attached to.
+		day, _ := a.AddChild("Plan", "2020-02-03")
+		parent, _ := a.GetGraph(day.ID)
+		vars := map[string]string{"version": "1.3"}
+		tree, err = templates.Instantiate("release", parent, vars)
+		if err != nil {
+			t.Fatalf("couldn't instantiate template: %v", err)
+		}
+		if tree.GetByName("Tag 1.3 on 2020-02-03") == nil {
+			t.Errorf("date isn't the one the tree is attached to")
 		}
-		if !reflect.DeepEqual(c3, c4) {
-			t.Errorf("successor should inherit the completion time from its checked "+
-				"predecessor; want %v, got %v", ptrValueToString(c3),
-				ptrValueToString(c4))
+		tree, _ = templates.Instantiate("release", nil, vars)
+		if tree.GetByName("Tag 1.3 on "+multitree.Today()) == nil {
+			t.Errorf("date of a root tree isn't today")
+		}
+
+		if err := templates.Remove("release"); err != nil {
+			t.Fatalf("couldn't remove template: %v", err)
 		}
+		if _, err := templates.Read("release"); err == nil {
+			t.Errorf("removed template is still readable")
+		}
+	})
+}
+
+func TestCopyNode(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *App) {
+		root, _ := a.AddRoot("Project")
+		done, _ := a.AddChild("Done", root.ID)
+		todo, _ := a.AddChild("To do", root.ID)
+		shared, _ := a.AddChild("Shared", todo.ID)
+		other, _ := a.AddRoot("Other")
+		a.LinkNodes(other.ID, shared.ID)
+		a.SetAlias(root.ID, "project")
+		a.SetNotes(done.ID, "notes")
+		a.CheckNode(done.ID)
+
+		copyNode := func(parent interface{}, opts CopyOptions) *multitree.Node {
+			id, err := a.CopyNode(root.ID, parent, opts)
+			if err != nil {
+				t.Fatalf("couldn't copy node: %v", err)
+			}
+			g, _ := a.GetGraph(id)
+			return g.Tree()
+		}
+
+		c := copyNode(nil, CopyOptions{})
+		if c.ID == root.ID || c.Alias != "" || len(c.Children()) != 2 {
+			t.Fatalf("node wasn't copied without an alias: %+v", c)
+		}
+		if d := c.GetByName("Done"); d.IsCompleted() || d.Notes != "notes" {
+			t.Errorf("node wasn't copied as unchecked with notes: %+v", d)
+		}
+		if s := c.GetByName("Shared"); s.ID == shared.ID {
+			t.Errorf("shared node wasn't copied")
+		}
+
+		c = copyNode(nil, CopyOptions{KeepStatus: true})
+		if !c.GetByName("Done").IsCompleted() || c.IsCompleted() {
+			t.Errorf("completion state wasn't kept")
+		}
+
+		c = copyNode(nil, CopyOptions{Incomplete: true})
+		if c.GetByName("Done") != nil || c.GetByName("To do") == nil {
+			t.Errorf("completed branch was copied or incomplete one wasn't")
+		}
+
+		c = copyNode(nil, CopyOptions{KeepLinks: true})
+		if s := c.GetByName("To do").Children()[0]; s.ID != shared.ID {
+			t.Errorf("copy wasn't linked to the shared node")
+		}
+
+		// Linking the shared node would create a diamond below "Other".
+		c = copyNode(other.ID, CopyOptions{KeepLinks: true})
+		if s := c.GetByName("To do").Children()[0]; s.ID == shared.ID {
+			t.Errorf("shared node was linked in violation of the multitree rules")
+		}
+
+		// The copy is linked to the shared node and saved in a single step.
+		before, _ := a.GetGraph(shared.ID)
+		c = copyNode("2020-01-02", CopyOptions{KeepLinks: true})
+		if s := c.GetByName("To do").Children()[0]; s.ID != shared.ID {
+			t.Errorf("copy under a new date node wasn't linked to the shared node")
+		}
+		if _, ok := a.Store.(store.Journal); ok {
+			if _, err := a.Undo(1); err != nil {
+				t.Fatalf("couldn't undo: %v", err)
+			}
+			if n, _ := a.GetNode(c.ID); n != nil {
+				t.Errorf("copy wasn't undone in one step")
+			}
+			if n, _ := a.GetGraph(shared.ID); len(n.Parents()) != len(before.Parents()) {
+				t.Errorf("got %d parents of the shared node, want %d",
+					len(n.Parents()), len(before.Parents()))
+			}
+		}
+
+		d, _ := a.AddChild("task", "2020-01-01")
+		if _, err := a.CopyNode(d.Parents()[0].ID, nil, CopyOptions{}); err == nil {
+			t.Errorf("date node copied")
+		}
+		if _, err := a.CopyNode(root.ID, "nosuchalias", CopyOptions{}); err == nil {
+			t.Errorf("node copied under a nonexistent parent")
+		}
+	})
+}
+
+func TestMoveNode(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *App) {
+		p1, _ := a.AddRoot("Project 1")
+		task1, _ := a.AddChild("Task 1", p1.ID)
+		task2, _ := a.AddChild("Task 2", p1.ID)
+		p2, _ := a.AddRoot("Project 2")
+		task3, _ := a.AddChild("Task 3", p2.ID)
+		a.CheckNode(task1.ID)
+		a.CheckNode(task3.ID)
+
+		if err := a.MoveNode(task2.ID, nil, p2.ID); err != nil {
+			t.Fatalf("couldn't move node: %v", err)
+		}
+		g, _ := a.GetGraph(task2.ID)
+		if len(g.Parents()) != 1 || g.Parents()[0].ID != p2.ID {
+			t.Fatalf("node wasn't moved to the new parent")
+		}
+		if n, _ := a.GetNode(p1.ID); !n.IsCompleted() {
+			t.Errorf("old parent's status wasn't updated")
+		}
+		if n, _ := a.GetNode(p2.ID); n.IsCompleted() {
+			t.Errorf("new parent's status wasn't updated")
+		}
+
+		// Linking before unlinking would create a diamond.
+		sub, _ := a.AddChild("Subtask", task3.ID)
+		if err := a.MoveNode(sub.ID, task3.ID, p2.ID); err != nil {
+			t.Errorf("couldn't move node to its grandparent: %v", err)
+		}
+
+		a.LinkNodes(p1.ID, sub.ID)
+		if err := a.MoveNode(sub.ID, nil, task1.ID); err == nil {
+			t.Errorf("node with two parents moved without giving the old one")
+		}
+		if err := a.MoveNode(p2.ID, p1.ID, sub.ID); err == nil {
+			t.Errorf("nonexistent link replaced")
+		}
+		if err := a.MoveNode(task2.ID, p2.ID, task2.ID); err == nil {
+			t.Errorf("cycle created")
+		}
+		if g, _ := a.GetGraph(task2.ID); len(g.Parents()) != 1 {
+			t.Errorf("failed move wasn't rolled back")
+		}
+
+		task, _ := a.AddChild("task", "2020-01-01")
+		if err := a.MoveNode(task.ID, "2020-01-01", "2020-01-02"); err != nil {
+			t.Fatalf("couldn't move node to date node: %v", err)
+		}
+		if n, _ := a.GetNodeByName("2020-01-01"); n != nil {
+			t.Errorf("empty date node wasn't deleted")
+		}
+		if g, _ := a.GetGraph(task.ID); g.Parents()[0].Name != "2020-01-02" {
+			t.Errorf("node wasn't moved to the date node")
+		}
+	})
+}
+
+func TestCancelNode(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *App) {
+		root, _ := a.AddRoot("Project")
+		task1, _ := a.AddChild("Task 1", root.ID)
+		task2, _ := a.AddChild("Task 2", root.ID)
+		sub, _ := a.AddChild("Subtask", task2.ID)
+		a.CheckNode(task1.ID)
+
+		if err := a.CancelNode(task2.ID); err != nil {
+			t.Fatalf("couldn't cancel node: %v", err)
+		}
+		if n, _ := a.GetNode(sub.ID); !n.IsCancelled() {
+			t.Errorf("descendant wasn't cancelled")
+		}
+		if n, _ := a.GetNode(root.ID); !n.IsCompleted() {
+			t.Errorf("parent of resolved nodes wasn't completed")
+		}
+		if err := a.CancelNode(task1.ID); err == nil {
+			t.Errorf("completed node cancelled")
+		}
+
+		if err := a.UncancelNode(task2.ID); err != nil {
+			t.Fatalf("couldn't uncancel node: %v", err)
+		}
+		if n, _ := a.GetNode(sub.ID); n.IsCancelled() {
+			t.Errorf("descendant wasn't uncancelled")
+		}
+		if n, _ := a.GetNode(root.ID); n.IsCompleted() {
+			t.Errorf("parent's status wasn't updated")
+		}
+
+		a.CancelNode(sub.ID)
+		if n, _ := a.GetNode(task2.ID); !n.IsCompleted() {
+			t.Errorf("node with only cancelled children wasn't completed")
+		}
+
+		task3, _ := a.AddChild("Task 3", root.ID)
+		sub3, _ := a.AddChild("Subtask 3", task3.ID)
+		a.CancelNode(task3.ID)
+		a.CheckNode(task3.ID)
+		if n, _ := a.GetNode(task3.ID); n.IsCancelled() || !n.IsCompleted() {
+			t.Errorf("checked node is still cancelled")
+		}
+		if n, _ := a.GetNode(sub3.ID); !n.IsCancelled() {
+			t.Errorf("cancelled descendant was checked")
+		}
+	})
+}
+
+func TestNextNodes(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *App) {
+		root, _ := a.AddRoot("Release")
+		build, _ := a.AddChild("Build", root.ID)
+		ship, _ := a.AddChild("Ship", root.ID)
+		announce, _ := a.AddChild("Announce", ship.ID)
+		other, _ := a.AddRoot("Other")
+
+		if _, ok := a.Store.(store.DependencyTracker); !ok {
+			if err := a.AddDependency(ship.ID, build.ID); err == nil {
+				t.Errorf("dependency added to a store without dependencies")
+			}
+			if nodes, _ := a.GetNextNodes(nil); len(nodes) != 3 {
+				t.Errorf("got %d next nodes, want all 3 leaves", len(nodes))
+			}
+			return
+		}
+
+		if err := a.AddDependency(ship.ID, build.ID); err != nil {
+			t.Fatalf("couldn't add dependency: %v", err)
+		}
+		if err := a.AddDependency("2020-01-01", build.ID); err == nil {
+			t.Errorf("dependency added to a date node")
+		}
+		if err := a.AddDependency(ship.ID, announce.ID); err == nil {
+			t.Errorf("node made to depend on its child")
+		}
+
+		// Announce is blocked through its parent.
+		nodes, err := a.GetNextNodes(nil)
+		if err != nil {
+			t.Fatalf("couldn't get next nodes: %v", err)
+		}
+		if len(nodes) != 2 || nodes[0].ID != build.ID || nodes[1].ID != other.ID {
+			t.Errorf("got %v, want the build and other nodes", nodes)
+		}
+		g, _ := a.GetGraph(root.ID)
+		blockers, _ := a.GetBlockers(g)
+		if ids := blockers[announce.ID]; len(ids) != 1 || ids[0] != build.ID {
+			t.Errorf("got blockers %v, want announce blocked by build", blockers)
+		}
+
+		a.CheckNode(build.ID)
+		nodes, _ = a.GetNextNodes(root.ID)
+		if len(nodes) != 1 || nodes[0].ID != announce.ID {
+			t.Errorf("got %v, want the unblocked node", nodes)
+		}
+
+		a.UncheckNode(build.ID)
+		if err := a.RemoveDependency(ship.ID, build.ID); err != nil {
+			t.Fatalf("couldn't remove dependency: %v", err)
+		}
+		if nodes, _ := a.GetNextNodes(root.ID); len(nodes) != 2 {
+			t.Errorf("got %d next nodes, want 2", len(nodes))
+		}
+
+		// A prerequisite that later becomes an ancestor doesn't block the node.
+		if err := a.AddDependency(other.ID, ship.ID); err != nil {
+			t.Fatalf("couldn't add dependency: %v", err)
+		}
+		if _, err := a.LinkNodes(other.ID, ship.ID); err != nil {
+			t.Fatalf("couldn't link nodes: %v", err)
+		}
+		if nodes, _ := a.GetNextNodes(root.ID); len(nodes) != 2 {
+			t.Errorf("got %d next nodes, want 2", len(nodes))
+		}
+	})
+}
+
+func TestProperties(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *App) {
+		root, _ := a.AddRoot("Project")
+		task1, _ := a.AddChild("Task 1", root.ID)
+		task2, _ := a.AddChild("Task 2", root.ID)
+
+		if _, ok := a.Store.(store.PropertyTracker); !ok {
+			if err := a.SetProperty(task1.ID, "owner", "alice"); err == nil {
+				t.Errorf("property set in a store without properties")
+			}
+			where := map[string]string{"owner": "alice"}
+			if _, err := a.FilterByProperties([]*multitree.Node{task1}, where); err == nil {
+				t.Errorf("nodes filtered by properties in a store without properties")
+			}
+			return
+		}
+
+		if err := a.SetProperty(task1.ID, "owner", "alice"); err != nil {
+			t.Fatalf("couldn't set property: %v", err)
+		}
+		a.SetProperty(task2.ID, "owner", "bob")
+		a.SetProperty(task2.ID, "ticket", "T-1")
+		if err := a.SetProperty(task1.ID, "bad key", "x"); err == nil {
+			t.Errorf("invalid key accepted")
+		}
+		if err := a.SetProperty("2020-01-01", "owner", "alice"); err == nil {
+			t.Errorf("property set on a date node")
+		}
+
+		g, _ := a.GetGraph(root.ID)
+		nodes, _ := a.FilterByProperties(g.Children(), map[string]string{"owner": "bob"})
+		if len(nodes) != 1 || nodes[0].ID != task2.ID {
+			t.Errorf("got %v, want the node owned by bob", nodes)
+		}
+		where := map[string]string{"owner": "alice", "ticket": "T-1"}
+		if nodes, _ := a.FilterByProperties(g.Children(), where); len(nodes) != 0 {
+			t.Errorf("got %v, want no nodes matching both properties", nodes)
+		}
+
+		if err := a.UnsetProperty(task2.ID, "ticket"); err != nil {
+			t.Fatalf("couldn't unset property: %v", err)
+		}
+		if props, _ := a.GetProperties(task2.ID); len(props) != 1 {
+			t.Errorf("got %d properties, want 1", len(props))
+		}
+	})
+}
+
+// TestConvertProperties fails if a graph with properties is converted into a
+// store that can't keep them.
+func TestConvertProperties(t *testing.T) {
+	s, cleanup := backends[0].open(t)
+	a := NewWithStore(s)
+	defer func() {
+		a.Close()
+		cleanup()
+	}()
+	node, _ := a.AddRoot("Project")
+	if err := a.SetProperty(node.ID, "owner", "alice"); err != nil {
+		t.Fatalf("couldn't set property: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "grit_test_text")
+	if err != nil {
+		t.Fatalf("couldn't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := a.Convert("text", dir); err == nil {
+		t.Fatalf("properties dropped and no error returned")
 	}
 
-	// Unchecking (3) should cause (1) and (4) to be unchecked as well; (2) should
-	// be left unchanged.
-	//
-	//   [ ] test (1)
-	//    ├──[x] test (2)
-	//    └──[ ] test (3)
-	//        └──[ ] test (4)
-	//
-	if err := a.UncheckNode(node3.ID); err != nil {
-		t.Fatalf("couldn't uncheck successor (3): %v", err)
+	a.UnsetProperty(node.ID, "owner")
+	if n, err := a.Convert("text", dir); err != nil || n != 1 {
+		t.Errorf("got %d, %v; want the node converted", n, err)
 	}
-	if root, err := a.GetGraph(node1.ID); err != nil {
-		t.Fatalf("couldn't get graph: %v", err)
-	} else {
-		if n := root.Get(node3.ID); n.IsCompleted() {
-			t.Fatalf("unchecking node had no effect: %s", n)
+}
+
+func TestCheckNodeAt(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *App) {
+		now := time.Now()
+		yesterday := now.AddDate(0, 0, -1).Format("2006-01-02")
+		y, _ := time.ParseInLocation("2006-01-02", yesterday, time.Local)
+
+		task, err := a.AddChild("Task", yesterday)
+		if err != nil {
+			t.Fatalf("couldn't create node: %v", err)
+		}
+		if err := a.CheckNodeAt(task.ID, y.Add(18*time.Hour)); err != nil {
+			t.Fatalf("couldn't check node: %v", err)
 		}
-		if root.IsCompleted() {
-			t.Fatal("node unchecked, but predecessor is checked")
+		for _, sel := range []interface{}{task.ID, yesterday} {
+			n, _ := a.GetNode(sel)
+			if !n.IsCompletedOnDate(yesterday, multitree.DayOffset) {
+				t.Errorf("(%v) wasn't completed on %s", sel, yesterday)
+			}
 		}
-		if n := root.Get(node2.ID); !n.IsCompleted() {
-			t.Fatalf("unchecking node changed node's sibling(!): %s", n)
+
+		if err := a.CheckNodeAt(task.ID, now.Add(time.Hour)); err == nil {
+			t.Errorf("node checked in the future")
+		}
+
+		date := now.AddDate(0, 0, -3).Format("2006-01-02")
+		other, _ := a.AddRoot("Other")
+		if err := a.CheckNodeOnDate(other.ID, date); err != nil {
+			t.Fatalf("couldn't check node: %v", err)
 		}
-		if n := root.Get(node4.ID); n.IsCompleted() {
-			t.Fatalf("node unchecked, but successor is checked: %s", n)
+		if n, _ := a.GetNode(other.ID); !n.IsCompletedOnDate(date, multitree.DayOffset) {
+			t.Errorf("node wasn't completed on %s", date)
+		}
+		future := now.AddDate(0, 0, 2).Format("2006-01-02")
+		if err := a.CheckNodeOnDate(other.ID, future); err == nil {
+			t.Errorf("node checked on a future date")
+		}
+	})
+}
+
+// TestCheckTimeOnDate checks the completion times given by CheckNodeOnDate,
+// in particular past midnight, before the day has started.
+func TestCheckTimeOnDate(t *testing.T) {
+	now, _ := time.ParseInLocation("2006-01-02 15:04", "2020-01-02 00:30", time.Local)
+	node := multitree.NewNode("test")
+
+	for _, date := range []string{"2020-01-01", "2019-12-31"} {
+		tm, err := checkTimeOnDate(date, now)
+		if err != nil {
+			t.Errorf("%s: %v", date, err)
+			continue
+		}
+		completed := tm.Unix()
+		node.Completed = &completed
+		if !node.IsCompletedOnDate(date, multitree.DayOffset) {
+			t.Errorf("%s: got %v, not within the day", date, tm)
+		}
+	}
+	if tm, _ := checkTimeOnDate("2020-01-01", now); !tm.Equal(now) {
+		t.Errorf("got %v for the current day, want now", tm)
+	}
+	for _, date := range []string{"2020-01-02", "2020-01-03"} {
+		if _, err := checkTimeOnDate(date, now); err == nil {
+			t.Errorf("node checked on %s, which hasn't started", date)
 		}
 	}
 }
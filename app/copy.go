@@ -0,0 +1,160 @@
+package app
+
+import (
+	"github.com/climech/grit/multitree"
+	"github.com/climech/grit/store"
+)
+
+// CopyOptions control what CopyNode copies.
+type CopyOptions struct {
+	// KeepStatus keeps the completion and cancellation state of the nodes.
+	// Otherwise, the copies are inactive.
+	KeepStatus bool
+
+	// KeepLinks links the copies to the shared nodes of the tree, i.e. the nodes
+	// that also have parents outside of it, instead of copying them, wherever
+	// the multitree rules allow it.
+	KeepLinks bool
+
+	// Incomplete leaves out the completed and cancelled branches.
+	Incomplete bool
+}
+
+// sharedLink is a link to a shared node that was left out of a copy.
+type sharedLink struct {
+	origin *multitree.Node // the copy of the parent
+	dest   *multitree.Node // the shared node, with its children
+}
+
+// prepareCopy strips the tree of the nodes that shouldn't be copied, and clears
+// the fields that shouldn't be. It returns the links to the shared nodes that
+// were left out of the tree, which have yet to be linked or copied. The
+// original nodes are looked up by ID in originals.
+func prepareCopy(tree *multitree.Node, originals map[int64]*multitree.Node,
+	opts CopyOptions) []sharedLink {
+
+	var links []sharedLink
+	var prune func(*multitree.Node)
+	prune = func(n *multitree.Node) {
+		children := append([]*multitree.Node(nil), n.Children()...)
+		for _, c := range children {
+			if opts.Incomplete && c.IsResolved() {
+				multitree.UnlinkNodes(n, c)
+			} else if opts.KeepLinks && len(originals[c.ID].Parents()) > 1 {
+				multitree.UnlinkNodes(n, c)
+				links = append(links, sharedLink{origin: n, dest: c})
+			} else {
+				prune(c)
+			}
+		}
+	}
+	prune(tree)
+
+	tree.TraverseDescendants(func(current *multitree.Node, _ func()) {
+		current.Alias = ""
+		if !opts.KeepStatus {
+			current.Completed = nil
+			current.Cancelled = nil
+		}
+	})
+	return links
+}
+
+// CopyNode makes a deep copy of the tree rooted at the node, and links parent
+// to its root, unless parent is nil, in which case the copy is a new root. The
+// names, notes, due dates and estimates of the nodes are kept, but not the
+// aliases. The copy is saved in a single step. It returns the ID of the new
+// root.
+func (a *App) CopyNode(selector, parent interface{}, opts CopyOptions) (int64, error) {
+	node, err := a.GetGraph(selector)
+	if err != nil {
+		return 0, err
+	}
+	if node == nil {
+		return 0, NewError(ErrNotFound, "node does not exist")
+	}
+	if node.IsDateNode() {
+		return 0, NewError(ErrForbidden, "date nodes cannot be copied")
+	}
+	if opts.Incomplete && node.IsResolved() {
+		return 0, NewError(ErrForbidden, "node is resolved, nothing to copy")
+	}
+
+	originals := make(map[int64]*multitree.Node)
+	for _, d := range node.Descendants() {
+		originals[d.ID] = d
+	}
+	tree := node.Tree()
+	links := prepareCopy(tree, originals, opts)
+	if err := validateTree(tree); err != nil {
+		return 0, err
+	}
+
+	// The copy is put together in memory, attached to the original graph, to
+	// find out which of the shared nodes it can link to. The new nodes are
+	// given negative IDs in the meantime.
+	var nextID int64
+	renumber := func(root *multitree.Node) {
+		root.TraverseDescendants(func(current *multitree.Node, _ func()) {
+			nextID--
+			current.ID = nextID
+		})
+	}
+	renumber(tree)
+
+	var parentID int64
+	var parentNode *multitree.Node
+	var date string
+	if parent != nil {
+		if parentID, err = a.selectorToID(parent); err != nil {
+			return 0, NewError(ErrInvalidSelector, err.Error())
+		}
+		if parentID == 0 {
+			// The date node doesn't exist yet.
+			if s, ok := parent.(string); ok && multitree.ValidateDateNodeName(s) == nil {
+				date = s
+				parentNode = multitree.NewNode(date)
+				nextID--
+				parentNode.ID = nextID
+			}
+		} else if parentNode = node.Get(parentID); parentNode == nil {
+			if parentNode, err = a.Store.GetGraph(parentID); err != nil {
+				return 0, err
+			}
+		}
+		if parentNode == nil {
+			return 0, NewError(ErrNotFound, "parent does not exist")
+		}
+		multitree.LinkNodesUnchecked(parentNode, tree)
+	}
+
+	var shared []store.SharedLink
+	for len(links) > 0 {
+		l := links[0]
+		links = links[1:]
+		if err := multitree.LinkNodes(l.origin, originals[l.dest.ID]); err == nil {
+			shared = append(shared, store.SharedLink{Origin: l.origin, DestID: l.dest.ID})
+			continue
+		}
+		// The link would break the multitree, so the node is copied after all.
+		links = append(links, prepareCopy(l.dest, originals, opts)...)
+		renumber(l.dest)
+		multitree.LinkNodesUnchecked(l.origin, l.dest)
+	}
+
+	// Only the copy itself is saved; the links to the existing nodes are
+	// created along with it.
+	for _, l := range shared {
+		multitree.UnlinkNodes(l.Origin, originals[l.DestID])
+	}
+	if parentNode != nil {
+		multitree.UnlinkNodes(parentNode, tree)
+	}
+	switch {
+	case parentNode == nil:
+		return a.Store.CreateTree(tree, 0, shared...)
+	case date != "":
+		return a.Store.CreateTreeAsChildOfDateNode(date, tree, shared...)
+	}
+	return a.Store.CreateTree(tree, parentID, shared...)
+}
@@ -0,0 +1,225 @@
+package app
+
+import (
+	"sort"
+
+	"github.com/climech/grit/multitree"
+	"github.com/climech/grit/store"
+)
+
+func (a *App) dependencyTracker() (store.DependencyTracker, error) {
+	if t, ok := a.Store.(store.DependencyTracker); ok {
+		return t, nil
+	}
+	return nil, errNotSupported("dependencies")
+}
+
+// AddDependency records that the node can't be started until the prerequisite
+// is resolved, i.e. completed or cancelled.
+func (a *App) AddDependency(selector, prereq interface{}) error {
+	t, err := a.dependencyTracker()
+	if err != nil {
+		return err
+	}
+	node, err := a.GetNode(selector)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		return NewError(ErrNotFound, "node does not exist")
+	}
+	p, err := a.GetNode(prereq)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return NewError(ErrNotFound, "prerequisite does not exist")
+	}
+	if node.IsDateNode() || p.IsDateNode() {
+		return NewError(ErrForbidden, "date nodes cannot have dependencies")
+	}
+	_, err = t.AddDependency(node.ID, p.ID)
+	return err
+}
+
+// RemoveDependency removes the dependency of the node on the prerequisite.
+func (a *App) RemoveDependency(selector, prereq interface{}) error {
+	t, err := a.dependencyTracker()
+	if err != nil {
+		return err
+	}
+	nodeID, err := a.selectorToID(selector)
+	if err != nil {
+		return NewError(ErrInvalidSelector, err.Error())
+	}
+	prereqID, err := a.selectorToID(prereq)
+	if err != nil {
+		return NewError(ErrInvalidSelector, err.Error())
+	}
+	if nodeID == 0 || prereqID == 0 {
+		return NewError(ErrNotFound, "dependency does not exist")
+	}
+	return t.DeleteDependency(nodeID, prereqID)
+}
+
+// GetPrerequisites returns the nodes that the node depends on directly, as
+// members of their multitrees, sorted by ID. Stores that don't support
+// dependencies have none.
+func (a *App) GetPrerequisites(selector interface{}) ([]*multitree.Node, error) {
+	id, err := a.selectorToID(selector)
+	if err != nil {
+		return nil, NewError(ErrInvalidSelector, err.Error())
+	}
+	t, ok := a.Store.(store.DependencyTracker)
+	if !ok || id == 0 {
+		return nil, nil
+	}
+	deps, err := t.GetDependencies()
+	if err != nil {
+		return nil, err
+	}
+	var prereqs []*multitree.Node
+	for _, d := range deps {
+		if d.NodeID != id {
+			continue
+		}
+		p, err := a.Store.GetGraph(d.PrereqID)
+		if err != nil {
+			return nil, err
+		}
+		if p != nil {
+			prereqs = append(prereqs, p)
+		}
+	}
+	multitree.SortNodesByID(prereqs)
+	return prereqs, nil
+}
+
+// unresolvedPrereqs maps the IDs of the nodes that have unresolved
+// prerequisites to the IDs of those prerequisites.
+func (a *App) unresolvedPrereqs() (map[int64][]int64, error) {
+	t, ok := a.Store.(store.DependencyTracker)
+	if !ok {
+		return nil, nil
+	}
+	deps, err := t.GetDependencies()
+	if err != nil {
+		return nil, err
+	}
+	resolved := make(map[int64]bool)
+	prereqs := make(map[int64][]int64)
+	for _, d := range deps {
+		done, ok := resolved[d.PrereqID]
+		if !ok {
+			p, err := a.Store.GetNode(d.PrereqID)
+			if err != nil {
+				return nil, err
+			}
+			done = p == nil || p.IsResolved()
+			resolved[d.PrereqID] = done
+		}
+		if !done {
+			prereqs[d.NodeID] = append(prereqs[d.NodeID], d.PrereqID)
+		}
+	}
+	return prereqs, nil
+}
+
+// blockersOf returns the unresolved prerequisites of the node and of its
+// ancestors, which hold up the node as well, sorted by ID. The node itself and
+// its ancestors are never counted, even if they were linked after the
+// dependency was added, as they can't be resolved before the node is.
+func blockersOf(node *multitree.Node, prereqs map[int64][]int64) []int64 {
+	lineage := append([]*multitree.Node{node}, node.Ancestors()...)
+	seen := make(map[int64]bool)
+	for _, n := range lineage {
+		seen[n.ID] = true
+	}
+	var ids []int64
+	for _, n := range lineage {
+		for _, id := range prereqs[n.ID] {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// GetBlockers maps the IDs of the unresolved leaves of the node's multitree
+// that are blocked to the IDs of the unresolved prerequisites holding them up,
+// either their own or their ancestors'.
+func (a *App) GetBlockers(node *multitree.Node) (map[int64][]int64, error) {
+	prereqs, err := a.unresolvedPrereqs()
+	if err != nil {
+		return nil, err
+	}
+	blockers := make(map[int64][]int64)
+	if len(prereqs) == 0 {
+		return blockers, nil
+	}
+	for _, leaf := range node.LeavesAll() {
+		if leaf.IsResolved() {
+			continue
+		}
+		if ids := blockersOf(leaf, prereqs); len(ids) > 0 {
+			blockers[leaf.ID] = ids
+		}
+	}
+	return blockers, nil
+}
+
+// GetNextNodes returns the unresolved leaves that aren't blocked by any
+// dependencies, i.e. the tasks that can be worked on next. If selector is nil,
+// all multitrees are searched; otherwise, only the node's descendants. The
+// nodes are returned as members of their multitrees, sorted by ID.
+func (a *App) GetNextNodes(selector interface{}) ([]*multitree.Node, error) {
+	var candidates []*multitree.Node
+	if selector == nil {
+		roots, err := a.Store.GetRoots()
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[int64]bool)
+		for _, r := range roots {
+			if seen[r.ID] {
+				continue
+			}
+			g, err := a.Store.GetGraph(r.ID)
+			if err != nil {
+				return nil, err
+			}
+			for _, n := range g.All() {
+				seen[n.ID] = true
+			}
+			candidates = append(candidates, g.LeavesAll()...)
+		}
+	} else {
+		node, err := a.GetGraph(selector)
+		if err != nil {
+			return nil, err
+		}
+		if node == nil {
+			return nil, NewError(ErrNotFound, "node does not exist")
+		}
+		candidates = node.Leaves()
+	}
+
+	prereqs, err := a.unresolvedPrereqs()
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*multitree.Node
+	for _, n := range candidates {
+		if n.ID == 0 || n.IsDateNode() || n.IsResolved() {
+			continue
+		}
+		if len(blockersOf(n, prereqs)) == 0 {
+			nodes = append(nodes, n)
+		}
+	}
+	multitree.SortNodesByID(nodes)
+	return nodes, nil
+}
@@ -0,0 +1,49 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/climech/grit/store"
+)
+
+// Export returns a lossless snapshot of the database.
+func (a *App) Export() (*store.Dump, error) {
+	return a.Store.Export()
+}
+
+// Import validates the dump and saves it. An empty database is restored
+// exactly; otherwise, the nodes are given new IDs. It returns a map of the
+// dumped node IDs to the new ones.
+func (a *App) Import(dump *store.Dump) (map[int64]int64, error) {
+	if err := dump.Validate(); err != nil {
+		return nil, err
+	}
+	return a.Store.Import(dump)
+}
+
+// Convert copies the whole graph into the store at path, which is opened with
+// the given backend. The destination must be empty. It returns the number of
+// nodes copied.
+func (a *App) Convert(backend, path string) (int, error) {
+	dump, err := a.Store.Export()
+	if err != nil {
+		return 0, err
+	}
+	dst, err := OpenStore(backend, path, Options{})
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+
+	existing, err := dst.Export()
+	if err != nil {
+		return 0, err
+	}
+	if len(existing.Nodes) > 0 {
+		return 0, NewError(ErrForbidden, fmt.Sprintf("%s is not empty", path))
+	}
+	if _, err := dst.Import(dump); err != nil {
+		return 0, err
+	}
+	return len(dump.Nodes), nil
+}
@@ -10,6 +10,8 @@ const (
 	ErrForbidden
 	ErrInvalidSelector
 	ErrInvalidName
+	ErrNotSupported
+	ErrInvalidValue
 )
 
 type AppError struct {
@@ -0,0 +1,118 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/climech/grit/multitree"
+	"github.com/climech/grit/store"
+)
+
+func (a *App) propertyTracker() (store.PropertyTracker, error) {
+	if t, ok := a.Store.(store.PropertyTracker); ok {
+		return t, nil
+	}
+	return nil, errNotSupported("properties")
+}
+
+// ParseProperty splits an assignment such as "owner=alice" into the key and
+// the value, and validates them.
+func ParseProperty(s string) (string, string, error) {
+	i := strings.Index(s, "=")
+	if i == -1 {
+		return "", "", fmt.Errorf("invalid property: %q (want key=value)", s)
+	}
+	key, value := s[:i], s[i+1:]
+	if err := multitree.ValidatePropertyKey(key); err != nil {
+		return "", "", err
+	}
+	if err := multitree.ValidatePropertyValue(value); err != nil {
+		return "", "", err
+	}
+	return key, value, nil
+}
+
+// SetProperty sets the node's property, replacing the previous value.
+func (a *App) SetProperty(selector interface{}, key, value string) error {
+	t, err := a.propertyTracker()
+	if err != nil {
+		return err
+	}
+	if err := multitree.ValidatePropertyKey(key); err != nil {
+		return NewError(ErrInvalidName, err.Error())
+	}
+	if err := multitree.ValidatePropertyValue(value); err != nil {
+		return NewError(ErrInvalidValue, err.Error())
+	}
+	node, err := a.GetNode(selector)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		return NewError(ErrNotFound, "node does not exist")
+	}
+	if node.IsDateNode() {
+		return NewError(ErrForbidden, "date nodes cannot have properties")
+	}
+	return t.SetProperty(node.ID, key, value)
+}
+
+// UnsetProperty removes the node's property.
+func (a *App) UnsetProperty(selector interface{}, key string) error {
+	t, err := a.propertyTracker()
+	if err != nil {
+		return err
+	}
+	id, err := a.selectorToID(selector)
+	if err != nil {
+		return NewError(ErrInvalidSelector, err.Error())
+	}
+	if id == 0 {
+		return NewError(ErrNotFound, "property does not exist")
+	}
+	return t.DeleteProperty(id, key)
+}
+
+// GetProperties returns the node's properties, sorted by key. Stores that
+// don't support properties have none.
+func (a *App) GetProperties(selector interface{}) ([]*store.Property, error) {
+	id, err := a.selectorToID(selector)
+	if err != nil {
+		return nil, NewError(ErrInvalidSelector, err.Error())
+	}
+	t, ok := a.Store.(store.PropertyTracker)
+	if !ok || id == 0 {
+		return nil, nil
+	}
+	return t.GetProperties(id)
+}
+
+// FilterByProperties returns the nodes that have all the given properties,
+// keeping their order. A nil or empty map matches every node, even in stores
+// that don't support properties.
+func (a *App) FilterByProperties(nodes []*multitree.Node, where map[string]string) ([]*multitree.Node, error) {
+	if len(where) == 0 {
+		return nodes, nil
+	}
+	t, err := a.propertyTracker()
+	if err != nil {
+		return nil, err
+	}
+	props, err := t.GetProperties(0)
+	if err != nil {
+		return nil, err
+	}
+	matches := make(map[int64]int) // node ID -> number of matching properties
+	for _, p := range props {
+		if value, ok := where[p.Key]; ok && value == p.Value {
+			matches[p.NodeID]++
+		}
+	}
+	var filtered []*multitree.Node
+	for _, n := range nodes {
+		if matches[n.ID] == len(where) {
+			filtered = append(filtered, n)
+		}
+	}
+	return filtered, nil
+}
@@ -0,0 +1,347 @@
+package app
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/climech/grit/multitree"
+	"github.com/climech/grit/store"
+)
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// RecurrenceCatchUpDays is the number of days, counting today, for which
+// SyncRecurrences creates missed occurrences. Older ones are skipped, so that
+// a rule that hasn't been synced in a long time doesn't flood the date nodes.
+const RecurrenceCatchUpDays = 7
+
+// recurrenceRule is a parsed recurrence rule. Exactly one of the fields is set.
+type recurrenceRule struct {
+	interval int     // every N days, counting from the start
+	weekdays [7]bool // weekly, on the given days
+	monthDay int     // monthly, on the given day
+	workdays bool    // Monday to Friday
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	s = strings.ToLower(s)
+	for i, name := range weekdayNames {
+		full := strings.ToLower(time.Weekday(i).String())
+		if s == name || s == full {
+			return time.Weekday(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid weekday: %s", s)
+}
+
+// parseRecurrenceRule parses one of the following rules. Weekly and monthly
+// rules without days repeat on the start date's weekday or day of the month.
+//
+//	daily
+//	weekdays
+//	every N days
+//	weekly [on DAY[,DAY...]]
+//	monthly [on [day] N]
+func parseRecurrenceRule(s string, start time.Time) (*recurrenceRule, error) {
+	invalid := fmt.Errorf("invalid rule: %s", s)
+	words := strings.Fields(strings.ToLower(s))
+	if len(words) == 0 {
+		return nil, invalid
+	}
+	r := &recurrenceRule{}
+	args := words[1:]
+	if len(args) > 0 && (words[0] == "weekly" || words[0] == "monthly") {
+		if args[0] != "on" {
+			return nil, invalid
+		}
+		args = args[1:]
+		if len(args) == 0 {
+			return nil, invalid
+		}
+	}
+
+	switch words[0] {
+	case "daily":
+		if len(args) != 0 {
+			return nil, invalid
+		}
+		r.interval = 1
+	case "weekdays":
+		if len(args) != 0 {
+			return nil, invalid
+		}
+		r.workdays = true
+	case "every":
+		if len(args) != 2 || (args[1] != "days" && args[1] != "day") {
+			return nil, invalid
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid number of days: %s", args[0])
+		}
+		r.interval = n
+	case "weekly":
+		if len(args) == 0 {
+			r.weekdays[start.Weekday()] = true
+		}
+		for _, arg := range args {
+			for _, name := range strings.Split(arg, ",") {
+				if name == "" {
+					continue
+				}
+				day, err := parseWeekday(name)
+				if err != nil {
+					return nil, err
+				}
+				r.weekdays[day] = true
+			}
+		}
+	case "monthly":
+		r.monthDay = start.Day()
+		if len(args) > 0 && args[0] == "day" {
+			args = args[1:]
+		}
+		if len(args) > 1 || (len(args) == 0 && len(words) > 1) {
+			return nil, invalid
+		}
+		if len(args) == 1 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 || n > 31 {
+				return nil, fmt.Errorf("invalid day of the month: %s", args[0])
+			}
+			r.monthDay = n
+		}
+	default:
+		return nil, invalid
+	}
+	return r, nil
+}
+
+// String returns the rule in its canonical form, which parses back to the
+// same rule regardless of the start date.
+func (r *recurrenceRule) String() string {
+	switch {
+	case r.interval == 1:
+		return "daily"
+	case r.interval > 1:
+		return fmt.Sprintf("every %d days", r.interval)
+	case r.workdays:
+		return "weekdays"
+	case r.monthDay != 0:
+		return fmt.Sprintf("monthly on %d", r.monthDay)
+	}
+	var days []string
+	for _, d := range []time.Weekday{time.Monday, time.Tuesday, time.Wednesday,
+		time.Thursday, time.Friday, time.Saturday, time.Sunday} {
+		if r.weekdays[d] {
+			days = append(days, weekdayNames[d])
+		}
+	}
+	return "weekly on " + strings.Join(days, ",")
+}
+
+// matches returns true if the rule, applied from the start date, matches the
+// date. Monthly rules fall on the last day of the month in months that are too
+// short.
+func (r *recurrenceRule) matches(date, start time.Time) bool {
+	if date.Before(start) {
+		return false
+	}
+	switch {
+	case r.interval > 0:
+		days := int(date.Sub(start).Hours()/24 + 0.5)
+		return days%r.interval == 0
+	case r.workdays:
+		return date.Weekday() != time.Saturday && date.Weekday() != time.Sunday
+	case r.monthDay != 0:
+		last := time.Date(date.Year(), date.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+		day := r.monthDay
+		if day > last {
+			day = last
+		}
+		return date.Day() == day
+	}
+	return r.weekdays[date.Weekday()]
+}
+
+// next returns the first date after the given one that the rule matches.
+func (r *recurrenceRule) next(after, start time.Time) time.Time {
+	date := after.AddDate(0, 0, 1)
+	if date.Before(start) {
+		date = start
+	}
+	for !r.matches(date, start) {
+		date = date.AddDate(0, 0, 1)
+	}
+	return date
+}
+
+func (a *App) recurrer() (store.Recurrer, error) {
+	if r, ok := a.Store.(store.Recurrer); ok {
+		return r, nil
+	}
+	return nil, errNotSupported("recurrence")
+}
+
+// AddRecurrence attaches a recurrence rule to the node, so that copies of it
+// and its descendants are created under the date nodes of the matching days,
+// starting from start ("YYYY-MM-DD"), or today if start is empty.
+func (a *App) AddRecurrence(selector interface{}, rule, start string) (*store.Recurrence, error) {
+	rec, err := a.recurrer()
+	if err != nil {
+		return nil, err
+	}
+	if start == "" {
+		start = multitree.Today()
+	}
+	startDate, err := time.Parse("2006-01-02", start)
+	if err != nil {
+		return nil, NewError(ErrInvalidValue, fmt.Sprintf("invalid date: %s", start))
+	}
+	r, err := parseRecurrenceRule(rule, startDate)
+	if err != nil {
+		return nil, NewError(ErrInvalidValue, err.Error())
+	}
+	node, err := a.GetNode(selector)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, NewError(ErrNotFound, "node does not exist")
+	}
+	if node.IsDateNode() {
+		return nil, NewError(ErrForbidden, "date nodes cannot recur")
+	}
+
+	recurrence := &store.Recurrence{
+		NodeID:   node.ID,
+		NodeName: node.Name,
+		Rule:     r.String(),
+		Start:    start,
+	}
+	if recurrence.ID, err = rec.AddRecurrence(recurrence); err != nil {
+		return nil, err
+	}
+	return recurrence, nil
+}
+
+// GetRecurrences returns all recurrence rules.
+func (a *App) GetRecurrences() ([]*store.Recurrence, error) {
+	rec, err := a.recurrer()
+	if err != nil {
+		return nil, err
+	}
+	return rec.GetRecurrences()
+}
+
+// RemoveRecurrence removes the recurrence rule. The nodes created so far are
+// left alone.
+func (a *App) RemoveRecurrence(id int64) error {
+	rec, err := a.recurrer()
+	if err != nil {
+		return err
+	}
+	return rec.DeleteRecurrence(id)
+}
+
+// NextOccurrence returns the first date after the last synced one that the
+// recurrence matches.
+func NextOccurrence(r *store.Recurrence) (string, error) {
+	start, err := time.Parse("2006-01-02", r.Start)
+	if err != nil {
+		return "", err
+	}
+	rule, err := parseRecurrenceRule(r.Rule, start)
+	if err != nil {
+		return "", err
+	}
+	after := start.AddDate(0, 0, -1)
+	if r.Synced != "" {
+		if after, err = time.Parse("2006-01-02", r.Synced); err != nil {
+			return "", err
+		}
+	}
+	return rule.next(after, start).Format("2006-01-02"), nil
+}
+
+// freshCopy returns a copy of the tree rooted at the node, made of new,
+// inactive nodes without aliases or due dates.
+func freshCopy(node *multitree.Node) *multitree.Node {
+	tree := node.Tree()
+	tree.TraverseDescendants(func(current *multitree.Node, _ func()) {
+		current.Alias = ""
+		current.Due = ""
+		current.Completed = nil
+		current.Cancelled = nil
+	})
+	return tree
+}
+
+// SyncRecurrences creates the occurrences of all recurrence rules that fall
+// between the last synced date and today, inclusive, and returns the roots of
+// the created trees as members of their multitrees. Occurrences missed while
+// grit wasn't run are created as well, as long as they fall within the last
+// RecurrenceCatchUpDays days. A date node that already has a child named like
+// the template is left alone, so that occurrences are never duplicated.
+//
+// Each occurrence is saved together with the rule's sync date, and the checks
+// for existing occurrences are made in the same step, so that an interrupted
+// sync picks up where it left off, and concurrent syncs don't collide.
+func (a *App) SyncRecurrences() ([]*multitree.Node, error) {
+	rec, err := a.recurrer()
+	if err != nil {
+		return nil, err
+	}
+	recurrences, err := rec.GetRecurrences()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	today := multitree.DateOf(now)
+	skipped := multitree.DateOf(now.AddDate(0, 0, -RecurrenceCatchUpDays))
+	var created []*multitree.Node
+	for _, r := range recurrences {
+		if r.Synced >= today {
+			continue
+		}
+		template, err := a.Store.GetGraph(r.NodeID)
+		if err != nil {
+			return created, err
+		}
+		if template == nil {
+			// The template is gone, but it may be restored from the trash.
+			continue
+		}
+		if r.Synced < skipped {
+			r.Synced = skipped
+		}
+		for {
+			date, err := NextOccurrence(r)
+			if err != nil {
+				return created, fmt.Errorf("recurrence %d: %v", r.ID, err)
+			}
+			if date > today {
+				break
+			}
+			id, err := rec.CreateOccurrence(r.ID, date, freshCopy(template))
+			if err != nil {
+				return created, err
+			}
+			if id != 0 {
+				node, err := a.Store.GetGraph(id)
+				if err != nil {
+					return created, err
+				}
+				created = append(created, node)
+			}
+			r.Synced = date
+		}
+		if err := rec.SetRecurrenceSynced(r.ID, today); err != nil {
+			return created, err
+		}
+	}
+	return created, nil
+}
@@ -0,0 +1,184 @@
+package app
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/climech/grit/multitree"
+)
+
+const (
+	templatesDirname  = "templates"
+	templateExtension = ".txt"
+)
+
+var (
+	templateNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,50}$`)
+
+	// templateVarRegexp matches placeholders such as "{{date}}".
+	templateVarRegexp = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_-]+)\s*\}\}`)
+)
+
+func ValidateTemplateName(name string) error {
+	if !templateNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid template name: %q", name)
+	}
+	return nil
+}
+
+// Templates is the collection of subtree templates, kept in the config
+// directory as files of tab-indented lines (see multitree.ImportTrees), so
+// that they can be edited by hand and shared between workspaces.
+type Templates struct {
+	dir string
+}
+
+// NewTemplates returns the collection of templates kept in configPath.
+func NewTemplates(configPath string) *Templates {
+	return &Templates{dir: filepath.Join(configPath, templatesDirname)}
+}
+
+func (t *Templates) path(name string) string {
+	return filepath.Join(t.dir, name+templateExtension)
+}
+
+// List returns the names of all templates, sorted.
+func (t *Templates) List() ([]string, error) {
+	files, err := ioutil.ReadDir(t.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		name := strings.TrimSuffix(f.Name(), templateExtension)
+		if !f.IsDir() && name != f.Name() && ValidateTemplateName(name) == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Read returns the contents of the named template.
+func (t *Templates) Read(name string) (string, error) {
+	if err := ValidateTemplateName(name); err != nil {
+		return "", NewError(ErrInvalidName, err.Error())
+	}
+	data, err := ioutil.ReadFile(t.path(name))
+	if os.IsNotExist(err) {
+		return "", NewError(ErrNotFound,
+			fmt.Sprintf("template %q does not exist", name))
+	}
+	return string(data), err
+}
+
+// Save captures the tree rooted at the node as a template. The names and notes
+// of the nodes are kept. An existing template is only replaced if overwrite is
+// true.
+func (t *Templates) Save(name string, node *multitree.Node, overwrite bool) error {
+	if err := ValidateTemplateName(name); err != nil {
+		return NewError(ErrInvalidName, err.Error())
+	}
+	if node.IsDateNode() {
+		return NewError(ErrForbidden, "date nodes cannot be saved as templates")
+	}
+	tree := node.Tree()
+	for _, n := range tree.All() {
+		// These would be read back as part of the indentation.
+		if strings.TrimSpace(n.Name) != n.Name {
+			return NewError(ErrInvalidName,
+				fmt.Sprintf("node name %q can't be saved in a template", n.Name))
+		}
+	}
+	if _, err := os.Stat(t.path(name)); err == nil && !overwrite {
+		return NewError(ErrForbidden, fmt.Sprintf("template %q already exists", name))
+	}
+	if err := os.MkdirAll(t.dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(t.path(name), []byte(multitree.FormatTree(tree)), 0644)
+}
+
+// Remove deletes the named template.
+func (t *Templates) Remove(name string) error {
+	if err := ValidateTemplateName(name); err != nil {
+		return NewError(ErrInvalidName, err.Error())
+	}
+	err := os.Remove(t.path(name))
+	if os.IsNotExist(err) {
+		return NewError(ErrNotFound, fmt.Sprintf("template %q does not exist", name))
+	}
+	return err
+}
+
+// expandVars replaces the placeholders in s with the values of the variables.
+func expandVars(s string, vars map[string]string) (string, error) {
+	var err error
+	expanded := templateVarRegexp.ReplaceAllStringFunc(s, func(match string) string {
+		key := templateVarRegexp.FindStringSubmatch(match)[1]
+		value, ok := vars[key]
+		if !ok && err == nil {
+			err = fmt.Errorf("undefined variable: %s", key)
+		}
+		return value
+	})
+	return expanded, err
+}
+
+// attachedDate returns the date of a tree attached to parent: the parent's own
+// date if it's a date node, or the earliest date node it can be reached from.
+// Root trees (nil parent) and trees outside of date nodes get today's date.
+func attachedDate(parent *multitree.Node) string {
+	var date string
+	if parent != nil {
+		for _, r := range parent.Roots() {
+			if r.IsDateNode() && (date == "" || r.Name < date) {
+				date = r.Name
+			}
+		}
+	}
+	if date == "" {
+		return multitree.Today()
+	}
+	return date
+}
+
+// Instantiate returns a fresh tree built from the named template, to be
+// attached to parent, or created as a root if parent is nil. Placeholders such
+// as "{{name}}" in the names and notes of the nodes are replaced with the
+// values of the variables. The variable "date" is set to the date the tree is
+// attached to (see attachedDate) unless it's given.
+func (t *Templates) Instantiate(name string, parent *multitree.Node, vars map[string]string) (*multitree.Node, error) {
+	text, err := t.Read(name)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := multitree.ImportTrees(strings.NewReader(text))
+	if err != nil {
+		return nil, fmt.Errorf("template %q: %v", name, err)
+	}
+	if len(roots) != 1 {
+		return nil, fmt.Errorf("template %q: got %d roots, want 1", name, len(roots))
+	}
+
+	all := map[string]string{"date": attachedDate(parent)}
+	for k, v := range vars {
+		all[k] = v
+	}
+	for _, n := range roots[0].All() {
+		if n.Name, err = expandVars(n.Name, all); err != nil {
+			return nil, NewError(ErrInvalidName, err.Error())
+		}
+		if n.Notes, err = expandVars(n.Notes, all); err != nil {
+			return nil, NewError(ErrInvalidName, err.Error())
+		}
+	}
+	return roots[0], nil
+}
@@ -0,0 +1,265 @@
+package app
+
+import (
+	"sort"
+	"time"
+
+	"github.com/climech/grit/multitree"
+	"github.com/climech/grit/store"
+)
+
+// TimeDay is the time tracked on a single day, broken down by node.
+type TimeDay struct {
+	Date  string // YYYY-MM-DD
+	Total time.Duration
+	Nodes []*TimeTotal
+}
+
+// TimeTotal is the time tracked on a node, including its descendants in the
+// report's tree.
+type TimeTotal struct {
+	NodeID   int64
+	NodeName string // empty if the node no longer exists
+	Time     time.Duration
+}
+
+func (a *App) timeTracker() (store.TimeTracker, error) {
+	if t, ok := a.Store.(store.TimeTracker); ok {
+		return t, nil
+	}
+	return nil, errNotSupported("time tracking")
+}
+
+// isReachableFrom returns true if the node is the date node or one of its
+// descendants.
+func isReachableFrom(node *multitree.Node, date string) bool {
+	if node.Name == date {
+		return true
+	}
+	for _, a := range node.Ancestors() {
+		if a.Name == date {
+			return true
+		}
+	}
+	return false
+}
+
+// StartTimer starts tracking the time spent on the node, and returns the new
+// entry along with the entry of the timer it stopped, if any. If link is true,
+// the node is linked from today's date node, unless it's already reachable
+// from it.
+func (a *App) StartTimer(selector interface{}, link bool) (*store.TimeEntry, *store.TimeEntry, error) {
+	t, err := a.timeTracker()
+	if err != nil {
+		return nil, nil, err
+	}
+	node, err := a.GetGraph(selector)
+	if err != nil {
+		return nil, nil, err
+	}
+	if node == nil {
+		return nil, nil, NewError(ErrNotFound, "node does not exist")
+	}
+	if node.IsDateNode() {
+		return nil, nil, NewError(ErrForbidden, "date nodes cannot be timed")
+	}
+
+	now := time.Now()
+	var date string
+	if today := multitree.DateOf(now); link && !isReachableFrom(node, today) {
+		date = today
+	}
+
+	stopped, err := t.GetRunningTimer()
+	if err != nil {
+		return nil, nil, err
+	}
+	entry, err := t.StartTimer(node.ID, now.Unix(), date)
+	if err != nil {
+		return nil, nil, err
+	}
+	if stopped != nil {
+		stopped.Stop = entry.Start
+	}
+	return entry, stopped, nil
+}
+
+// StopTimer stops the running timer, and returns its entry, or nil if no timer
+// was running.
+func (a *App) StopTimer() (*store.TimeEntry, error) {
+	t, err := a.timeTracker()
+	if err != nil {
+		return nil, err
+	}
+	return t.StopTimer(time.Now().Unix())
+}
+
+// GetRunningTimer returns the entry of the running timer, or nil if there
+// isn't one.
+func (a *App) GetRunningTimer() (*store.TimeEntry, error) {
+	t, err := a.timeTracker()
+	if err != nil {
+		return nil, err
+	}
+	return t.GetRunningTimer()
+}
+
+// splitByDay calls f with the parts of the period between start and stop that
+// fall on each day, in local time. Days start multitree.DayOffset hours after
+// midnight, like the date nodes.
+func splitByDay(start, stop time.Time, f func(date string, d time.Duration)) {
+	for start.Before(stop) {
+		date := multitree.DateOf(start)
+		day, _ := time.ParseInLocation("2006-01-02", date, start.Location())
+		end := time.Date(day.Year(), day.Month(), day.Day()+1, multitree.DayOffset,
+			0, 0, 0, start.Location())
+		if end.After(stop) {
+			end = stop
+		}
+		f(date, end.Sub(start))
+		start = end
+	}
+}
+
+// topLevelNode returns the node that the time tracked on n is rolled up into
+// when all nodes are reported. That's the root of n's multitree, not counting
+// date nodes, which only group the tasks planned for a day. If n has several
+// roots, the one with the lowest ID is used, so that the time is counted once.
+func topLevelNode(n *multitree.Node) *multitree.Node {
+	var top, planned *multitree.Node
+	for _, path := range n.Paths() {
+		if path[0].IsDateNode() && len(path) > 1 {
+			if planned == nil || path[1].ID < planned.ID {
+				planned = path[1]
+			}
+		} else if top == nil || path[0].ID < top.ID {
+			top = path[0]
+		}
+	}
+	if top == nil {
+		// Tasks that are only linked from date nodes stand for themselves.
+		return planned
+	}
+	return top
+}
+
+// GetTimeReport returns the time tracked between since and until, grouped by
+// the day it was tracked on. Zero values of since and until are ignored, and
+// running timers count up to now.
+//
+// If selector is nil, the time is rolled up into the top-level nodes, each of
+// which stands for its own multitree (see topLevelNode). Otherwise, only the
+// time tracked on the selected node and its descendants is counted, and it's
+// rolled up into the node's children. Time tracked on the node itself is
+// listed separately. Subtrees of a node never overlap in a multitree, so
+// nothing is counted twice.
+func (a *App) GetTimeReport(selector interface{}, since, until time.Time) ([]*TimeDay, error) {
+	t, err := a.timeTracker()
+	if err != nil {
+		return nil, err
+	}
+
+	// groups maps the IDs of the timed nodes to the nodes they're reported
+	// under, unless all nodes are reported.
+	var groups map[int64]*multitree.Node
+	if selector != nil {
+		node, err := a.GetGraph(selector)
+		if err != nil {
+			return nil, err
+		}
+		if node == nil {
+			return nil, NewError(ErrNotFound, "node does not exist")
+		}
+		groups = map[int64]*multitree.Node{node.ID: node}
+		for _, c := range node.Children() {
+			groups[c.ID] = c
+			for _, d := range c.Descendants() {
+				groups[d.ID] = c
+			}
+		}
+	}
+
+	var from, to int64
+	if !since.IsZero() {
+		from = since.Unix()
+	}
+	if !until.IsZero() {
+		to = until.Unix()
+	}
+	entries, err := t.GetTimeEntries(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	if groups == nil {
+		groups = make(map[int64]*multitree.Node)
+		for _, e := range entries {
+			if _, ok := groups[e.NodeID]; ok {
+				continue
+			}
+			node, err := a.Store.GetGraph(e.NodeID)
+			if err != nil {
+				return nil, err
+			}
+			if node == nil {
+				// The node has been deleted, report it under its old name.
+				node = &multitree.Node{ID: e.NodeID, Name: e.NodeName}
+			}
+			groups[e.NodeID] = topLevelNode(node)
+		}
+	}
+
+	now := time.Now()
+	days := make(map[string]*TimeDay)
+	totals := make(map[string]map[int64]*TimeTotal)
+	for _, e := range entries {
+		group, ok := groups[e.NodeID]
+		if !ok {
+			continue
+		}
+		id, name := group.ID, group.Name
+
+		start, stop := time.Unix(e.Start, 0), now
+		if e.Stop != 0 {
+			stop = time.Unix(e.Stop, 0)
+		}
+		if !since.IsZero() && start.Before(since) {
+			start = since
+		}
+		if !until.IsZero() && stop.After(until) {
+			stop = until
+		}
+
+		splitByDay(start, stop, func(date string, d time.Duration) {
+			day, ok := days[date]
+			if !ok {
+				day = &TimeDay{Date: date}
+				days[date] = day
+				totals[date] = make(map[int64]*TimeTotal)
+			}
+			tt, ok := totals[date][id]
+			if !ok {
+				tt = &TimeTotal{NodeID: id, NodeName: name}
+				totals[date][id] = tt
+				day.Nodes = append(day.Nodes, tt)
+			}
+			tt.Time += d
+			day.Total += d
+		})
+	}
+
+	var report []*TimeDay
+	for _, day := range days {
+		sort.Slice(day.Nodes, func(i, j int) bool {
+			if day.Nodes[i].Time != day.Nodes[j].Time {
+				return day.Nodes[i].Time > day.Nodes[j].Time
+			}
+			return day.Nodes[i].NodeID < day.Nodes[j].NodeID
+		})
+		report = append(report, day)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		return report[i].Date < report[j].Date
+	})
+	return report, nil
+}
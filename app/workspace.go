@@ -0,0 +1,167 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+const (
+	// DefaultWorkspace is the implicit workspace backed by graph.db in the
+	// config directory. It always exists and cannot be removed.
+	DefaultWorkspace = "default"
+
+	workspacesFilename = "workspaces.json"
+	defaultDBFilename  = "graph.db"
+)
+
+var workspaceNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,50}$`)
+
+func ValidateWorkspaceName(name string) error {
+	if !workspaceNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid workspace name: %q", name)
+	}
+	return nil
+}
+
+// Workspace maps a name to a database file.
+type Workspace struct {
+	Name string
+	Path string
+}
+
+// Workspaces is the registry of named workspaces, persisted as JSON in the
+// config directory.
+type Workspaces struct {
+	// Active is the name of the workspace used when no other is selected. An
+	// empty string means the default workspace.
+	Active string `json:"active,omitempty"`
+
+	// Paths maps workspace names to database paths.
+	Paths map[string]string `json:"workspaces"`
+
+	configPath string
+}
+
+// LoadWorkspaces reads the registry from configPath. A missing registry is not
+// an error -- an empty one is returned.
+func LoadWorkspaces(configPath string) (*Workspaces, error) {
+	ws := &Workspaces{Paths: make(map[string]string), configPath: configPath}
+	data, err := ioutil.ReadFile(filepath.Join(configPath, workspacesFilename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ws, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, ws); err != nil {
+		return nil, fmt.Errorf("couldn't parse %s: %v", workspacesFilename, err)
+	}
+	if ws.Paths == nil {
+		ws.Paths = make(map[string]string)
+	}
+	return ws, nil
+}
+
+// Save writes the registry back to the config directory.
+func (ws *Workspaces) Save() error {
+	data, err := json.MarshalIndent(ws, "", "  ")
+	if err != nil {
+		return err
+	}
+	fp := filepath.Join(ws.configPath, workspacesFilename)
+	return ioutil.WriteFile(fp, append(data, '\n'), 0644)
+}
+
+// ActiveName returns the name of the active workspace.
+func (ws *Workspaces) ActiveName() string {
+	if ws.Active == "" {
+		return DefaultWorkspace
+	}
+	return ws.Active
+}
+
+// Path returns the database path of the named workspace.
+func (ws *Workspaces) Path(name string) (string, error) {
+	if name == DefaultWorkspace {
+		return filepath.Join(ws.configPath, defaultDBFilename), nil
+	}
+	fp, ok := ws.Paths[name]
+	if !ok {
+		return "", NewError(ErrNotFound,
+			fmt.Sprintf("workspace %q does not exist", name))
+	}
+	return fp, nil
+}
+
+// List returns all workspaces sorted by name, starting with the default one.
+func (ws *Workspaces) List() []*Workspace {
+	names := make([]string, 0, len(ws.Paths))
+	for name := range ws.Paths {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	names = append([]string{DefaultWorkspace}, names...)
+
+	list := make([]*Workspace, 0, len(names))
+	for _, name := range names {
+		fp, _ := ws.Path(name)
+		list = append(list, &Workspace{Name: name, Path: fp})
+	}
+	return list
+}
+
+// Create registers a new workspace. If dbPath is empty, the database is placed
+// in the config directory under the workspace's name.
+func (ws *Workspaces) Create(name, dbPath string) (*Workspace, error) {
+	if err := ValidateWorkspaceName(name); err != nil {
+		return nil, NewError(ErrInvalidName, err.Error())
+	}
+	if _, err := ws.Path(name); err == nil {
+		return nil, NewError(ErrForbidden,
+			fmt.Sprintf("workspace %q already exists", name))
+	}
+	if dbPath == "" {
+		dbPath = filepath.Join(ws.configPath, name+".db")
+	}
+	abs, err := filepath.Abs(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	ws.Paths[name] = abs
+	return &Workspace{Name: name, Path: abs}, nil
+}
+
+// Use makes the named workspace active.
+func (ws *Workspaces) Use(name string) error {
+	if _, err := ws.Path(name); err != nil {
+		return err
+	}
+	if name == DefaultWorkspace {
+		ws.Active = ""
+	} else {
+		ws.Active = name
+	}
+	return nil
+}
+
+// Remove unregisters the named workspace. The database file is left intact. If
+// the workspace was active, the default workspace becomes active.
+func (ws *Workspaces) Remove(name string) (*Workspace, error) {
+	if name == DefaultWorkspace {
+		return nil, NewError(ErrForbidden, "the default workspace cannot be removed")
+	}
+	fp, err := ws.Path(name)
+	if err != nil {
+		return nil, err
+	}
+	delete(ws.Paths, name)
+	if ws.Active == name {
+		ws.Active = ""
+	}
+	return &Workspace{Name: name, Path: fp}, nil
+}
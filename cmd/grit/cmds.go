@@ -1,8 +1,10 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"strconv"
 	"strings"
@@ -10,14 +12,16 @@ import (
 
 	"github.com/climech/grit/app"
 	"github.com/climech/grit/multitree"
+	"github.com/climech/grit/store"
 
 	"github.com/fatih/color"
 	cli "github.com/jawher/mow.cli"
+	"github.com/kirsle/configdir"
 )
 
 func cmdAdd(cmd *cli.Cmd) {
 	cmd.Spec = "[ -p=<predecessor> | -r ] NAME_PARTS..."
-	today := time.Now().Format("2006-01-02")
+	today := multitree.Today()
 
 	var (
 		nameParts = cmd.StringsArg("NAME_PARTS", nil,
@@ -29,7 +33,7 @@ func cmdAdd(cmd *cli.Cmd) {
 	)
 
 	cmd.Action = func() {
-		a, err := app.New()
+		a, err := app.New(appOptions())
 		if err != nil {
 			die(err)
 		}
@@ -60,13 +64,15 @@ func cmdAdd(cmd *cli.Cmd) {
 }
 
 func cmdTree(cmd *cli.Cmd) {
-	cmd.Spec = "[NODE]"
-	today := time.Now().Format("2006-01-02")
+	cmd.Spec = "[-p] [-c] [NODE]"
+	today := multitree.Today()
 	var (
-		selector = cmd.StringArg("NODE", today, "node selector")
+		selector      = cmd.StringArg("NODE", today, "node selector")
+		progress      = cmd.BoolOpt("p progress", false, "show the completed percentage of each node")
+		hideCancelled = cmd.BoolOpt("c hide-cancelled", false, "leave out cancelled nodes")
 	)
 	cmd.Action = func() {
-		a, err := app.New()
+		a, err := app.New(appOptions())
 		if err != nil {
 			die(err)
 		}
@@ -80,20 +86,31 @@ func cmdTree(cmd *cli.Cmd) {
 			die("Node does not exist")
 		}
 
+		blockers, err := a.GetBlockers(node)
+		if err != nil {
+			die(capitalize(err.Error()))
+		}
+		if *hideCancelled {
+			removeCancelled(node)
+		}
 		node.TraverseDescendants(func(current *multitree.Node, _ func()) {
 			multitree.SortNodesByName(current.Children())
 		})
-		fmt.Print(node.StringTree())
+		fmt.Print(node.StringTreeFunc(*progress, func(n *multitree.Node) string {
+			return describeBlockers(blockers[n.ID])
+		}))
 	}
 }
 
 func cmdList(cmd *cli.Cmd) {
-	cmd.Spec = "[NODE]"
+	cmd.Spec = "[-c] [--where=<key=value>...] [NODE]"
 	var (
-		selector = cmd.StringArg("NODE", "", "node selector")
+		selector      = cmd.StringArg("NODE", "", "node selector")
+		hideCancelled = cmd.BoolOpt("c hide-cancelled", false, "leave out cancelled nodes")
+		where         = cmd.StringsOpt("where", nil, "only list nodes with the property")
 	)
 	cmd.Action = func() {
-		a, err := app.New()
+		a, err := app.New(appOptions())
 		if err != nil {
 			die(err)
 		}
@@ -128,26 +145,91 @@ func cmdList(cmd *cli.Cmd) {
 			nodes = node.Children()
 		}
 
+		nodes, err = a.FilterByProperties(nodes, parseWhere(*where))
+		if err != nil {
+			die(capitalize(err.Error()))
+		}
 		multitree.SortNodesByName(nodes)
 		for _, n := range nodes {
+			if *hideCancelled && n.IsCancelled() {
+				continue
+			}
 			fmt.Println(n)
 		}
 	}
 }
 
+// parseWhere parses the key=value conditions given with --where.
+func parseWhere(conds []string) map[string]string {
+	where := make(map[string]string)
+	for _, c := range conds {
+		key, value, err := app.ParseProperty(c)
+		if err != nil {
+			die(capitalize(err.Error()))
+		}
+		where[key] = value
+	}
+	return where
+}
+
+// describeBlockers returns the marker of a blocked node, e.g. "blocked by (4),
+// (7)", or an empty string if there are no blockers.
+func describeBlockers(ids []int64) string {
+	if len(ids) == 0 {
+		return ""
+	}
+	strs := make([]string, len(ids))
+	for i, id := range ids {
+		strs[i] = fmt.Sprintf("(%d)", id)
+	}
+	return color.New(color.FgMagenta).Sprint("blocked by " + strings.Join(strs, ", "))
+}
+
+// removeCancelled unlinks the cancelled descendants of the node, so that they're
+// left out of its tree.
+func removeCancelled(node *multitree.Node) {
+	node.TraverseDescendants(func(current *multitree.Node, _ func()) {
+		for _, c := range append([]*multitree.Node(nil), current.Children()...) {
+			if c.IsCancelled() {
+				multitree.UnlinkNodes(current, c)
+			}
+		}
+	})
+}
+
 func cmdCheck(cmd *cli.Cmd) {
-	cmd.Spec = "NODE..."
+	cmd.Spec = "[ --at=<time> | --on=<date> ] NODE..."
 	var (
 		selectors = cmd.StringsArg("NODE", nil, "node selector(s)")
+		at        = cmd.StringOpt("at", "", "completion time, e.g. "+
+			"\"2021-01-01 18:00\", \"yesterday 18:00\" or \"09:30\"")
+		on = cmd.StringOpt("on", "", "completion date (YYYY-MM-DD)")
 	)
 	cmd.Action = func() {
-		a, err := app.New()
+		var t time.Time
+		date := *on
+		if *at != "" {
+			var err error
+			if t, date, err = parseCheckTime(*at, time.Now()); err != nil {
+				die(capitalize(err.Error()))
+			}
+		}
+
+		a, err := app.New(appOptions())
 		if err != nil {
 			die(err)
 		}
 		defer a.Close()
 		for _, sel := range *selectors {
-			if err := a.CheckNode(sel); err != nil {
+			switch {
+			case date != "":
+				err = a.CheckNodeOnDate(sel, date)
+			case !t.IsZero():
+				err = a.CheckNodeAt(sel, t)
+			default:
+				err = a.CheckNode(sel)
+			}
+			if err != nil {
 				dief("Couldn't check node: %v", err)
 			}
 		}
@@ -160,7 +242,7 @@ func cmdUncheck(cmd *cli.Cmd) {
 		selectors = cmd.StringsArg("NODE", nil, "node selector(s)")
 	)
 	cmd.Action = func() {
-		a, err := app.New()
+		a, err := app.New(appOptions())
 		if err != nil {
 			die(err)
 		}
@@ -173,6 +255,44 @@ func cmdUncheck(cmd *cli.Cmd) {
 	}
 }
 
+func cmdCancel(cmd *cli.Cmd) {
+	cmd.Spec = "NODE..."
+	var (
+		selectors = cmd.StringsArg("NODE", nil, "node selector(s)")
+	)
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+		for _, sel := range *selectors {
+			if err := a.CancelNode(sel); err != nil {
+				dief("Couldn't cancel node: %v", err)
+			}
+		}
+	}
+}
+
+func cmdUncancel(cmd *cli.Cmd) {
+	cmd.Spec = "NODE..."
+	var (
+		selectors = cmd.StringsArg("NODE", nil, "node selector(s)")
+	)
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+		for _, sel := range *selectors {
+			if err := a.UncancelNode(sel); err != nil {
+				dief("Couldn't uncancel node: %v", err)
+			}
+		}
+	}
+}
+
 func cmdLink(cmd *cli.Cmd) {
 	cmd.Spec = "ORIGIN TARGETS..."
 	var (
@@ -180,7 +300,7 @@ func cmdLink(cmd *cli.Cmd) {
 		targets = cmd.StringsArg("TARGETS", nil, "target selector(s)")
 	)
 	cmd.Action = func() {
-		a, err := app.New()
+		a, err := app.New(appOptions())
 		if err != nil {
 			die(err)
 		}
@@ -201,7 +321,7 @@ func cmdUnlink(cmd *cli.Cmd) {
 		target = cmd.StringArg("TARGET", "", "target selector")
 	)
 	cmd.Action = func() {
-		a, err := app.New()
+		a, err := app.New(appOptions())
 		if err != nil {
 			die(err)
 		}
@@ -213,9 +333,148 @@ func cmdUnlink(cmd *cli.Cmd) {
 	}
 }
 
+func cmdDepend(cmd *cli.Cmd) {
+	cmd.Spec = "NODE PREREQS..."
+	var (
+		selector = cmd.StringArg("NODE", "", "selector of the dependent node")
+		prereqs  = cmd.StringsArg("PREREQS", nil,
+			"selector(s) of the node(s) to be resolved first")
+	)
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		for _, p := range *prereqs {
+			if err := a.AddDependency(*selector, p); err != nil {
+				errf("Couldn't make (%s) depend on (%s): %v\n", *selector, p, err)
+			}
+		}
+	}
+}
+
+func cmdUndepend(cmd *cli.Cmd) {
+	cmd.Spec = "NODE PREREQ"
+	var (
+		selector = cmd.StringArg("NODE", "", "selector of the dependent node")
+		prereq   = cmd.StringArg("PREREQ", "", "selector of the prerequisite")
+	)
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		if err := a.RemoveDependency(*selector, *prereq); err != nil {
+			dief("Couldn't remove dependency: %v\n", err)
+		}
+	}
+}
+
+func cmdNext(cmd *cli.Cmd) {
+	cmd.Spec = "[--where=<key=value>...] [NODE]"
+	var (
+		selector = cmd.StringArg("NODE", "", "limit to the descendants of the node")
+		where    = cmd.StringsOpt("where", nil, "only list nodes with the property")
+	)
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		var sel interface{}
+		if *selector != "" {
+			sel = *selector
+		}
+		nodes, err := a.GetNextNodes(sel)
+		if err != nil {
+			die(capitalize(err.Error()))
+		}
+		nodes, err = a.FilterByProperties(nodes, parseWhere(*where))
+		if err != nil {
+			die(capitalize(err.Error()))
+		}
+		for _, n := range nodes {
+			fmt.Println(n)
+		}
+	}
+}
+
+func cmdCopy(cmd *cli.Cmd) {
+	cmd.Spec = "[-s] [-l] [-i] [ -p=<predecessor> | -r ] NODE"
+	today := multitree.Today()
+	var (
+		selector    = cmd.StringArg("NODE", "", "root of the tree to copy")
+		predecessor = cmd.StringOpt("p predecessor", today,
+			"predecessor to attach the copy to")
+		makeRoot   = cmd.BoolOpt("r root", false, "make the copy a top-level tree")
+		keepStatus = cmd.BoolOpt("s keep-status", false, "keep the completion state")
+		keepLinks  = cmd.BoolOpt("l keep-links", false,
+			"link to the nodes shared with other trees instead of copying them")
+		incomplete = cmd.BoolOpt("i incomplete", false,
+			"copy only the incomplete branches")
+	)
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		opts := app.CopyOptions{
+			KeepStatus: *keepStatus,
+			KeepLinks:  *keepLinks,
+			Incomplete: *incomplete,
+		}
+		var parent interface{}
+		if !*makeRoot {
+			parent = *predecessor
+		}
+		id, err := a.CopyNode(*selector, parent, opts)
+		if err != nil {
+			dief("Couldn't copy node: %v\n", err)
+		}
+		node, err := a.GetGraph(id)
+		if err != nil {
+			die(capitalize(err.Error()))
+		}
+		fmt.Print(node.StringTree())
+	}
+}
+
+func cmdMove(cmd *cli.Cmd) {
+	cmd.Spec = "NODE [--from=<parent>] --to=<parent>"
+	var (
+		selector = cmd.StringArg("NODE", "", "node selector")
+		from     = cmd.StringOpt("from", "",
+			"parent to move the node from (default: its only parent)")
+		to = cmd.StringOpt("to", "", "parent to move the node to")
+	)
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		var old interface{}
+		if *from != "" {
+			old = *from
+		}
+		if err := a.MoveNode(*selector, old, *to); err != nil {
+			dief("Couldn't move node: %v\n", err)
+		}
+	}
+}
+
 func cmdListDates(cmd *cli.Cmd) {
 	cmd.Action = func() {
-		a, err := app.New()
+		a, err := app.New(appOptions())
 		if err != nil {
 			die(err)
 		}
@@ -247,7 +506,7 @@ func cmdRename(cmd *cli.Cmd) {
 			"strings forming the new node name")
 	)
 	cmd.Action = func() {
-		a, err := app.New()
+		a, err := app.New(appOptions())
 		if err != nil {
 			die(err)
 		}
@@ -266,7 +525,7 @@ func cmdAlias(cmd *cli.Cmd) {
 		alias    = cmd.StringArg("ALIAS", "", "alias string")
 	)
 	cmd.Action = func() {
-		a, err := app.New()
+		a, err := app.New(appOptions())
 		if err != nil {
 			die(err)
 		}
@@ -288,7 +547,7 @@ func cmdUnalias(cmd *cli.Cmd) {
 		selector = cmd.StringArg("NODE_ID", "", "node ID selector")
 	)
 	cmd.Action = func() {
-		a, err := app.New()
+		a, err := app.New(appOptions())
 		if err != nil {
 			die(err)
 		}
@@ -304,198 +563,1400 @@ func cmdUnalias(cmd *cli.Cmd) {
 	}
 }
 
-func cmdRemove(cmd *cli.Cmd) {
-	cmd.Spec = "[-r] [-v] NODE..."
+func cmdNote(cmd *cli.Cmd) {
+	cmd.Spec = "NODE [-m=<text>]"
 	var (
-		selectors = cmd.StringsArg("NODE", nil, "node selector(s)")
-		recursive = cmd.BoolOpt("r recursive", false,
-			"remove node and all its descendants")
-		verbose = cmd.BoolOpt("v verbose", false, "print each removed node")
+		selector = cmd.StringArg("NODE", "", "node selector")
+		message  = cmd.StringOpt("m message", "",
+			"append text instead of opening the editor")
 	)
 	cmd.Action = func() {
-		a, err := app.New()
+		a, err := app.New(appOptions())
 		if err != nil {
 			die(err)
 		}
 		defer a.Close()
 
-		var msgs []string
-		var errs []error
-
-		appendErr := func(sel string, err error) {
-			errs = append(errs, fmt.Errorf("Couldn't remove %s: %v", sel, err))
-		}
-
-		for _, sel := range *selectors {
-			if *recursive {
-				removed, err := a.RemoveNodeRecursive(sel)
-				if err != nil {
-					appendErr(sel, err)
-					continue
-				}
-				for _, node := range removed {
-					msgs = append(msgs, fmt.Sprintf("Removed: %v ", node))
-				}
-			} else {
-				removed, err := a.GetGraph(sel)
-				if err != nil {
-					appendErr(sel, err)
-					continue
-				}
-				orphaned, err := a.RemoveNode(sel)
-				if err != nil {
-					appendErr(sel, err)
-					continue
-				}
-				msgs = append(msgs, fmt.Sprintf("Removed: %v ", removed))
-				for _, node := range orphaned {
-					msgs = append(msgs, fmt.Sprintf("Orphaned: %v ", node))
-				}
+		if *message != "" {
+			if err := a.AppendNote(*selector, *message); err != nil {
+				dief("Couldn't add note: %v", err)
 			}
+			return
 		}
 
-		if *verbose {
-			for _, msg := range msgs {
-				fmt.Println(msg)
-			}
+		node, err := a.GetNode(*selector)
+		if err != nil {
+			die(err)
+		} else if node == nil || node.ID == 0 {
+			die("Node does not exist")
 		}
-
-		for _, e := range errs {
-			fmt.Fprintln(os.Stderr, e)
+		notes, err := editText(node.Notes)
+		if err != nil {
+			dief("Couldn't edit notes: %v", err)
+		}
+		if notes == node.Notes {
+			return
+		}
+		if err := a.SetNotes(node.ID, notes); err != nil {
+			dief("Couldn't save notes: %v", err)
 		}
 	}
 }
 
-func cmdImport(cmd *cli.Cmd) {
-	cmd.Spec = "[ -p=<predecessor> | -r ] [FILENAME]"
-	today := time.Now().Format("2006-01-02")
-
+func cmdDue(cmd *cli.Cmd) {
+	cmd.Spec = "NODE (DATE | --clear)"
 	var (
-		filename = cmd.StringArg("FILENAME", "",
-			"file containing tab-indented lines")
-		predecessor = cmd.StringOpt("p predecessor", today,
-			"predecessor for the tree root(s)")
-		makeRoot = cmd.BoolOpt("r root", false, "create top-level tree(s)")
+		selector = cmd.StringArg("NODE", "", "node selector")
+		date     = cmd.StringArg("DATE", "", "due date (YYYY-MM-DD)")
+		clear    = cmd.BoolOpt("clear", false, "remove the due date")
 	)
-
 	cmd.Action = func() {
-		a, err := app.New()
+		a, err := app.New(appOptions())
 		if err != nil {
 			die(err)
 		}
 		defer a.Close()
 
-		var reader io.Reader
-		if *filename == "" {
-			reader = os.Stdin
-		} else {
-			f, err := os.Open(*filename)
-			if err != nil {
-				dief("%s\n", capitalize(err.Error()))
-			}
-			defer f.Close()
-			reader = f
+		due := *date
+		if *clear {
+			due = ""
+		}
+		if err := a.SetDue(*selector, due); err != nil {
+			dief("Couldn't set due date: %v", err)
 		}
+	}
+}
 
-		roots, err := multitree.ImportTrees(reader)
+func cmdEstimate(cmd *cli.Cmd) {
+	cmd.Spec = "NODE (VALUE | --clear)"
+	var (
+		selector = cmd.StringArg("NODE", "", "node selector")
+		value    = cmd.StringArg("VALUE", "", "effort in points or as a duration (e.g. 3, 90m, 2h, 1.5d)")
+		clear    = cmd.BoolOpt("clear", false, "remove the estimate")
+	)
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
 		if err != nil {
-			dief("Import error: %v", err)
+			die(err)
 		}
+		defer a.Close()
 
-		var errs []error
-		var treesTotal, nodesTotal int
+		estimate := *value
+		if *clear {
+			estimate = ""
+		}
+		if err := a.SetEstimate(*selector, estimate); err != nil {
+			dief("Couldn't set estimate: %v", err)
+		}
+	}
+}
 
-		for _, root := range roots {
-			var id int64
-			var err error
-			if *makeRoot {
-				id, err = a.AddRootTree(root)
-			} else {
-				id, err = a.AddChildTree(root, *predecessor)
-			}
-			if err != nil {
-				e := fmt.Errorf("Couldn't import tree: %v", err)
-				errs = append(errs, e)
-				continue
-			}
+// listDeadlines prints the incomplete nodes with deadlines between from and
+// to, inclusive.
+func listDeadlines(from, to string, inherited bool) {
+	a, err := app.New(appOptions())
+	if err != nil {
+		die(err)
+	}
+	defer a.Close()
 
-			if g, err := a.GetGraph(id); err != nil {
-				errs = append(errs, err)
-			} else {
-				fmt.Print(g.StringTree())
-				treesTotal++
-				nodesTotal += len(g.Tree().All())
-			}
+	nodes, err := a.GetDeadlines(from, to, inherited)
+	if err != nil {
+		die(err)
+	}
+	today := multitree.Today()
+	red := color.New(color.FgRed).SprintFunc()
+	for _, n := range nodes {
+		deadline := n.Deadline()
+		if deadline < today {
+			deadline = red(deadline)
 		}
-
-		for _, e := range errs {
-			fmt.Fprintln(os.Stderr, e)
+		line := fmt.Sprintf("%s  %s", deadline, n)
+		if d := n.DeadlineNode(); d != n {
+			line += fmt.Sprintf(" (via %d)", d.ID)
 		}
-		fmt.Printf("Imported %d trees (%d nodes)\n", treesTotal, nodesTotal)
+		fmt.Println(line)
 	}
 }
 
-func cmdStat(cmd *cli.Cmd) {
-	cmd.Spec = "NODE"
+func cmdSet(cmd *cli.Cmd) {
+	cmd.Spec = "NODE PROPS..."
 	var (
 		selector = cmd.StringArg("NODE", "", "node selector")
+		props    = cmd.StringsArg("PROPS", nil, "properties to set (key=value)")
 	)
 	cmd.Action = func() {
-		a, err := app.New()
+		a, err := app.New(appOptions())
 		if err != nil {
 			die(err)
 		}
 		defer a.Close()
 
-		node, err := a.GetGraph(*selector)
-		if err != nil {
+		for _, p := range *props {
+			key, value, err := app.ParseProperty(p)
+			if err != nil {
+				dief("Couldn't set property: %v\n", err)
+			}
+			if err := a.SetProperty(*selector, key, value); err != nil {
+				dief("Couldn't set property: %v\n", err)
+			}
+		}
+	}
+}
+
+func cmdUnset(cmd *cli.Cmd) {
+	cmd.Spec = "NODE KEYS..."
+	var (
+		selector = cmd.StringArg("NODE", "", "node selector")
+		keys     = cmd.StringsArg("KEYS", nil, "keys of the properties to remove")
+	)
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
 			die(err)
-		} else if node == nil {
+		}
+		defer a.Close()
+
+		for _, k := range *keys {
+			if err := a.UnsetProperty(*selector, k); err != nil {
+				dief("Couldn't unset property: %v\n", err)
+			}
+		}
+	}
+}
+
+func cmdProps(cmd *cli.Cmd) {
+	cmd.Spec = "NODE"
+	var (
+		selector = cmd.StringArg("NODE", "", "node selector")
+	)
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		node, err := a.GetNode(*selector)
+		if err != nil {
+			die(capitalize(err.Error()))
+		}
+		if node == nil {
 			die("Node does not exist")
 		}
+		props, err := a.GetProperties(node.ID)
+		if err != nil {
+			die(capitalize(err.Error()))
+		}
+		for _, p := range props {
+			fmt.Printf("%s=%s\n", p.Key, p.Value)
+		}
+	}
+}
 
-		parents := node.Parents()
-		children := node.Children()
+func cmdOverdue(cmd *cli.Cmd) {
+	cmd.Spec = "[-a]"
+	var (
+		inherited = cmd.BoolOpt("a all", false,
+			"include nodes that inherit the deadline of an ancestor")
+	)
+	cmd.Action = func() {
+		yesterday := multitree.DateOf(time.Now().AddDate(0, 0, -1))
+		listDeadlines("", yesterday, *inherited)
+	}
+}
 
-		if len(parents)+len(children) > 0 {
-			fmt.Println(node.StringNeighbors())
+func cmdUpcoming(cmd *cli.Cmd) {
+	cmd.Spec = "[-a] [DAYS]"
+	var (
+		days      = cmd.IntArg("DAYS", 7, "number of days to look ahead")
+		inherited = cmd.BoolOpt("a all", false,
+			"include nodes that inherit the deadline of an ancestor")
+	)
+	cmd.Action = func() {
+		if *days < 0 {
+			die("Number of days can't be negative")
 		}
+		now := time.Now()
+		listDeadlines(multitree.DateOf(now),
+			multitree.DateOf(now.AddDate(0, 0, *days)), *inherited)
+	}
+}
 
-		status := node.Status().String()
-		leaves := node.Leaves()
-		done := 0
-		total := len(leaves)
-		for _, leaf := range leaves {
-			if leaf.IsCompleted() {
-				done++
+func cmdRemove(cmd *cli.Cmd) {
+	cmd.Spec = "[-r] [-v] [-P] NODE..."
+	var (
+		selectors = cmd.StringsArg("NODE", nil, "node selector(s)")
+		recursive = cmd.BoolOpt("r recursive", false,
+			"remove node and all its descendants")
+		verbose   = cmd.BoolOpt("v verbose", false, "print each removed node")
+		permanent = cmd.BoolOpt("P permanent", false,
+			"delete permanently instead of moving to the trash")
+	)
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		removeNode, removeNodeRecursive := a.TrashNode, a.TrashNodeRecursive
+		if *permanent || !a.HasTrash() {
+			removeNode, removeNodeRecursive = a.RemoveNode, a.RemoveNodeRecursive
+		}
+
+		var msgs []string
+		var errs []error
+
+		appendErr := func(sel string, err error) {
+			errs = append(errs, fmt.Errorf("Couldn't remove %s: %v", sel, err))
+		}
+
+		for _, sel := range *selectors {
+			if *recursive {
+				removed, err := removeNodeRecursive(sel)
+				if err != nil {
+					appendErr(sel, err)
+					continue
+				}
+				for _, node := range removed {
+					msgs = append(msgs, fmt.Sprintf("Removed: %v ", node))
+				}
+			} else {
+				removed, err := a.GetGraph(sel)
+				if err != nil {
+					appendErr(sel, err)
+					continue
+				}
+				orphaned, err := removeNode(sel)
+				if err != nil {
+					appendErr(sel, err)
+					continue
+				}
+				msgs = append(msgs, fmt.Sprintf("Removed: %v ", removed))
+				for _, node := range orphaned {
+					msgs = append(msgs, fmt.Sprintf("Orphaned: %v ", node))
+				}
 			}
 		}
-		if total > 0 {
-			status += fmt.Sprintf(" (%d/%d)", done, total)
+
+		if *verbose {
+			for _, msg := range msgs {
+				fmt.Println(msg)
+			}
 		}
 
-		// Make name bold if root.
-		name := node.Name
-		if len(parents) == 0 {
-			bold := color.New(color.Bold).SprintFunc()
-			name = bold(name)
+		for _, e := range errs {
+			fmt.Fprintln(os.Stderr, e)
 		}
+	}
+}
 
-		fmt.Printf("ID: %d\n", node.ID)
-		fmt.Printf("Name: %s\n", name)
-		fmt.Printf("Status: %s\n", status)
-		fmt.Printf("Parents: %d\n", len(parents))
-		fmt.Printf("Children: %d\n", len(children))
+func cmdTrash(cmd *cli.Cmd) {
+	cmd.Command("list ls", "List removed nodes (default)", cmdTrashList)
+	cmd.Command("empty", "Permanently delete removed nodes", cmdTrashEmpty)
+	cmd.Action = func() {
+		listTrash()
+	}
+}
 
-		if node.Alias != "" {
-			fmt.Printf("Alias: %s\n", node.Alias)
+func listTrash() {
+	a, err := app.New(appOptions())
+	if err != nil {
+		die(err)
+	}
+	defer a.Close()
+
+	entries, err := a.GetTrash()
+	if err != nil {
+		die(err)
+	}
+	timeFmt := "2006-01-02 15:04:05"
+	accent := color.New(color.FgCyan).SprintFunc()
+	for _, e := range entries {
+		t := time.Unix(e.Time, 0).Format(timeFmt)
+		line := fmt.Sprintf("%s  %s %s", t, e.NodeName,
+			accent(fmt.Sprintf("(%d)", e.NodeID)))
+		if e.Size > 1 {
+			line += fmt.Sprintf(" +%d", e.Size-1)
 		}
+		fmt.Println(line)
+	}
+}
 
-		timeFmt := "2006-01-02 15:04:05"
-		fmt.Printf("Created: %s\n", time.Unix(node.Created, 0).Format(timeFmt))
-		if node.IsCompleted() {
-			fmt.Printf("Checked: %s\n", time.Unix(*node.Completed, 0).Format(timeFmt))
+func cmdTrashList(cmd *cli.Cmd) {
+	cmd.Action = listTrash
+}
+
+func cmdTrashEmpty(cmd *cli.Cmd) {
+	cmd.Spec = "[--older-than=<age>]"
+	var (
+		olderThan = cmd.StringOpt("older-than", "",
+			"only purge entries older than age, e.g. 30d or 12h")
+	)
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		var age time.Duration
+		if *olderThan != "" {
+			if age, err = parseAge(*olderThan); err != nil {
+				die(capitalize(err.Error()))
+			}
+		}
+		count, err := a.EmptyTrash(age)
+		if err != nil {
+			dief("Couldn't empty trash: %v", err)
+		}
+		fmt.Printf("Purged %d entries\n", count)
+	}
+}
+
+func cmdRestore(cmd *cli.Cmd) {
+	cmd.Spec = "NODE_ID..."
+	var (
+		selectors = cmd.StringsArg("NODE_ID", nil, "ID(s) of removed node(s)")
+	)
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		for _, sel := range *selectors {
+			id, err := strconv.ParseInt(sel, 10, 64)
+			if err != nil {
+				dief("Selector must be an integer")
+			}
+			node, err := a.RestoreNode(id)
+			if err != nil {
+				dief("Couldn't restore node %d: %v", id, err)
+			}
+			fmt.Printf("Restored: %v\n", node)
+		}
+	}
+}
+
+func cmdImport(cmd *cli.Cmd) {
+	cmd.Spec = "[--format=<fmt>] [ -p=<predecessor> | -r ] [FILENAME]"
+	today := multitree.Today()
+
+	var (
+		filename = cmd.StringArg("FILENAME", "",
+			"file containing tab-indented lines or a JSON dump")
+		format = cmd.StringOpt("format", "text",
+			"input format (text or json)")
+		predecessor = cmd.StringOpt("p predecessor", today,
+			"predecessor for the tree root(s)")
+		makeRoot = cmd.BoolOpt("r root", false, "create top-level tree(s)")
+	)
+
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		var reader io.Reader
+		if *filename == "" {
+			reader = os.Stdin
+		} else {
+			f, err := os.Open(*filename)
+			if err != nil {
+				dief("%s\n", capitalize(err.Error()))
+			}
+			defer f.Close()
+			reader = f
+		}
+
+		switch *format {
+		case "text":
+		case "json":
+			importDump(a, reader)
+			return
+		default:
+			dief("Unsupported format: %s\n", *format)
+		}
+
+		roots, err := multitree.ImportTrees(reader)
+		if err != nil {
+			dief("Import error: %v", err)
+		}
+
+		var errs []error
+		var treesTotal, nodesTotal int
+
+		for _, root := range roots {
+			var id int64
+			var err error
+			if *makeRoot {
+				id, err = a.AddRootTree(root)
+			} else {
+				id, err = a.AddChildTree(root, *predecessor)
+			}
+			if err != nil {
+				e := fmt.Errorf("Couldn't import tree: %v", err)
+				errs = append(errs, e)
+				continue
+			}
+
+			if g, err := a.GetGraph(id); err != nil {
+				errs = append(errs, err)
+			} else {
+				fmt.Print(g.StringTree())
+				treesTotal++
+				nodesTotal += len(g.Tree().All())
+			}
+		}
+
+		for _, e := range errs {
+			fmt.Fprintln(os.Stderr, e)
+		}
+		fmt.Printf("Imported %d trees (%d nodes)\n", treesTotal, nodesTotal)
+	}
+}
+
+func importDump(a *app.App, r io.Reader) {
+	dump := &store.Dump{}
+	if err := json.NewDecoder(r).Decode(dump); err != nil {
+		dief("Import error: %v\n", err)
+	}
+	if _, err := a.Import(dump); err != nil {
+		dief("Import error: %v\n", err)
+	}
+	fmt.Printf("Imported %d nodes (%d links)\n", len(dump.Nodes), len(dump.Links))
+}
+
+func cmdExport(cmd *cli.Cmd) {
+	cmd.Spec = "[--format=<fmt>] [FILENAME]"
+	var (
+		filename = cmd.StringArg("FILENAME", "",
+			"output file (default: standard output)")
+		format = cmd.StringOpt("format", "json", "output format (json)")
+	)
+
+	cmd.Action = func() {
+		if *format != "json" {
+			dief("Unsupported format: %s\n", *format)
+		}
+
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
 		}
+		defer a.Close()
 
+		dump, err := a.Export()
+		if err != nil {
+			die(err)
+		}
+
+		var w io.Writer = os.Stdout
+		if *filename != "" {
+			f, err := os.Create(*filename)
+			if err != nil {
+				dief("%s\n", capitalize(err.Error()))
+			}
+			defer f.Close()
+			w = f
+		}
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(dump); err != nil {
+			die(err)
+		}
+	}
+}
+
+func cmdConvert(cmd *cli.Cmd) {
+	cmd.Spec = "BACKEND PATH"
+	var (
+		backend = cmd.StringArg("BACKEND", "", "backend of the new store: sqlite or text")
+		path    = cmd.StringArg("PATH", "", "path of the new store")
+	)
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		count, err := a.Convert(*backend, *path)
+		if err != nil {
+			die(capitalize(err.Error()))
+		}
+		fmt.Printf("Copied %d node(s) to %s\n", count, *path)
+	}
+}
+
+func cmdBackup(cmd *cli.Cmd) {
+	cmd.Spec = "[--keep=<n>] [PATH]"
+	var (
+		path = cmd.StringArg("PATH", "",
+			"backup file or directory (default: backups next to the database)")
+		keep = cmd.Int(cli.IntOpt{
+			Name:   "k keep",
+			Value:  10,
+			Desc:   "number of backups to keep in the directory, 0 for all",
+			EnvVar: "GRIT_BACKUP_KEEP",
+		})
+	)
+	cmd.Command("restore", "Replace the database with a backup", cmdBackupRestore)
+
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		backup, err := a.Backup(*path, *keep)
+		if err != nil {
+			dief("Couldn't back up: %v\n", err)
+		}
+		fmt.Printf("Backed up to %s\n", backup)
+	}
+}
+
+func cmdBackupRestore(cmd *cli.Cmd) {
+	cmd.Spec = "PATH"
+	var (
+		path = cmd.StringArg("PATH", "", "backup file")
+	)
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		previous, err := a.RestoreBackup(*path)
+		if err != nil {
+			dief("Couldn't restore: %v\n", err)
+		}
+		fmt.Printf("Restored %s (previous contents saved to %s)\n", *path, previous)
+	}
+}
+
+func cmdMerge(cmd *cli.Cmd) {
+	cmd.Spec = "[--ours | --theirs] DATABASE"
+	var (
+		path   = cmd.StringArg("DATABASE", "", "path of the other database")
+		ours   = cmd.BoolOpt("ours", false, "resolve conflicts using the local version")
+		theirs = cmd.BoolOpt("theirs", false, "resolve conflicts using the other version")
+	)
+
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		resolve := store.MergeAbort
+		if *ours {
+			resolve = store.MergeOurs
+		} else if *theirs {
+			resolve = store.MergeTheirs
+		}
+
+		result, err := a.Merge(*path, resolve)
+		if err == store.ErrConflicts {
+			for _, c := range result.Conflicts {
+				fmt.Printf("%s: %s\n", c.Kind, c.Message)
+			}
+			dief("Found %d conflict(s), nothing merged; "+
+				"use --ours or --theirs to resolve them\n", len(result.Conflicts))
+		}
+		if err != nil {
+			dief("Couldn't merge: %v\n", err)
+		}
+
+		for _, c := range result.Conflicts {
+			fmt.Printf("%s: %s (resolved)\n", c.Kind, c.Message)
+		}
+		fmt.Printf("Merged %s: %d node(s) added, %d updated, %d deleted; "+
+			"%d link(s) added, %d deleted\n", *path, result.NodesAdded,
+			result.NodesUpdated, result.NodesDeleted, result.LinksAdded,
+			result.LinksDeleted)
+	}
+}
+
+func cmdFind(cmd *cli.Cmd) {
+	cmd.Spec = "[-u=<node>] [-i] [--where=<key=value>...] QUERY..."
+	var (
+		query = cmd.StringsArg("QUERY", nil, "words to search for")
+		under = cmd.StringOpt("u under", "",
+			"only search the descendants of the node")
+		incomplete = cmd.BoolOpt("i incomplete", false,
+			"only show nodes that aren't completed")
+		where = cmd.StringsOpt("where", nil, "only show nodes with the property")
+	)
+
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		var ancestor interface{}
+		if *under != "" {
+			ancestor = *under
+		}
+		nodes, err := a.Search(strings.Join(*query, " "), ancestor, *incomplete)
+		if err != nil {
+			dief("Couldn't search: %v\n", err)
+		}
+		nodes, err = a.FilterByProperties(nodes, parseWhere(*where))
+		if err != nil {
+			dief("Couldn't search: %v\n", err)
+		}
+		if len(nodes) == 0 {
+			die("No matches")
+		}
+
+		for _, n := range nodes {
+			fmt.Println(n)
+			if n.IsRoot() {
+				continue
+			}
+			for _, path := range n.Paths() {
+				names := make([]string, len(path))
+				for i, p := range path {
+					names[i] = p.Name
+				}
+				fmt.Printf("    %s\n", strings.Join(names, " > "))
+			}
+		}
+	}
+}
+
+func cmdFsck(cmd *cli.Cmd) {
+	cmd.Spec = "[--repair]"
+	var (
+		repair = cmd.BoolOpt("repair", false, "fix the problems that can be fixed")
+	)
+
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		problems, err := a.Fsck(*repair)
+		if err != nil {
+			die(err)
+		}
+		if len(problems) == 0 {
+			fmt.Println("No problems found")
+			return
+		}
+
+		left := 0
+		for _, p := range problems {
+			line := fmt.Sprintf("%s: %s", p.Kind, p.Message)
+			if *repair && p.Fixable {
+				line += " (fixed)"
+			} else {
+				left++
+			}
+			fmt.Println(line)
+		}
+
+		if !*repair {
+			fmt.Printf("Found %d problem(s)\n", len(problems))
+		} else {
+			fmt.Printf("Fixed %d of %d problem(s)\n", len(problems)-left,
+				len(problems))
+		}
+		if left > 0 {
+			os.Exit(1)
+		}
+	}
+}
+
+func cmdStat(cmd *cli.Cmd) {
+	cmd.Spec = "NODE"
+	var (
+		selector = cmd.StringArg("NODE", "", "node selector")
+	)
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		node, err := a.GetGraph(*selector)
+		if err != nil {
+			die(err)
+		} else if node == nil {
+			die("Node does not exist")
+		}
+
+		parents := node.Parents()
+		children := node.Children()
+
+		if len(parents)+len(children) > 0 {
+			fmt.Println(node.StringNeighbors())
+		}
+
+		status := node.Status().String()
+		leaves := node.Leaves()
+		done, total := 0, 0
+		for _, leaf := range leaves {
+			// Cancelled leaves don't count towards progress.
+			if leaf.IsCancelled() {
+				continue
+			}
+			total++
+			if leaf.IsCompleted() {
+				done++
+			}
+		}
+		if total > 0 {
+			status += fmt.Sprintf(" (%d/%d)", done, total)
+		}
+
+		// Make name bold if root.
+		name := node.Name
+		if len(parents) == 0 {
+			bold := color.New(color.Bold).SprintFunc()
+			name = bold(name)
+		}
+
+		fmt.Printf("ID: %d\n", node.ID)
+		fmt.Printf("Name: %s\n", name)
+		fmt.Printf("Status: %s\n", status)
+		fmt.Printf("Parents: %d\n", len(parents))
+		fmt.Printf("Children: %d\n", len(children))
+
+		if node.Alias != "" {
+			fmt.Printf("Alias: %s\n", node.Alias)
+		}
+
+		timeFmt := "2006-01-02 15:04:05"
+		fmt.Printf("Created: %s\n", time.Unix(node.Created, 0).Format(timeFmt))
+		if node.IsCompleted() {
+			fmt.Printf("Checked: %s\n", time.Unix(*node.Completed, 0).Format(timeFmt))
+		}
+		if node.IsCancelled() {
+			fmt.Printf("Cancelled: %s\n", time.Unix(*node.Cancelled, 0).Format(timeFmt))
+		}
+
+		if node.Estimate != 0 {
+			fmt.Printf("Estimate: %s point(s)\n", formatPoints(node.Estimate))
+		}
+		effort, effortDone := node.Effort()
+		fmt.Printf("Progress: %.0f%% (%s/%s points)\n", math.Floor(node.Progress()*100),
+			formatPoints(effortDone), formatPoints(effort))
+
+		if d := node.DeadlineNode(); d == node {
+			fmt.Printf("Due: %s\n", d.Due)
+		} else if d != nil {
+			fmt.Printf("Due: %s (inherited from %d)\n", d.Due, d.ID)
+		}
+
+		props, err := a.GetProperties(node.ID)
+		if err != nil {
+			die(err)
+		}
+		if len(props) > 0 {
+			fmt.Println("Properties:")
+			for _, p := range props {
+				fmt.Printf("    %s: %s\n", p.Key, p.Value)
+			}
+		}
+
+		prereqs, err := a.GetPrerequisites(node.ID)
+		if err != nil {
+			die(err)
+		}
+		if len(prereqs) > 0 {
+			fmt.Println("Depends on:")
+			for _, p := range prereqs {
+				fmt.Printf("    %s\n", p)
+			}
+		}
+
+		if node.Notes != "" {
+			fmt.Println("Notes:")
+			for _, line := range strings.Split(node.Notes, "\n") {
+				fmt.Printf("    %s\n", line)
+			}
+		}
+
+	}
+}
+
+func cmdLog(cmd *cli.Cmd) {
+	cmd.Spec = "[-t=<type>...] [--since=<time>] [--until=<time>] [NODE]"
+	var (
+		selector = cmd.StringArg("NODE", "", "node selector")
+		types    = cmd.StringsOpt("t type", nil, "event type to show ("+
+			strings.Join(store.EventTypes, ", ")+")")
+		sinceStr = cmd.StringOpt("since", "",
+			"show events since date (YYYY-MM-DD[ HH:MM[:SS]])")
+		untilStr = cmd.StringOpt("until", "",
+			"show events until date (YYYY-MM-DD[ HH:MM[:SS]])")
+	)
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		var since, until time.Time
+		if *sinceStr != "" {
+			if since, _, err = parseTimeRange(*sinceStr); err != nil {
+				die(capitalize(err.Error()))
+			}
+		}
+		if *untilStr != "" {
+			if _, until, err = parseTimeRange(*untilStr); err != nil {
+				die(capitalize(err.Error()))
+			}
+		}
+
+		var sel interface{}
+		if *selector != "" {
+			sel = *selector
+		}
+		events, err := a.GetEvents(sel, *types, since, until)
+		if err != nil {
+			die(capitalize(err.Error()))
+		}
+
+		timeFmt := "2006-01-02 15:04:05"
+		accent := color.New(color.FgCyan).SprintFunc()
+		for _, e := range events {
+			t := time.Unix(e.Time, 0).Format(timeFmt)
+			id := accent(fmt.Sprintf("(%d)", e.NodeID))
+			if e.OtherID != 0 {
+				id = accent(fmt.Sprintf("(%d) -> (%d)", e.OtherID, e.NodeID))
+			}
+			line := fmt.Sprintf("%s  %-7s  %s %s", t, e.Type, id, e.NodeName)
+			if e.Detail != "" {
+				line += fmt.Sprintf(" [%s]", e.Detail)
+			}
+			fmt.Println(line)
+		}
+	}
+}
+
+// nodeLabel formats a reference to a node that may no longer exist.
+func nodeLabel(id int64, name string) string {
+	accent := color.New(color.FgCyan).SprintFunc()
+	if name == "" {
+		name = "[deleted]"
+	}
+	return fmt.Sprintf("%s %s", accent(fmt.Sprintf("(%d)", id)), name)
+}
+
+func cmdStart(cmd *cli.Cmd) {
+	cmd.Spec = "[-l] NODE"
+	var (
+		selector = cmd.StringArg("NODE", "", "node selector")
+		link     = cmd.Bool(cli.BoolOpt{
+			Name:   "l link",
+			Value:  false,
+			Desc:   "link the node from today's date node",
+			EnvVar: "GRIT_START_LINK",
+		})
+	)
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		entry, stopped, err := a.StartTimer(*selector, *link)
+		if err != nil {
+			dief("Couldn't start timer: %v", err)
+		}
+		if stopped != nil {
+			d := time.Duration(stopped.Stop-stopped.Start) * time.Second
+			fmt.Printf("Stopped %s after %s\n",
+				nodeLabel(stopped.NodeID, stopped.NodeName), formatDuration(d))
+		}
+		fmt.Printf("Started %s\n", nodeLabel(entry.NodeID, entry.NodeName))
+	}
+}
+
+func cmdStop(cmd *cli.Cmd) {
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		entry, err := a.StopTimer()
+		if err != nil {
+			dief("Couldn't stop timer: %v", err)
+		}
+		if entry == nil {
+			die("No timer is running")
+		}
+		d := time.Duration(entry.Stop-entry.Start) * time.Second
+		fmt.Printf("Stopped %s after %s\n", nodeLabel(entry.NodeID, entry.NodeName),
+			formatDuration(d))
+	}
+}
+
+func cmdStatus(cmd *cli.Cmd) {
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		entry, err := a.GetRunningTimer()
+		if err != nil {
+			die(capitalize(err.Error()))
+		}
+		if entry == nil {
+			fmt.Println("No timer is running")
+			return
+		}
+		start := time.Unix(entry.Start, 0)
+		fmt.Printf("Tracking %s for %s (since %s)\n",
+			nodeLabel(entry.NodeID, entry.NodeName),
+			formatDuration(time.Since(start)), start.Format("2006-01-02 15:04"))
+	}
+}
+
+func cmdTime(cmd *cli.Cmd) {
+	cmd.Spec = "[--since=<time>] [--until=<time>] [NODE]"
+	var (
+		selector = cmd.StringArg("NODE", "", "node selector")
+		sinceStr = cmd.StringOpt("since", "",
+			"count time since date (YYYY-MM-DD[ HH:MM[:SS]])")
+		untilStr = cmd.StringOpt("until", "",
+			"count time until date (YYYY-MM-DD[ HH:MM[:SS]])")
+	)
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		var since, until time.Time
+		if *sinceStr != "" {
+			if since, _, err = parseTimeRange(*sinceStr); err != nil {
+				die(capitalize(err.Error()))
+			}
+		}
+		if *untilStr != "" {
+			if _, until, err = parseTimeRange(*untilStr); err != nil {
+				die(capitalize(err.Error()))
+			}
+		}
+
+		var sel interface{}
+		if *selector != "" {
+			sel = *selector
+		}
+		days, err := a.GetTimeReport(sel, since, until)
+		if err != nil {
+			die(capitalize(err.Error()))
+		}
+
+		var total time.Duration
+		bold := color.New(color.Bold).SprintFunc()
+		for _, day := range days {
+			fmt.Printf("%s  %s\n", bold(day.Date), formatDuration(day.Total))
+			for _, n := range day.Nodes {
+				fmt.Printf("    %7s  %s\n", formatDuration(n.Time),
+					nodeLabel(n.NodeID, n.NodeName))
+			}
+			total += day.Total
+		}
+		if len(days) > 0 {
+			fmt.Printf("Total: %s\n", formatDuration(total))
+		}
+	}
+}
+
+func cmdRecur(cmd *cli.Cmd) {
+	cmd.Command("add", "Make a node recur under date nodes", cmdRecurAdd)
+	cmd.Command("list ls", "List recurrence rules", cmdRecurList)
+	cmd.Command("remove rm", "Remove a recurrence rule", cmdRecurRemove)
+	cmd.Command("sync", "Create the recurring tasks that are due", cmdRecurSync)
+}
+
+func cmdRecurAdd(cmd *cli.Cmd) {
+	cmd.Spec = "[--from=<date>] NODE RULE..."
+	var (
+		selector = cmd.StringArg("NODE", "", "template node selector")
+		rule     = cmd.StringsArg("RULE", nil, "daily, weekdays, every N days, "+
+			"weekly [on DAY[,DAY...]] or monthly [on N]")
+		from = cmd.StringOpt("from", "", "first date the rule applies to (default: today)")
+	)
+	cmd.Action = func() {
+		opts := appOptions()
+		opts.SyncRecurrences = false
+		a, err := app.New(opts)
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		r, err := a.AddRecurrence(*selector, strings.Join(*rule, " "), *from)
+		if err != nil {
+			dief("Couldn't add recurrence: %v", err)
+		}
+		fmt.Printf("(%d) %s recurs %s\n", r.NodeID, r.NodeName, r.Rule)
+		syncRecurrences(a)
+	}
+}
+
+func cmdRecurList(cmd *cli.Cmd) {
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		recurrences, err := a.GetRecurrences()
+		if err != nil {
+			die(capitalize(err.Error()))
+		}
+		for _, r := range recurrences {
+			next, err := app.NextOccurrence(r)
+			if err != nil {
+				next = "?"
+			}
+			fmt.Printf("%d  %s  %s (next: %s)\n", r.ID, nodeLabel(r.NodeID, r.NodeName),
+				r.Rule, next)
+		}
+	}
+}
+
+func cmdRecurRemove(cmd *cli.Cmd) {
+	cmd.Spec = "ID"
+	var (
+		id = cmd.IntArg("ID", 0, "recurrence ID, as shown by recur list")
+	)
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		if err := a.RemoveRecurrence(int64(*id)); err != nil {
+			dief("Couldn't remove recurrence: %v", err)
+		}
+	}
+}
+
+// syncRecurrences creates the due recurring tasks and prints them.
+func syncRecurrences(a *app.App) {
+	created, err := a.SyncRecurrences()
+	for _, n := range created {
+		fmt.Printf("Created %s under %s\n", nodeLabel(n.ID, n.Name),
+			n.Parents()[0].Name)
+	}
+	if err != nil {
+		dief("Couldn't create recurring tasks: %v", err)
+	}
+}
+
+func cmdRecurSync(cmd *cli.Cmd) {
+	cmd.Action = func() {
+		opts := appOptions()
+		opts.SyncRecurrences = false
+		a, err := app.New(opts)
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+		syncRecurrences(a)
+	}
+}
+
+func printJournalEntries(prefix string, entries []*store.JournalEntry) {
+	timeFmt := "2006-01-02 15:04:05"
+	for _, e := range entries {
+		t := time.Unix(e.Time, 0).Format(timeFmt)
+		fmt.Printf("%s: %s (%s)\n", prefix, e.Description, t)
+	}
+}
+
+func cmdUndo(cmd *cli.Cmd) {
+	cmd.Spec = "[N]"
+	var (
+		n = cmd.IntArg("N", 1, "number of changes to undo")
+	)
+	cmd.Action = func() {
+		// Recurring tasks created now would become the change to undo.
+		opts := appOptions()
+		opts.SyncRecurrences = false
+		a, err := app.New(opts)
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		entries, err := a.Undo(*n)
+		if err != nil {
+			dief("Couldn't undo: %v", err)
+		}
+		printJournalEntries("Undone", entries)
+	}
+}
+
+func cmdRedo(cmd *cli.Cmd) {
+	cmd.Spec = "[N]"
+	var (
+		n = cmd.IntArg("N", 1, "number of changes to redo")
+	)
+	cmd.Action = func() {
+		// Recurring tasks created now would become the change to redo.
+		opts := appOptions()
+		opts.SyncRecurrences = false
+		a, err := app.New(opts)
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		entries, err := a.Redo(*n)
+		if err != nil {
+			dief("Couldn't redo: %v", err)
+		}
+		printJournalEntries("Redone", entries)
+	}
+}
+
+func loadWorkspaces() *app.Workspaces {
+	configPath := app.DefaultConfigPath()
+	if err := configdir.MakePath(configPath); err != nil {
+		die(err)
+	}
+	ws, err := app.LoadWorkspaces(configPath)
+	if err != nil {
+		die(err)
+	}
+	return ws
+}
+
+func cmdWorkspace(cmd *cli.Cmd) {
+	cmd.Command("create", "Create a new workspace", cmdWorkspaceCreate)
+	cmd.Command("list ls", "List workspaces", cmdWorkspaceList)
+	cmd.Command("use", "Set the active workspace", cmdWorkspaceUse)
+	cmd.Command("remove rm", "Remove a workspace (the database file is kept)",
+		cmdWorkspaceRemove)
+}
+
+func cmdWorkspaceCreate(cmd *cli.Cmd) {
+	cmd.Spec = "NAME [PATH]"
+	var (
+		name = cmd.StringArg("NAME", "", "workspace name")
+		fp   = cmd.StringArg("PATH", "",
+			"database file (defaults to NAME.db in the config directory)")
+	)
+	cmd.Action = func() {
+		ws := loadWorkspaces()
+		w, err := ws.Create(*name, *fp)
+		if err != nil {
+			dief("Couldn't create workspace: %v", err)
+		}
+		if err := ws.Save(); err != nil {
+			dief("Couldn't save workspaces: %v", err)
+		}
+		fmt.Printf("%s -> %s\n", w.Name, w.Path)
+	}
+}
+
+func cmdWorkspaceList(cmd *cli.Cmd) {
+	cmd.Action = func() {
+		ws := loadWorkspaces()
+		active := ws.ActiveName()
+		accent := color.New(color.FgCyan).SprintFunc()
+		for _, w := range ws.List() {
+			if w.Name == active {
+				fmt.Printf("* %s %s\n", accent(w.Name), w.Path)
+			} else {
+				fmt.Printf("  %s %s\n", w.Name, w.Path)
+			}
+		}
+	}
+}
+
+func cmdWorkspaceUse(cmd *cli.Cmd) {
+	cmd.Spec = "NAME"
+	var (
+		name = cmd.StringArg("NAME", "", "workspace name")
+	)
+	cmd.Action = func() {
+		ws := loadWorkspaces()
+		if err := ws.Use(*name); err != nil {
+			dief("Couldn't switch workspace: %v", err)
+		}
+		if err := ws.Save(); err != nil {
+			dief("Couldn't save workspaces: %v", err)
+		}
+	}
+}
+
+func cmdWorkspaceRemove(cmd *cli.Cmd) {
+	cmd.Spec = "NAME"
+	var (
+		name = cmd.StringArg("NAME", "", "workspace name")
+	)
+	cmd.Action = func() {
+		ws := loadWorkspaces()
+		w, err := ws.Remove(*name)
+		if err != nil {
+			dief("Couldn't remove workspace: %v", err)
+		}
+		if err := ws.Save(); err != nil {
+			dief("Couldn't save workspaces: %v", err)
+		}
+		fmt.Printf("Removed workspace %s (database kept at %s)\n", w.Name, w.Path)
+	}
+}
+
+func cmdTemplate(cmd *cli.Cmd) {
+	cmd.Command("save", "Save the tree rooted at a node as a template", cmdTemplateSave)
+	cmd.Command("apply", "Create a new tree from a template", cmdTemplateApply)
+	cmd.Command("list ls", "List templates", cmdTemplateList)
+	cmd.Command("show", "Print a template", cmdTemplateShow)
+	cmd.Command("remove rm", "Remove a template", cmdTemplateRemove)
+}
+
+func cmdTemplateSave(cmd *cli.Cmd) {
+	cmd.Spec = "[-f] NODE NAME"
+	var (
+		selector = cmd.StringArg("NODE", "", "root of the tree to save")
+		name     = cmd.StringArg("NAME", "", "template name")
+		force    = cmd.BoolOpt("f force", false, "replace an existing template")
+	)
+	cmd.Action = func() {
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		node, err := a.GetGraph(*selector)
+		if err != nil {
+			die(capitalize(err.Error()))
+		}
+		if node == nil {
+			die("Node does not exist")
+		}
+		templates := app.NewTemplates(app.DefaultConfigPath())
+		if err := templates.Save(*name, node, *force); err != nil {
+			dief("Couldn't save template: %v", err)
+		}
+	}
+}
+
+func cmdTemplateApply(cmd *cli.Cmd) {
+	cmd.Spec = "[ -p=<predecessor> | -r ] [--var=<key=value>...] NAME"
+	today := multitree.Today()
+	var (
+		name        = cmd.StringArg("NAME", "", "template name")
+		predecessor = cmd.StringOpt("p predecessor", today,
+			"predecessor to attach the tree to")
+		makeRoot = cmd.BoolOpt("r root", false, "create a top-level tree")
+		varArgs  = cmd.StringsOpt("var", nil,
+			"value of a placeholder, e.g. version=1.2 for {{version}}")
+	)
+	cmd.Action = func() {
+		vars := make(map[string]string)
+		for _, v := range *varArgs {
+			i := strings.IndexByte(v, '=')
+			if i <= 0 {
+				dief("Invalid variable: %s (want key=value)\n", v)
+			}
+			vars[v[:i]] = v[i+1:]
+		}
+
+		a, err := app.New(appOptions())
+		if err != nil {
+			die(err)
+		}
+		defer a.Close()
+
+		var parent *multitree.Node
+		if !*makeRoot {
+			if parent, err = a.GetGraph(*predecessor); err != nil {
+				dief("Couldn't apply template: %v", err)
+			}
+			if parent == nil {
+				die("Predecessor does not exist")
+			}
+		}
+
+		templates := app.NewTemplates(app.DefaultConfigPath())
+		tree, err := templates.Instantiate(*name, parent, vars)
+		if err != nil {
+			dief("Couldn't apply template: %v", err)
+		}
+		var id int64
+		if *makeRoot {
+			id, err = a.AddRootTree(tree)
+		} else {
+			id, err = a.AddChildTree(tree, *predecessor)
+		}
+		if err != nil {
+			dief("Couldn't apply template: %v", err)
+		}
+		color.Cyan("(%d)", id)
+	}
+}
+
+func cmdTemplateList(cmd *cli.Cmd) {
+	cmd.Action = func() {
+		names, err := app.NewTemplates(app.DefaultConfigPath()).List()
+		if err != nil {
+			die(err)
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+	}
+}
+
+func cmdTemplateShow(cmd *cli.Cmd) {
+	cmd.Spec = "NAME"
+	var (
+		name = cmd.StringArg("NAME", "", "template name")
+	)
+	cmd.Action = func() {
+		text, err := app.NewTemplates(app.DefaultConfigPath()).Read(*name)
+		if err != nil {
+			die(capitalize(err.Error()))
+		}
+		fmt.Print(text)
+	}
+}
+
+func cmdTemplateRemove(cmd *cli.Cmd) {
+	cmd.Spec = "NAME"
+	var (
+		name = cmd.StringArg("NAME", "", "template name")
+	)
+	cmd.Action = func() {
+		if err := app.NewTemplates(app.DefaultConfigPath()).Remove(*name); err != nil {
+			dief("Couldn't remove template: %v", err)
+		}
 	}
 }
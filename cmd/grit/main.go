@@ -3,29 +3,130 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/climech/grit/app"
 	cli "github.com/jawher/mow.cli"
 )
 
+var (
+	dbPath         *string
+	workspace      *string
+	trashRetention *string
+	busyTimeout    *string
+	backend        *string
+	noRecur        *bool
+)
+
+// appOptions returns the app options set by the global flags.
+func appOptions() app.Options {
+	opts := app.Options{
+		DatabasePath: *dbPath,
+		Workspace:    *workspace,
+		Backend:      *backend,
+
+		SyncRecurrences: !*noRecur,
+	}
+	if *trashRetention != "" {
+		age, err := parseAge(*trashRetention)
+		if err != nil {
+			die(capitalize(err.Error()))
+		}
+		opts.TrashRetention = age
+	}
+	if *busyTimeout != "" {
+		d, err := time.ParseDuration(*busyTimeout)
+		if err != nil || d < 0 {
+			dief("Invalid busy timeout: %s\n", *busyTimeout)
+		}
+		opts.BusyTimeout = d
+	}
+	return opts
+}
+
 func main() {
 	c := cli.App(app.AppName, "A multitree-based personal task manager")
 	c.Version("v version", fmt.Sprintf("%s %s", app.AppName, app.Version))
 
+	dbPath = c.String(cli.StringOpt{
+		Name:   "db",
+		Desc:   "path to the database file (overrides workspace)",
+		EnvVar: "GRIT_DB",
+	})
+	workspace = c.String(cli.StringOpt{
+		Name:   "w workspace",
+		Desc:   "workspace to use instead of the active one",
+		EnvVar: "GRIT_WORKSPACE",
+	})
+	trashRetention = c.String(cli.StringOpt{
+		Name:   "trash-retention",
+		Desc:   "purge trash entries older than this age, e.g. 30d",
+		EnvVar: "GRIT_TRASH_RETENTION",
+	})
+	busyTimeout = c.String(cli.StringOpt{
+		Name:   "busy-timeout",
+		Desc:   "how long to wait for other grit processes, e.g. 10s",
+		EnvVar: "GRIT_BUSY_TIMEOUT",
+	})
+
+	backend = c.String(cli.StringOpt{
+		Name:   "backend",
+		Desc:   "storage backend, sqlite or text (detected by default)",
+		EnvVar: "GRIT_BACKEND",
+	})
+	noRecur = c.Bool(cli.BoolOpt{
+		Name:   "no-recur",
+		Desc:   "don't create the due recurring tasks",
+		EnvVar: "GRIT_NO_RECUR",
+	})
+
 	c.Command("add", "Add a new node", cmdAdd)
 	c.Command("alias", "Create alias", cmdAlias)
 	c.Command("unalias", "Remove alias", cmdUnalias)
+	c.Command("note", "Edit the notes of a node", cmdNote)
+	c.Command("due", "Set the date a node must be completed by", cmdDue)
+	c.Command("estimate", "Set the effort a node takes", cmdEstimate)
+	c.Command("set", "Set custom properties of a node", cmdSet)
+	c.Command("unset", "Remove custom properties of a node", cmdUnset)
+	c.Command("props", "List the custom properties of a node", cmdProps)
+	c.Command("overdue", "List incomplete nodes past their deadline", cmdOverdue)
+	c.Command("upcoming", "List incomplete nodes due in the next days", cmdUpcoming)
 	c.Command("tree", "Print tree representation rooted at node", cmdTree)
 	c.Command("check", "Mark node(s) as completed", cmdCheck)
 	c.Command("uncheck", "Revert node status to inactive", cmdUncheck)
+	c.Command("cancel", "Mark node(s) as cancelled", cmdCancel)
+	c.Command("uncancel", "Revert cancelled node(s) to inactive", cmdUncancel)
 	c.Command("link", "Create a link from one node to another", cmdLink)
 	c.Command("unlink", "Remove an existing link between two nodes", cmdUnlink)
+	c.Command("depend", "Make a node wait for other node(s) to be resolved", cmdDepend)
+	c.Command("undepend", "Remove a dependency between two nodes", cmdUndepend)
+	c.Command("next", "List the incomplete tasks that aren't blocked", cmdNext)
 	c.Command("list ls", "List children of selected node", cmdList)
 	c.Command("list-dates lsd", "List all date nodes", cmdListDates)
+	c.Command("copy cp", "Copy a node and its descendants", cmdCopy)
+	c.Command("move mv", "Move a node to another parent", cmdMove)
 	c.Command("rename", "Rename a node", cmdRename)
 	c.Command("remove rm", "Remove node(s)", cmdRemove)
-	c.Command("import", "Import trees from indented lines", cmdImport)
+	c.Command("trash", "List removed nodes", cmdTrash)
+	c.Command("restore", "Restore a removed node from the trash", cmdRestore)
+	c.Command("import", "Import trees from indented lines or a JSON dump", cmdImport)
+	c.Command("export", "Export the whole graph as JSON", cmdExport)
+	c.Command("convert", "Copy the graph into a new store", cmdConvert)
+	c.Command("backup", "Back up the database", cmdBackup)
+	c.Command("merge", "Merge the changes made in another database", cmdMerge)
+	c.Command("fsck", "Check the database for inconsistencies", cmdFsck)
+	c.Command("find", "Search node names", cmdFind)
 	c.Command("stat", "Display node information", cmdStat)
+	c.Command("start", "Start timing a node", cmdStart)
+	c.Command("stop", "Stop the running timer", cmdStop)
+	c.Command("status", "Show the running timer", cmdStatus)
+	c.Command("time", "Report the time tracked per day", cmdTime)
+	c.Command("recur", "Manage recurring tasks", cmdRecur)
+	c.Command("log", "Show the history of a node or the whole graph", cmdLog)
+	c.Command("undo", "Revert the last change(s)", cmdUndo)
+	c.Command("redo", "Reapply the last undone change(s)", cmdRedo)
+	c.Command("template", "Manage subtree templates", cmdTemplate)
+	c.Command("workspace ws", "Manage named workspaces", cmdWorkspace)
 
 	args := os.Args
 	if len(args) == 1 {
@@ -2,8 +2,15 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
+	"math"
 	"os"
+	"os/exec"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/climech/grit/multitree"
 )
 
 func die(a ...interface{}) {
@@ -34,3 +41,159 @@ func capitalize(s string) string {
 	}
 	return s
 }
+
+// parseTimeRange parses a date or datetime in local time. It returns the start
+// and end of the period it denotes, i.e. a whole day for dates, or a single
+// moment otherwise. Like the days of date nodes, the day starts at
+// multitree.DayOffset.
+func parseTimeRange(s string) (time.Time, time.Time, error) {
+	if t, err := multitree.DayStart(s); err == nil {
+		return t, t.AddDate(0, 0, 1), nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, t, nil
+		}
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid date or time: %s", s)
+}
+
+// parseCheckTime parses a completion time: a date and a time of day, with
+// "today" or "yesterday" allowed in place of the date, e.g. "yesterday 18:00".
+// Days start at multitree.DayOffset, so past midnight "today" still refers to
+// the previous date, and "today 01:30" to the night that ends it. A time of
+// day alone refers to the last time the clock showed it. If the time of day is
+// left out, only the date is returned, as the exact time within the day is up
+// to the caller.
+func parseCheckTime(s string, now time.Time) (time.Time, string, error) {
+	invalid := fmt.Errorf("invalid time: %s", s)
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return time.Time{}, "", invalid
+	}
+
+	var date string
+	switch fields[0] {
+	case "now":
+		if len(fields) != 1 {
+			return time.Time{}, "", invalid
+		}
+		return now, "", nil
+	case "today":
+		date = multitree.DateOf(now)
+	case "yesterday":
+		date = multitree.DateOf(now.AddDate(0, 0, -1))
+	default:
+		if _, err := time.Parse("2006-01-02", fields[0]); err == nil {
+			date = fields[0]
+		} else if len(fields) == 1 {
+			t, err := parseClock(now.Format("2006-01-02"), fields[0])
+			if err != nil {
+				return time.Time{}, "", invalid
+			}
+			if t.After(now) {
+				t = t.AddDate(0, 0, -1)
+			}
+			return t, "", nil
+		} else {
+			return time.Time{}, "", invalid
+		}
+	}
+	if len(fields) == 1 {
+		return time.Time{}, date, nil
+	}
+
+	t, err := parseClock(date, fields[1])
+	if err != nil {
+		return time.Time{}, "", invalid
+	}
+	if fields[0] == "today" || fields[0] == "yesterday" {
+		if start, _ := multitree.DayStart(date); t.Before(start) {
+			t = t.AddDate(0, 0, 1)
+		}
+	}
+	return t, "", nil
+}
+
+// parseClock returns the local time on the calendar date ("YYYY-MM-DD") when
+// the clock shows s ("HH:MM" or "HH:MM:SS").
+func parseClock(date, s string) (time.Time, error) {
+	var err error
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		var t time.Time
+		t, err = time.ParseInLocation("2006-01-02 "+layout, date+" "+s, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// parseAge parses a duration, additionally accepting a number of days with the
+// "d" suffix, e.g. "30d".
+func parseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err == nil && days >= 0 {
+			return time.Duration(days) * 24 * time.Hour, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age: %s", s)
+	}
+	return d, nil
+}
+
+// editText opens the text in the user's editor, and returns the edited text
+// without trailing newlines. The editor is taken from $VISUAL or $EDITOR,
+// defaulting to vi.
+func editText(text string) (string, error) {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	f, err := ioutil.TempFile("", "grit-note-*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	if text != "" {
+		text += "\n"
+	}
+	if _, err := f.WriteString(text); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	args := append(strings.Fields(editor), f.Name())
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %v", args[0], err)
+	}
+
+	data, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\n"), nil
+}
+
+// formatPoints formats an amount of effort, rounded to two decimal places.
+func formatPoints(points float64) string {
+	return strconv.FormatFloat(math.Round(points*100)/100, 'f', -1, 64)
+}
+
+// formatDuration formats the duration in hours and minutes, e.g. "1h05m".
+func formatDuration(d time.Duration) string {
+	m := int64(d.Round(time.Minute) / time.Minute)
+	return fmt.Sprintf("%dh%02dm", m/60, m%60)
+}
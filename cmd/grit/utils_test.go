@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/climech/grit/multitree"
+)
+
+// TestParseCheckTime checks the relative times given to check --at past
+// midnight, before the day has started.
+func TestParseCheckTime(t *testing.T) {
+	parse := func(s string) time.Time {
+		tm, _ := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+		return tm
+	}
+	now := parse("2020-01-02 00:30")
+
+	dates := map[string]string{
+		"today":      "2020-01-01",
+		"yesterday":  "2019-12-31",
+		"2020-01-02": "2020-01-02",
+	}
+	for s, want := range dates {
+		if _, date, err := parseCheckTime(s, now); err != nil || date != want {
+			t.Errorf("%s: got %q (err: %v), want %s", s, date, err, want)
+		}
+	}
+
+	times := map[string]time.Time{
+		"now":              now,
+		"today 18:00":      parse("2020-01-01 18:00"),
+		"today 00:15":      parse("2020-01-02 00:15"),
+		"yesterday 18:00":  parse("2019-12-31 18:00"),
+		"00:15":            parse("2020-01-02 00:15"),
+		"23:00":            parse("2020-01-01 23:00"),
+		"2019-06-01 09:30": parse("2019-06-01 09:30"),
+	}
+	for s, want := range times {
+		if got, _, err := parseCheckTime(s, now); err != nil || !got.Equal(want) {
+			t.Errorf("%s: got %v (err: %v), want %v", s, got, err, want)
+		}
+	}
+
+	for _, s := range []string{"", "tomorrow", "25:00", "today 18:00 x", "now 18:00"} {
+		if _, _, err := parseCheckTime(s, now); err == nil {
+			t.Errorf("%q: parsed and no error returned", s)
+		}
+	}
+}
+
+func TestParseTimeRange(t *testing.T) {
+	start, end, err := parseTimeRange("2020-01-02")
+	if err != nil {
+		t.Fatalf("couldn't parse date: %v", err)
+	}
+	want := time.Date(2020, 1, 2, multitree.DayOffset, 0, 0, 0, time.Local)
+	if !start.Equal(want) || !end.Equal(want.AddDate(0, 0, 1)) {
+		t.Errorf("got %v to %v, want the day starting at %v", start, end, want)
+	}
+	if start, end, _ := parseTimeRange("2020-01-02 01:30"); !start.Equal(end) {
+		t.Errorf("got %v to %v, want a single moment", start, end)
+	}
+}
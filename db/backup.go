@@ -0,0 +1,243 @@
+package db
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// BackupDirName is the name of the default backup directory, which is created
+// next to the database file.
+const BackupDirName = "backups"
+
+// backupTimeLayout is used in the names of the backup files. The names sort
+// in chronological order.
+const backupTimeLayout = "20060102-150405.000"
+
+// DefaultBackupDir returns the default backup directory for the database.
+func DefaultBackupDir(filename string) string {
+	return filepath.Join(filepath.Dir(filename), BackupDirName)
+}
+
+// backupName returns the name of a backup of the database taken at t, e.g.
+// "graph-20201112-180311.000.db". The suffix is appended to the timestamp.
+func backupName(filename string, t time.Time, suffix string) string {
+	base := filepath.Base(filename)
+	ext := filepath.Ext(base)
+	return fmt.Sprintf("%s-%s%s%s", strings.TrimSuffix(base, ext),
+		t.Format(backupTimeLayout), suffix, ext)
+}
+
+// listBackups returns the names of the database's backups in dir, oldest
+// first.
+func listBackups(filename, dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Base(filename)
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "-"
+
+	var names []string
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasPrefix(name, prefix) ||
+			!strings.HasSuffix(name, ext) {
+			continue
+		}
+		stamp := strings.TrimPrefix(name, prefix)
+		if len(stamp) < len(backupTimeLayout) {
+			continue
+		}
+		if _, err := time.Parse(backupTimeLayout, stamp[:len(backupTimeLayout)]); err != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// rotateBackups removes the oldest backups in dir, so that at most keep
+// remain.
+func rotateBackups(filename, dir string, keep int) error {
+	names, err := listBackups(filename, dir)
+	if err != nil {
+		return err
+	}
+	for len(names) > keep {
+		if err := os.Remove(filepath.Join(dir, names[0])); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// openRawConn opens a connection bypassing database/sql, which doesn't give
+// access to the backup API.
+func (d *Database) openRawConn(filename string) (*sqlite3.SQLiteConn, error) {
+	timeout := d.opts.BusyTimeout
+	if timeout == 0 {
+		timeout = DefaultBusyTimeout
+	}
+	params := url.Values{}
+	params.Set("_busy_timeout", strconv.FormatInt(timeout.Milliseconds(), 10))
+	conn, err := (&sqlite3.SQLiteDriver{}).Open(filename + "?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*sqlite3.SQLiteConn), nil
+}
+
+// copyDatabase copies the database at src into dst using SQLite's online
+// backup API, which gives a consistent snapshot even if src is being written
+// to.
+func (d *Database) copyDatabase(dst, src string) error {
+	srcConn, err := d.openRawConn(src)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+	dstConn, err := d.openRawConn(dst)
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+
+	b, err := dstConn.Backup("main", srcConn, "main")
+	if err != nil {
+		return err
+	}
+	if _, err := b.Step(-1); err != nil {
+		b.Finish()
+		return err
+	}
+	return b.Finish()
+}
+
+// backupTo writes a backup of the database to a new file. The backup is
+// written to a temporary file first, so that an interrupted backup doesn't
+// leave a partial file behind.
+func (d *Database) backupTo(path string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err := d.copyDatabase(tmp.Name(), d.Filename); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// snapshot backs up the database into the default backup directory. The
+// suffix is appended to the file name to describe the occasion.
+func (d *Database) snapshot(suffix string) (string, error) {
+	dir := DefaultBackupDir(d.Filename)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, backupName(d.Filename, time.Now(), suffix))
+	if err := d.backupTo(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Backup copies the database into a new file at path. If path is empty or a
+// directory, a timestamped file is created in the default backup directory or
+// the given one, and the oldest backups in it are removed, so that at most
+// keep remain. Zero keeps them all.
+func (d *Database) Backup(path string, keep int) (string, error) {
+	dir := path
+	if dir == "" {
+		dir = DefaultBackupDir(d.Filename)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", err
+		}
+	}
+	info, err := os.Stat(dir)
+	if err == nil && !info.IsDir() {
+		return "", fmt.Errorf("%s already exists", path)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if os.IsNotExist(err) {
+		if err := d.backupTo(path); err != nil {
+			return "", err
+		}
+		return path, nil
+	}
+
+	path = filepath.Join(dir, backupName(d.Filename, time.Now(), ""))
+	if err := d.backupTo(path); err != nil {
+		return "", err
+	}
+	if keep > 0 {
+		if err := rotateBackups(d.Filename, dir, keep); err != nil {
+			return "", err
+		}
+	}
+	return path, nil
+}
+
+// checkBackup returns an error if the file isn't a database that can be
+// restored by this version of grit.
+func (d *Database) checkBackup(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	conn, err := d.openRawConn(path)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	rows, err := conn.Query("PRAGMA user_version", nil)
+	if err != nil {
+		return fmt.Errorf("%s is not a grit database", path)
+	}
+	defer rows.Close()
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		return fmt.Errorf("%s is not a grit database", path)
+	}
+	v, _ := dest[0].(int64)
+	if v <= 0 {
+		return fmt.Errorf("%s is not a grit database", path)
+	}
+	if v > int64(len(migrationFuncs)) {
+		return fmt.Errorf("%s was created by a newer version of grit", path)
+	}
+	return nil
+}
+
+// Restore replaces the contents of the database with the backup at path, and
+// migrates it if needed. The current contents are backed up into the default
+// backup directory first, and the path of that backup is returned.
+func (d *Database) Restore(path string) (string, error) {
+	if err := d.checkBackup(path); err != nil {
+		return "", err
+	}
+	snapshot, err := d.snapshot("-restore")
+	if err != nil {
+		return "", fmt.Errorf("couldn't back up the database: %v", err)
+	}
+	if err := d.copyDatabase(d.Filename, path); err != nil {
+		return "", err
+	}
+	return snapshot, d.migrate()
+}
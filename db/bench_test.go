@@ -0,0 +1,191 @@
+package db
+
+import (
+	"container/list"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/climech/grit/multitree"
+)
+
+const (
+	benchNodes     = 50000
+	benchTreeSize  = 100 // root + 9 children + 90 grandchildren
+	benchDateNodes = 50  // date nodes joining the first trees into one component
+)
+
+// setupBenchDB creates a database with benchNodes nodes arranged into trees of
+// benchTreeSize. The first benchDateNodes trees are joined by date nodes
+// linking to their grandchildren, forming a single large multitree. It returns
+// the ID of a node in an isolated tree and the ID of a node in the large one.
+func setupBenchDB(b *testing.B) (*Database, int64, int64) {
+	tmpfile, err := ioutil.TempFile("", "grit_bench_db")
+	if err != nil {
+		b.Fatalf("couldn't create temp file: %v", err)
+	}
+	tmpfile.Close()
+	d, err := New(tmpfile.Name())
+	if err != nil {
+		b.Fatalf("couldn't create db: %v", err)
+	}
+
+	tx, err := d.DB.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	insertNode, _ := tx.Prepare("INSERT INTO nodes (node_id, node_name) VALUES (?, ?)")
+	insertLink, _ := tx.Prepare("INSERT INTO links (origin_id, dest_id) VALUES (?, ?)")
+	mustExec := func(stmt *sql.Stmt, args ...interface{}) {
+		if _, err := stmt.Exec(args...); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	var id int64
+	trees := (benchNodes - benchDateNodes) / benchTreeSize
+	for t := 0; t < trees; t++ {
+		id++
+		root := id
+		mustExec(insertNode, root, fmt.Sprintf("Tree %d", t))
+		for c := 0; c < 9; c++ {
+			id++
+			child := id
+			mustExec(insertNode, child, fmt.Sprintf("Child %d", c))
+			mustExec(insertLink, root, child)
+			for g := 0; g < 10; g++ {
+				id++
+				mustExec(insertNode, id, fmt.Sprintf("Grandchild %d", g))
+				mustExec(insertLink, child, id)
+			}
+		}
+	}
+	first := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < benchDateNodes; i++ {
+		id++
+		mustExec(insertNode, id, first.AddDate(0, 0, i).Format("2006-01-02"))
+		// Link to a grandchild in the current and the next tree.
+		for _, t := range []int{i, i + 1} {
+			grandchild := int64(t*benchTreeSize + 3 + i%10)
+			mustExec(insertLink, id, grandchild)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+
+	isolated := int64((trees-1)*benchTreeSize + 1)
+	joined := int64(1)
+	return d, isolated, joined
+}
+
+// getGraphBFS is the previous implementation of getGraph, which walks the
+// multitree breadth-first, querying the neighbors of each node separately. It's
+// kept for comparison.
+func getGraphBFS(tx *sql.Tx, nodeID int64) (*multitree.Node, error) {
+	query := func(q string, id int64) ([]*multitree.Node, error) {
+		rows, err := tx.Query(q, id)
+		if err != nil {
+			return nil, err
+		}
+		return rowsToNodes(rows), nil
+	}
+	parentsQuery := "SELECT " + nodeColumns + " FROM nodes " +
+		"LEFT JOIN links ON node_id = origin_id WHERE dest_id = ?"
+	childrenQuery := "SELECT " + nodeColumns + " FROM nodes " +
+		"LEFT JOIN links ON node_id = dest_id WHERE origin_id = ?"
+
+	node, err := getNode(tx, nodeID)
+	if err != nil || node == nil {
+		return nil, err
+	}
+
+	queue := list.New()
+	queue.PushBack(node)
+	visited := map[int64]*multitree.Node{node.ID: node}
+
+	linkNodes := func(origin, dest *multitree.Node) {
+		if origin.HasChild(dest) {
+			return
+		}
+		if err := multitree.LinkNodes(origin, dest); err != nil {
+			panic(err)
+		}
+	}
+
+	for elem := queue.Front(); elem != nil; elem = queue.Front() {
+		queue.Remove(elem)
+		current := elem.Value.(*multitree.Node)
+
+		parents, err := query(parentsQuery, current.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range parents {
+			if _, ok := visited[p.ID]; !ok {
+				visited[p.ID] = p
+				queue.PushBack(p)
+			}
+			linkNodes(visited[p.ID], current)
+		}
+
+		children, err := query(childrenQuery, current.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range children {
+			if _, ok := visited[c.ID]; !ok {
+				visited[c.ID] = c
+				queue.PushBack(c)
+			}
+			linkNodes(current, visited[c.ID])
+		}
+	}
+
+	return node, nil
+}
+
+func benchmarkGetGraph(b *testing.B, f func(*sql.Tx, int64) (*multitree.Node, error), joined bool) {
+	d, isolated, large := setupBenchDB(b)
+	defer func() {
+		d.Close()
+		os.Remove(d.Filename)
+	}()
+	id := isolated
+	if joined {
+		id = large
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := d.execTxFunc(func(tx *sql.Tx) error {
+			node, err := f(tx, id)
+			if err == nil && node == nil {
+				err = fmt.Errorf("node %d not found", id)
+			}
+			return err
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetGraphTree(b *testing.B) {
+	benchmarkGetGraph(b, getGraph, false)
+}
+
+func BenchmarkGetGraphTreeBFS(b *testing.B) {
+	benchmarkGetGraph(b, getGraphBFS, false)
+}
+
+func BenchmarkGetGraphMultitree(b *testing.B) {
+	benchmarkGetGraph(b, getGraph, true)
+}
+
+func BenchmarkGetGraphMultitreeBFS(b *testing.B) {
+	benchmarkGetGraph(b, getGraphBFS, true)
+}
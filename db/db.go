@@ -5,37 +5,91 @@ package db
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/climech/grit/store"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// Database implements all of the optional store interfaces.
+var (
+	_ store.Store             = (*Database)(nil)
+	_ store.Journal           = (*Database)(nil)
+	_ store.History           = (*Database)(nil)
+	_ store.Trash             = (*Database)(nil)
+	_ store.Checker           = (*Database)(nil)
+	_ store.Searcher          = (*Database)(nil)
+	_ store.Merger            = (*Database)(nil)
+	_ store.Backuper          = (*Database)(nil)
+	_ store.TimeTracker       = (*Database)(nil)
+	_ store.Recurrer          = (*Database)(nil)
+	_ store.PropertyTracker   = (*Database)(nil)
+	_ store.DependencyTracker = (*Database)(nil)
 )
 
+// DefaultBusyTimeout is the default time to wait for a lock held by another
+// connection.
+const DefaultBusyTimeout = 5 * time.Second
+
+const (
+	// maxBusyRetries is the number of times a transaction is retried if the
+	// database is still locked after the busy timeout.
+	maxBusyRetries = 5
+
+	// initialBusyBackoff is the delay before the first retry. It's doubled
+	// with each attempt.
+	initialBusyBackoff = 10 * time.Millisecond
+)
+
+// Options configure the database connection.
+type Options struct {
+	// BusyTimeout is the time to wait for a lock held by another connection
+	// before giving up. Defaults to DefaultBusyTimeout.
+	BusyTimeout time.Duration
+}
+
 type Database struct {
 	DB       *sql.DB
 	Filename string
+
+	// writer is the connection pool used for transactions that write. They
+	// start with BEGIN IMMEDIATE, see execWriteTxFunc.
+	writer *sql.DB
+
+	opts Options
 }
 
 func New(filename string) (*Database, error) {
-	d := &Database{}
+	return NewWithOptions(filename, Options{})
+}
+
+func NewWithOptions(filename string, opts Options) (*Database, error) {
+	if opts.BusyTimeout == 0 {
+		opts.BusyTimeout = DefaultBusyTimeout
+	}
+	d := &Database{Filename: filename, opts: opts}
 	if err := d.Open(filename); err != nil {
 		return nil, err
 	}
-	if err := d.init(); err != nil {
+	if err := d.migrate(); err != nil {
+		d.Close()
 		return nil, err
 	}
-	d.Filename = filename
 	return d, nil
 }
 
-func (d *Database) init() error {
-	if _, err := d.DB.Exec("PRAGMA foreign_keys = ON"); err != nil {
-		return err
-	}
-	return d.migrate()
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
 }
 
-func (d *Database) getUserVersion() (int64, error) {
-	row := d.DB.QueryRow(`PRAGMA user_version`)
+func getUserVersion(q queryRower) (int64, error) {
+	row := q.QueryRow(`PRAGMA user_version`)
 	var version int64
 	if err := row.Scan(&version); err != nil {
 		return 0, err
@@ -43,36 +97,59 @@ func (d *Database) getUserVersion() (int64, error) {
 	return version, nil
 }
 
-func (d *Database) setUserVersion(version int64) error {
+func setUserVersion(tx *sql.Tx, version int64) error {
 	// Using fmt.Sprintf -- driver doesn't parametrize values for PRAGMAs.
 	query := fmt.Sprintf("PRAGMA user_version = %d", version)
-	_, err := d.DB.Exec(query)
+	_, err := tx.Exec(query)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// Open connects to the database file. WAL mode is enabled, so that readers and
+// the writer don't block each other. Writers wait up to the busy timeout for
+// the lock held by another process; transactions that still fail to get it are
+// retried by execTxFunc and execWriteTxFunc.
 func (d *Database) Open(fp string) error {
-	sqlite3db, err := sql.Open("sqlite3", fp)
+	timeout := d.opts.BusyTimeout
+	if timeout == 0 {
+		timeout = DefaultBusyTimeout
+	}
+	params := url.Values{}
+	params.Set("_busy_timeout", strconv.FormatInt(timeout.Milliseconds(), 10))
+	params.Set("_journal_mode", "WAL")
+	params.Set("_foreign_keys", "1")
+
+	sqlite3db, err := sql.Open("sqlite3", fp+"?"+params.Encode())
+	if err != nil {
+		return err
+	}
+	params.Set("_txlock", "immediate")
+	writer, err := sql.Open("sqlite3", fp+"?"+params.Encode())
 	if err != nil {
+		sqlite3db.Close()
 		return err
 	}
-	d.DB = sqlite3db
+	d.DB, d.writer = sqlite3db, writer
 	return nil
 }
 
 func (d *Database) Close() error {
-	return d.DB.Close()
+	err := d.writer.Close()
+	if e := d.DB.Close(); e != nil {
+		err = e
+	}
+	return err
 }
 
-func (d *Database) beginTx() (*sql.Tx, error) {
+func beginTx(db *sql.DB) (*sql.Tx, error) {
 	ctx := context.TODO()
-	return d.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	return db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
 }
 
-func (d *Database) execTxFunc(f func(*sql.Tx) error) error {
-	tx, err := d.beginTx()
+func tryTxFunc(db *sql.DB, f func(*sql.Tx) error) error {
+	tx, err := beginTx(db)
 	if err != nil {
 		return err
 	}
@@ -82,3 +159,40 @@ func (d *Database) execTxFunc(f func(*sql.Tx) error) error {
 	}
 	return tx.Commit()
 }
+
+// isBusy returns true if the error was caused by a lock held by another
+// connection.
+func isBusy(err error) bool {
+	var e sqlite3.Error
+	return errors.As(err, &e) && (e.Code == sqlite3.ErrBusy || e.Code == sqlite3.ErrLocked)
+}
+
+// retryTxFunc runs f in a transaction on the pool. If the database is locked,
+// the whole transaction is retried with exponential backoff, so f must not
+// have side effects outside of it.
+func retryTxFunc(db *sql.DB, f func(*sql.Tx) error) error {
+	backoff := initialBusyBackoff
+	for i := 0; ; i++ {
+		err := tryTxFunc(db, f)
+		if err == nil || !isBusy(err) || i == maxBusyRetries {
+			return err
+		}
+		jitter := time.Duration(rand.Int63n(int64(backoff)))
+		time.Sleep(backoff + jitter)
+		backoff *= 2
+	}
+}
+
+// execTxFunc runs f in a read transaction, retried if the database is locked.
+func (d *Database) execTxFunc(f func(*sql.Tx) error) error {
+	return retryTxFunc(d.DB, f)
+}
+
+// execWriteTxFunc runs f in a transaction that takes the write lock when it
+// begins, retried if the database is locked. A transaction that only takes
+// the lock at its first write fails right away if another connection has
+// written in the meantime, as the busy timeout doesn't apply to it in WAL
+// mode.
+func (d *Database) execWriteTxFunc(f func(*sql.Tx) error) error {
+	return retryTxFunc(d.writer, f)
+}
@@ -1,9 +1,20 @@
 package db
 
 import (
+	"database/sql"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/climech/grit/store"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
 )
 
 func setupDB(t *testing.T) *Database {
@@ -99,3 +110,1141 @@ func TestAutodeleteDateNode(t *testing.T) {
 		}
 	}
 }
+
+func TestUndoRedo(t *testing.T) {
+	d := setupDB(t)
+	defer tearDB(t, d)
+
+	// Create the graph:
+	//
+	//   [x] test (1)
+	//    ├──[x] test (2)
+	//    └──[x] test (3)
+	//
+	rootID, err := d.CreateNode("test", 0)
+	if err != nil {
+		t.Fatalf("couldn't create root: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := d.CreateNode("test", rootID); err != nil {
+			t.Fatalf("couldn't create child: %v", err)
+		}
+	}
+	if err := d.CheckNode(rootID); err != nil {
+		t.Fatalf("couldn't check node: %v", err)
+	}
+	if _, err := d.DeleteNodeRecursive(rootID); err != nil {
+		t.Fatalf("couldn't delete tree: %v", err)
+	}
+
+	// Undo the deletion.
+	if entries, err := d.Undo(1); err != nil {
+		t.Fatalf("couldn't undo: %v", err)
+	} else if len(entries) != 1 {
+		t.Fatalf("got %d undone entries, want 1", len(entries))
+	}
+	root, err := d.GetGraph(rootID)
+	if err != nil {
+		t.Fatalf("couldn't get graph: %v", err)
+	}
+	if root == nil || len(root.Children()) != 2 {
+		t.Fatal("undo didn't restore the tree")
+	}
+	if !root.IsCompleted() || !root.Children()[0].IsCompleted() {
+		t.Error("undo didn't restore completion state")
+	}
+
+	// Undo the check.
+	if _, err := d.Undo(1); err != nil {
+		t.Fatalf("couldn't undo: %v", err)
+	}
+	if root, _ := d.GetGraph(rootID); root.IsCompleted() {
+		t.Error("undoing check left the node completed")
+	}
+
+	// Redo both.
+	if entries, err := d.Redo(2); err != nil {
+		t.Fatalf("couldn't redo: %v", err)
+	} else if len(entries) != 2 {
+		t.Fatalf("got %d redone entries, want 2", len(entries))
+	}
+	if n, _ := d.GetNode(rootID); n != nil {
+		t.Error("redo didn't delete the tree again")
+	}
+
+	// A new change discards the redo history.
+	if _, err := d.Undo(1); err != nil {
+		t.Fatalf("couldn't undo: %v", err)
+	}
+	if _, err := d.CreateNode("test", 0); err != nil {
+		t.Fatalf("couldn't create node: %v", err)
+	}
+	if entries, err := d.Redo(1); err != nil {
+		t.Fatalf("couldn't redo: %v", err)
+	} else if len(entries) != 0 {
+		t.Error("redo history wasn't discarded after a new change")
+	}
+}
+
+func TestEvents(t *testing.T) {
+	d := setupDB(t)
+	defer tearDB(t, d)
+
+	rootID, err := d.CreateNode("test", 0)
+	if err != nil {
+		t.Fatalf("couldn't create root: %v", err)
+	}
+	childID, err := d.CreateNode("test child", rootID)
+	if err != nil {
+		t.Fatalf("couldn't create child: %v", err)
+	}
+	if err := d.RenameNode(childID, "renamed"); err != nil {
+		t.Fatalf("couldn't rename node: %v", err)
+	}
+	if err := d.CheckNode(childID); err != nil {
+		t.Fatalf("couldn't check node: %v", err)
+	}
+	if err := d.UncheckNode(childID); err != nil {
+		t.Fatalf("couldn't uncheck node: %v", err)
+	}
+	if _, err := d.DeleteNode(childID); err != nil {
+		t.Fatalf("couldn't delete node: %v", err)
+	}
+
+	events, err := d.GetEvents(store.EventFilter{NodeID: childID})
+	if err != nil {
+		t.Fatalf("couldn't get events: %v", err)
+	}
+	want := []string{store.EventCreate, store.EventLink, store.EventRename, store.EventCheck,
+		store.EventUncheck, store.EventUnlink, store.EventDelete}
+	var got []string
+	for _, e := range events {
+		got = append(got, e.Type)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("invalid node history; want %v, got %v", want, got)
+	}
+
+	// Backpropagated status changes are part of the parent's history.
+	events, err = d.GetEvents(store.EventFilter{
+		NodeID: rootID,
+		Types:  []string{store.EventCheck, store.EventUncheck},
+	})
+	if err != nil {
+		t.Fatalf("couldn't get events: %v", err)
+	}
+	if len(events) != 2 {
+		t.Errorf("got %d status events for parent, want 2", len(events))
+	}
+}
+
+func TestTrashAndRestore(t *testing.T) {
+	d := setupDB(t)
+	defer tearDB(t, d)
+
+	// Create the graph:
+	//
+	//   [ ] test (1)           [ ] 2020-01-01 (4)
+	//    └──[ ] test (2)        └──[ ] test (5)
+	//        └──[ ] test (3)        └──[ ] test (3)
+	//
+	rootID, _ := d.CreateNode("test", 0)
+	childID, _ := d.CreateNode("test", rootID)
+	leafID, _ := d.CreateNode("test", childID)
+	dateChildID, err := d.CreateChildOfDateNode("2020-01-01", "test")
+	if err != nil {
+		t.Fatalf("couldn't create child of date node: %v", err)
+	}
+	if _, err := d.CreateLink(dateChildID, leafID); err != nil {
+		t.Fatalf("couldn't create link: %v", err)
+	}
+	if err := d.SetAlias(rootID, "root"); err != nil {
+		t.Fatalf("couldn't set alias: %v", err)
+	}
+
+	if _, err := d.TrashNodeRecursive(rootID); err != nil {
+		t.Fatalf("couldn't trash tree: %v", err)
+	}
+	if _, err := d.TrashNode(dateChildID); err != nil {
+		t.Fatalf("couldn't trash node: %v", err)
+	}
+	if n, _ := d.GetNodeByName("2020-01-01"); n != nil {
+		t.Fatal("empty date node wasn't deleted")
+	}
+	entries, err := d.GetTrash()
+	if err != nil {
+		t.Fatalf("couldn't get trash: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d trash entries, want 2", len(entries))
+	}
+
+	// Restoring should bring back the original links, recreating the date node.
+	if _, err := d.RestoreNode(dateChildID); err != nil {
+		t.Fatalf("couldn't restore node: %v", err)
+	}
+	if id, err := d.RestoreNode(rootID); err != nil {
+		t.Fatalf("couldn't restore tree: %v", err)
+	} else if id != rootID {
+		t.Errorf("restored node got ID %d, want %d", id, rootID)
+	}
+	leaf, err := d.GetGraph(leafID)
+	if err != nil {
+		t.Fatalf("couldn't get graph: %v", err)
+	}
+	if len(leaf.Parents()) != 2 {
+		t.Errorf("restored leaf has %d parents, want 2", len(leaf.Parents()))
+	}
+	if root := leaf.Get(rootID); root == nil || root.Alias != "root" {
+		t.Error("restored root lost its alias")
+	}
+	if dn := leaf.GetByName("2020-01-01"); dn == nil || !dn.IsDateNode() {
+		t.Error("date node wasn't recreated")
+	}
+	if entries, _ := d.GetTrash(); len(entries) != 0 {
+		t.Errorf("got %d trash entries after restoring, want 0", len(entries))
+	}
+
+	// Purge.
+	if _, err := d.TrashNode(childID); err != nil {
+		t.Fatalf("couldn't trash node: %v", err)
+	}
+	if count, err := d.EmptyTrash(0); err != nil {
+		t.Fatalf("couldn't empty trash: %v", err)
+	} else if count != 1 {
+		t.Errorf("purged %d entries, want 1", count)
+	}
+	if _, err := d.RestoreNode(childID); err == nil {
+		t.Error("restored a purged node")
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	src := setupDB(t)
+	defer tearDB(t, src)
+
+	rootID, _ := src.CreateNode("test", 0)
+	childID, _ := src.CreateNode("test", rootID)
+	dateChildID, err := src.CreateChildOfDateNode("2020-01-01", "test")
+	if err != nil {
+		t.Fatalf("couldn't create child of date node: %v", err)
+	}
+	if _, err := src.CreateLink(dateChildID, childID); err != nil {
+		t.Fatalf("couldn't create link: %v", err)
+	}
+	if err := src.SetAlias(rootID, "root"); err != nil {
+		t.Fatalf("couldn't set alias: %v", err)
+	}
+	if err := src.CheckNode(childID); err != nil {
+		t.Fatalf("couldn't check node: %v", err)
+	}
+	if err := src.SetNotes(childID, "line 1\nline 2"); err != nil {
+		t.Fatalf("couldn't set notes: %v", err)
+	}
+	if err := src.SetEstimate(childID, 2.5); err != nil {
+		t.Fatalf("couldn't set estimate: %v", err)
+	}
+	if err := src.SetProperty(childID, "owner", "alice"); err != nil {
+		t.Fatalf("couldn't set property: %v", err)
+	}
+	// Leave a gap in the IDs.
+	tmpID, _ := src.CreateNode("tmp", 0)
+	if _, err := src.DeleteNode(tmpID); err != nil {
+		t.Fatalf("couldn't delete node: %v", err)
+	}
+	lastID, _ := src.CreateNode("last", 0)
+	if err := src.CancelNode(lastID); err != nil {
+		t.Fatalf("couldn't cancel node: %v", err)
+	}
+	if _, err := src.AddDependency(rootID, lastID); err != nil {
+		t.Fatalf("couldn't add dependency: %v", err)
+	}
+
+	dump, err := src.Export()
+	if err != nil {
+		t.Fatalf("couldn't export: %v", err)
+	}
+
+	// Importing into an empty database should give an identical dump.
+	dst := setupDB(t)
+	defer tearDB(t, dst)
+	if _, err := dst.Import(dump); err != nil {
+		t.Fatalf("couldn't import: %v", err)
+	}
+	got, err := dst.Export()
+	if err != nil {
+		t.Fatalf("couldn't export: %v", err)
+	}
+	if !reflect.DeepEqual(got, dump) {
+		t.Errorf("import into empty database isn't exact")
+	}
+	if n, _ := dst.GetNode(lastID); n == nil || n.Name != "last" {
+		t.Errorf("node %d wasn't restored with its ID", lastID)
+	}
+
+	// Importing into the same database should remap the IDs and merge the date
+	// nodes. The alias is taken, so we drop it first.
+	if err := src.SetAlias(rootID, ""); err != nil {
+		t.Fatalf("couldn't unset alias: %v", err)
+	}
+	ids, err := src.Import(dump)
+	if err != nil {
+		t.Fatalf("couldn't import: %v", err)
+	}
+	if ids[rootID] == rootID {
+		t.Errorf("node ID wasn't remapped")
+	}
+	dateNode, err := src.GetNodeByName("2020-01-01")
+	if err != nil {
+		t.Fatalf("couldn't get date node: %v", err)
+	}
+	g, err := src.GetGraph(dateNode.ID)
+	if err != nil {
+		t.Fatalf("couldn't get graph: %v", err)
+	}
+	if len(g.Children()) != 2 {
+		t.Errorf("date node has %d children, want 2", len(g.Children()))
+	}
+	if n := g.Get(ids[rootID]); n == nil || n.Alias != "root" {
+		t.Errorf("imported node lost its alias")
+	}
+	if n := g.Get(ids[childID]); n == nil || !n.IsCompleted() {
+		t.Errorf("imported node lost its completion status")
+	}
+	if props, _ := src.GetProperties(ids[childID]); len(props) != 1 {
+		t.Errorf("imported node lost its properties")
+	}
+	deps, _ := src.GetDependencies()
+	if len(deps) != 2 || deps[1].NodeID != ids[rootID] || deps[1].PrereqID != ids[lastID] {
+		t.Errorf("got dependencies %+v, want the imported one remapped", deps)
+	}
+}
+
+func TestFsck(t *testing.T) {
+	d := setupDB(t)
+	defer tearDB(t, d)
+
+	rootID, _ := d.CreateNode("test", 0)
+	childID, _ := d.CreateNode("test", rootID)
+	leafID, _ := d.CreateNode("test", childID)
+
+	// Corrupt the database behind the API's back.
+	queries := []string{
+		fmt.Sprintf("INSERT INTO links (origin_id, dest_id) VALUES (%d, %d)",
+			leafID, rootID),
+		fmt.Sprintf("INSERT INTO links (origin_id, dest_id) VALUES (%d, %d)",
+			rootID, leafID),
+		"PRAGMA foreign_keys = OFF",
+		fmt.Sprintf("INSERT INTO links (origin_id, dest_id) VALUES (%d, 99)",
+			childID),
+		"PRAGMA foreign_keys = ON",
+		"INSERT INTO nodes (node_name) VALUES ('2020-01-01')",
+		fmt.Sprintf("UPDATE nodes SET node_completed = 1 WHERE node_id = %d",
+			rootID),
+		fmt.Sprintf("UPDATE nodes SET node_alias = '%s' WHERE node_id = %d",
+			strings.Repeat("a", 101), childID),
+		fmt.Sprintf("UPDATE nodes SET node_name = '' WHERE node_id = %d", leafID),
+	}
+	for _, q := range queries {
+		if _, err := d.DB.Exec(q); err != nil {
+			t.Fatalf("couldn't execute %q: %v", q, err)
+		}
+	}
+
+	if _, err := d.GetGraph(rootID); err == nil {
+		t.Error("invalid graph loaded and no error returned")
+	}
+
+	kinds := func(problems []*store.Problem) []string {
+		var kinds []string
+		for _, p := range problems {
+			kinds = append(kinds, p.Kind)
+		}
+		return kinds
+	}
+
+	problems, err := d.Fsck()
+	if err != nil {
+		t.Fatalf("fsck failed: %v", err)
+	}
+	want := []string{
+		store.ProblemCycle,
+		store.ProblemDiamond,
+		store.ProblemDanglingLink,
+		store.ProblemCompletion,
+		store.ProblemInvalidAlias,
+		store.ProblemInvalidName,
+		store.ProblemEmptyDateNode,
+	}
+	if got := kinds(problems); !reflect.DeepEqual(got, want) {
+		t.Errorf("got problems %v, want %v", got, want)
+	}
+
+	if _, err := d.Repair(); err != nil {
+		t.Fatalf("repair failed: %v", err)
+	}
+	problems, err = d.Fsck()
+	if err != nil {
+		t.Fatalf("fsck failed: %v", err)
+	}
+	if got := kinds(problems); !reflect.DeepEqual(got, []string{store.ProblemInvalidName}) {
+		t.Errorf("got problems %v after repair, want only %v", got,
+			store.ProblemInvalidName)
+	}
+	if _, err := d.GetGraph(rootID); err != nil {
+		t.Errorf("couldn't load repaired graph: %v", err)
+	}
+}
+
+// TestRepairCompletionTime fails if fsck completes a node at a different time
+// than checking its children does.
+func TestRepairCompletionTime(t *testing.T) {
+	d := setupDB(t)
+	defer tearDB(t, d)
+
+	rootID, _ := d.CreateNode("root", 0)
+	aID, _ := d.CreateNode("a", rootID)
+	bID, _ := d.CreateNode("b", rootID)
+	d.CheckNodeAt(bID, 200)
+	d.CheckNodeAt(aID, 100)
+	root, _ := d.GetNode(rootID)
+	want := *root.Completed
+
+	if _, err := d.DB.Exec("UPDATE nodes SET node_completed = NULL "+
+		"WHERE node_id = ?", rootID); err != nil {
+		t.Fatalf("couldn't uncheck node: %v", err)
+	}
+	if _, err := d.Repair(); err != nil {
+		t.Fatalf("repair failed: %v", err)
+	}
+	if root, _ := d.GetNode(rootID); root.Completed == nil || *root.Completed != want {
+		t.Errorf("got completion time %v, want %d", root.Completed, want)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	d := setupDB(t)
+	defer tearDB(t, d)
+
+	mathID, _ := d.CreateNode("Math", 0)
+	longID, _ := d.CreateNode("Work through the algebra textbook", mathID)
+	shortID, _ := d.CreateNode("Algebra", mathID)
+	otherID, _ := d.CreateNode("Algèbre", 0)
+	groceriesID, _ := d.CreateNode("Buy groceries", 0)
+
+	search := func(query string, filter store.SearchFilter) []int64 {
+		nodes, err := d.Search(query, filter)
+		if err != nil {
+			t.Fatalf("couldn't search for %q: %v", query, err)
+		}
+		var ids []int64
+		for _, n := range nodes {
+			ids = append(ids, n.ID)
+		}
+		return ids
+	}
+
+	// Shorter names should be ranked higher; diacritics are ignored.
+	want := []int64{shortID, otherID, longID}
+	if got := search("alg", store.SearchFilter{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	want = []int64{shortID, longID}
+	if got := search("alg", store.SearchFilter{AncestorID: mathID}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v for descendants, want %v", got, want)
+	}
+	if err := d.CheckNode(shortID); err != nil {
+		t.Fatalf("couldn't check node: %v", err)
+	}
+	want = []int64{otherID, longID}
+	if got := search("alg", store.SearchFilter{Incomplete: true}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v for incomplete, want %v", got, want)
+	}
+
+	// The index should follow renames and deletions.
+	if err := d.RenameNode(groceriesID, "Buy vegetables"); err != nil {
+		t.Fatalf("couldn't rename node: %v", err)
+	}
+	if got := search("groceries", store.SearchFilter{}); len(got) != 0 {
+		t.Errorf("got %v for old name, want none", got)
+	}
+	if got := search("veg", store.SearchFilter{}); !reflect.DeepEqual(got, []int64{groceriesID}) {
+		t.Errorf("got %v for new name, want [%d]", got, groceriesID)
+	}
+	if _, err := d.DeleteNode(groceriesID); err != nil {
+		t.Fatalf("couldn't delete node: %v", err)
+	}
+	if got := search("veg", store.SearchFilter{}); len(got) != 0 {
+		t.Errorf("got %v for deleted node, want none", got)
+	}
+}
+
+// TestSearchIndex fails if the search index doesn't use FTS5 when SQLite has
+// it, or stops working after a database is opened by a build with a different
+// set of modules.
+func TestSearchIndex(t *testing.T) {
+	d := setupDB(t)
+	defer tearDB(t, d)
+
+	id, _ := d.CreateNode("Algebra", 0)
+	fts5, _ := fts5Available(d.DB)
+	if got, _ := searchIndexUsesFTS5(d.DB); got != fts5 {
+		t.Errorf("index uses FTS5: %v, want %v", got, fts5)
+	}
+
+	// Pretend that the database was last opened by a build with FTS4 only.
+	err := d.execWriteTxFunc(func(tx *sql.Tx) error {
+		return createSearchIndex(tx, false)
+	})
+	if err != nil {
+		t.Fatalf("couldn't create FTS4 index: %v", err)
+	}
+	if nodes, err := d.Search("alg", store.SearchFilter{}); err != nil ||
+		len(nodes) != 1 || nodes[0].ID != id {
+		t.Errorf("got %v (err: %v) from FTS4 index, want node %d", nodes, err, id)
+	}
+	if err := d.updateSearchIndex(); err != nil {
+		t.Fatalf("couldn't update index: %v", err)
+	}
+	if got, _ := searchIndexUsesFTS5(d.DB); got != fts5 {
+		t.Errorf("index uses FTS5 after update: %v, want %v", got, fts5)
+	}
+	d.RenameNode(id, "Geometry")
+	if nodes, err := d.Search("geo", store.SearchFilter{}); err != nil || len(nodes) != 1 {
+		t.Errorf("got %v (err: %v) from updated index, want node %d", nodes, err, id)
+	}
+}
+
+func TestIsBusy(t *testing.T) {
+	busy := sqlite3.Error{Code: sqlite3.ErrBusy}
+	if !isBusy(busy) || !isBusy(fmt.Errorf("couldn't undo %q: %w", "add", busy)) {
+		t.Errorf("busy error not recognized")
+	}
+	if isBusy(sqlite3.Error{Code: sqlite3.ErrConstraint}) || isBusy(fmt.Errorf("busy")) {
+		t.Errorf("other error taken for a busy one")
+	}
+}
+
+// TestConcurrentAccess opens the same database file from many connections at
+// once, as separate processes would, and fails if any of the writes fail or
+// leave the completion statuses inconsistent.
+func TestConcurrentAccess(t *testing.T) {
+	d := setupDB(t)
+	defer tearDB(t, d)
+
+	const workers, iterations = 8, 10
+	rootID, _ := d.CreateNode("root", 0)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*iterations)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := NewWithOptions(d.Filename, Options{BusyTimeout: 10 * time.Second})
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer conn.Close()
+			for j := 0; j < iterations; j++ {
+				id, err := conn.CreateNode("test", rootID)
+				if err != nil {
+					errs <- err
+					continue
+				}
+				if err := conn.CheckNode(id); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+
+	// Keep reading the graph in the meantime.
+	done := make(chan struct{})
+	readErrs := make(chan error, 1)
+	go func() {
+		defer close(readErrs)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if _, err := d.GetGraph(rootID); err != nil {
+				readErrs <- err
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(done)
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent write failed: %v", err)
+	}
+	if err := <-readErrs; err != nil {
+		t.Errorf("concurrent read failed: %v", err)
+	}
+
+	root, err := d.GetGraph(rootID)
+	if err != nil {
+		t.Fatalf("couldn't get graph: %v", err)
+	}
+	if got := len(root.Children()); got != workers*iterations {
+		t.Errorf("got %d children, want %d", got, workers*iterations)
+	}
+	if !root.IsCompleted() {
+		t.Error("root isn't completed after all children were checked")
+	}
+	if problems, err := d.Fsck(); err != nil {
+		t.Fatalf("fsck failed: %v", err)
+	} else if len(problems) > 0 {
+		t.Errorf("fsck found %d problem(s), first: %s", len(problems),
+			problems[0].Message)
+	}
+}
+
+// setupCopy returns a new database holding an exact copy of d, as if it was
+// copied to another machine.
+func setupCopy(t *testing.T, d *Database) *Database {
+	dump, err := d.Export()
+	if err != nil {
+		t.Fatalf("couldn't export: %v", err)
+	}
+	c := setupDB(t)
+	if _, err := c.Import(dump); err != nil {
+		t.Fatalf("couldn't import: %v", err)
+	}
+	return c
+}
+
+func mustMerge(t *testing.T, d, other *Database, resolve string) *store.MergeResult {
+	result, err := d.Merge(other.Filename, resolve)
+	if err != nil {
+		t.Fatalf("couldn't merge: %v", err)
+	}
+	return result
+}
+
+func nodeName(d *Database, id int64) string {
+	if n, _ := d.GetNode(id); n != nil {
+		return n.Name
+	}
+	return ""
+}
+
+func TestMerge(t *testing.T) {
+	a := setupDB(t)
+	defer tearDB(t, a)
+
+	rootID, _ := a.CreateNode("root", 0)
+	xID, _ := a.CreateNode("x", rootID)
+	yID, _ := a.CreateNode("y", rootID)
+	otherID, _ := a.CreateNode("other", 0)
+
+	b := setupCopy(t, a)
+	defer tearDB(t, b)
+
+	// Identical databases should merge cleanly both ways.
+	if r := mustMerge(t, a, b, store.MergeAbort); r.NodesAdded+r.NodesUpdated+
+		r.NodesDeleted+r.LinksAdded+r.LinksDeleted != 0 {
+		t.Errorf("merging identical databases changed something: %+v", r)
+	}
+	mustMerge(t, b, a, store.MergeAbort)
+
+	// Non-conflicting changes on both sides.
+	a.CreateNode("a only", rootID)
+	a.CheckNode(xID)
+	b.RenameNode(yID, "y renamed")
+	b.CreateNode("b only", rootID)
+	b.DeleteNode(otherID)
+
+	r := mustMerge(t, a, b, store.MergeAbort)
+	if len(r.Conflicts) > 0 {
+		t.Fatalf("unexpected conflict: %s", r.Conflicts[0].Message)
+	}
+	if r.NodesAdded != 1 || r.NodesUpdated != 1 || r.NodesDeleted != 1 {
+		t.Errorf("got %d added, %d updated, %d deleted nodes, want 1 each",
+			r.NodesAdded, r.NodesUpdated, r.NodesDeleted)
+	}
+	root, err := a.GetGraph(rootID)
+	if err != nil {
+		t.Fatalf("couldn't get graph: %v", err)
+	}
+	var names []string
+	for _, c := range root.Children() {
+		names = append(names, c.Name)
+	}
+	if want := "x, y renamed, a only, b only"; strings.Join(names, ", ") != want {
+		t.Errorf("got children %q, want %q", strings.Join(names, ", "), want)
+	}
+	if n, _ := a.GetNode(xID); n == nil || !n.IsCompleted() {
+		t.Errorf("local change was lost")
+	}
+	if n, _ := a.GetNode(otherID); n != nil {
+		t.Errorf("deleted node is still present")
+	}
+
+	// The other side gets our changes, and the merges are now in sync.
+	mustMerge(t, b, a, store.MergeAbort)
+	if got, _ := b.GetNodeByName("a only"); got == nil {
+		t.Errorf("node wasn't merged back")
+	}
+	if r := mustMerge(t, a, b, store.MergeAbort); r.NodesAdded+r.NodesUpdated+
+		r.NodesDeleted != 0 {
+		t.Errorf("merging synced databases changed something: %+v", r)
+	}
+
+	// Colliding renames should abort the merge, unless resolved.
+	a.RenameNode(xID, "x by a")
+	b.RenameNode(xID, "x by b")
+	r, err = a.Merge(b.Filename, store.MergeAbort)
+	if err != store.ErrConflicts {
+		t.Fatalf("got error %v, want %v", err, store.ErrConflicts)
+	}
+	if len(r.Conflicts) != 1 || r.Conflicts[0].Kind != store.ConflictName {
+		t.Errorf("got conflicts %+v, want one name conflict", r.Conflicts)
+	}
+	if got := nodeName(a, xID); got != "x by a" {
+		t.Errorf("aborted merge changed the name to %q", got)
+	}
+	mustMerge(t, a, b, store.MergeTheirs)
+	if got := nodeName(a, xID); got != "x by b" {
+		t.Errorf("got name %q, want %q", got, "x by b")
+	}
+
+	// Links that would form a cycle together must not both be merged.
+	newID, _ := a.CreateNode("new", 0)
+	mustMerge(t, b, a, store.MergeAbort)
+	a.CreateLink(newID, rootID)
+	b.CreateLink(xID, newID)
+	r, err = a.Merge(b.Filename, store.MergeAbort)
+	if err != store.ErrConflicts {
+		t.Fatalf("got error %v, want %v", err, store.ErrConflicts)
+	}
+	if len(r.Conflicts) != 1 || r.Conflicts[0].Kind != store.ConflictLink {
+		t.Errorf("got conflicts %+v, want one link conflict", r.Conflicts)
+	}
+	r = mustMerge(t, a, b, store.MergeOurs)
+	if r.LinksAdded != 0 {
+		t.Errorf("conflicting link was added")
+	}
+	if problems, err := a.Fsck(); err != nil {
+		t.Fatalf("fsck failed: %v", err)
+	} else if len(problems) > 0 {
+		t.Errorf("merge left a problem: %s", problems[0].Message)
+	}
+
+	// The merge can be undone like any other change.
+	a.RenameNode(rootID, "root by a")
+	b.RenameNode(yID, "y by b")
+	mustMerge(t, a, b, store.MergeAbort)
+	if _, err := a.Undo(1); err != nil {
+		t.Fatalf("couldn't undo: %v", err)
+	}
+	if got := nodeName(a, yID); got != "y renamed" {
+		t.Errorf("got name %q after undo, want %q", got, "y renamed")
+	}
+
+	if _, err := a.Merge(a.Filename, store.MergeAbort); err == nil {
+		t.Errorf("database merged with itself")
+	}
+}
+
+// TestMergeFirst fails if the first merge of two copies of a database doesn't
+// tell the changes made on either side since the copy was made.
+func TestMergeFirst(t *testing.T) {
+	a := setupDB(t)
+	defer tearDB(t, a)
+
+	rootID, _ := a.CreateNode("root", 0)
+	xID, _ := a.CreateNode("x", rootID)
+	yID, _ := a.CreateNode("y", rootID)
+	zID, _ := a.CreateNode("z", rootID)
+
+	// Pretend that the graph was made a while before the copy.
+	age := func(d *Database) {
+		for _, q := range []string{
+			"UPDATE nodes SET node_modified = node_modified - 100",
+			"UPDATE links SET link_modified = link_modified - 100",
+		} {
+			if _, err := d.DB.Exec(q); err != nil {
+				t.Fatalf("couldn't update modification times: %v", err)
+			}
+		}
+	}
+	age(a)
+	b := setupCopy(t, a)
+	defer tearDB(t, b)
+	age(b)
+
+	b.DeleteNode(xID)
+	b.RenameNode(yID, "y by b")
+	a.RenameNode(zID, "z by a")
+	a.CreateNode("a only", rootID)
+
+	// The deletion can't be told apart from an addition, so it's a conflict.
+	r, err := a.Merge(b.Filename, store.MergeAbort)
+	if err != store.ErrConflicts {
+		t.Fatalf("got error %v, want %v", err, store.ErrConflicts)
+	}
+	if len(r.Conflicts) != 1 || r.Conflicts[0].Kind != store.ConflictDelete {
+		t.Fatalf("got conflicts %+v, want the deleted node", r.Conflicts)
+	}
+	r = mustMerge(t, a, b, store.MergeTheirs)
+	if len(r.Conflicts) != 1 {
+		t.Errorf("got %d conflicts, want 1", len(r.Conflicts))
+	}
+	if n, _ := a.GetNode(xID); n != nil {
+		t.Errorf("node deleted in the other database was restored")
+	}
+	if got := nodeName(a, yID); got != "y by b" {
+		t.Errorf("got name %q, want %q", got, "y by b")
+	}
+	if got := nodeName(a, zID); got != "z by a" {
+		t.Errorf("got name %q, want %q", got, "z by a")
+	}
+	if n, _ := a.GetNodeByName("a only"); n == nil {
+		t.Errorf("local node was deleted")
+	}
+
+	// Changes made to the same node on both sides still collide.
+	c := setupCopy(t, a)
+	defer tearDB(t, c)
+	age(a)
+	age(c)
+	a.RenameNode(yID, "y by a")
+	c.RenameNode(yID, "y by c")
+	if _, err := a.Merge(c.Filename, store.MergeAbort); err != store.ErrConflicts {
+		t.Errorf("got error %v, want %v", err, store.ErrConflicts)
+	}
+
+	// The same change made on both sides after the split doesn't make the
+	// nodes added since look deleted.
+	d := setupCopy(t, a)
+	defer tearDB(t, d)
+	age(a)
+	age(d)
+	newID, _ := a.CreateNode("new", rootID)
+	a.CheckNode(zID)
+	d.CheckNode(zID)
+	r, err = a.Merge(d.Filename, store.MergeOurs)
+	if err != nil {
+		t.Fatalf("couldn't merge: %v", err)
+	}
+	if r.NodesDeleted != 0 {
+		t.Errorf("got %d deleted nodes, want 0", r.NodesDeleted)
+	}
+	if n, _ := a.GetNode(newID); n == nil {
+		t.Errorf("local node was deleted")
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grit_test_backups")
+	if err != nil {
+		t.Fatalf("couldn't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	// Keep the default backup directory inside the temp dir.
+	d, err := New(filepath.Join(dir, "graph.db"))
+	if err != nil {
+		t.Fatalf("couldn't create db: %v", err)
+	}
+	defer d.Close()
+
+	d.CreateNode("before", 0)
+	backup, err := d.Backup(filepath.Join(dir, "backup.db"), 0)
+	if err != nil {
+		t.Fatalf("couldn't back up: %v", err)
+	}
+	if _, err := d.Backup(backup, 0); err == nil {
+		t.Errorf("existing backup was overwritten")
+	}
+
+	// Only the newest backups should be kept in a directory.
+	rotated := filepath.Join(dir, "rotated")
+	os.Mkdir(rotated, 0755)
+	var paths []string
+	for i := 0; i < 3; i++ {
+		path, err := d.Backup(rotated, 2)
+		if err != nil {
+			t.Fatalf("couldn't back up: %v", err)
+		}
+		paths = append(paths, path)
+		time.Sleep(2 * time.Millisecond)
+	}
+	if _, err := os.Stat(paths[0]); !os.IsNotExist(err) {
+		t.Errorf("oldest backup wasn't removed")
+	}
+	for _, path := range paths[1:] {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("backup missing: %v", err)
+		}
+	}
+
+	d.CreateNode("after", 0)
+	previous, err := d.Restore(backup)
+	if err != nil {
+		t.Fatalf("couldn't restore: %v", err)
+	}
+	if n, _ := d.GetNodeByName("after"); n != nil {
+		t.Errorf("restored database contains a newer node")
+	}
+	if n, _ := d.GetNodeByName("before"); n == nil {
+		t.Errorf("restored database is missing a node")
+	}
+
+	// The replaced contents should be kept.
+	prev, err := New(previous)
+	if err != nil {
+		t.Fatalf("couldn't open previous contents: %v", err)
+	}
+	defer prev.Close()
+	if n, _ := prev.GetNodeByName("after"); n == nil {
+		t.Errorf("previous contents weren't backed up")
+	}
+
+	junk := filepath.Join(dir, "junk.db")
+	ioutil.WriteFile(junk, []byte("junk"), 0644)
+	if _, err := d.Restore(junk); err == nil {
+		t.Errorf("restored from a file that isn't a database")
+	}
+}
+
+// TestMigrationBackup fails if an old database isn't backed up before it's
+// migrated.
+func TestMigrationBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grit_test_migration")
+	if err != nil {
+		t.Fatalf("couldn't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "graph.db")
+
+	// Create a database at version 1.
+	old := &Database{Filename: filename}
+	if err := old.Open(filename); err != nil {
+		t.Fatalf("couldn't open db: %v", err)
+	}
+	err = old.execWriteTxFunc(func(tx *sql.Tx) error {
+		if err := migrationFuncs[0](tx); err != nil {
+			return err
+		}
+		return setUserVersion(tx, 1)
+	})
+	old.Close()
+	if err != nil {
+		t.Fatalf("couldn't create old db: %v", err)
+	}
+
+	d, err := New(filename)
+	if err != nil {
+		t.Fatalf("couldn't migrate db: %v", err)
+	}
+	d.Close()
+
+	backups, err := listBackups(filename, DefaultBackupDir(filename))
+	if err != nil {
+		t.Fatalf("couldn't list backups: %v", err)
+	}
+	if len(backups) != 1 || !strings.HasSuffix(backups[0], "-v1.db") {
+		t.Fatalf("got backups %v, want one of version 1", backups)
+	}
+	b := &Database{}
+	b.Open(filepath.Join(DefaultBackupDir(filename), backups[0]))
+	defer b.Close()
+	if v, err := getUserVersion(b.DB); err != nil || v != 1 {
+		t.Errorf("backup has version %d, want 1 (err: %v)", v, err)
+	}
+}
+
+func TestTimers(t *testing.T) {
+	d := setupDB(t)
+	defer tearDB(t, d)
+
+	aID, _ := d.CreateNode("a", 0)
+	bID, _ := d.CreateNode("b", 0)
+
+	if _, err := d.StartTimer(aID, 100, ""); err != nil {
+		t.Fatalf("couldn't start timer: %v", err)
+	}
+	if _, err := d.StartTimer(bID, 50, ""); err == nil {
+		t.Errorf("timer stopped before it started")
+	}
+	if _, err := d.StartTimer(bID, 200, ""); err != nil {
+		t.Fatalf("couldn't start timer: %v", err)
+	}
+	if _, err := d.StartTimer(bID+1, 300, ""); err == nil {
+		t.Errorf("timer started on a nonexistent node")
+	}
+	if e, _ := d.GetRunningTimer(); e == nil || e.NodeID != bID || e.NodeName != "b" {
+		t.Errorf("got running timer %+v, want node %d", e, bID)
+	}
+
+	// Entries outlive their nodes.
+	if _, err := d.DeleteNode(aID); err != nil {
+		t.Fatalf("couldn't delete node: %v", err)
+	}
+	entries, err := d.GetTimeEntries(150, 0)
+	if err != nil {
+		t.Fatalf("couldn't get entries: %v", err)
+	}
+	if len(entries) != 2 || entries[0].NodeID != aID || entries[0].NodeName != "" ||
+		entries[0].Stop != 200 || entries[1].Stop != 0 {
+		t.Errorf("got entries %+v, want the stopped and the running one", entries)
+	}
+	if entries, _ := d.GetTimeEntries(0, 100); len(entries) != 0 {
+		t.Errorf("got %d entries before the first timer started", len(entries))
+	}
+
+	// Stopping the timer can be undone.
+	if e, err := d.StopTimer(400); err != nil || e == nil || e.Stop != 400 {
+		t.Fatalf("couldn't stop timer: %+v, %v", e, err)
+	}
+	if e, err := d.StopTimer(500); err != nil || e != nil {
+		t.Errorf("got %+v, %v when no timer is running", e, err)
+	}
+	if _, err := d.Undo(1); err != nil {
+		t.Fatalf("couldn't undo: %v", err)
+	}
+	if e, _ := d.GetRunningTimer(); e == nil || e.NodeID != bID {
+		t.Errorf("timer isn't running after undo")
+	}
+
+	// The node is linked from the date node in the same step.
+	if _, err := d.StartTimer(bID, 100, "2020-11-14"); err == nil {
+		t.Errorf("timer stopped before it started")
+	}
+	if n, _ := d.GetNodeByName("2020-11-14"); n != nil {
+		t.Errorf("date node created by a failed start")
+	}
+	if _, err := d.StartTimer(bID, 600, "2020-11-14"); err != nil {
+		t.Fatalf("couldn't start timer: %v", err)
+	}
+	if g, _ := d.GetGraph(bID); len(g.Parents()) != 1 || g.Parents()[0].Name != "2020-11-14" {
+		t.Errorf("node wasn't linked from the date node")
+	}
+	if _, err := d.Undo(1); err != nil {
+		t.Fatalf("couldn't undo: %v", err)
+	}
+	if g, _ := d.GetGraph(bID); len(g.Parents()) != 0 {
+		t.Errorf("link remains after undo")
+	}
+	if e, _ := d.GetRunningTimer(); e == nil || e.Start != 200 {
+		t.Errorf("got running timer %+v after undo, want the one started at 200", e)
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	d := setupDB(t)
+	defer tearDB(t, d)
+
+	aID, _ := d.CreateNode("a", 0)
+	bID, _ := d.CreateNode("b", 0)
+	cID, _ := d.CreateNode("c", 0)
+	childID, _ := d.CreateNode("child", bID)
+	grandchildID, _ := d.CreateNode("grandchild", childID)
+
+	if _, err := d.AddDependency(bID, grandchildID); err == nil {
+		t.Errorf("node made to depend on its descendant")
+	}
+	if _, err := d.AddDependency(grandchildID, bID); err == nil {
+		t.Errorf("node made to depend on its ancestor")
+	}
+	if _, err := d.AddDependency(aID, bID); err != nil {
+		t.Fatalf("couldn't add dependency: %v", err)
+	}
+	if _, err := d.AddDependency(bID, cID); err != nil {
+		t.Fatalf("couldn't add dependency: %v", err)
+	}
+	if _, err := d.AddDependency(aID, bID); err == nil {
+		t.Errorf("duplicate dependency added")
+	}
+	if _, err := d.AddDependency(cID, aID); err == nil {
+		t.Errorf("dependency cycle created")
+	}
+	if _, err := d.AddDependency(aID, aID); err == nil {
+		t.Errorf("node made to depend on itself")
+	}
+
+	// Dependencies are removed and restored along with their nodes.
+	if _, err := d.TrashNode(cID); err != nil {
+		t.Fatalf("couldn't trash node: %v", err)
+	}
+	if deps, _ := d.GetDependencies(); len(deps) != 1 {
+		t.Fatalf("got %d dependencies, want 1", len(deps))
+	}
+	if _, err := d.RestoreNode(cID); err != nil {
+		t.Fatalf("couldn't restore node: %v", err)
+	}
+	deps, _ := d.GetDependencies()
+	if len(deps) != 2 || deps[1].NodeID != bID || deps[1].PrereqID != cID {
+		t.Fatalf("got dependencies %+v, want the restored one", deps)
+	}
+
+	if err := d.DeleteDependency(aID, bID); err != nil {
+		t.Fatalf("couldn't delete dependency: %v", err)
+	}
+	if err := d.DeleteDependency(aID, bID); err == nil {
+		t.Errorf("nonexistent dependency deleted")
+	}
+	if _, err := d.Undo(1); err != nil {
+		t.Fatalf("couldn't undo: %v", err)
+	}
+	if deps, _ := d.GetDependencies(); len(deps) != 2 {
+		t.Errorf("dependency wasn't restored by undo")
+	}
+}
+
+func TestProperties(t *testing.T) {
+	d := setupDB(t)
+	defer tearDB(t, d)
+
+	aID, _ := d.CreateNode("a", 0)
+	bID, _ := d.CreateNode("b", aID)
+
+	if err := d.SetProperty(aID, "owner", "alice"); err != nil {
+		t.Fatalf("couldn't set property: %v", err)
+	}
+	if err := d.SetProperty(aID, "owner", "bob"); err != nil {
+		t.Fatalf("couldn't replace property: %v", err)
+	}
+	d.SetProperty(bID, "ticket", "T-1")
+	d.SetProperty(bID, "cost", "x")
+	if err := d.SetProperty(bID+1, "owner", "alice"); err == nil {
+		t.Errorf("property set on a nonexistent node")
+	}
+
+	props, err := d.GetProperties(0)
+	if err != nil {
+		t.Fatalf("couldn't get properties: %v", err)
+	}
+	if len(props) != 3 || props[0].Value != "bob" || props[1].Key != "cost" {
+		t.Errorf("got properties %+v, want 3 sorted by node and key", props)
+	}
+
+	if err := d.DeleteProperty(bID, "cost"); err != nil {
+		t.Fatalf("couldn't delete property: %v", err)
+	}
+	if err := d.DeleteProperty(bID, "cost"); err == nil {
+		t.Errorf("nonexistent property deleted")
+	}
+
+	// Properties are removed and restored along with their nodes.
+	if _, err := d.TrashNode(bID); err != nil {
+		t.Fatalf("couldn't trash node: %v", err)
+	}
+	if props, _ := d.GetProperties(0); len(props) != 1 {
+		t.Errorf("got %d properties, want 1", len(props))
+	}
+	if _, err := d.RestoreNode(bID); err != nil {
+		t.Fatalf("couldn't restore node: %v", err)
+	}
+	if props, _ := d.GetProperties(bID); len(props) != 1 || props[0].Value != "T-1" {
+		t.Errorf("got properties %+v, want the restored one", props)
+	}
+}
@@ -0,0 +1,168 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/climech/grit/store"
+)
+
+// queryIDs returns the IDs selected by the query.
+func queryIDs(tx *sql.Tx, query string, args ...interface{}) ([]int64, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// dependsOn returns true if nodeID depends on prereqID, directly or through
+// other nodes.
+func dependsOn(tx *sql.Tx, nodeID, prereqID int64) (bool, error) {
+	visited := map[int64]bool{nodeID: true}
+	queue := []int64{nodeID}
+	for len(queue) > 0 {
+		ids, err := queryIDs(tx,
+			"SELECT prereq_id FROM dependencies WHERE node_id = ?", queue[0])
+		if err != nil {
+			return false, err
+		}
+		queue = queue[1:]
+		for _, id := range ids {
+			if id == prereqID {
+				return true, nil
+			}
+			if !visited[id] {
+				visited[id] = true
+				queue = append(queue, id)
+			}
+		}
+	}
+	return false, nil
+}
+
+// isAncestor returns true if ancestorID is an ancestor of nodeID.
+func isAncestor(tx *sql.Tx, ancestorID, nodeID int64) (bool, error) {
+	var count int
+	row := tx.QueryRow("WITH RECURSIVE descendants(id) AS ("+
+		"SELECT dest_id FROM links WHERE origin_id = ? UNION "+
+		"SELECT dest_id FROM links JOIN descendants ON origin_id = id) "+
+		"SELECT count(*) FROM descendants WHERE id = ?", ancestorID, nodeID)
+	if err := row.Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func createDependency(tx *sql.Tx, nodeID, prereqID int64) (int64, error) {
+	if nodeID == prereqID {
+		return 0, fmt.Errorf("node cannot depend on itself")
+	}
+	for _, id := range []int64{nodeID, prereqID} {
+		node, err := getNode(tx, id)
+		if err != nil {
+			return 0, err
+		}
+		if node == nil {
+			return 0, fmt.Errorf("node (%d) does not exist", id)
+		}
+	}
+	// A node already waits for its descendants, and is held up by whatever
+	// holds up its ancestors; a dependency between them could never be met.
+	for _, pair := range [][2]int64{{nodeID, prereqID}, {prereqID, nodeID}} {
+		if related, err := isAncestor(tx, pair[0], pair[1]); err != nil {
+			return 0, err
+		} else if related {
+			return 0, fmt.Errorf("node cannot depend on its ancestor or descendant")
+		}
+	}
+	var count int
+	row := tx.QueryRow("SELECT count(*) FROM dependencies "+
+		"WHERE node_id = ? AND prereq_id = ?", nodeID, prereqID)
+	if err := row.Scan(&count); err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		return 0, fmt.Errorf("dependency already exists")
+	}
+	if cycle, err := dependsOn(tx, prereqID, nodeID); err != nil {
+		return 0, err
+	} else if cycle {
+		return 0, fmt.Errorf("dependency would create a cycle")
+	}
+	return journaledInsert(tx, "dependencies",
+		"INSERT INTO dependencies (node_id, prereq_id) VALUES (?, ?)",
+		nodeID, prereqID)
+}
+
+// AddDependency records that nodeID can't be started until prereqID is
+// resolved, and returns the ID of the dependency.
+func (d *Database) AddDependency(nodeID, prereqID int64) (int64, error) {
+	var id int64
+	desc := fmt.Sprintf("depend (%d) on (%d)", nodeID, prereqID)
+	err := d.execJournaledTxFunc(desc, func(tx *sql.Tx) error {
+		var err error
+		id, err = createDependency(tx, nodeID, prereqID)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// DeleteDependency removes the dependency between the nodes.
+func (d *Database) DeleteDependency(nodeID, prereqID int64) error {
+	desc := fmt.Sprintf("undepend (%d) on (%d)", nodeID, prereqID)
+	return d.execJournaledTxFunc(desc, func(tx *sql.Tx) error {
+		var id int64
+		row := tx.QueryRow("SELECT dep_id FROM dependencies "+
+			"WHERE node_id = ? AND prereq_id = ?", nodeID, prereqID)
+		if err := row.Scan(&id); err == sql.ErrNoRows {
+			return fmt.Errorf("dependency does not exist")
+		} else if err != nil {
+			return err
+		}
+		_, err := journaledExec(tx, "dependencies", id,
+			"DELETE FROM dependencies WHERE dep_id = ?", id)
+		return err
+	})
+}
+
+func getDependencies(tx *sql.Tx) ([]*store.Dependency, error) {
+	rows, err := tx.Query(
+		"SELECT dep_id, node_id, prereq_id FROM dependencies ORDER BY dep_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var deps []*store.Dependency
+	for rows.Next() {
+		dep := &store.Dependency{}
+		if err := rows.Scan(&dep.ID, &dep.NodeID, &dep.PrereqID); err != nil {
+			return nil, err
+		}
+		deps = append(deps, dep)
+	}
+	return deps, rows.Err()
+}
+
+// GetDependencies returns all dependencies, sorted by ID.
+func (d *Database) GetDependencies() ([]*store.Dependency, error) {
+	var deps []*store.Dependency
+	err := d.execTxFunc(func(tx *sql.Tx) error {
+		var err error
+		deps, err = getDependencies(tx)
+		return err
+	})
+	return deps, err
+}
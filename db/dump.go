@@ -0,0 +1,212 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/climech/grit/multitree"
+	"github.com/climech/grit/store"
+)
+
+// Export returns a snapshot of all nodes, links and dependencies, sorted by ID,
+// and of the properties of the nodes.
+func (d *Database) Export() (*store.Dump, error) {
+	dump := &store.Dump{Version: store.DumpVersion}
+
+	err := d.execTxFunc(func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT " + nodeColumns +
+			", node_uuid, node_modified FROM nodes ORDER BY node_id")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			n := &multitree.Node{}
+			dn := &store.DumpNode{}
+			if err := scanToNode(rows, n, &dn.UUID, &dn.Modified); err != nil {
+				return err
+			}
+			dn.ID, dn.Name, dn.Alias, dn.Notes, dn.Due = n.ID, n.Name, n.Alias,
+				n.Notes, n.Due
+			dn.Estimate = n.Estimate
+			dn.Created, dn.Completed, dn.Cancelled = n.Created, n.Completed, n.Cancelled
+			dump.Nodes = append(dump.Nodes, dn)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		linkRows, err := tx.Query("SELECT link_id, link_uuid, origin_id, dest_id " +
+			"FROM links ORDER BY link_id")
+		if err != nil {
+			return err
+		}
+		defer linkRows.Close()
+		for linkRows.Next() {
+			l := &store.DumpLink{}
+			if err := linkRows.Scan(&l.ID, &l.UUID, &l.OriginID, &l.DestID); err != nil {
+				return err
+			}
+			dump.Links = append(dump.Links, l)
+		}
+		if err := linkRows.Err(); err != nil {
+			return err
+		}
+
+		deps, err := getDependencies(tx)
+		if err != nil {
+			return err
+		}
+		for _, dep := range deps {
+			dump.Dependencies = append(dump.Dependencies, &store.DumpDependency{
+				ID:       dep.ID,
+				NodeID:   dep.NodeID,
+				PrereqID: dep.PrereqID,
+			})
+		}
+
+		props, err := getProperties(tx, 0)
+		if err != nil {
+			return err
+		}
+		for _, p := range props {
+			dump.Properties = append(dump.Properties, &store.DumpProperty{
+				NodeID: p.NodeID,
+				Key:    p.Key,
+				Value:  p.Value,
+			})
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return dump, nil
+}
+
+func isEmpty(tx *sql.Tx) (bool, error) {
+	var count int64
+	if err := tx.QueryRow("SELECT COUNT(*) FROM nodes").Scan(&count); err != nil {
+		return false, err
+	}
+	return count == 0, nil
+}
+
+// Import saves the dumped nodes, links, dependencies and properties. The dump
+// is assumed to be a valid multitree. If the database is empty, the dump is
+// restored exactly, IDs included. Otherwise, the nodes are given new IDs, and
+// date nodes are merged with the existing ones. It returns a map of dumped
+// node IDs to the IDs of the imported nodes.
+func (d *Database) Import(dump *store.Dump) (map[int64]int64, error) {
+	ids := make(map[int64]int64)
+
+	txf := func(tx *sql.Tx) error {
+		exact, err := isEmpty(tx)
+		if err != nil {
+			return err
+		}
+
+		var merged []int64
+		for _, n := range dump.Nodes {
+			if !exact && multitree.ValidateDateNodeName(n.Name) == nil {
+				existing, err := getNodeByName(tx, n.Name)
+				if err != nil {
+					return err
+				}
+				if existing != nil {
+					ids[n.ID] = existing.ID
+					merged = append(merged, existing.ID)
+					continue
+				}
+			}
+			if n.Alias != "" && !exact {
+				existing, err := getNodeByAlias(tx, n.Alias)
+				if err != nil {
+					return err
+				}
+				if existing != nil {
+					return fmt.Errorf("alias already exists: %s", n.Alias)
+				}
+			}
+			r := row{
+				"node_name":      n.Name,
+				"node_alias":     nil,
+				"node_notes":     nullIfEmpty(n.Notes),
+				"node_due":       nullIfEmpty(n.Due),
+				"node_estimate":  nullIfZero(n.Estimate),
+				"node_created":   n.Created,
+				"node_completed": nil,
+				"node_cancelled": nullIfNil(n.Cancelled),
+			}
+			if exact {
+				r["node_id"] = n.ID
+				if n.UUID != "" {
+					r["node_uuid"] = n.UUID
+				}
+				if n.Modified != 0 {
+					r["node_modified"] = n.Modified
+				}
+			}
+			if n.Alias != "" {
+				r["node_alias"] = n.Alias
+			}
+			if n.Completed != nil {
+				r["node_completed"] = *n.Completed
+			}
+			id, err := journaledInsertRow(tx, "nodes", r)
+			if err != nil {
+				return err
+			}
+			ids[n.ID] = id
+		}
+
+		for _, l := range dump.Links {
+			r := row{"origin_id": ids[l.OriginID], "dest_id": ids[l.DestID]}
+			if exact {
+				r["link_id"] = l.ID
+				if l.UUID != "" {
+					r["link_uuid"] = l.UUID
+				}
+			}
+			if _, err := journaledInsertRow(tx, "links", r); err != nil {
+				return err
+			}
+		}
+
+		for _, dep := range dump.Dependencies {
+			r := row{"node_id": ids[dep.NodeID], "prereq_id": ids[dep.PrereqID]}
+			if exact {
+				r["dep_id"] = dep.ID
+			}
+			if _, err := journaledInsertRow(tx, "dependencies", r); err != nil {
+				return err
+			}
+		}
+
+		for _, p := range dump.Properties {
+			r := row{"node_id": ids[p.NodeID], "prop_key": p.Key, "prop_value": p.Value}
+			if _, err := journaledInsertRow(tx, "properties", r); err != nil {
+				return err
+			}
+		}
+
+		// Merged date nodes may need their status updated.
+		for _, id := range merged {
+			node, err := getGraph(tx, id)
+			if err != nil {
+				return err
+			}
+			if err := backpropCompletion(tx, node); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	desc := fmt.Sprintf("import %d nodes", len(dump.Nodes))
+	if err := d.execJournaledTxFunc(desc, txf); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
@@ -0,0 +1,284 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/climech/grit/multitree"
+	"github.com/climech/grit/store"
+)
+
+func insertEvent(tx *sql.Tx, e *store.Event) error {
+	var otherID interface{}
+	if e.OtherID != 0 {
+		otherID = e.OtherID
+	}
+	var detail interface{}
+	if e.Detail != "" {
+		detail = e.Detail
+	}
+	_, err := tx.Exec(
+		"INSERT INTO events (node_id, event_type, event_node_name, "+
+			"event_other_id, event_detail) VALUES (?, ?, ?, ?, ?)",
+		e.NodeID, e.Type, e.NodeName, otherID, detail)
+	return err
+}
+
+func nullableString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return ""
+}
+
+// describeEstimate formats an estimate read from a row, or returns an empty
+// string if it's not set.
+func describeEstimate(v interface{}) string {
+	if f, ok := v.(float64); ok {
+		return multitree.FormatEstimate(f)
+	}
+	return ""
+}
+
+func describeChange(before, after string) string {
+	if before == "" {
+		before = "none"
+	}
+	if after == "" {
+		after = "none"
+	}
+	return fmt.Sprintf("%s -> %s", before, after)
+}
+
+// nodeEvents derives the events from a change made to a row in the nodes table.
+func nodeEvents(before, after row) []*store.Event {
+	switch {
+	case before == nil:
+		return []*store.Event{{
+			Type:     store.EventCreate,
+			NodeID:   after.id("nodes"),
+			NodeName: nullableString(after["node_name"]),
+		}}
+	case after == nil:
+		return []*store.Event{{
+			Type:     store.EventDelete,
+			NodeID:   before.id("nodes"),
+			NodeName: nullableString(before["node_name"]),
+		}}
+	}
+
+	var events []*store.Event
+	id := after.id("nodes")
+	name := nullableString(after["node_name"])
+
+	if oldName := nullableString(before["node_name"]); oldName != name {
+		events = append(events, &store.Event{
+			Type:     store.EventRename,
+			NodeID:   id,
+			NodeName: name,
+			Detail:   describeChange(oldName, name),
+		})
+	}
+	oldAlias := nullableString(before["node_alias"])
+	if alias := nullableString(after["node_alias"]); oldAlias != alias {
+		events = append(events, &store.Event{
+			Type:     store.EventAlias,
+			NodeID:   id,
+			NodeName: name,
+			Detail:   describeChange(oldAlias, alias),
+		})
+	}
+	if nullableString(before["node_notes"]) != nullableString(after["node_notes"]) {
+		events = append(events, &store.Event{
+			Type:     store.EventNote,
+			NodeID:   id,
+			NodeName: name,
+		})
+	}
+	oldDue := nullableString(before["node_due"])
+	if due := nullableString(after["node_due"]); oldDue != due {
+		events = append(events, &store.Event{
+			Type:     store.EventDue,
+			NodeID:   id,
+			NodeName: name,
+			Detail:   describeChange(oldDue, due),
+		})
+	}
+	oldEstimate := describeEstimate(before["node_estimate"])
+	if estimate := describeEstimate(after["node_estimate"]); oldEstimate != estimate {
+		events = append(events, &store.Event{
+			Type:     store.EventEstimate,
+			NodeID:   id,
+			NodeName: name,
+			Detail:   describeChange(oldEstimate, estimate),
+		})
+	}
+	if c := after["node_completed"]; c != before["node_completed"] {
+		e := &store.Event{Type: store.EventCheck, NodeID: id, NodeName: name}
+		if c == nil {
+			e.Type = store.EventUncheck
+		}
+		events = append(events, e)
+	}
+	if c := after["node_cancelled"]; c != before["node_cancelled"] {
+		e := &store.Event{Type: store.EventCancel, NodeID: id, NodeName: name}
+		if c == nil {
+			e.Type = store.EventUncancel
+		}
+		events = append(events, e)
+	}
+
+	return events
+}
+
+// linkEvents derives the events from a change made to a row in the links
+// table. The events are attributed to the link's destination.
+func linkEvents(tx *sql.Tx, before, after row) ([]*store.Event, error) {
+	e := &store.Event{Type: store.EventLink}
+	r := after
+	switch {
+	case before == nil:
+	case after == nil:
+		e.Type = store.EventUnlink
+		r = before
+	default:
+		return nil, nil // links are never updated in place
+	}
+	e.NodeID = r["dest_id"].(int64)
+	e.OtherID = r["origin_id"].(int64)
+
+	row := tx.QueryRow("SELECT node_name FROM nodes WHERE node_id = ?", e.NodeID)
+	if err := row.Scan(&e.NodeName); err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return []*store.Event{e}, nil
+}
+
+// dependencyEvents derives the events from a change made to a row in the
+// dependencies table. The events are attributed to the dependent node.
+func dependencyEvents(tx *sql.Tx, before, after row) ([]*store.Event, error) {
+	e := &store.Event{Type: store.EventDepend}
+	r := after
+	switch {
+	case before == nil:
+	case after == nil:
+		e.Type = store.EventUndepend
+		r = before
+	default:
+		return nil, nil // dependencies are never updated in place
+	}
+	e.NodeID = r["node_id"].(int64)
+	e.OtherID = r["prereq_id"].(int64)
+
+	row := tx.QueryRow("SELECT node_name FROM nodes WHERE node_id = ?", e.NodeID)
+	if err := row.Scan(&e.NodeName); err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return []*store.Event{e}, nil
+}
+
+// propertyEvents derives the events from a change made to a row in the
+// properties table.
+func propertyEvents(tx *sql.Tx, before, after row) ([]*store.Event, error) {
+	r := after
+	if r == nil {
+		r = before
+	}
+	e := &store.Event{Type: store.EventProperty, NodeID: r["node_id"].(int64)}
+	key := nullableString(r["prop_key"])
+	e.Detail = key + ": " + describeChange(nullableString(before["prop_value"]),
+		nullableString(after["prop_value"]))
+
+	row := tx.QueryRow("SELECT node_name FROM nodes WHERE node_id = ?", e.NodeID)
+	if err := row.Scan(&e.NodeName); err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return []*store.Event{e}, nil
+}
+
+// logChangeEvents records the events implied by a row change in the history.
+func logChangeEvents(tx *sql.Tx, table string, before, after row) error {
+	var events []*store.Event
+	switch table {
+	case "nodes":
+		events = nodeEvents(before, after)
+	case "links":
+		var err error
+		if events, err = linkEvents(tx, before, after); err != nil {
+			return err
+		}
+	case "dependencies":
+		var err error
+		if events, err = dependencyEvents(tx, before, after); err != nil {
+			return err
+		}
+	case "properties":
+		var err error
+		if events, err = propertyEvents(tx, before, after); err != nil {
+			return err
+		}
+	}
+	for _, e := range events {
+		if err := insertEvent(tx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetEvents returns the events matching the filter in chronological order.
+func (d *Database) GetEvents(filter store.EventFilter) ([]*store.Event, error) {
+	var conds []string
+	var args []interface{}
+
+	if filter.NodeID != 0 {
+		conds = append(conds, "(node_id = ? OR event_other_id = ?)")
+		args = append(args, filter.NodeID, filter.NodeID)
+	}
+	if len(filter.Types) > 0 {
+		placeholders := strings.TrimSuffix(
+			strings.Repeat("?, ", len(filter.Types)), ", ")
+		conds = append(conds, fmt.Sprintf("event_type IN (%s)", placeholders))
+		for _, t := range filter.Types {
+			args = append(args, t)
+		}
+	}
+	if filter.Since != 0 {
+		conds = append(conds, "event_time >= ?")
+		args = append(args, filter.Since)
+	}
+	if filter.Until != 0 {
+		conds = append(conds, "event_time < ?")
+		args = append(args, filter.Until)
+	}
+
+	query := "SELECT event_id, event_type, event_time, node_id, " +
+		"event_node_name, event_other_id, event_detail FROM events"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY event_time, event_id"
+
+	rows, err := d.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*store.Event
+	for rows.Next() {
+		e := &store.Event{}
+		var otherID sql.NullInt64
+		var detail sql.NullString
+		err := rows.Scan(&e.ID, &e.Type, &e.Time, &e.NodeID, &e.NodeName,
+			&otherID, &detail)
+		if err != nil {
+			return nil, err
+		}
+		e.OtherID = otherID.Int64
+		e.Detail = detail.String
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,316 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/climech/grit/multitree"
+	"github.com/climech/grit/store"
+)
+
+// problem pairs a problem with the function that fixes it, if it's fixable.
+type problem struct {
+	*store.Problem
+	fix func(*sql.Tx) error
+}
+
+// adjacency holds the links of a graph as plain maps, so that an invalid graph
+// can be inspected without building it with multitree.
+type adjacency struct {
+	children map[int64][]int64
+	parents  map[int64][]int64
+}
+
+func newAdjacency() *adjacency {
+	return &adjacency{
+		children: make(map[int64][]int64),
+		parents:  make(map[int64][]int64),
+	}
+}
+
+func (g *adjacency) link(originID, destID int64) {
+	g.children[originID] = append(g.children[originID], destID)
+	g.parents[destID] = append(g.parents[destID], originID)
+}
+
+// reachable returns the set of nodes reachable from the sources by following
+// the edges in next, including the sources themselves.
+func reachable(next map[int64][]int64, sources ...int64) map[int64]bool {
+	visited := make(map[int64]bool)
+	stack := append([]int64{}, sources...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+		stack = append(stack, next[id]...)
+	}
+	return visited
+}
+
+// checkLink returns the kind of problem that adding the link would introduce
+// to the graph, or an empty string if the link is valid.
+func (g *adjacency) checkLink(originID, destID int64) string {
+	descendants := reachable(g.children, destID)
+	if descendants[originID] {
+		return store.ProblemCycle
+	}
+	// A diamond is formed if any of the origin's ancestors can already reach
+	// any of the destination's descendants.
+	var sources []int64
+	for id := range descendants {
+		sources = append(sources, id)
+	}
+	reaching := reachable(g.parents, sources...)
+	for id := range reachable(g.parents, originID) {
+		if reaching[id] {
+			return store.ProblemDiamond
+		}
+	}
+	return ""
+}
+
+func deleteLinkFix(id int64) func(*sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := journaledExec(tx, "links", id,
+			"DELETE FROM links WHERE link_id = ?", id)
+		return err
+	}
+}
+
+// checkLinks replays the links in the order they were created, and reports
+// every link that is dangling, points to a date node, or closes a cycle or a
+// diamond. The graph formed by the remaining links is returned.
+func checkLinks(nodes map[int64]*multitree.Node, links []*multitree.Link) (*adjacency, []*problem) {
+	g := newAdjacency()
+	var problems []*problem
+
+	for _, l := range links {
+		p := &store.Problem{LinkID: l.ID, NodeID: l.DestID, Fixable: true}
+		origin, dest := nodes[l.OriginID], nodes[l.DestID]
+
+		switch {
+		case origin == nil || dest == nil:
+			p.Kind = store.ProblemDanglingLink
+			p.Message = fmt.Sprintf("link (%d) -> (%d) points to a missing node",
+				l.OriginID, l.DestID)
+		case multitree.ValidateDateNodeName(dest.Name) == nil:
+			p.Kind = store.ProblemDateNodeLink
+			p.Message = fmt.Sprintf("link (%d) -> (%d) points to a date node",
+				l.OriginID, l.DestID)
+		default:
+			p.Kind = g.checkLink(l.OriginID, l.DestID)
+			if p.Kind == "" {
+				g.link(l.OriginID, l.DestID)
+				continue
+			}
+			p.Message = fmt.Sprintf("link (%d) -> (%d) creates a %s",
+				l.OriginID, l.DestID, p.Kind)
+		}
+
+		problems = append(problems, &problem{p, deleteLinkFix(l.ID)})
+	}
+
+	return g, problems
+}
+
+// checkNodes reports invalid names and aliases, empty date nodes and
+// completion statuses that don't match the children's, assuming the graph
+// has been cleaned up by checkLinks.
+func checkNodes(nodes []*multitree.Node, g *adjacency) []*problem {
+	var problems []*problem
+
+	byID := make(map[int64]*multitree.Node)
+	for _, n := range nodes {
+		byID[n.ID] = n
+	}
+
+	// expected computes the completion status implied by the node's children.
+	// Cancelled nodes are never completed, but count as resolved. A node
+	// completed by its children takes the time the last of them was resolved,
+	// as in multitree.BackpropCompletion.
+	memo := make(map[int64]*int64)
+	var expected func(id int64) *int64
+	resolved := func(id int64) *int64 {
+		if v := expected(id); v != nil {
+			return v
+		}
+		return byID[id].Cancelled
+	}
+	expected = func(id int64) *int64 {
+		if v, ok := memo[id]; ok {
+			return v
+		}
+		n := byID[id]
+		v := n.Completed
+		if n.Cancelled != nil {
+			v = nil
+		} else if children := g.children[id]; len(children) > 0 {
+			var latest *int64
+			for _, c := range children {
+				e := resolved(c)
+				if e == nil {
+					latest = nil
+					break
+				}
+				if latest == nil || *e > *latest {
+					latest = e
+				}
+			}
+			if latest == nil {
+				v = nil
+			} else if v == nil {
+				v = latest
+			}
+		}
+		memo[id] = v
+		return v
+	}
+
+	for _, n := range nodes {
+		id := n.ID
+		isDate := multitree.ValidateDateNodeName(n.Name) == nil
+
+		if !isDate {
+			if err := multitree.ValidateNodeName(n.Name); err != nil {
+				problems = append(problems, &problem{Problem: &store.Problem{
+					Kind:    store.ProblemInvalidName,
+					Message: fmt.Sprintf("node (%d): %v", id, err),
+					NodeID:  id,
+				}})
+			}
+		}
+
+		if n.Alias != "" {
+			if err := multitree.ValidateNodeAlias(n.Alias); err != nil {
+				problems = append(problems, &problem{
+					Problem: &store.Problem{
+						Kind:    store.ProblemInvalidAlias,
+						Message: fmt.Sprintf("node (%d): %v", id, err),
+						NodeID:  id,
+						Fixable: true,
+					},
+					fix: func(tx *sql.Tx) error {
+						_, err := journaledExec(tx, "nodes", id,
+							"UPDATE nodes SET node_alias = NULL WHERE node_id = ?", id)
+						return err
+					},
+				})
+			}
+		}
+
+		if isDate && len(g.children[id]) == 0 {
+			problems = append(problems, &problem{
+				Problem: &store.Problem{
+					Kind:    store.ProblemEmptyDateNode,
+					Message: fmt.Sprintf("date node %s (%d) has no children", n.Name, id),
+					NodeID:  id,
+					Fixable: true,
+				},
+				fix: func(tx *sql.Tx) error {
+					_, err := journaledExec(tx, "nodes", id,
+						"DELETE FROM nodes WHERE node_id = ?", id)
+					return err
+				},
+			})
+			continue
+		}
+
+		if want := expected(id); (want == nil) != (n.Completed == nil) {
+			msg := "should be completed"
+			if want == nil {
+				msg = "should not be completed"
+			}
+			problems = append(problems, &problem{
+				Problem: &store.Problem{
+					Kind:    store.ProblemCompletion,
+					Message: fmt.Sprintf("node (%d) %s", id, msg),
+					NodeID:  id,
+					Fixable: true,
+				},
+				fix: func(tx *sql.Tx) error {
+					_, err := journaledExec(tx, "nodes", id,
+						"UPDATE nodes SET node_completed = ? WHERE node_id = ?",
+						want, id)
+					return err
+				},
+			})
+		}
+	}
+
+	return problems
+}
+
+func fsck(tx *sql.Tx) ([]*problem, error) {
+	rows, err := tx.Query("SELECT " + nodeColumns + " FROM nodes ORDER BY node_id")
+	if err != nil {
+		return nil, err
+	}
+	nodes := rowsToNodes(rows)
+	byID := make(map[int64]*multitree.Node)
+	for _, n := range nodes {
+		byID[n.ID] = n
+	}
+
+	rows, err = tx.Query("SELECT " + linkColumns + " FROM links ORDER BY link_id")
+	if err != nil {
+		return nil, err
+	}
+	links := rowsToLinks(rows)
+
+	g, problems := checkLinks(byID, links)
+	return append(problems, checkNodes(nodes, g)...), nil
+}
+
+func exportProblems(problems []*problem) []*store.Problem {
+	exported := make([]*store.Problem, len(problems))
+	for i, p := range problems {
+		exported[i] = p.Problem
+	}
+	return exported
+}
+
+// Fsck scans all nodes and links for inconsistencies. Links are checked in the
+// order they were created, so the newest link is blamed for a cycle or a
+// diamond. Each check assumes the problems found by the previous ones to be
+// fixed.
+func (d *Database) Fsck() ([]*store.Problem, error) {
+	var problems []*problem
+	err := d.execTxFunc(func(tx *sql.Tx) error {
+		p, err := fsck(tx)
+		problems = p
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return exportProblems(problems), nil
+}
+
+// Repair fixes the fixable problems found by Fsck in a single transaction. It
+// returns all the problems found, fixed or not.
+func (d *Database) Repair() ([]*store.Problem, error) {
+	var problems []*problem
+	err := d.execJournaledTxFunc("fsck --repair", func(tx *sql.Tx) error {
+		p, err := fsck(tx)
+		if err != nil {
+			return err
+		}
+		for _, problem := range p {
+			if !problem.Fixable {
+				continue
+			}
+			if err := problem.fix(tx); err != nil {
+				return fmt.Errorf("couldn't repair %s: %w", problem.Kind, err)
+			}
+		}
+		problems = p
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return exportProblems(problems), nil
+}
@@ -1,122 +1,84 @@
 package db
 
 import (
-	"container/list"
 	"database/sql"
 	"fmt"
 
 	"github.com/climech/grit/multitree"
 )
 
-// getAdjacent gets parents and children of the node.
-func getAdjacent(tx *sql.Tx, id int64) ([]*multitree.Node, error) {
-	rows, err := tx.Query(
-		"SELECT node_id, node_name, node_alias, node_created, node_completed "+
-			"FROM nodes LEFT JOIN links ON node_id = origin_id WHERE dest_id = ?",
-		id,
+// componentQuery selects the nodes of the multitree that the given node belongs
+// to, along with the IDs of their children. Nodes without children are
+// returned with a NULL child ID. The component is found by following the links
+// in both directions.
+const componentQuery = `
+	WITH RECURSIVE component(id) AS (
+		SELECT ?
+		UNION
+		SELECT CASE WHEN origin_id = id THEN dest_id ELSE origin_id END
+			FROM links JOIN component ON origin_id = id OR dest_id = id
 	)
-	if err != nil {
-		return nil, err
-	}
-	nodes := rowsToNodes(rows)
-	return nodes, nil
-}
-
-// getParents gets nodes connected to the given node by incoming links.
-func getParents(tx *sql.Tx, id int64) ([]*multitree.Node, error) {
-	rows, err := tx.Query(
-		"SELECT node_id, node_name, node_alias, node_created, node_completed "+
-			"FROM nodes LEFT JOIN links ON node_id = origin_id WHERE dest_id = ?",
-		id,
-	)
-	if err != nil {
-		return nil, err
-	}
-	nodes := rowsToNodes(rows)
-	return nodes, nil
-}
-
-// getChildren gets nodes connected to the given node by outgoing links.
-func getChildren(tx *sql.Tx, id int64) ([]*multitree.Node, error) {
-	rows, err := tx.Query(
-		"SELECT node_id, node_name, node_alias, node_created, node_completed "+
-			"FROM nodes LEFT JOIN links ON node_id = dest_id WHERE origin_id = ?",
-		id,
-	)
-	if err != nil {
-		return nil, err
-	}
-	nodes := rowsToNodes(rows)
-	return nodes, nil
-}
+	SELECT ` + nodeColumns + `, dest_id
+		FROM component
+		JOIN nodes ON node_id = id
+		LEFT JOIN links ON origin_id = node_id
+		ORDER BY node_id, link_id`
 
+// getGraph loads the multitree that the node belongs to in a single query, and
+// returns the node as its member, or nil if the node doesn't exist.
 func getGraph(tx *sql.Tx, nodeID int64) (*multitree.Node, error) {
-	row := tx.QueryRow("SELECT * FROM nodes WHERE node_id = ?", nodeID)
-	node, err := rowToNode(row)
+	rows, err := tx.Query(componentQuery, nodeID)
 	if err != nil {
 		return nil, err
 	}
-	if node == nil {
-		return nil, nil
-	}
+	defer rows.Close()
 
-	queue := list.New()
-	queue.PushBack(node)
-	visited := make(map[int64]*multitree.Node)
-	visited[node.ID] = node
+	type edge struct{ origin, dest int64 }
+	nodes := make(map[int64]*multitree.Node)
+	var edges []edge
 
-	linkNodes := func(origin, dest *multitree.Node) {
-		if origin.HasChild(dest) {
-			return
+	for rows.Next() {
+		node := &multitree.Node{}
+		var destID sql.NullInt64
+		if err := scanToNode(rows, node, &destID); err != nil {
+			return nil, err
+		}
+		if _, ok := nodes[node.ID]; !ok {
+			nodes[node.ID] = node
 		}
-		if err := multitree.LinkNodes(origin, dest); err != nil {
-			panic(fmt.Sprintf("invalid multitree link in DB (%d->%d): %v",
-				origin.ID, dest.ID, err))
+		if destID.Valid {
+			edges = append(edges, edge{node.ID, destID.Int64})
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-	for {
-		if elem := queue.Front(); elem == nil {
-			break
-		} else {
-			queue.Remove(elem)
-			current := elem.Value.(*multitree.Node)
-
-			parents, err := getParents(tx, current.ID)
-			if err != nil {
-				return nil, err
-			}
-			for _, p := range parents {
-				if _, ok := visited[p.ID]; !ok {
-					linkNodes(p, current)
-					visited[p.ID] = p
-					queue.PushBack(p)
-				} else {
-					linkNodes(visited[p.ID], current)
-				}
-			}
+	node, ok := nodes[nodeID]
+	if !ok {
+		return nil, nil
+	}
 
-			children, err := getChildren(tx, current.ID)
-			if err != nil {
-				return nil, err
-			}
-			for _, c := range children {
-				if _, ok := visited[c.ID]; !ok {
-					linkNodes(current, c)
-					visited[c.ID] = c
-					queue.PushBack(c)
-				} else {
-					linkNodes(current, visited[c.ID])
-				}
-			}
+	// Validating each link separately is quadratic, so validate the whole graph
+	// once it's built instead.
+	for _, e := range edges {
+		dest, ok := nodes[e.dest]
+		if !ok {
+			return nil, fmt.Errorf("dangling link in DB: (%d) -> (%d) "+
+				"(run 'grit fsck' for details)", e.origin, e.dest)
 		}
+		multitree.LinkNodesUnchecked(nodes[e.origin], dest)
+	}
+	if err := multitree.ValidateGraph(node); err != nil {
+		return nil, fmt.Errorf("invalid multitree in DB (node %d): %v "+
+			"(run 'grit fsck' for details)", nodeID, err)
 	}
 
 	return node, nil
 }
 
-// GetGraph builds a multitree using Breadth-First Search, and returns the
-// requested node as part of the multitree.
+// GetGraph loads the multitree that the node belongs to, and returns the
+// requested node as its member.
 func (d *Database) GetGraph(nodeID int64) (*multitree.Node, error) {
 	var node *multitree.Node
 	err := d.execTxFunc(func(tx *sql.Tx) error {
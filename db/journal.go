@@ -0,0 +1,454 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/climech/grit/store"
+)
+
+// journalCapacity is the number of most recent journal entries kept in the DB.
+const journalCapacity = 100
+
+// primaryKeys maps the tables covered by the journal to their primary keys.
+var primaryKeys = map[string]string{
+	"nodes":        "node_id",
+	"links":        "link_id",
+	"trash":        "trash_id",
+	"merges":       "merge_id",
+	"time_entries": "time_id",
+	"recurrences":  "recur_id",
+	"dependencies": "dep_id",
+	"properties":   "prop_id",
+}
+
+// row is a snapshot of a table row, mapping column names to values.
+type row map[string]interface{}
+
+func (r row) id(table string) int64 {
+	return r[primaryKeys[table]].(int64)
+}
+
+func (r row) copy() row {
+	cp := make(row, len(r))
+	for k, v := range r {
+		cp[k] = v
+	}
+	return cp
+}
+
+func (r row) columns() []string {
+	cols := make([]string, 0, len(r))
+	for c := range r {
+		cols = append(cols, c)
+	}
+	sort.Strings(cols)
+	return cols
+}
+
+func marshalRow(r row) (interface{}, error) {
+	if r == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func unmarshalRow(data sql.NullString) (row, error) {
+	if !data.Valid {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader([]byte(data.String)))
+	dec.UseNumber()
+	var r row
+	if err := dec.Decode(&r); err != nil {
+		return nil, err
+	}
+	for k, v := range r {
+		if num, ok := v.(json.Number); ok {
+			if i, err := num.Int64(); err == nil {
+				r[k] = i
+			} else if f, err := num.Float64(); err == nil {
+				r[k] = f
+			}
+		}
+	}
+	return r, nil
+}
+
+// getRow returns a snapshot of the row identified by the primary key, or nil
+// if the row doesn't exist.
+func getRow(tx *sql.Tx, table string, id int64) (row, error) {
+	query := fmt.Sprintf("SELECT * FROM %s WHERE %s = ?", table, primaryKeys[table])
+	rows, err := tx.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	values := make([]interface{}, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return nil, err
+	}
+	r := make(row)
+	for i, c := range cols {
+		if b, ok := values[i].([]byte); ok {
+			r[c] = string(b)
+		} else {
+			r[c] = values[i]
+		}
+	}
+	return r, nil
+}
+
+func insertRow(tx *sql.Tx, table string, r row) (sql.Result, error) {
+	cols := r.columns()
+	args := make([]interface{}, len(cols))
+	for i, c := range cols {
+		args[i] = r[c]
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table,
+		strings.Join(cols, ", "),
+		strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", "))
+	return tx.Exec(query, args...)
+}
+
+func updateRow(tx *sql.Tx, table string, r row) error {
+	cols := r.columns()
+	assignments := make([]string, len(cols))
+	args := make([]interface{}, 0, len(cols)+1)
+	for i, c := range cols {
+		assignments[i] = c + " = ?"
+		args = append(args, r[c])
+	}
+	args = append(args, r.id(table))
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s = ?", table,
+		strings.Join(assignments, ", "), primaryKeys[table])
+	_, err := tx.Exec(query, args...)
+	return err
+}
+
+func deleteRow(tx *sql.Tx, table string, r row) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s = ?", table, primaryKeys[table])
+	_, err := tx.Exec(query, r.id(table))
+	return err
+}
+
+// recordChange appends a row change to the open journal entry and logs the
+// events it implies in the node history. Nothing is added to the journal
+// outside of journaled transactions.
+func recordChange(tx *sql.Tx, table string, before, after row) error {
+	if reflect.DeepEqual(before, after) {
+		return nil
+	}
+	b, err := marshalRow(before)
+	if err != nil {
+		return err
+	}
+	a, err := marshalRow(after)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO journal_changes (entry_id, change_table, change_before, "+
+			"change_after) SELECT entry_id, ?, ?, ? FROM journal WHERE entry_open = 1",
+		table, b, a)
+	if err != nil {
+		return err
+	}
+	return logChangeEvents(tx, table, before, after)
+}
+
+// journaledInsert executes an INSERT statement and records the new row in the
+// journal. It returns the ID of the inserted row.
+func journaledInsert(tx *sql.Tx, table, query string, args ...interface{}) (int64, error) {
+	r, err := tx.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	id, err := r.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	after, err := getRow(tx, table, id)
+	if err != nil {
+		return 0, err
+	}
+	if err := recordChange(tx, table, nil, after); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// journaledInsertRow inserts the row snapshot and records it in the journal. It
+// returns the ID of the inserted row.
+func journaledInsertRow(tx *sql.Tx, table string, r row) (int64, error) {
+	res, err := insertRow(tx, table, r)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	after, err := getRow(tx, table, id)
+	if err != nil {
+		return 0, err
+	}
+	if err := recordChange(tx, table, nil, after); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// journaledExec executes an UPDATE or DELETE statement affecting the row
+// identified by id, and records the change in the journal.
+func journaledExec(tx *sql.Tx, table string, id int64, query string, args ...interface{}) (sql.Result, error) {
+	before, err := getRow(tx, table, id)
+	if err != nil {
+		return nil, err
+	}
+	r, err := tx.Exec(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	after, err := getRow(tx, table, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := recordChange(tx, table, before, after); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// openJournalEntry starts a new journal entry. Entries that were undone can no
+// longer be redone once a new one is added.
+func openJournalEntry(tx *sql.Tx, desc string) error {
+	if _, err := tx.Exec("DELETE FROM journal WHERE entry_undone = 1"); err != nil {
+		return err
+	}
+	_, err := tx.Exec("INSERT INTO journal (entry_desc) VALUES (?)", desc)
+	return err
+}
+
+// closeJournalEntry closes the open entry, discarding it if nothing was
+// changed, and prunes the oldest entries beyond capacity.
+func closeJournalEntry(tx *sql.Tx) error {
+	queries := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"DELETE FROM journal WHERE entry_open = 1 AND NOT EXISTS(" +
+			"SELECT * FROM journal_changes WHERE journal_changes.entry_id = " +
+			"journal.entry_id)", nil},
+		{"UPDATE journal SET entry_open = 0 WHERE entry_open = 1", nil},
+		{"DELETE FROM journal WHERE entry_id NOT IN (" +
+			"SELECT entry_id FROM journal ORDER BY entry_id DESC LIMIT ?)",
+			[]interface{}{journalCapacity}},
+		{"DELETE FROM journal_changes WHERE entry_id NOT IN (" +
+			"SELECT entry_id FROM journal)", nil},
+	}
+	for _, q := range queries {
+		if _, err := tx.Exec(q.query, q.args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execJournaledTxFunc runs f in a transaction, recording the changes it makes
+// as a single journal entry, so that they can be undone later.
+func (d *Database) execJournaledTxFunc(desc string, f func(*sql.Tx) error) error {
+	return d.execWriteTxFunc(func(tx *sql.Tx) error {
+		if err := openJournalEntry(tx, desc); err != nil {
+			return err
+		}
+		if err := f(tx); err != nil {
+			return err
+		}
+		return closeJournalEntry(tx)
+	})
+}
+
+type journalChange struct {
+	table  string
+	before row
+	after  row
+}
+
+func getJournalChanges(tx *sql.Tx, entryID int64) ([]*journalChange, error) {
+	rows, err := tx.Query(
+		"SELECT change_table, change_before, change_after FROM journal_changes "+
+			"WHERE entry_id = ? ORDER BY change_id", entryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var changes []*journalChange
+	for rows.Next() {
+		var table string
+		var before, after sql.NullString
+		if err := rows.Scan(&table, &before, &after); err != nil {
+			return nil, err
+		}
+		c := &journalChange{table: table}
+		if c.before, err = unmarshalRow(before); err != nil {
+			return nil, err
+		}
+		if c.after, err = unmarshalRow(after); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// applyChange transforms the row from one state to another, and logs the
+// events it implies. A nil state means the row doesn't exist.
+func applyChange(tx *sql.Tx, table string, from, to row) error {
+	var err error
+	switch {
+	case from == nil:
+		_, err = insertRow(tx, table, to)
+	case to == nil:
+		err = deleteRow(tx, table, from)
+	default:
+		err = updateRow(tx, table, to)
+	}
+	if err != nil {
+		return err
+	}
+	return logChangeEvents(tx, table, from, to)
+}
+
+// affectedNodeIDs returns the IDs of the nodes touched by the changes.
+func affectedNodeIDs(changes []*journalChange) []int64 {
+	var ids []int64
+	for _, c := range changes {
+		for _, r := range []row{c.before, c.after} {
+			if r == nil {
+				continue
+			}
+			switch c.table {
+			case "nodes":
+				ids = append(ids, r.id(c.table))
+			case "links":
+				ids = append(ids, r["origin_id"].(int64), r["dest_id"].(int64))
+			}
+		}
+	}
+	return ids
+}
+
+// replayJournal reverts (undo = true) or reapplies up to n journal entries. It
+// returns the affected entries in the order they were replayed.
+func (d *Database) replayJournal(n int, undo bool) ([]*store.JournalEntry, error) {
+	var entries []*store.JournalEntry
+
+	query := "SELECT entry_id, entry_desc, entry_time FROM journal " +
+		"WHERE entry_open = 0 AND entry_undone = 0 ORDER BY entry_id DESC LIMIT ?"
+	if !undo {
+		query = "SELECT entry_id, entry_desc, entry_time FROM journal " +
+			"WHERE entry_open = 0 AND entry_undone = 1 ORDER BY entry_id LIMIT ?"
+	}
+
+	txf := func(tx *sql.Tx) error {
+		rows, err := tx.Query(query, n)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			e := &store.JournalEntry{}
+			if err := rows.Scan(&e.ID, &e.Description, &e.Time); err != nil {
+				rows.Close()
+				return err
+			}
+			entries = append(entries, e)
+		}
+		rows.Close()
+
+		var affected []int64
+		for _, e := range entries {
+			changes, err := getJournalChanges(tx, e.ID)
+			if err != nil {
+				return err
+			}
+			if undo {
+				for i := len(changes) - 1; i >= 0; i-- {
+					c := changes[i]
+					if err := applyChange(tx, c.table, c.after, c.before); err != nil {
+						return fmt.Errorf("couldn't undo %q: %w", e.Description, err)
+					}
+				}
+			} else {
+				for _, c := range changes {
+					if err := applyChange(tx, c.table, c.before, c.after); err != nil {
+						return fmt.Errorf("couldn't redo %q: %w", e.Description, err)
+					}
+				}
+			}
+			_, err = tx.Exec("UPDATE journal SET entry_undone = ? WHERE entry_id = ?",
+				undo, e.ID)
+			if err != nil {
+				return err
+			}
+			affected = append(affected, affectedNodeIDs(changes)...)
+		}
+
+		// Make sure the status of the multitree is consistent.
+		visited := make(map[int64]bool)
+		for _, id := range affected {
+			if visited[id] {
+				continue
+			}
+			visited[id] = true
+			node, err := getGraph(tx, id)
+			if err != nil {
+				return err
+			}
+			if node == nil {
+				continue
+			}
+			if err := backpropCompletion(tx, node); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := d.execWriteTxFunc(txf); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Undo reverts the last n journal entries, most recent first. It returns the
+// reverted entries.
+func (d *Database) Undo(n int) ([]*store.JournalEntry, error) {
+	return d.replayJournal(n, true)
+}
+
+// Redo reapplies the last n undone journal entries. It returns the reapplied
+// entries.
+func (d *Database) Redo(n int) ([]*store.JournalEntry, error) {
+	return d.replayJournal(n, false)
+}
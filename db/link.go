@@ -10,20 +10,22 @@ import (
 )
 
 func (d *Database) GetLink(linkID int64) (*multitree.Link, error) {
-	row := d.DB.QueryRow("SELECT * FROM links WHERE link_id = ?", linkID)
+	row := d.DB.QueryRow(
+		"SELECT "+linkColumns+" FROM links WHERE link_id = ?", linkID)
 	return rowToLink(row)
 }
 
 func (d *Database) GetLinkByEndpoints(originID, destID int64) (*multitree.Link, error) {
 	row := d.DB.QueryRow(
-		"SELECT * FROM links WHERE origin_id = ? AND dest_id = ?", originID, destID)
+		"SELECT "+linkColumns+" FROM links WHERE origin_id = ? AND dest_id = ?",
+		originID, destID)
 	return rowToLink(row)
 }
 
 // GetLinksByNodeID gets the node's incoming and outcoming links.
 func (d *Database) GetLinksByNodeID(nodeID int64) ([]*multitree.Link, error) {
 	rows, err := d.DB.Query(
-		"SELECT * FROM links WHERE origin_id = ? OR dest_id = ?",
+		"SELECT "+linkColumns+" FROM links WHERE origin_id = ? OR dest_id = ?",
 		nodeID,
 		nodeID,
 	)
@@ -34,12 +36,8 @@ func (d *Database) GetLinksByNodeID(nodeID int64) ([]*multitree.Link, error) {
 }
 
 func insertLink(tx *sql.Tx, originID, destID int64) (int64, error) {
-	r, err := tx.Exec("INSERT INTO links (origin_id, dest_id) VALUES (?, ?)",
-		originID, destID)
-	if err != nil {
-		return 0, err
-	}
-	return r.LastInsertId()
+	return journaledInsert(tx, "links",
+		"INSERT INTO links (origin_id, dest_id) VALUES (?, ?)", originID, destID)
 }
 
 func createLink(tx *sql.Tx, originID, destID int64) (int64, error) {
@@ -84,7 +82,8 @@ func (d *Database) CreateLink(originID, destID int64) (int64, error) {
 		linkID = id
 		return nil
 	}
-	if err := d.execTxFunc(txf); err != nil {
+	desc := fmt.Sprintf("link (%d) -> (%d)", originID, destID)
+	if err := d.execJournaledTxFunc(desc, txf); err != nil {
 		return 0, err
 	}
 	return linkID, nil
@@ -111,43 +110,87 @@ func (d *Database) CreateLinkFromDateNode(date string, destID int64) (int64, err
 		return nil
 	}
 
-	if err := d.execTxFunc(txf); err != nil {
+	desc := fmt.Sprintf("link %s -> (%d)", date, destID)
+	if err := d.execJournaledTxFunc(desc, txf); err != nil {
 		return 0, err
 	}
 	return linkID, nil
 }
 
 func deleteLinkByEndpoints(tx *sql.Tx, originID, destID int64) error {
-	r, err := tx.Exec("DELETE FROM links WHERE origin_id = ? AND dest_id = ?",
+	row := tx.QueryRow(
+		"SELECT "+linkColumns+" FROM links WHERE origin_id = ? AND dest_id = ?",
 		originID, destID)
+	link, err := rowToLink(row)
 	if err != nil {
 		return err
 	}
-	if count, _ := r.RowsAffected(); count == 0 {
+	if link == nil {
 		return fmt.Errorf("link (%d) -> (%d) does not exist", originID, destID)
 	}
-	return nil
+	_, err = journaledExec(tx, "links", link.ID,
+		"DELETE FROM links WHERE link_id = ?", link.ID)
+	return err
 }
 
 func (d *Database) DeleteLinkByEndpoints(originID, destID int64) error {
-	return d.execTxFunc(func(tx *sql.Tx) error {
+	desc := fmt.Sprintf("unlink (%d) -> (%d)", originID, destID)
+	return d.execJournaledTxFunc(desc, func(tx *sql.Tx) error {
 		if err := deleteLinkByEndpoints(tx, originID, destID); err != nil {
 			return err
 		}
 
-		origin, err := getGraph(tx, originID)
+		return updateFormerOrigin(tx, originID)
+	})
+}
+
+// updateFormerOrigin deletes the origin of a removed link if it's a date node
+// left without children, or updates its status otherwise.
+func updateFormerOrigin(tx *sql.Tx, originID int64) error {
+	origin, err := getGraph(tx, originID)
+	if err != nil {
+		return err
+	}
+	if origin.IsDateNode() && len(origin.Children()) == 0 {
+		_, err := deleteNode(tx, originID)
+		return err
+	}
+	return backpropCompletion(tx, origin)
+}
+
+// moveNode replaces the link from fromID to nodeID with a link from toID. The
+// new link is validated against the graph without the old one, and the old
+// parent is updated only once the node is in place.
+func moveNode(tx *sql.Tx, nodeID, fromID, toID int64) error {
+	if err := deleteLinkByEndpoints(tx, fromID, nodeID); err != nil {
+		return err
+	}
+	if _, err := createLink(tx, toID, nodeID); err != nil {
+		return err
+	}
+	return updateFormerOrigin(tx, fromID)
+}
+
+// MoveNode atomically moves the node from one parent to another.
+func (d *Database) MoveNode(nodeID, fromID, toID int64) error {
+	desc := fmt.Sprintf("move (%d) from (%d) to (%d)", nodeID, fromID, toID)
+	return d.execJournaledTxFunc(desc, func(tx *sql.Tx) error {
+		return moveNode(tx, nodeID, fromID, toID)
+	})
+}
+
+// MoveNodeToDateNode atomically moves the node from its parent to the date
+// node. Date node is automatically created if it doesn't exist.
+func (d *Database) MoveNodeToDateNode(nodeID, fromID int64, date string) error {
+	if err := multitree.ValidateDateNodeName(date); err != nil {
+		panic(err)
+	}
+	desc := fmt.Sprintf("move (%d) from (%d) to %s", nodeID, fromID, date)
+	return d.execJournaledTxFunc(desc, func(tx *sql.Tx) error {
+		toID, err := createDateNodeIfNotExists(tx, date)
 		if err != nil {
 			return err
 		}
-
-		if origin.IsDateNode() && len(origin.Children()) == 0 {
-			deleteNode(tx, originID)
-		} else {
-			if err := backpropCompletion(tx, origin); err != nil {
-				return err
-			}
-		}
-
-		return nil
+		return moveNode(tx, nodeID, fromID, toID)
 	})
 }
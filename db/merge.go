@@ -0,0 +1,917 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/climech/grit/multitree"
+	"github.com/climech/grit/store"
+)
+
+// mergeNode is the state of a node as seen by a merge.
+type mergeNode struct {
+	ID        int64   `json:"-"` // local ID, zero for the other database's nodes
+	UUID      string  `json:"uuid"`
+	Name      string  `json:"name"`
+	Alias     string  `json:"alias,omitempty"`
+	Notes     string  `json:"notes,omitempty"`
+	Due       string  `json:"due,omitempty"`
+	Estimate  float64 `json:"estimate,omitempty"`
+	Created   int64   `json:"created"`
+	Modified  int64   `json:"modified"`
+	Completed *int64  `json:"completed,omitempty"`
+	Cancelled *int64  `json:"cancelled,omitempty"`
+}
+
+// key identifies the node across databases. Date nodes are identified by
+// name, so that the date nodes created independently on both sides are
+// merged.
+func (n *mergeNode) key() string {
+	if multitree.ValidateDateNodeName(n.Name) == nil {
+		return "date:" + n.Name
+	}
+	return n.UUID
+}
+
+func (n *mergeNode) String() string {
+	if n.ID != 0 {
+		return fmt.Sprintf("%q (%d)", n.Name, n.ID)
+	}
+	return fmt.Sprintf("%q", n.Name)
+}
+
+func (n *mergeNode) copy() *mergeNode {
+	cp := *n
+	cp.Completed = copyCompletion(n.Completed)
+	cp.Cancelled = copyCompletion(n.Cancelled)
+	return &cp
+}
+
+// status is the node's completion status, as compared by a merge. The
+// completion and cancellation times themselves are not compared.
+func (n *mergeNode) status() string {
+	if n.Completed != nil {
+		return "completed"
+	}
+	if n.Cancelled != nil {
+		return "cancelled"
+	}
+	return "inactive"
+}
+
+// changedFrom returns true if any of the node's attributes other than the
+// completion and cancellation times differ from the other's.
+func (n *mergeNode) changedFrom(other *mergeNode) bool {
+	return n.Name != other.Name || n.Alias != other.Alias ||
+		n.Notes != other.Notes || n.Due != other.Due ||
+		n.Estimate != other.Estimate || n.status() != other.status()
+}
+
+type mergeLink struct {
+	ID       int64  `json:"-"`
+	UUID     string `json:"uuid"`
+	Origin   string `json:"origin"` // node key
+	Dest     string `json:"dest"`   // node key
+	Modified int64  `json:"modified,omitempty"`
+}
+
+// key identifies the link across databases. Links are identified by their
+// endpoints, so that the same link created on both sides is merged.
+func (l *mergeLink) key() string {
+	return l.Origin + " -> " + l.Dest
+}
+
+// snapshot is the state of a database as seen by a merge, with the nodes and
+// links mapped by their keys.
+type snapshot struct {
+	Nodes map[string]*mergeNode `json:"nodes"`
+	Links map[string]*mergeLink `json:"links"`
+}
+
+func newSnapshot() *snapshot {
+	return &snapshot{
+		Nodes: make(map[string]*mergeNode),
+		Links: make(map[string]*mergeLink),
+	}
+}
+
+func getSnapshot(tx *sql.Tx) (*snapshot, error) {
+	s := newSnapshot()
+	keys := make(map[int64]string)
+
+	rows, err := tx.Query("SELECT node_id, node_uuid, node_name, node_alias, " +
+		"node_notes, node_due, node_estimate, node_created, node_modified, " +
+		"node_completed, node_cancelled FROM nodes")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		n := &mergeNode{}
+		var alias, notes, due sql.NullString
+		var estimate sql.NullFloat64
+		var completed, cancelled sql.NullInt64
+		err := rows.Scan(&n.ID, &n.UUID, &n.Name, &alias, &notes, &due,
+			&estimate, &n.Created, &n.Modified, &completed, &cancelled)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		n.Alias, n.Notes, n.Due = alias.String, notes.String, due.String
+		n.Estimate = estimate.Float64
+		if completed.Valid {
+			n.Completed = &completed.Int64
+		}
+		if cancelled.Valid {
+			n.Cancelled = &cancelled.Int64
+		}
+		s.Nodes[n.key()] = n
+		keys[n.ID] = n.key()
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.Query("SELECT link_id, link_uuid, origin_id, dest_id, " +
+		"link_modified FROM links")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		l := &mergeLink{}
+		var originID, destID int64
+		err := rows.Scan(&l.ID, &l.UUID, &originID, &destID, &l.Modified)
+		if err != nil {
+			return nil, err
+		}
+		l.Origin, l.Dest = keys[originID], keys[destID]
+		s.Links[l.key()] = l
+	}
+	return s, rows.Err()
+}
+
+func nodeKeys(snapshots ...*snapshot) []string {
+	set := make(map[string]bool)
+	for _, s := range snapshots {
+		for k := range s.Nodes {
+			set[k] = true
+		}
+	}
+	var keys []string
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func linkKeys(snapshots ...*snapshot) []string {
+	set := make(map[string]bool)
+	for _, s := range snapshots {
+		for k := range s.Links {
+			set[k] = true
+		}
+	}
+	var keys []string
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sameTimes returns true if the node was completed or cancelled at the same
+// time as the other.
+func (n *mergeNode) sameTimes(other *mergeNode) bool {
+	equal := func(a, b *int64) bool {
+		return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+	}
+	return equal(n.Completed, other.Completed) && equal(n.Cancelled, other.Cancelled)
+}
+
+// inferBase guesses the base of a first merge, for databases that share nodes
+// without having been merged before, e.g. copies of the same database. The
+// databases are taken to have diverged when the last of the nodes that are
+// still the same on both sides was modified. Whatever is older than that is
+// taken to be part of the base: of two versions of a node, the older one is
+// the base, and the nodes and links found on one side only may have been
+// deleted on the other. If both versions of a node are newer, the node has no
+// base, so that the differences are reported as conflicts.
+//
+// The guess can be wrong, e.g. if the same change was made on both sides after
+// the split, so mergeSnapshots reports suspected deletions as conflicts rather
+// than carrying them out.
+func inferBase(ours, theirs *snapshot) *snapshot {
+	base := newSnapshot()
+	diverged := int64(-1)
+	for key, o := range ours.Nodes {
+		t := theirs.Nodes[key]
+		if t == nil || key != o.UUID || o.changedFrom(t) || !o.sameTimes(t) {
+			continue
+		}
+		if o.Modified > diverged {
+			diverged = o.Modified
+		}
+		if t.Modified > diverged {
+			diverged = t.Modified
+		}
+	}
+	if diverged < 0 {
+		// Nothing in common, or nothing to tell when the databases diverged.
+		return base
+	}
+
+	for _, key := range nodeKeys(ours, theirs) {
+		o, t := ours.Nodes[key], theirs.Nodes[key]
+		switch {
+		case o != nil && t != nil && !o.changedFrom(t):
+			base.Nodes[key] = o.copy()
+		case o != nil && t != nil:
+			if o.Modified < t.Modified && o.Modified <= diverged {
+				base.Nodes[key] = o.copy()
+			} else if t.Modified < o.Modified && t.Modified <= diverged {
+				base.Nodes[key] = t.copy()
+			}
+		case o != nil && t == nil && o.Modified <= diverged:
+			base.Nodes[key] = o.copy()
+		case t != nil && o == nil && t.Modified <= diverged:
+			base.Nodes[key] = t.copy()
+		}
+	}
+	for _, key := range linkKeys(ours, theirs) {
+		o, t := ours.Links[key], theirs.Links[key]
+		switch {
+		case o != nil && t != nil, o != nil && o.Modified <= diverged:
+			cp := *o
+			base.Links[key] = &cp
+		case t != nil && t.Modified <= diverged:
+			cp := *t
+			base.Links[key] = &cp
+		}
+	}
+	return base
+}
+
+// merge3 merges a value that may have been changed on either side since the
+// base. It returns false if both sides changed it differently. Without a base,
+// any difference is a conflict.
+func merge3(base, ours, theirs string, inBase bool) (string, bool) {
+	switch {
+	case ours == theirs:
+		return ours, true
+	case inBase && ours == base:
+		return theirs, true
+	case inBase && theirs == base:
+		return ours, true
+	}
+	return "", false
+}
+
+// merger computes the result of a three-way merge of two snapshots.
+type merger struct {
+	base, ours, theirs *snapshot
+
+	// other names the other database in messages.
+	other   string
+	resolve string
+
+	// inferred is true if the base was guessed by inferBase, in which case the
+	// nodes and links missing from one side may have been added on the other
+	// rather than deleted, so their deletion is reported as a conflict.
+	inferred bool
+
+	result    *snapshot
+	graph     map[string]*multitree.Node
+	conflicts []*store.Conflict
+
+	// statusConflicts holds the messages of completion conflicts. They're only
+	// reported for nodes left without children, since the status of the other
+	// nodes is derived from their children.
+	statusConflicts map[string]string
+}
+
+func (m *merger) conflict(kind, format string, a ...interface{}) {
+	m.conflicts = append(m.conflicts, &store.Conflict{
+		Kind:    kind,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+// pick returns the value from the side that wins conflicts.
+func (m *merger) pick(ours, theirs string) string {
+	if m.resolve == store.MergeTheirs {
+		return theirs
+	}
+	return ours
+}
+
+// describe returns the description of the node identified by key, preferring
+// the local version.
+func (m *merger) describe(key string) string {
+	for _, s := range []*snapshot{m.ours, m.theirs, m.base} {
+		if n := s.Nodes[key]; n != nil {
+			return n.String()
+		}
+	}
+	return key
+}
+
+func (m *merger) mergeNode(key string, b, o, t *mergeNode) *mergeNode {
+	r := o.copy()
+	inBase := b != nil
+	var baseName, baseAlias, baseNotes, baseDue, baseEstimate, baseStatus string
+	if inBase {
+		baseName, baseAlias, baseNotes, baseDue = b.Name, b.Alias, b.Notes, b.Due
+		baseEstimate = multitree.FormatEstimate(b.Estimate)
+		baseStatus = b.status()
+	}
+
+	if name, ok := merge3(baseName, o.Name, t.Name, inBase); ok {
+		r.Name = name
+	} else {
+		m.conflict(store.ConflictName, "%s is named %q here, but %q in %s",
+			o, o.Name, t.Name, m.other)
+		r.Name = m.pick(o.Name, t.Name)
+	}
+
+	if alias, ok := merge3(baseAlias, o.Alias, t.Alias, inBase); ok {
+		r.Alias = alias
+	} else {
+		m.conflict(store.ConflictAlias, "%s has alias %q here, but %q in %s",
+			o, o.Alias, t.Alias, m.other)
+		r.Alias = m.pick(o.Alias, t.Alias)
+	}
+
+	if notes, ok := merge3(baseNotes, o.Notes, t.Notes, inBase); ok {
+		r.Notes = notes
+	} else {
+		m.conflict(store.ConflictNotes, "%s has different notes here and in %s",
+			o, m.other)
+		r.Notes = m.pick(o.Notes, t.Notes)
+	}
+
+	if due, ok := merge3(baseDue, o.Due, t.Due, inBase); ok {
+		r.Due = due
+	} else {
+		m.conflict(store.ConflictDue, "%s is due %s here, but %s in %s",
+			o, describeDue(o.Due), describeDue(t.Due), m.other)
+		r.Due = m.pick(o.Due, t.Due)
+	}
+
+	ourEstimate := multitree.FormatEstimate(o.Estimate)
+	theirEstimate := multitree.FormatEstimate(t.Estimate)
+	if _, ok := merge3(baseEstimate, ourEstimate, theirEstimate, inBase); ok {
+		if ourEstimate == baseEstimate {
+			r.Estimate = t.Estimate
+		}
+	} else {
+		m.conflict(store.ConflictEstimate, "%s is estimated at %s here, but %s in %s",
+			o, ourEstimate, theirEstimate, m.other)
+		if m.resolve == store.MergeTheirs {
+			r.Estimate = t.Estimate
+		}
+	}
+
+	status, ok := merge3(baseStatus, o.status(), t.status(), inBase)
+	if !ok {
+		m.statusConflicts[key] = fmt.Sprintf("%s is %s here, but %s in %s",
+			o, o.status(), t.status(), m.other)
+		status = m.pick(o.status(), t.status())
+	}
+	switch {
+	case status == o.status() && status == t.status():
+		// Keep the earliest completion or cancellation time.
+		if o.Completed != nil && *t.Completed < *o.Completed {
+			r.Completed = copyCompletion(t.Completed)
+		}
+		if o.Cancelled != nil && *t.Cancelled < *o.Cancelled {
+			r.Cancelled = copyCompletion(t.Cancelled)
+		}
+	case status == t.status():
+		r.Completed = copyCompletion(t.Completed)
+		r.Cancelled = copyCompletion(t.Cancelled)
+	}
+
+	if t.Modified > r.Modified {
+		r.Modified = t.Modified
+	}
+	return r
+}
+
+func (m *merger) mergeNodes() {
+	for _, key := range nodeKeys(m.base, m.ours, m.theirs) {
+		b, o, t := m.base.Nodes[key], m.ours.Nodes[key], m.theirs.Nodes[key]
+		switch {
+		case o != nil && t != nil:
+			m.result.Nodes[key] = m.mergeNode(key, b, o, t)
+		case o != nil && b == nil:
+			m.result.Nodes[key] = o.copy()
+		case o != nil && m.inferred:
+			m.conflict(store.ConflictDelete, "%s may have been deleted in %s, "+
+				"or added here", o, m.other)
+			if m.resolve != store.MergeTheirs {
+				m.result.Nodes[key] = o.copy()
+			}
+		case o != nil && o.changedFrom(b):
+			m.conflict(store.ConflictDelete, "%s was changed here, but deleted in %s",
+				o, m.other)
+			if m.resolve != store.MergeTheirs {
+				m.result.Nodes[key] = o.copy()
+			}
+		case t != nil && b == nil:
+			m.result.Nodes[key] = t.copy()
+		case t != nil && m.inferred:
+			m.conflict(store.ConflictDelete, "%s may have been deleted here, "+
+				"or added in %s", t, m.other)
+			if m.resolve == store.MergeTheirs {
+				m.result.Nodes[key] = t.copy()
+			}
+		case t != nil && t.changedFrom(b):
+			m.conflict(store.ConflictDelete, "%s was changed in %s, but deleted here",
+				t, m.other)
+			if m.resolve == store.MergeTheirs {
+				m.result.Nodes[key] = t.copy()
+			}
+		}
+	}
+}
+
+// mergeAliases makes sure that no alias is used twice, keeping it on the node
+// that has it on the winning side.
+func (m *merger) mergeAliases() {
+	users := make(map[string][]string)
+	var aliases []string
+	for _, key := range nodeKeys(m.result) {
+		if alias := m.result.Nodes[key].Alias; alias != "" {
+			if users[alias] == nil {
+				aliases = append(aliases, alias)
+			}
+			users[alias] = append(users[alias], key)
+		}
+	}
+
+	winning := m.ours
+	if m.resolve == store.MergeTheirs {
+		winning = m.theirs
+	}
+
+	for _, alias := range aliases {
+		keys := users[alias]
+		if len(keys) < 2 {
+			continue
+		}
+		m.conflict(store.ConflictAlias, "alias %q is used by both %s and %s",
+			alias, m.describe(keys[0]), m.describe(keys[1]))
+		winner := keys[0]
+		for _, key := range keys {
+			if n := winning.Nodes[key]; n != nil && n.Alias == alias {
+				winner = key
+				break
+			}
+		}
+		for _, key := range keys {
+			if key != winner {
+				m.result.Nodes[key].Alias = ""
+			}
+		}
+	}
+}
+
+// addLink adds the link to the result, unless an endpoint is missing or the
+// link would break the multitree. added tells where the link was added since
+// the base, if it was.
+func (m *merger) addLink(l *mergeLink, added string) {
+	origin, dest := m.graph[l.Origin], m.graph[l.Dest]
+	if origin == nil || dest == nil {
+		if added == "" {
+			// The endpoint was deleted on purpose.
+			return
+		}
+		missing := l.Origin
+		if origin != nil {
+			missing = l.Dest
+		}
+		m.conflict(store.ConflictLink, "link %s -> %s was added %s, but %s was deleted",
+			m.describe(l.Origin), m.describe(l.Dest), added, m.describe(missing))
+		return
+	}
+	if err := multitree.LinkNodes(origin, dest); err != nil {
+		m.conflict(store.ConflictLink, "link %s -> %s added %s was dropped: %v",
+			m.describe(l.Origin), m.describe(l.Dest), added, err)
+		return
+	}
+	cp := *l
+	m.result.Links[l.key()] = &cp
+}
+
+// suspectDeletion reports the link found on one side only as a conflict. It
+// returns false if an endpoint is gone from the result. The conflict is left
+// out if an endpoint is found on one side only, as it's reported already.
+func (m *merger) suspectDeletion(l *mergeLink, deleted, added string) bool {
+	if m.graph[l.Origin] == nil || m.graph[l.Dest] == nil {
+		return false
+	}
+	for _, key := range []string{l.Origin, l.Dest} {
+		if m.ours.Nodes[key] == nil || m.theirs.Nodes[key] == nil {
+			return true
+		}
+	}
+	m.conflict(store.ConflictLink, "link %s -> %s may have been deleted %s, "+
+		"or added %s", m.describe(l.Origin), m.describe(l.Dest), deleted, added)
+	return true
+}
+
+// mergeLinks adds the links kept by both sides to the result. The local links
+// are added first, then the new links from the other side, each checked
+// against the multitree built so far.
+func (m *merger) mergeLinks() {
+	m.graph = make(map[string]*multitree.Node)
+	for i, key := range nodeKeys(m.result) {
+		n := multitree.NewNode(m.result.Nodes[key].Name)
+		n.ID = int64(i + 1)
+		m.graph[key] = n
+	}
+
+	var incoming []*mergeLink
+	for _, key := range linkKeys(m.base, m.ours, m.theirs) {
+		b, o, t := m.base.Links[key], m.ours.Links[key], m.theirs.Links[key]
+		switch {
+		case o != nil && t != nil && b != nil:
+			m.addLink(o, "")
+		case o != nil && t != nil:
+			m.addLink(o, "on both sides")
+		case o != nil && b == nil:
+			m.addLink(o, "here")
+		case t != nil && b == nil:
+			incoming = append(incoming, t)
+		case o != nil && m.inferred:
+			if m.suspectDeletion(o, "in "+m.other, "here") && m.resolve != store.MergeTheirs {
+				m.addLink(o, "")
+			}
+		case t != nil && m.inferred:
+			if m.suspectDeletion(t, "here", "in "+m.other) && m.resolve == store.MergeTheirs {
+				incoming = append(incoming, t)
+			}
+		}
+	}
+	for _, l := range incoming {
+		cp := *l
+		cp.ID = 0
+		m.addLink(&cp, "in "+m.other)
+	}
+}
+
+// finish reports the completion conflicts of the leaves, and removes the date
+// nodes left without children.
+func (m *merger) finish() {
+	var keys []string
+	for key := range m.statusConflicts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if n := m.graph[key]; n != nil && len(n.Children()) == 0 {
+			m.conflict(store.ConflictCompletion, "%s", m.statusConflicts[key])
+		}
+	}
+
+	for key, n := range m.graph {
+		if n.IsDateNode() && len(n.Children()) == 0 {
+			delete(m.result.Nodes, key)
+		}
+	}
+}
+
+// mergeSnapshots does a three-way merge of the snapshots. Conflicts are
+// resolved as requested, defaulting to the local version. inferred tells
+// whether the base was guessed by inferBase.
+func mergeSnapshots(base, ours, theirs *snapshot, inferred bool, other, resolve string) (*snapshot, []*store.Conflict) {
+	m := &merger{
+		base:            base,
+		ours:            ours,
+		theirs:          theirs,
+		other:           other,
+		resolve:         resolve,
+		inferred:        inferred,
+		result:          newSnapshot(),
+		statusConflicts: make(map[string]string),
+	}
+	m.mergeNodes()
+	m.mergeAliases()
+	m.mergeLinks()
+	m.finish()
+	return m.result, m.conflicts
+}
+
+func describeDue(due string) string {
+	if due == "" {
+		return "never"
+	}
+	return due
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nullIfZero(f float64) interface{} {
+	if f == 0 {
+		return nil
+	}
+	return f
+}
+
+func nullIfNil(p *int64) interface{} {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+// applySnapshot changes the database to match the snapshot, which is the
+// result of merging ours with another one.
+func applySnapshot(tx *sql.Tx, ours, result *snapshot) (*store.MergeResult, error) {
+	res := &store.MergeResult{}
+
+	for _, key := range linkKeys(ours) {
+		if result.Links[key] != nil {
+			continue
+		}
+		id := ours.Links[key].ID
+		_, err := journaledExec(tx, "links", id, "DELETE FROM links WHERE link_id = ?", id)
+		if err != nil {
+			return nil, err
+		}
+		res.LinksDeleted++
+	}
+
+	var updated []string
+	for _, key := range nodeKeys(ours) {
+		o, r := ours.Nodes[key], result.Nodes[key]
+		switch {
+		case r == nil:
+			if _, err := deleteNode(tx, o.ID); err != nil {
+				return nil, err
+			}
+			res.NodesDeleted++
+		case r.changedFrom(o) || nullIfNil(r.Completed) != nullIfNil(o.Completed) ||
+			nullIfNil(r.Cancelled) != nullIfNil(o.Cancelled):
+			// Aliases are cleared first, so that they can be swapped.
+			if o.Alias != "" && o.Alias != r.Alias {
+				_, err := journaledExec(tx, "nodes", o.ID,
+					"UPDATE nodes SET node_alias = NULL WHERE node_id = ?", o.ID)
+				if err != nil {
+					return nil, err
+				}
+			}
+			updated = append(updated, key)
+		}
+	}
+	for _, key := range updated {
+		r := result.Nodes[key]
+		_, err := journaledExec(tx, "nodes", r.ID,
+			"UPDATE nodes SET node_name = ?, node_alias = ?, node_notes = ?, "+
+				"node_due = ?, node_estimate = ?, node_completed = ?, "+
+				"node_cancelled = ?, node_modified = ? WHERE node_id = ?",
+			r.Name, nullIfEmpty(r.Alias), nullIfEmpty(r.Notes), nullIfEmpty(r.Due),
+			nullIfZero(r.Estimate), nullIfNil(r.Completed), nullIfNil(r.Cancelled),
+			r.Modified, r.ID)
+		if err != nil {
+			return nil, err
+		}
+		res.NodesUpdated++
+	}
+
+	ids := make(map[string]int64)
+	for _, key := range nodeKeys(result) {
+		if o := ours.Nodes[key]; o != nil {
+			ids[key] = o.ID
+			continue
+		}
+		r := result.Nodes[key]
+		id, err := journaledInsertRow(tx, "nodes", row{
+			"node_uuid":      r.UUID,
+			"node_name":      r.Name,
+			"node_alias":     nullIfEmpty(r.Alias),
+			"node_notes":     nullIfEmpty(r.Notes),
+			"node_due":       nullIfEmpty(r.Due),
+			"node_estimate":  nullIfZero(r.Estimate),
+			"node_created":   r.Created,
+			"node_modified":  r.Modified,
+			"node_completed": nullIfNil(r.Completed),
+			"node_cancelled": nullIfNil(r.Cancelled),
+		})
+		if err != nil {
+			return nil, err
+		}
+		ids[key] = id
+		res.NodesAdded++
+	}
+
+	for _, key := range linkKeys(result) {
+		if ours.Links[key] != nil {
+			continue
+		}
+		l := result.Links[key]
+		_, err := journaledInsertRow(tx, "links", row{
+			"link_uuid": l.UUID,
+			"origin_id": ids[l.Origin],
+			"dest_id":   ids[l.Dest],
+		})
+		if err != nil {
+			return nil, err
+		}
+		res.LinksAdded++
+	}
+
+	// Update the status of the nodes whose children have changed.
+	problems, err := fsck(tx)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range problems {
+		switch p.Kind {
+		case store.ProblemCompletion, store.ProblemEmptyDateNode:
+			if err := p.fix(tx); err != nil {
+				return nil, err
+			}
+		case store.ProblemInvalidName, store.ProblemInvalidAlias:
+			// Not caused by the merge.
+		default:
+			return nil, fmt.Errorf("merge would break the graph: %s", p.Message)
+		}
+	}
+
+	return res, nil
+}
+
+func getDatabaseUUID(q queryRower) (string, error) {
+	var uuid string
+	err := q.QueryRow("SELECT meta_value FROM meta WHERE meta_key = 'uuid'").Scan(&uuid)
+	return uuid, err
+}
+
+// mergeBase is the snapshot of a database taken at the time of the last merge.
+type mergeBase struct {
+	time     int64
+	snapshot *snapshot
+}
+
+func getMergeBase(tx *sql.Tx, peer string) (*mergeBase, error) {
+	b := &mergeBase{snapshot: newSnapshot()}
+	var data string
+	err := tx.QueryRow("SELECT merge_time, merge_base FROM merges "+
+		"WHERE merge_peer = ?", peer).Scan(&b.time, &data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(data), b.snapshot); err != nil {
+		return nil, fmt.Errorf("invalid merge base: %v", err)
+	}
+	return b, nil
+}
+
+func saveMergeBase(tx *sql.Tx, peer string, s *snapshot) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+
+	var id int64
+	err = tx.QueryRow("SELECT merge_id FROM merges WHERE merge_peer = ?", peer).Scan(&id)
+	if err == sql.ErrNoRows {
+		_, err = journaledInsertRow(tx, "merges", row{
+			"merge_peer": peer,
+			"merge_time": now,
+			"merge_base": string(data),
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	_, err = journaledExec(tx, "merges", id,
+		"UPDATE merges SET merge_time = ?, merge_base = ? WHERE merge_id = ?",
+		now, string(data), id)
+	return err
+}
+
+// Merge does a three-way merge of the database at path into this one. The
+// base of the merge is the snapshot of the other database taken by the last
+// merge on either side; for the first merge, the base is inferred from the
+// modification times (see inferBase). The other database is snapshotted in
+// turn, to serve as the base of the next merge.
+//
+// If there are conflicts and resolve is store.MergeAbort, it returns
+// store.ErrConflicts along with the result, and nothing is changed.
+func (d *Database) Merge(path string, resolve string) (*store.MergeResult, error) {
+	ours, err := os.Stat(d.Filename)
+	if err != nil {
+		return nil, err
+	}
+	theirs, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if os.SameFile(ours, theirs) {
+		return nil, fmt.Errorf("can't merge a database with itself")
+	}
+
+	ourUUID, err := getDatabaseUUID(d.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	other, err := NewWithOptions(path, d.opts)
+	if err != nil {
+		return nil, err
+	}
+	defer other.Close()
+
+	var theirUUID string
+	var theirSnapshot *snapshot
+	var theirBase *mergeBase
+	err = other.execTxFunc(func(tx *sql.Tx) error {
+		var err error
+		if theirUUID, err = getDatabaseUUID(tx); err != nil {
+			return err
+		}
+		// Copies of a database share its UUID, so one of them needs a new one.
+		if theirUUID == ourUUID {
+			ourUUID = newUUID()
+		}
+		if theirSnapshot, err = getSnapshot(tx); err != nil {
+			return err
+		}
+		theirBase, err = getMergeBase(tx, ourUUID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range theirSnapshot.Nodes {
+		n.ID = 0
+	}
+	for _, l := range theirSnapshot.Links {
+		l.ID = 0
+	}
+
+	var result *store.MergeResult
+	desc := fmt.Sprintf("merge %s", path)
+	err = d.execJournaledTxFunc(desc, func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE meta SET meta_value = ? WHERE meta_key = 'uuid'",
+			ourUUID)
+		if err != nil {
+			return err
+		}
+
+		ourSnapshot, err := getSnapshot(tx)
+		if err != nil {
+			return err
+		}
+		base, err := getMergeBase(tx, theirUUID)
+		if err != nil {
+			return err
+		}
+		if base == nil || (theirBase != nil && theirBase.time > base.time) {
+			base = theirBase
+		}
+		inferred := base == nil
+		if inferred {
+			base = &mergeBase{snapshot: inferBase(ourSnapshot, theirSnapshot)}
+		}
+
+		merged, conflicts := mergeSnapshots(base.snapshot, ourSnapshot,
+			theirSnapshot, inferred, filepath.Base(path), resolve)
+		if len(conflicts) > 0 && resolve == store.MergeAbort {
+			result = &store.MergeResult{Conflicts: conflicts}
+			return store.ErrConflicts
+		}
+		if result, err = applySnapshot(tx, ourSnapshot, merged); err != nil {
+			return err
+		}
+		result.Conflicts = conflicts
+		return saveMergeBase(tx, theirUUID, theirSnapshot)
+	})
+
+	if err == store.ErrConflicts {
+		return result, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -3,11 +3,12 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func migrateFrom0(db *sql.DB) error {
+func migrateFrom0(tx *sql.Tx) error {
 	createNodes := `
 		CREATE TABLE nodes (
 			node_id INTEGER PRIMARY KEY,
@@ -37,49 +38,438 @@ func migrateFrom0(db *sql.DB) error {
 			UNIQUE(origin_id, dest_id)
 		)`
 
-	if _, err := db.Exec(createNodes); err != nil {
+	if _, err := tx.Exec(createNodes); err != nil {
 		return err
 	}
-	if _, err := db.Exec(createLinks); err != nil {
+	if _, err := tx.Exec(createLinks); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// migrateFrom1 adds the journal used to undo and redo changes.
+func migrateFrom1(tx *sql.Tx) error {
+	createJournal := `
+		CREATE TABLE journal (
+			entry_id INTEGER PRIMARY KEY,
+			entry_desc VARCHAR(100) NOT NULL,
+			entry_time INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+			entry_open INTEGER NOT NULL DEFAULT 1,
+			entry_undone INTEGER NOT NULL DEFAULT 0
+		)`
+
+	createJournalChanges := `
+		CREATE TABLE journal_changes (
+			change_id INTEGER PRIMARY KEY,
+			entry_id INTEGER NOT NULL,
+			change_table VARCHAR(100) NOT NULL,
+			change_before TEXT DEFAULT NULL,
+			change_after TEXT DEFAULT NULL,
+
+			FOREIGN KEY (entry_id)
+				REFERENCES journal (entry_id)
+				ON DELETE CASCADE
+		)`
+
+	if _, err := tx.Exec(createJournal); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(createJournalChanges); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// migrateFrom2 adds the history of node events.
+func migrateFrom2(tx *sql.Tx) error {
+	createEvents := `
+		CREATE TABLE events (
+			event_id INTEGER PRIMARY KEY,
+			node_id INTEGER NOT NULL,
+			event_type VARCHAR(20) NOT NULL,
+			event_time INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+			event_node_name VARCHAR(100) NOT NULL,
+			event_other_id INTEGER DEFAULT NULL,
+			event_detail TEXT DEFAULT NULL
+		)`
+
+	// No foreign keys -- the history outlives the nodes.
+	createIndexes := []string{
+		`CREATE INDEX events_node_id ON events (node_id)`,
+		`CREATE INDEX events_other_id ON events (event_other_id)`,
+		`CREATE INDEX events_time ON events (event_time)`,
+	}
+
+	if _, err := tx.Exec(createEvents); err != nil {
+		return err
+	}
+	for _, query := range createIndexes {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateFrom3 adds the trash, holding the rows of removed nodes and links.
+func migrateFrom3(tx *sql.Tx) error {
+	createTrash := `
+		CREATE TABLE trash (
+			trash_id INTEGER PRIMARY KEY,
+			node_id INTEGER NOT NULL,
+			trash_name VARCHAR(100) NOT NULL,
+			trash_size INTEGER NOT NULL,
+			trash_time INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+			trash_data TEXT NOT NULL
+		)`
+
+	if _, err := tx.Exec(createTrash); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`CREATE INDEX trash_node_id ON trash (node_id)`); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// migrateFrom4 indexes link destinations, so that graphs can be loaded
+// efficiently in both directions.
+func migrateFrom4(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE INDEX links_dest_id ON links (dest_id)`)
+	return err
+}
+
+// migrateFrom5 adds a full-text index over node names, kept in sync with the
+// nodes table by triggers (see createSearchIndex).
+func migrateFrom5(tx *sql.Tx) error {
+	fts5, err := fts5Available(tx)
+	if err != nil {
+		return err
+	}
+	return createSearchIndex(tx, fts5)
+}
+
+// migrateFrom6 gives every node and link a UUID and a modification time, so
+// that databases can be merged, and gives the database a UUID of its own. The
+// existing rows get name-based UUIDs derived from their IDs and creation
+// times, so that copies of a database made before the migration agree on
+// them. New rows get random UUIDs from the triggers.
+func migrateFrom6(tx *sql.Tx) error {
+	queries := []string{
+		`ALTER TABLE nodes ADD COLUMN node_uuid TEXT`,
+		`ALTER TABLE nodes ADD COLUMN node_modified INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE links ADD COLUMN link_uuid TEXT`,
+		`ALTER TABLE links ADD COLUMN link_modified INTEGER NOT NULL DEFAULT 0`,
+		`CREATE TABLE meta (
+			meta_key TEXT PRIMARY KEY,
+			meta_value TEXT NOT NULL
+		)`,
+
+		// merges holds a snapshot of each database merged into this one, taken at
+		// the time of the last merge. It's used as the base of the next merge.
+		`CREATE TABLE merges (
+			merge_id INTEGER PRIMARY KEY,
+			merge_peer TEXT NOT NULL UNIQUE,
+			merge_time INTEGER NOT NULL,
+			merge_base TEXT NOT NULL
+		)`,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec("INSERT INTO meta (meta_key, meta_value) VALUES ('uuid', ?)",
+		newUUID())
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT node_id, node_created FROM nodes")
+	if err != nil {
+		return err
+	}
+	nodeUUIDs := make(map[int64]string)
+	for rows.Next() {
+		var id, created int64
+		if err := rows.Scan(&id, &created); err != nil {
+			rows.Close()
+			return err
+		}
+		nodeUUIDs[id] = nameUUID(fmt.Sprintf("node:%d:%d", id, created))
+	}
+	rows.Close()
+	for id, uuid := range nodeUUIDs {
+		_, err := tx.Exec("UPDATE nodes SET node_uuid = ?, "+
+			"node_modified = node_created WHERE node_id = ?", uuid, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	rows, err = tx.Query("SELECT " + linkColumns + " FROM links")
+	if err != nil {
+		return err
+	}
+	for _, l := range rowsToLinks(rows) {
+		uuid := nameUUID(fmt.Sprintf("link:%d:%s:%s", l.ID,
+			nodeUUIDs[l.OriginID], nodeUUIDs[l.DestID]))
+		_, err := tx.Exec("UPDATE links SET link_uuid = ?, link_modified = "+
+			"strftime('%s', 'now') WHERE link_id = ?", uuid, l.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	queries = []string{
+		`CREATE UNIQUE INDEX nodes_uuid ON nodes (node_uuid)`,
+		`CREATE UNIQUE INDEX links_uuid ON links (link_uuid)`,
+
+		// Rows inserted without a UUID or a modification time are given them.
+		`CREATE TRIGGER nodes_uuid_ai AFTER INSERT ON nodes BEGIN
+			UPDATE nodes SET
+				node_uuid = coalesce(new.node_uuid, ` + sqlNewUUID + `),
+				node_modified = CASE new.node_modified
+					WHEN 0 THEN strftime('%s', 'now') ELSE new.node_modified END
+			WHERE node_id = new.node_id;
+		END`,
+		`CREATE TRIGGER links_uuid_ai AFTER INSERT ON links BEGIN
+			UPDATE links SET
+				link_uuid = coalesce(new.link_uuid, ` + sqlNewUUID + `),
+				link_modified = CASE new.link_modified
+					WHEN 0 THEN strftime('%s', 'now') ELSE new.link_modified END
+			WHERE link_id = new.link_id;
+		END`,
+
+		// The modification time is updated unless it's set explicitly, e.g. by
+		// undo.
+		`CREATE TRIGGER nodes_modified_au
+			AFTER UPDATE OF node_name, node_alias, node_completed ON nodes
+			WHEN new.node_modified IS old.node_modified
+		BEGIN
+			UPDATE nodes SET node_modified = strftime('%s', 'now')
+				WHERE node_id = new.node_id;
+		END`,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addNodeColumn adds a column to the nodes table, and recreates the trigger
+// that updates the modification time, so that it watches columns, which
+// should include the new one.
+func addNodeColumn(tx *sql.Tx, definition string, columns ...string) error {
+	queries := []string{
+		`ALTER TABLE nodes ADD COLUMN ` + definition,
+		`DROP TRIGGER nodes_modified_au`,
+		`CREATE TRIGGER nodes_modified_au
+			AFTER UPDATE OF ` + strings.Join(columns, ", ") + ` ON nodes
+			WHEN new.node_modified IS old.node_modified
+		BEGIN
+			UPDATE nodes SET node_modified = strftime('%s', 'now')
+				WHERE node_id = new.node_id;
+		END`,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateFrom7 adds notes to the nodes.
+func migrateFrom7(tx *sql.Tx) error {
+	return addNodeColumn(tx, "node_notes TEXT DEFAULT NULL",
+		"node_name", "node_alias", "node_notes", "node_completed")
+}
+
+// migrateFrom8 adds due dates to the nodes.
+func migrateFrom8(tx *sql.Tx) error {
+	return addNodeColumn(tx, "node_due TEXT DEFAULT NULL",
+		"node_name", "node_alias", "node_notes", "node_due", "node_completed")
+}
+
+// migrateFrom9 adds effort estimates to the nodes.
+func migrateFrom9(tx *sql.Tx) error {
+	return addNodeColumn(tx, "node_estimate REAL DEFAULT NULL",
+		"node_name", "node_alias", "node_notes", "node_due", "node_estimate",
+		"node_completed")
+}
+
+// migrateFrom10 adds the time entries recorded by the timers. Like the events,
+// the entries outlive their nodes, so there are no foreign keys. At most one
+// timer can be running at a time.
+func migrateFrom10(tx *sql.Tx) error {
+	queries := []string{
+		`CREATE TABLE time_entries (
+			time_id INTEGER PRIMARY KEY,
+			node_id INTEGER NOT NULL,
+			time_start INTEGER NOT NULL,
+			time_stop INTEGER DEFAULT NULL,
+
+			CHECK(time_stop IS NULL OR time_stop >= time_start)
+		)`,
+		`CREATE INDEX time_entries_node_id ON time_entries (node_id)`,
+		`CREATE INDEX time_entries_start ON time_entries (time_start)`,
+		// NULLs are distinct in unique indexes, so an expression is indexed.
+		`CREATE UNIQUE INDEX time_entries_running
+			ON time_entries ((time_stop IS NULL)) WHERE time_stop IS NULL`,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateFrom11 adds the recurrence rules. The rules of deleted template nodes
+// are kept, so that they come back with the nodes restored from the trash.
+func migrateFrom11(tx *sql.Tx) error {
+	createRecurrences := `
+		CREATE TABLE recurrences (
+			recur_id INTEGER PRIMARY KEY,
+			node_id INTEGER NOT NULL,
+			recur_rule TEXT NOT NULL,
+			recur_start TEXT NOT NULL,
+			recur_synced TEXT DEFAULT NULL
+		)`
+
+	_, err := tx.Exec(createRecurrences)
+	return err
+}
+
+// migrateFrom12 adds the cancellation time to the nodes.
+func migrateFrom12(tx *sql.Tx) error {
+	return addNodeColumn(tx, "node_cancelled INTEGER DEFAULT NULL",
+		"node_name", "node_alias", "node_notes", "node_due", "node_estimate",
+		"node_completed", "node_cancelled")
+}
+
+// migrateFrom13 adds the dependencies between nodes.
+func migrateFrom13(tx *sql.Tx) error {
+	createDependencies := `
+		CREATE TABLE dependencies (
+			dep_id INTEGER PRIMARY KEY,
+			node_id INTEGER NOT NULL,
+			prereq_id INTEGER NOT NULL,
+
+			FOREIGN KEY (node_id)
+				REFERENCES nodes (node_id)
+				ON DELETE CASCADE
+
+			FOREIGN KEY (prereq_id)
+				REFERENCES nodes (node_id)
+				ON DELETE CASCADE
+
+			CHECK(node_id != prereq_id)
+			UNIQUE(node_id, prereq_id)
+		)`
+
+	_, err := tx.Exec(createDependencies)
+	return err
+}
+
+// migrateFrom14 adds the custom properties of the nodes.
+func migrateFrom14(tx *sql.Tx) error {
+	createProperties := `
+		CREATE TABLE properties (
+			prop_id INTEGER PRIMARY KEY,
+			node_id INTEGER NOT NULL,
+			prop_key TEXT NOT NULL,
+			prop_value TEXT NOT NULL,
+
+			FOREIGN KEY (node_id)
+				REFERENCES nodes (node_id)
+				ON DELETE CASCADE
+
+			UNIQUE(node_id, prop_key)
+		)`
+
+	_, err := tx.Exec(createProperties)
+	return err
+}
+
 // migrationFuncs is a slice of functions that incrementally migrate the DB from
 // one version to the next. The length of this slice determines the latest known
 // database version. The first "migration" initializes an empty DB.
-var migrationFuncs = []func(*sql.DB) error{
+var migrationFuncs = []func(*sql.Tx) error{
 	migrateFrom0,
+	migrateFrom1,
+	migrateFrom2,
+	migrateFrom3,
+	migrateFrom4,
+	migrateFrom5,
+	migrateFrom6,
+	migrateFrom7,
+	migrateFrom8,
+	migrateFrom9,
+	migrateFrom10,
+	migrateFrom11,
+	migrateFrom12,
+	migrateFrom13,
+	migrateFrom14,
 }
 
 // migrate checks if the underlying database is up-to-date, and migrates
 // the data if needed. It returns an error if there's an IO problem or
-// Grit doesn't recognize the DB version.
+// Grit doesn't recognize the DB version. The database is backed up into the
+// default backup directory first. All migrations are applied in a single
+// transaction, so that concurrent processes don't migrate the DB twice.
 func (d *Database) migrate() error {
-	v, err := d.getUserVersion()
+	current := int64(len(migrationFuncs))
+
+	// Avoid taking the write lock if there's nothing to do.
+	v, err := getUserVersion(d.DB)
 	if err != nil {
 		return err
 	}
-	if v < 0 {
-		return fmt.Errorf("Corrupted database (negative user_version).")
+	if v == current {
+		return d.updateSearchIndex()
 	}
 
-	current := int64(len(migrationFuncs))
-	if v > current {
-		return fmt.Errorf("Database version is not supported by this version of " +
-			"Grit -- try upgrading to the latest release.")
+	// Keep a copy of the data in case the migration goes wrong. New databases
+	// have nothing to lose.
+	if v > 0 && v < current {
+		if _, err := d.snapshot(fmt.Sprintf("-v%d", v)); err != nil {
+			return fmt.Errorf("couldn't back up the database before "+
+				"migrating: %v", err)
+		}
 	}
-	for v < current {
-		if err := migrationFuncs[v](d.DB); err != nil {
+
+	err = d.execWriteTxFunc(func(tx *sql.Tx) error {
+		v, err := getUserVersion(tx)
+		if err != nil {
 			return err
 		}
-		v++
-		if err := d.setUserVersion(v); err != nil {
-			return err
+		if v < 0 {
+			return fmt.Errorf("Corrupted database (negative user_version).")
+		}
+		if v > current {
+			return fmt.Errorf("Database version is not supported by this " +
+				"version of Grit -- try upgrading to the latest release.")
+		}
+		for v < current {
+			if err := migrationFuncs[v](tx); err != nil {
+				return err
+			}
+			v++
 		}
+		return setUserVersion(tx, v)
+	})
+	if err != nil {
+		return err
 	}
-
-	return nil
+	return d.updateSearchIndex()
 }
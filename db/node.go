@@ -6,12 +6,14 @@ import (
 	"time"
 
 	"github.com/climech/grit/multitree"
+	"github.com/climech/grit/store"
 
-	_ "github.com/mattn/go-sqlite3"
+	sqlite3 "github.com/mattn/go-sqlite3"
 )
 
 func getNode(tx *sql.Tx, id int64) (*multitree.Node, error) {
-	row := tx.QueryRow("SELECT * FROM nodes WHERE node_id = ?", id)
+	row := tx.QueryRow(
+		"SELECT "+nodeColumns+" FROM nodes WHERE node_id = ?", id)
 	return rowToNode(row)
 }
 
@@ -33,7 +35,8 @@ func (d *Database) GetNode(id int64) (*multitree.Node, error) {
 }
 
 func getNodeByName(tx *sql.Tx, name string) (*multitree.Node, error) {
-	row := tx.QueryRow("SELECT * FROM nodes WHERE node_name = ?", name)
+	row := tx.QueryRow(
+		"SELECT "+nodeColumns+" FROM nodes WHERE node_name = ?", name)
 	return rowToNode(row)
 }
 
@@ -55,7 +58,8 @@ func (d *Database) GetNodeByName(name string) (*multitree.Node, error) {
 }
 
 func getNodeByAlias(tx *sql.Tx, alias string) (*multitree.Node, error) {
-	row := tx.QueryRow("SELECT * FROM nodes WHERE node_alias = ?", alias)
+	row := tx.QueryRow(
+		"SELECT "+nodeColumns+" FROM nodes WHERE node_alias = ?", alias)
 	return rowToNode(row)
 }
 
@@ -79,7 +83,7 @@ func (d *Database) GetNodeByAlias(alias string) (*multitree.Node, error) {
 // GetRoots returns a slice of nodes that have no predecessors.
 func (d *Database) GetRoots() ([]*multitree.Node, error) {
 	rows, err := d.DB.Query(
-		"SELECT * FROM nodes " +
+		"SELECT " + nodeColumns + " FROM nodes " +
 			"WHERE NOT EXISTS(SELECT * FROM links WHERE dest_id = node_id)",
 	)
 	if err != nil {
@@ -88,54 +92,26 @@ func (d *Database) GetRoots() ([]*multitree.Node, error) {
 	return rowsToNodes(rows), nil
 }
 
+// backpropCompletion saves the status changes implied by the leaves below the
+// node. See multitree.BackpropCompletion.
 func backpropCompletion(tx *sql.Tx, node *multitree.Node) error {
-	var updateQueue []*multitree.Node
-	var backprop func(*multitree.Node)
-
-	backprop = func(n *multitree.Node) {
-		allChildrenCompleted := true
-		for _, c := range n.Children() {
-			if !c.IsCompleted() {
-				allChildrenCompleted = false
-				break
-			}
-		}
-		if n.IsCompleted() != allChildrenCompleted {
-			if allChildrenCompleted {
-				n.Completed = copyCompletion(n.Children()[0].Completed)
-			} else {
-				n.Completed = nil
-			}
-			updateQueue = append(updateQueue, n)
-		}
-		for _, p := range n.Parents() {
-			backprop(p)
-		}
-	}
-
-	for _, leaf := range node.Leaves() {
-		for _, p := range leaf.Parents() {
-			backprop(p)
-		}
-	}
-
-	for _, node := range updateQueue {
-		_, err := tx.Exec("UPDATE nodes SET node_completed = ? WHERE node_id = ?",
-			node.Completed, node.ID)
+	for _, n := range multitree.BackpropCompletion(node) {
+		_, err := journaledExec(tx, "nodes", n.ID,
+			"UPDATE nodes SET node_completed = ? WHERE node_id = ?",
+			n.Completed, n.ID)
 		if err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
 
 func createNode(tx *sql.Tx, name string, parentID int64) (int64, error) {
-	r, err := tx.Exec(`INSERT INTO nodes (node_name) VALUES (?)`, name)
+	id, err := journaledInsert(tx, "nodes",
+		`INSERT INTO nodes (node_name) VALUES (?)`, name)
 	if err != nil {
 		return 0, err
 	}
-	id, _ := r.LastInsertId()
 	if parentID != 0 {
 		if _, err := createLink(tx, parentID, id); err != nil {
 			return 0, err
@@ -163,7 +139,7 @@ func (d *Database) CreateNode(name string, parentID int64) (int64, error) {
 		childID = id
 		return nil
 	}
-	if err := d.execTxFunc(txf); err != nil {
+	if err := d.execJournaledTxFunc(fmt.Sprintf("add %q", name), txf); err != nil {
 		return 0, err
 	}
 	return childID, nil
@@ -200,7 +176,7 @@ func (d *Database) CreateChildOfDateNode(date, name string) (int64, error) {
 		return nil
 	}
 
-	if err := d.execTxFunc(txf); err != nil {
+	if err := d.execJournaledTxFunc(fmt.Sprintf("add %q", name), txf); err != nil {
 		return 0, err
 	}
 	return childID, nil
@@ -217,6 +193,17 @@ func createTree(tx *sql.Tx, node *multitree.Node, parentID int64) (int64, error)
 			pid = parents[0].ID
 		}
 		id, err := createNode(tx, current.Name, pid)
+		if err == nil && current.Notes != "" {
+			err = setNotes(tx, id, current.Notes)
+		}
+		if err == nil && (current.Due != "" || current.Estimate != 0 ||
+			current.Completed != nil || current.Cancelled != nil) {
+			_, err = journaledExec(tx, "nodes", id,
+				"UPDATE nodes SET node_due = ?, node_estimate = ?, node_completed = ?, "+
+					"node_cancelled = ? WHERE node_id = ?",
+				nullIfEmpty(current.Due), nullIfZero(current.Estimate),
+				current.Completed, current.Cancelled, id)
+		}
 		if err != nil {
 			retErr = err
 			stop()
@@ -229,23 +216,35 @@ func createTree(tx *sql.Tx, node *multitree.Node, parentID int64) (int64, error)
 		return 0, retErr
 	}
 
-	// Update ancestors, if any.
-	if parentID != 0 {
-		g, err := getGraph(tx, tree.ID)
-		if err != nil {
-			return 0, err
-		}
-		if err := backpropCompletion(tx, g); err != nil {
-			return 0, err
-		}
+	// Update the ancestors, if any, along with the nodes whose status is implied
+	// by the saved leaves.
+	g, err := getGraph(tx, tree.ID)
+	if err != nil {
+		return 0, err
+	}
+	if err := backpropCompletion(tx, g); err != nil {
+		return 0, err
 	}
 
+	multitree.CopyIDs(node, tree)
 	return tree.ID, nil
 }
 
-// CreateTree saves an entire tree in the database and returns the root ID. It
-// updates the status of other nodes in the multitree to reflect the change.
-func (d *Database) CreateTree(node *multitree.Node, parentID int64) (int64, error) {
+// createSharedLinks links the nodes of a newly created tree to the existing
+// nodes.
+func createSharedLinks(tx *sql.Tx, links []store.SharedLink) error {
+	for _, l := range links {
+		if _, err := createLink(tx, l.Origin.ID, l.DestID); err != nil {
+			return fmt.Errorf("link (%d) -> (%d): %w", l.Origin.ID, l.DestID, err)
+		}
+	}
+	return nil
+}
+
+// CreateTree saves an entire tree in the database, along with its links to
+// existing nodes, and returns the root ID. It updates the status of other
+// nodes in the multitree to reflect the change.
+func (d *Database) CreateTree(node *multitree.Node, parentID int64, links ...store.SharedLink) (int64, error) {
 	var rootID int64
 
 	txf := func(tx *sql.Tx) error {
@@ -254,10 +253,10 @@ func (d *Database) CreateTree(node *multitree.Node, parentID int64) (int64, erro
 			return err
 		}
 		rootID = id
-		return nil
+		return createSharedLinks(tx, links)
 	}
 
-	if err := d.execTxFunc(txf); err != nil {
+	if err := d.execJournaledTxFunc(fmt.Sprintf("import %q", node.Name), txf); err != nil {
 		return 0, err
 	}
 	return rootID, nil
@@ -265,7 +264,7 @@ func (d *Database) CreateTree(node *multitree.Node, parentID int64) (int64, erro
 
 // CreateTreeAsChildOfDateNode atomically creates a tree and links the date node
 // to its root. Date node is created if it doesn't exist.
-func (d *Database) CreateTreeAsChildOfDateNode(date string, node *multitree.Node) (int64, error) {
+func (d *Database) CreateTreeAsChildOfDateNode(date string, node *multitree.Node, links ...store.SharedLink) (int64, error) {
 	var rootID int64
 
 	txf := func(tx *sql.Tx) error {
@@ -278,25 +277,29 @@ func (d *Database) CreateTreeAsChildOfDateNode(date string, node *multitree.Node
 			return err
 		}
 		rootID = id
-		return nil
+		return createSharedLinks(tx, links)
 	}
 
-	if err := d.execTxFunc(txf); err != nil {
+	if err := d.execJournaledTxFunc(fmt.Sprintf("import %q", node.Name), txf); err != nil {
 		return 0, err
 	}
 	return rootID, nil
 }
 
-func (d *Database) checkNode(nodeID int64, check bool) error {
-	var value *int64
+// checkNode sets the completion time of the node and its descendants to value,
+// or unchecks them if value is nil.
+func (d *Database) checkNode(nodeID int64, value *int64) error {
+	check := value != nil
+
+	// Checked nodes are no longer cancelled.
+	query := "UPDATE nodes SET node_completed = ? WHERE node_id = ?"
 	if check {
-		now := time.Now().Unix()
-		value = &now
+		query = "UPDATE nodes SET node_completed = ?, node_cancelled = NULL " +
+			"WHERE node_id = ?"
 	}
 
 	update := func(tx *sql.Tx, node *multitree.Node) error {
-		r, err := tx.Exec("UPDATE nodes SET node_completed = ? WHERE node_id = ?",
-			value, node.ID)
+		r, err := journaledExec(tx, "nodes", node.ID, query, value, node.ID)
 		if err != nil {
 			return err
 		}
@@ -304,10 +307,18 @@ func (d *Database) checkNode(nodeID int64, check bool) error {
 			return fmt.Errorf("node does not exist")
 		}
 		node.Completed = copyCompletion(value)
+		if check {
+			node.Cancelled = nil
+		}
 		return nil
 	}
 
-	return d.execTxFunc(func(tx *sql.Tx) error {
+	desc := fmt.Sprintf("uncheck (%d)", nodeID)
+	if check {
+		desc = fmt.Sprintf("check (%d)", nodeID)
+	}
+
+	return d.execJournaledTxFunc(desc, func(tx *sql.Tx) error {
 		node, err := getGraph(tx, nodeID)
 		if err != nil {
 			return err
@@ -319,8 +330,11 @@ func (d *Database) checkNode(nodeID int64, check bool) error {
 		if err := update(tx, node); err != nil {
 			return err
 		}
-		// Update direct and indirect successors.
+		// Update direct and indirect successors, leaving the cancelled ones.
 		for _, n := range node.Descendants() {
+			if n.IsCancelled() {
+				continue
+			}
 			if err := update(tx, n); err != nil {
 				return err
 			}
@@ -335,78 +349,274 @@ func (d *Database) checkNode(nodeID int64, check bool) error {
 // CheckNode marks the node as completed, along with all its direct and indirect
 // successors. The rest of the multitree is updated to reflect the change.
 func (d *Database) CheckNode(nodeID int64) error {
-	return d.checkNode(nodeID, true)
+	return d.CheckNodeAt(nodeID, time.Now().Unix())
+}
+
+// CheckNodeAt is like CheckNode, but the nodes are completed at t, a Unix
+// timestamp, instead of now.
+func (d *Database) CheckNodeAt(nodeID int64, t int64) error {
+	return d.checkNode(nodeID, &t)
 }
 
 // UncheckNode sets the node's status to inactive, along with all its direct
 // and indirect successors. The rest of the multitree is updated to reflect the
 // change.
 func (d *Database) UncheckNode(nodeID int64) error {
-	return d.checkNode(nodeID, false)
+	return d.checkNode(nodeID, nil)
 }
 
-func (d *Database) RenameNode(nodeID int64, name string) error {
-	r, err := d.DB.Exec("UPDATE nodes SET node_name = ? WHERE node_id = ?",
-		name, nodeID)
-	if err != nil {
-		return err
-	}
-	if count, _ := r.RowsAffected(); count == 0 {
-		return fmt.Errorf("not found")
+func (d *Database) cancelNode(nodeID int64, cancel bool) error {
+	var value *int64
+	if cancel {
+		now := time.Now().Unix()
+		value = &now
 	}
-	return nil
-}
 
-func deleteNode(tx *sql.Tx, id int64) error {
-	r, err := tx.Exec(`DELETE FROM nodes WHERE node_id = ?`, id)
-	if err != nil {
+	update := func(tx *sql.Tx, node *multitree.Node) error {
+		_, err := journaledExec(tx, "nodes", node.ID,
+			"UPDATE nodes SET node_cancelled = ? WHERE node_id = ?", value, node.ID)
+		node.Cancelled = copyCompletion(value)
 		return err
 	}
-	if count, _ := r.RowsAffected(); count == 0 {
-		return fmt.Errorf("node does not exist")
-	}
-	return nil
-}
 
-// DeleteNode deletes a single node and propagates the change to the rest of the
-// multitree. It returns the node's orphaned successors.
-func (d *Database) DeleteNode(id int64) ([]*multitree.Node, error) {
-	var orphans []*multitree.Node
+	desc := fmt.Sprintf("uncancel (%d)", nodeID)
+	if cancel {
+		desc = fmt.Sprintf("cancel (%d)", nodeID)
+	}
 
-	txf := func(tx *sql.Tx) error {
-		node, err := getGraph(tx, id)
+	return d.execJournaledTxFunc(desc, func(tx *sql.Tx) error {
+		node, err := getGraph(tx, nodeID)
 		if err != nil {
 			return err
 		}
 		if node == nil {
 			return fmt.Errorf("node does not exist")
 		}
+		if cancel && node.IsCompleted() {
+			return fmt.Errorf("completed nodes cannot be cancelled")
+		}
+		if err := update(tx, node); err != nil {
+			return err
+		}
+		// Update the successors that are still unresolved, or were cancelled.
+		for _, n := range node.Descendants() {
+			if (cancel && n.IsResolved()) || (!cancel && !n.IsCancelled()) {
+				continue
+			}
+			if err := update(tx, n); err != nil {
+				return err
+			}
+		}
+		return backpropCompletion(tx, node)
+	})
+}
+
+// CancelNode marks the node as cancelled, along with its direct and indirect
+// successors that aren't resolved yet. The rest of the multitree is updated to
+// reflect the change.
+func (d *Database) CancelNode(nodeID int64) error {
+	return d.cancelNode(nodeID, true)
+}
+
+// UncancelNode reverts the cancellation of the node and its direct and
+// indirect successors. The rest of the multitree is updated to reflect the
+// change.
+func (d *Database) UncancelNode(nodeID int64) error {
+	return d.cancelNode(nodeID, false)
+}
 
-		if err := deleteNode(tx, id); err != nil {
+func (d *Database) RenameNode(nodeID int64, name string) error {
+	desc := fmt.Sprintf("rename (%d)", nodeID)
+	return d.execJournaledTxFunc(desc, func(tx *sql.Tx) error {
+		r, err := journaledExec(tx, "nodes", nodeID,
+			"UPDATE nodes SET node_name = ? WHERE node_id = ?", name, nodeID)
+		if err != nil {
 			return err
 		}
+		if count, _ := r.RowsAffected(); count == 0 {
+			return fmt.Errorf("not found")
+		}
+		return nil
+	})
+}
 
-		// Auto-delete any empty date nodes.
-		for _, dn := range filterDateNodes(node.Parents()) {
-			if len(dn.Children()) == 1 {
-				if err := deleteNode(tx, dn.ID); err != nil {
-					return err
-				}
-				// Unlink to ignore in backprop.
-				if err := multitree.UnlinkNodes(dn, node); err != nil {
-					panic(err)
-				}
+// removedRows holds the rows deleted from the nodes, links, dependencies and
+// properties tables.
+type removedRows struct {
+	Nodes        []row `json:"nodes"`
+	Links        []row `json:"links"`
+	Dependencies []row `json:"dependencies,omitempty"`
+	Properties   []row `json:"properties,omitempty"`
+}
+
+func (r *removedRows) add(other *removedRows) {
+	r.Nodes = append(r.Nodes, other.Nodes...)
+	r.Links = append(r.Links, other.Links...)
+	r.Dependencies = append(r.Dependencies, other.Dependencies...)
+	r.Properties = append(r.Properties, other.Properties...)
+}
+
+// deleteNode deletes the node along with its links, dependencies and
+// properties, and returns the deleted rows. They're deleted explicitly rather
+// than by cascade, so that the journal can restore them.
+func deleteNode(tx *sql.Tx, id int64) (*removedRows, error) {
+	removed := &removedRows{}
+	propIDs, err := queryIDs(tx,
+		"SELECT prop_id FROM properties WHERE node_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	for _, propID := range propIDs {
+		r, err := getRow(tx, "properties", propID)
+		if err != nil {
+			return nil, err
+		}
+		_, err = journaledExec(tx, "properties", propID,
+			"DELETE FROM properties WHERE prop_id = ?", propID)
+		if err != nil {
+			return nil, err
+		}
+		removed.Properties = append(removed.Properties, r)
+	}
+	depIDs, err := queryIDs(tx, "SELECT dep_id FROM dependencies "+
+		"WHERE node_id = ? OR prereq_id = ?", id, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, depID := range depIDs {
+		r, err := getRow(tx, "dependencies", depID)
+		if err != nil {
+			return nil, err
+		}
+		_, err = journaledExec(tx, "dependencies", depID,
+			"DELETE FROM dependencies WHERE dep_id = ?", depID)
+		if err != nil {
+			return nil, err
+		}
+		removed.Dependencies = append(removed.Dependencies, r)
+	}
+
+	rows, err := tx.Query(
+		"SELECT "+linkColumns+" FROM links WHERE origin_id = ? OR dest_id = ?",
+		id, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, link := range rowsToLinks(rows) {
+		r, err := getRow(tx, "links", link.ID)
+		if err != nil {
+			return nil, err
+		}
+		_, err = journaledExec(tx, "links", link.ID,
+			"DELETE FROM links WHERE link_id = ?", link.ID)
+		if err != nil {
+			return nil, err
+		}
+		removed.Links = append(removed.Links, r)
+	}
+	nodeRow, err := getRow(tx, "nodes", id)
+	if err != nil {
+		return nil, err
+	}
+	r, err := journaledExec(tx, "nodes", id,
+		`DELETE FROM nodes WHERE node_id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if count, _ := r.RowsAffected(); count == 0 {
+		return nil, fmt.Errorf("node does not exist")
+	}
+	removed.Nodes = append(removed.Nodes, nodeRow)
+	return removed, nil
+}
+
+// removeNode deletes a single node, and any date nodes left empty. It returns
+// the deleted node as a member of its former multitree, and the deleted rows.
+func removeNode(tx *sql.Tx, id int64) (*multitree.Node, *removedRows, error) {
+	node, err := getGraph(tx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if node == nil {
+		return nil, nil, fmt.Errorf("node does not exist")
+	}
+
+	removed, err := deleteNode(tx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Auto-delete any empty date nodes.
+	for _, dn := range filterDateNodes(node.Parents()) {
+		if len(dn.Children()) == 1 {
+			r, err := deleteNode(tx, dn.ID)
+			if err != nil {
+				return nil, nil, err
+			}
+			removed.add(r)
+			// Unlink to ignore in backprop.
+			if err := multitree.UnlinkNodes(dn, node); err != nil {
+				panic(err)
 			}
 		}
+	}
 
-		if err := backpropCompletion(tx, node); err != nil {
+	if err := backpropCompletion(tx, node); err != nil {
+		return nil, nil, err
+	}
+	return node, removed, nil
+}
+
+// removeNodeRecursive deletes the tree rooted at the given node, preserving
+// nodes that have parents outside of the tree. It returns the deleted nodes
+// and rows.
+func removeNodeRecursive(tx *sql.Tx, id int64) ([]*multitree.Node, *removedRows, error) {
+	node, err := getGraph(tx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if node == nil {
+		return nil, nil, fmt.Errorf("node does not exist")
+	}
+
+	removed, err := deleteNode(tx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	deleted := []*multitree.Node{node}
+
+	for _, d := range node.Descendants() {
+		if len(d.Parents()) == 1 {
+			r, err := deleteNode(tx, d.ID)
+			if err != nil {
+				return nil, nil, err
+			}
+			removed.add(r)
+			deleted = append(deleted, d)
+		}
+	}
+
+	if err := backpropCompletion(tx, node); err != nil {
+		return nil, nil, err
+	}
+	return deleted, removed, nil
+}
+
+// DeleteNode deletes a single node and propagates the change to the rest of the
+// multitree. It returns the node's orphaned successors.
+func (d *Database) DeleteNode(id int64) ([]*multitree.Node, error) {
+	var orphans []*multitree.Node
+
+	txf := func(tx *sql.Tx) error {
+		node, _, err := removeNode(tx, id)
+		if err != nil {
 			return err
 		}
 		orphans = node.Children()
 		return nil
 	}
 
-	if err := d.execTxFunc(txf); err != nil {
+	if err := d.execJournaledTxFunc(fmt.Sprintf("rm (%d)", id), txf); err != nil {
 		return nil, err
 	}
 	return orphans, nil
@@ -419,47 +629,24 @@ func (d *Database) DeleteNodeRecursive(id int64) ([]*multitree.Node, error) {
 	var deleted []*multitree.Node
 
 	txf := func(tx *sql.Tx) error {
-		node, err := getGraph(tx, id)
+		nodes, _, err := removeNodeRecursive(tx, id)
 		if err != nil {
 			return err
 		}
-		if node == nil {
-			return fmt.Errorf("node does not exist")
-		}
-
-		if err := deleteNode(tx, id); err != nil {
-			return err
-		}
-		deleted = append(deleted, node)
-
-		for _, d := range node.Descendants() {
-			if len(d.Parents()) == 1 {
-				if err := deleteNode(tx, d.ID); err != nil {
-					return err
-				}
-				deleted = append(deleted, d)
-			}
-		}
-
-		if err := backpropCompletion(tx, node); err != nil {
-			return err
-		}
+		deleted = nodes
 		return nil
 	}
 
-	if err := d.execTxFunc(txf); err != nil {
+	if err := d.execJournaledTxFunc(fmt.Sprintf("rm -r (%d)", id), txf); err != nil {
 		return nil, err
 	}
 	return deleted, nil
 }
 
-func (d *Database) SetAlias(nodeID int64, alias string) error {
-	nullable := &alias
-	if alias == "" {
-		nullable = nil
-	}
-	r, err := d.DB.Exec("UPDATE nodes SET node_alias = ? WHERE node_id = ?",
-		nullable, nodeID)
+func setNotes(tx *sql.Tx, nodeID int64, notes string) error {
+	r, err := journaledExec(tx, "nodes", nodeID,
+		"UPDATE nodes SET node_notes = ? WHERE node_id = ?", nullIfEmpty(notes),
+		nodeID)
 	if err != nil {
 		return err
 	}
@@ -468,3 +655,67 @@ func (d *Database) SetAlias(nodeID int64, alias string) error {
 	}
 	return nil
 }
+
+// SetNotes replaces the node's notes, or removes them if notes is empty.
+func (d *Database) SetNotes(nodeID int64, notes string) error {
+	desc := fmt.Sprintf("note (%d)", nodeID)
+	return d.execJournaledTxFunc(desc, func(tx *sql.Tx) error {
+		return setNotes(tx, nodeID, notes)
+	})
+}
+
+// SetDue sets the node's due date, or removes it if due is empty.
+func (d *Database) SetDue(nodeID int64, due string) error {
+	desc := fmt.Sprintf("due (%d)", nodeID)
+	return d.execJournaledTxFunc(desc, func(tx *sql.Tx) error {
+		r, err := journaledExec(tx, "nodes", nodeID,
+			"UPDATE nodes SET node_due = ? WHERE node_id = ?", nullIfEmpty(due),
+			nodeID)
+		if err != nil {
+			return err
+		}
+		if count, _ := r.RowsAffected(); count == 0 {
+			return fmt.Errorf("node does not exist")
+		}
+		return nil
+	})
+}
+
+// SetEstimate sets the node's effort estimate, or removes it if estimate is
+// zero.
+func (d *Database) SetEstimate(nodeID int64, estimate float64) error {
+	desc := fmt.Sprintf("estimate (%d)", nodeID)
+	return d.execJournaledTxFunc(desc, func(tx *sql.Tx) error {
+		r, err := journaledExec(tx, "nodes", nodeID,
+			"UPDATE nodes SET node_estimate = ? WHERE node_id = ?",
+			nullIfZero(estimate), nodeID)
+		if err != nil {
+			return err
+		}
+		if count, _ := r.RowsAffected(); count == 0 {
+			return fmt.Errorf("node does not exist")
+		}
+		return nil
+	})
+}
+
+func (d *Database) SetAlias(nodeID int64, alias string) error {
+	nullable := &alias
+	if alias == "" {
+		nullable = nil
+	}
+	desc := fmt.Sprintf("alias (%d)", nodeID)
+	return d.execJournaledTxFunc(desc, func(tx *sql.Tx) error {
+		r, err := journaledExec(tx, "nodes", nodeID,
+			"UPDATE nodes SET node_alias = ? WHERE node_id = ?", nullable, nodeID)
+		if e, ok := err.(sqlite3.Error); ok && e.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return store.ErrAliasExists
+		} else if err != nil {
+			return err
+		}
+		if count, _ := r.RowsAffected(); count == 0 {
+			return fmt.Errorf("node does not exist")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/climech/grit/store"
+)
+
+// SetProperty sets the node's property, replacing the previous value.
+func (d *Database) SetProperty(nodeID int64, key, value string) error {
+	desc := fmt.Sprintf("set (%d) %s", nodeID, key)
+	return d.execJournaledTxFunc(desc, func(tx *sql.Tx) error {
+		node, err := getNode(tx, nodeID)
+		if err != nil {
+			return err
+		}
+		if node == nil {
+			return fmt.Errorf("node does not exist")
+		}
+		var id int64
+		row := tx.QueryRow("SELECT prop_id FROM properties "+
+			"WHERE node_id = ? AND prop_key = ?", nodeID, key)
+		switch err := row.Scan(&id); err {
+		case sql.ErrNoRows:
+			_, err = journaledInsert(tx, "properties",
+				"INSERT INTO properties (node_id, prop_key, prop_value) "+
+					"VALUES (?, ?, ?)", nodeID, key, value)
+			return err
+		case nil:
+			_, err = journaledExec(tx, "properties", id,
+				"UPDATE properties SET prop_value = ? WHERE prop_id = ?", value, id)
+			return err
+		default:
+			return err
+		}
+	})
+}
+
+// DeleteProperty removes the node's property.
+func (d *Database) DeleteProperty(nodeID int64, key string) error {
+	desc := fmt.Sprintf("unset (%d) %s", nodeID, key)
+	return d.execJournaledTxFunc(desc, func(tx *sql.Tx) error {
+		var id int64
+		row := tx.QueryRow("SELECT prop_id FROM properties "+
+			"WHERE node_id = ? AND prop_key = ?", nodeID, key)
+		if err := row.Scan(&id); err == sql.ErrNoRows {
+			return fmt.Errorf("property does not exist")
+		} else if err != nil {
+			return err
+		}
+		_, err := journaledExec(tx, "properties", id,
+			"DELETE FROM properties WHERE prop_id = ?", id)
+		return err
+	})
+}
+
+func getProperties(tx *sql.Tx, nodeID int64) ([]*store.Property, error) {
+	query := "SELECT node_id, prop_key, prop_value FROM properties"
+	var args []interface{}
+	if nodeID != 0 {
+		query += " WHERE node_id = ?"
+		args = append(args, nodeID)
+	}
+	rows, err := tx.Query(query+" ORDER BY node_id, prop_key", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var props []*store.Property
+	for rows.Next() {
+		p := &store.Property{}
+		if err := rows.Scan(&p.NodeID, &p.Key, &p.Value); err != nil {
+			return nil, err
+		}
+		props = append(props, p)
+	}
+	return props, rows.Err()
+}
+
+// GetProperties returns the node's properties sorted by key, or the properties
+// of all nodes sorted by node ID and key if nodeID is zero.
+func (d *Database) GetProperties(nodeID int64) ([]*store.Property, error) {
+	var props []*store.Property
+	err := d.execTxFunc(func(tx *sql.Tx) error {
+		var err error
+		props, err = getProperties(tx, nodeID)
+		return err
+	})
+	return props, err
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/climech/grit/multitree"
+	"github.com/climech/grit/store"
+)
+
+// AddRecurrence saves the rule and returns its ID.
+func (d *Database) AddRecurrence(r *store.Recurrence) (int64, error) {
+	var id int64
+	desc := fmt.Sprintf("recur (%d)", r.NodeID)
+	err := d.execJournaledTxFunc(desc, func(tx *sql.Tx) error {
+		node, err := getNode(tx, r.NodeID)
+		if err != nil {
+			return err
+		}
+		if node == nil {
+			return fmt.Errorf("node does not exist")
+		}
+		id, err = journaledInsert(tx, "recurrences",
+			"INSERT INTO recurrences (node_id, recur_rule, recur_start, "+
+				"recur_synced) VALUES (?, ?, ?, ?)",
+			r.NodeID, r.Rule, r.Start, nullIfEmpty(r.Synced))
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// GetRecurrences returns all rules, sorted by ID, along with the current names
+// of their template nodes, if they still exist.
+func (d *Database) GetRecurrences() ([]*store.Recurrence, error) {
+	var recurrences []*store.Recurrence
+	err := d.execTxFunc(func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT recur_id, recurrences.node_id, " +
+			"coalesce(node_name, ''), recur_rule, recur_start, recur_synced " +
+			"FROM recurrences LEFT JOIN nodes " +
+			"ON nodes.node_id = recurrences.node_id ORDER BY recur_id")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			r := &store.Recurrence{}
+			var synced sql.NullString
+			err := rows.Scan(&r.ID, &r.NodeID, &r.NodeName, &r.Rule, &r.Start,
+				&synced)
+			if err != nil {
+				return err
+			}
+			r.Synced = synced.String
+			recurrences = append(recurrences, r)
+		}
+		return rows.Err()
+	})
+	return recurrences, err
+}
+
+// DeleteRecurrence removes the rule.
+func (d *Database) DeleteRecurrence(id int64) error {
+	desc := fmt.Sprintf("recur remove %d", id)
+	return d.execJournaledTxFunc(desc, func(tx *sql.Tx) error {
+		r, err := journaledExec(tx, "recurrences", id,
+			"DELETE FROM recurrences WHERE recur_id = ?", id)
+		if err != nil {
+			return err
+		}
+		if count, _ := r.RowsAffected(); count == 0 {
+			return fmt.Errorf("recurrence does not exist")
+		}
+		return nil
+	})
+}
+
+// SetRecurrenceSynced records that the rule's occurrences up to and including
+// the date have been created.
+func (d *Database) SetRecurrenceSynced(id int64, date string) error {
+	desc := fmt.Sprintf("recur sync %d", id)
+	return d.execJournaledTxFunc(desc, func(tx *sql.Tx) error {
+		return setRecurrenceSynced(tx, id, date)
+	})
+}
+
+// CreateOccurrence creates the tree as a child of the date node and records
+// the rule as synced up to and including the date, in a single transaction.
+// It returns the ID of the tree's root, or 0 if the rule was already synced up
+// to the date, or the date node already has a child with the tree's name, e.g.
+// because another process got there first.
+func (d *Database) CreateOccurrence(id int64, date string, node *multitree.Node) (int64, error) {
+	var rootID int64
+	desc := fmt.Sprintf("recur sync %d", id)
+	err := d.execJournaledTxFunc(desc, func(tx *sql.Tx) error {
+		var synced sql.NullString
+		err := tx.QueryRow("SELECT recur_synced FROM recurrences "+
+			"WHERE recur_id = ?", id).Scan(&synced)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("recurrence does not exist")
+		}
+		if err != nil {
+			return err
+		}
+		if synced.String >= date {
+			return nil
+		}
+		dateNodeID, err := createDateNodeIfNotExists(tx, date)
+		if err != nil {
+			return err
+		}
+		var exists bool
+		err = tx.QueryRow("SELECT EXISTS(SELECT * FROM links JOIN nodes "+
+			"ON node_id = dest_id WHERE origin_id = ? AND node_name = ?)",
+			dateNodeID, node.Name).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if rootID, err = createTree(tx, node, dateNodeID); err != nil {
+				return err
+			}
+		}
+		return setRecurrenceSynced(tx, id, date)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return rootID, nil
+}
+
+func setRecurrenceSynced(tx *sql.Tx, id int64, date string) error {
+	r, err := journaledExec(tx, "recurrences", id,
+		"UPDATE recurrences SET recur_synced = ? WHERE recur_id = ?", date, id)
+	if err != nil {
+		return err
+	}
+	if count, _ := r.RowsAffected(); count == 0 {
+		return fmt.Errorf("recurrence does not exist")
+	}
+	return nil
+}
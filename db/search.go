@@ -0,0 +1,199 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/climech/grit/multitree"
+	"github.com/climech/grit/store"
+)
+
+// fts5Available returns true if SQLite was built with FTS5, i.e. grit was
+// built with the sqlite_fts5 tag.
+func fts5Available(q queryRower) (bool, error) {
+	var ok bool
+	err := q.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&ok)
+	return ok, err
+}
+
+// searchIndexUsesFTS5 returns true if the search index is an FTS5 table.
+func searchIndexUsesFTS5(q queryRower) (bool, error) {
+	var schema string
+	err := q.QueryRow("SELECT sql FROM sqlite_master WHERE name = 'nodes_fts'").Scan(&schema)
+	return strings.Contains(strings.ToLower(schema), "using fts5"), err
+}
+
+// createSearchIndex (re)creates the full-text index over node names, kept in
+// sync with the nodes table by triggers. FTS5 is used if it's available, as
+// it ranks the matches with its built-in bm25(); otherwise, the index falls
+// back to FTS4, which is always available.
+func createSearchIndex(tx *sql.Tx, fts5 bool) error {
+	queries := []string{
+		`DROP TRIGGER IF EXISTS nodes_fts_bd`,
+		`DROP TRIGGER IF EXISTS nodes_fts_bu`,
+		`DROP TRIGGER IF EXISTS nodes_fts_ad`,
+		`DROP TRIGGER IF EXISTS nodes_fts_ai`,
+		`DROP TRIGGER IF EXISTS nodes_fts_au`,
+		`DROP TABLE IF EXISTS nodes_fts`,
+	}
+	if fts5 {
+		queries = append(queries,
+			`CREATE VIRTUAL TABLE nodes_fts USING fts5(
+				node_name,
+				content="nodes",
+				content_rowid="node_id",
+				tokenize="unicode61 remove_diacritics 1"
+			)`,
+
+			// Deleting from an external content table takes the old values.
+			`CREATE TRIGGER nodes_fts_ad AFTER DELETE ON nodes BEGIN
+				INSERT INTO nodes_fts (nodes_fts, rowid, node_name)
+					VALUES ('delete', old.node_id, old.node_name);
+			END`,
+			`CREATE TRIGGER nodes_fts_ai AFTER INSERT ON nodes BEGIN
+				INSERT INTO nodes_fts (rowid, node_name)
+					VALUES (new.node_id, new.node_name);
+			END`,
+			`CREATE TRIGGER nodes_fts_au AFTER UPDATE OF node_name ON nodes BEGIN
+				INSERT INTO nodes_fts (nodes_fts, rowid, node_name)
+					VALUES ('delete', old.node_id, old.node_name);
+				INSERT INTO nodes_fts (rowid, node_name)
+					VALUES (new.node_id, new.node_name);
+			END`,
+		)
+	} else {
+		queries = append(queries,
+			`CREATE VIRTUAL TABLE nodes_fts USING fts4(
+				content="nodes",
+				node_name,
+				tokenize=unicode61 "remove_diacritics=1"
+			)`,
+
+			// The old row must be removed from the index while it's still in
+			// the content table.
+			`CREATE TRIGGER nodes_fts_bd BEFORE DELETE ON nodes BEGIN
+				DELETE FROM nodes_fts WHERE docid = old.node_id;
+			END`,
+			`CREATE TRIGGER nodes_fts_bu BEFORE UPDATE OF node_name ON nodes BEGIN
+				DELETE FROM nodes_fts WHERE docid = old.node_id;
+			END`,
+			`CREATE TRIGGER nodes_fts_ai AFTER INSERT ON nodes BEGIN
+				INSERT INTO nodes_fts (docid, node_name)
+					VALUES (new.node_id, new.node_name);
+			END`,
+			`CREATE TRIGGER nodes_fts_au AFTER UPDATE OF node_name ON nodes BEGIN
+				INSERT INTO nodes_fts (docid, node_name)
+					VALUES (new.node_id, new.node_name);
+			END`,
+		)
+	}
+	queries = append(queries, `INSERT INTO nodes_fts (nodes_fts) VALUES ('rebuild')`)
+
+	for _, query := range queries {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateSearchIndex recreates the search index if it doesn't use the best
+// module available, e.g. when a database created by a build without FTS5 is
+// opened by one with it, or the other way around, in which case the FTS5
+// index couldn't be kept up to date.
+func (d *Database) updateSearchIndex() error {
+	fts5, err := fts5Available(d.DB)
+	if err != nil {
+		return err
+	}
+	if current, err := searchIndexUsesFTS5(d.DB); err != nil || current == fts5 {
+		return err
+	}
+	return d.execWriteTxFunc(func(tx *sql.Tx) error {
+		if current, err := searchIndexUsesFTS5(tx); err != nil || current == fts5 {
+			return err
+		}
+		return createSearchIndex(tx, fts5)
+	})
+}
+
+// ftsQuery converts the query to a full-text query matching names that
+// contain all the words, or words that start with them. The syntax for
+// prefixes differs between FTS4 and FTS5.
+func ftsQuery(query string, fts5 bool) string {
+	format := `"%s*"`
+	if fts5 {
+		format = `"%s"*`
+	}
+	var terms []string
+	for _, word := range strings.Fields(query) {
+		word = strings.Replace(word, `"`, "", -1)
+		if word != "" {
+			terms = append(terms, fmt.Sprintf(format, word))
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+// Search returns the nodes whose names match the query, most relevant first.
+// With FTS5, the matches are ranked by bm25(); with FTS4, which has no ranking
+// of its own, shorter names come first.
+func (d *Database) Search(query string, filter store.SearchFilter) ([]*multitree.Node, error) {
+	if strings.TrimSpace(strings.Replace(query, `"`, "", -1)) == "" {
+		return nil, nil
+	}
+
+	var nodes []*multitree.Node
+	err := d.execTxFunc(func(tx *sql.Tx) error {
+		fts5, err := searchIndexUsesFTS5(tx)
+		if err != nil {
+			return err
+		}
+		match := "matches(id, rank) AS (SELECT docid, length(node_name) " +
+			"FROM nodes_fts WHERE nodes_fts MATCH ?)"
+		if fts5 {
+			match = "matches(id, rank) AS (SELECT rowid, bm25(nodes_fts) " +
+				"FROM nodes_fts WHERE nodes_fts MATCH ?)"
+		}
+		ctes := []string{match}
+		args := []interface{}{ftsQuery(query, fts5)}
+		var conds []string
+
+		if filter.AncestorID != 0 {
+			ctes = append(ctes, "descendants(id) AS ("+
+				"SELECT dest_id FROM links WHERE origin_id = ? UNION "+
+				"SELECT dest_id FROM links JOIN descendants ON origin_id = id)")
+			args = append(args, filter.AncestorID)
+			conds = append(conds, "node_id IN (SELECT id FROM descendants)")
+		}
+		if filter.Incomplete {
+			conds = append(conds, "node_completed IS NULL AND node_cancelled IS NULL")
+		}
+
+		q := "WITH RECURSIVE " + strings.Join(ctes, ", ") +
+			" SELECT " + nodeColumns + " FROM matches JOIN nodes ON node_id = id"
+		if len(conds) > 0 {
+			q += " WHERE " + strings.Join(conds, " AND ")
+		}
+		q += " ORDER BY rank, node_id"
+
+		rows, err := tx.Query(q, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			node := &multitree.Node{}
+			if err := scanToNode(rows, node); err != nil {
+				return err
+			}
+			nodes = append(nodes, node)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
@@ -0,0 +1,172 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/climech/grit/store"
+)
+
+const timeEntryColumns = "time_id, time_entries.node_id, " +
+	"coalesce(node_name, ''), time_start, time_stop"
+
+// timeEntryQuery selects the entries along with the current names of their
+// nodes, if they still exist.
+const timeEntryQuery = "SELECT " + timeEntryColumns + " FROM time_entries " +
+	"LEFT JOIN nodes ON nodes.node_id = time_entries.node_id"
+
+func scanToTimeEntry(rows *sql.Rows, e *store.TimeEntry) error {
+	var stop sql.NullInt64
+	if err := rows.Scan(&e.ID, &e.NodeID, &e.NodeName, &e.Start, &stop); err != nil {
+		return err
+	}
+	e.Stop = stop.Int64
+	return nil
+}
+
+func getTimeEntries(tx *sql.Tx, query string, args ...interface{}) ([]*store.TimeEntry, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []*store.TimeEntry
+	for rows.Next() {
+		e := &store.TimeEntry{}
+		if err := scanToTimeEntry(rows, e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func getRunningTimer(tx *sql.Tx) (*store.TimeEntry, error) {
+	entries, err := getTimeEntries(tx, timeEntryQuery+" WHERE time_stop IS NULL")
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+	return entries[0], nil
+}
+
+// stopTimer stops the running timer at t, and returns its entry, or nil if no
+// timer is running.
+func stopTimer(tx *sql.Tx, t int64) (*store.TimeEntry, error) {
+	e, err := getRunningTimer(tx)
+	if err != nil || e == nil {
+		return nil, err
+	}
+	if t < e.Start {
+		return nil, fmt.Errorf("timer can't stop before it started")
+	}
+	_, err = journaledExec(tx, "time_entries", e.ID,
+		"UPDATE time_entries SET time_stop = ? WHERE time_id = ?", t, e.ID)
+	if err != nil {
+		return nil, err
+	}
+	e.Stop = t
+	return e, nil
+}
+
+// StartTimer starts timing the node at t, stopping the running timer first, if
+// any. If date isn't empty, the node is also linked from the date node, which
+// is created if it doesn't exist.
+func (d *Database) StartTimer(nodeID int64, t int64, date string) (*store.TimeEntry, error) {
+	var entry *store.TimeEntry
+	desc := fmt.Sprintf("start (%d)", nodeID)
+	err := d.execJournaledTxFunc(desc, func(tx *sql.Tx) error {
+		node, err := getNode(tx, nodeID)
+		if err != nil {
+			return err
+		}
+		if node == nil {
+			return fmt.Errorf("node does not exist")
+		}
+		if date != "" {
+			dateNodeID, err := createDateNodeIfNotExists(tx, date)
+			if err != nil {
+				return err
+			}
+			if _, err := createLink(tx, dateNodeID, nodeID); err != nil {
+				return err
+			}
+		}
+		if _, err := stopTimer(tx, t); err != nil {
+			return err
+		}
+		id, err := journaledInsert(tx, "time_entries",
+			"INSERT INTO time_entries (node_id, time_start) VALUES (?, ?)",
+			nodeID, t)
+		if err != nil {
+			return err
+		}
+		entry = &store.TimeEntry{
+			ID:       id,
+			NodeID:   nodeID,
+			NodeName: node.Name,
+			Start:    t,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// StopTimer stops the running timer at t, and returns its entry, or nil if no
+// timer is running.
+func (d *Database) StopTimer(t int64) (*store.TimeEntry, error) {
+	var entry *store.TimeEntry
+	err := d.execJournaledTxFunc("stop", func(tx *sql.Tx) error {
+		var err error
+		entry, err = stopTimer(tx, t)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// GetRunningTimer returns the entry of the running timer, or nil if there
+// isn't one.
+func (d *Database) GetRunningTimer() (*store.TimeEntry, error) {
+	var entry *store.TimeEntry
+	err := d.execTxFunc(func(tx *sql.Tx) error {
+		var err error
+		entry, err = getRunningTimer(tx)
+		return err
+	})
+	return entry, err
+}
+
+// GetTimeEntries returns the entries that overlap the period between since and
+// until, sorted by start time. Running timers are treated as if they never
+// stopped.
+func (d *Database) GetTimeEntries(since, until int64) ([]*store.TimeEntry, error) {
+	var conds []string
+	var args []interface{}
+	if since != 0 {
+		conds = append(conds, "(time_stop IS NULL OR time_stop > ?)")
+		args = append(args, since)
+	}
+	if until != 0 {
+		conds = append(conds, "time_start < ?")
+		args = append(args, until)
+	}
+	query := timeEntryQuery
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY time_start, time_id"
+
+	var entries []*store.TimeEntry
+	err := d.execTxFunc(func(tx *sql.Tx) error {
+		var err error
+		entries, err = getTimeEntries(tx, query, args...)
+		return err
+	})
+	return entries, err
+}
@@ -0,0 +1,306 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/climech/grit/multitree"
+	"github.com/climech/grit/store"
+)
+
+func decodeRemovedRows(data string) (*removedRows, error) {
+	dec := json.NewDecoder(bytes.NewReader([]byte(data)))
+	dec.UseNumber()
+	var raw struct {
+		Nodes        []json.RawMessage `json:"nodes"`
+		Links        []json.RawMessage `json:"links"`
+		Dependencies []json.RawMessage `json:"dependencies"`
+		Properties   []json.RawMessage `json:"properties"`
+	}
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	removed := &removedRows{}
+	for _, r := range raw.Nodes {
+		n, err := unmarshalRow(sql.NullString{String: string(r), Valid: true})
+		if err != nil {
+			return nil, err
+		}
+		removed.Nodes = append(removed.Nodes, n)
+	}
+	for _, r := range raw.Links {
+		l, err := unmarshalRow(sql.NullString{String: string(r), Valid: true})
+		if err != nil {
+			return nil, err
+		}
+		removed.Links = append(removed.Links, l)
+	}
+	for _, r := range raw.Dependencies {
+		dep, err := unmarshalRow(sql.NullString{String: string(r), Valid: true})
+		if err != nil {
+			return nil, err
+		}
+		removed.Dependencies = append(removed.Dependencies, dep)
+	}
+	for _, r := range raw.Properties {
+		p, err := unmarshalRow(sql.NullString{String: string(r), Valid: true})
+		if err != nil {
+			return nil, err
+		}
+		removed.Properties = append(removed.Properties, p)
+	}
+	return removed, nil
+}
+
+// isRemovedDateNode returns true if the row belongs to a date node that was
+// deleted automatically along with its last child.
+func isRemovedDateNode(r row) bool {
+	name, _ := r["node_name"].(string)
+	return multitree.ValidateDateNodeName(name) == nil
+}
+
+// insertTrashEntry moves the removed rows to the trash as a single entry.
+func insertTrashEntry(tx *sql.Tx, node *multitree.Node, removed *removedRows) error {
+	data, err := json.Marshal(removed)
+	if err != nil {
+		return err
+	}
+	size := 0
+	for _, r := range removed.Nodes {
+		if !isRemovedDateNode(r) {
+			size++
+		}
+	}
+	_, err = journaledInsert(tx, "trash",
+		"INSERT INTO trash (node_id, trash_name, trash_size, trash_data) "+
+			"VALUES (?, ?, ?, ?)", node.ID, node.Name, size, string(data))
+	return err
+}
+
+// TrashNode moves a single node to the trash. The node's children are
+// orphaned, as in DeleteNode.
+func (d *Database) TrashNode(id int64) ([]*multitree.Node, error) {
+	var orphans []*multitree.Node
+
+	txf := func(tx *sql.Tx) error {
+		node, removed, err := removeNode(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := insertTrashEntry(tx, node, removed); err != nil {
+			return err
+		}
+		orphans = node.Children()
+		return nil
+	}
+
+	if err := d.execJournaledTxFunc(fmt.Sprintf("rm (%d)", id), txf); err != nil {
+		return nil, err
+	}
+	return orphans, nil
+}
+
+// TrashNodeRecursive moves the tree rooted at the given node to the trash.
+// Nodes that have parents outside of this tree are preserved, as in
+// DeleteNodeRecursive.
+func (d *Database) TrashNodeRecursive(id int64) ([]*multitree.Node, error) {
+	var deleted []*multitree.Node
+
+	txf := func(tx *sql.Tx) error {
+		nodes, removed, err := removeNodeRecursive(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := insertTrashEntry(tx, nodes[0], removed); err != nil {
+			return err
+		}
+		deleted = nodes
+		return nil
+	}
+
+	if err := d.execJournaledTxFunc(fmt.Sprintf("rm -r (%d)", id), txf); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// GetTrash returns the trash entries, most recent first.
+func (d *Database) GetTrash() ([]*store.TrashEntry, error) {
+	rows, err := d.DB.Query(
+		"SELECT trash_id, node_id, trash_name, trash_size, trash_time " +
+			"FROM trash ORDER BY trash_time DESC, trash_id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []*store.TrashEntry
+	for rows.Next() {
+		e := &store.TrashEntry{}
+		if err := rows.Scan(&e.ID, &e.NodeID, &e.NodeName, &e.Size, &e.Time); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// restoreNodeRow inserts a removed node, preserving its ID and alias unless
+// they've been taken in the meantime. Date nodes are merged with existing ones.
+// It returns the ID of the restored node.
+func restoreNodeRow(tx *sql.Tx, r row) (int64, error) {
+	if isRemovedDateNode(r) {
+		return createDateNodeIfNotExists(tx, r["node_name"].(string))
+	}
+	r = r.copy()
+	if existing, err := getNode(tx, r.id("nodes")); err != nil {
+		return 0, err
+	} else if existing != nil {
+		delete(r, "node_id")
+	}
+	if alias, ok := r["node_alias"].(string); ok {
+		if existing, err := getNodeByAlias(tx, alias); err != nil {
+			return 0, err
+		} else if existing != nil {
+			r["node_alias"] = nil
+		}
+	}
+	return journaledInsertRow(tx, "nodes", r)
+}
+
+// RestoreNode brings back the most recently trashed node with the given ID,
+// along with its removed descendants and the links to its original parents and
+// children, provided they still exist. It returns the ID of the restored node,
+// which may differ from the original if the ID has been reused.
+func (d *Database) RestoreNode(nodeID int64) (int64, error) {
+	var restoredID int64
+
+	txf := func(tx *sql.Tx) error {
+		row := tx.QueryRow("SELECT trash_id, trash_data FROM trash "+
+			"WHERE node_id = ? ORDER BY trash_time DESC, trash_id DESC", nodeID)
+		var trashID int64
+		var data string
+		if err := row.Scan(&trashID, &data); err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("node is not in the trash")
+			}
+			return err
+		}
+		removed, err := decodeRemovedRows(data)
+		if err != nil {
+			return err
+		}
+
+		ids := make(map[int64]int64) // original -> restored
+		for _, r := range removed.Nodes {
+			id, err := restoreNodeRow(tx, r)
+			if err != nil {
+				return err
+			}
+			ids[r.id("nodes")] = id
+		}
+		restoredID = ids[nodeID]
+
+		// Links are recreated one by one, so that each is validated against
+		// the current state of the multitree.
+		for _, r := range removed.Links {
+			originID, destID := r["origin_id"].(int64), r["dest_id"].(int64)
+			if id, ok := ids[originID]; ok {
+				originID = id
+			}
+			if id, ok := ids[destID]; ok {
+				destID = id
+			}
+			origin, err := getNode(tx, originID)
+			if err != nil {
+				return err
+			}
+			dest, err := getNode(tx, destID)
+			if err != nil {
+				return err
+			}
+			if origin == nil || dest == nil {
+				continue // the other endpoint is gone
+			}
+			if _, err := createLink(tx, originID, destID); err != nil {
+				return fmt.Errorf("couldn't restore link (%d) -> (%d): %v",
+					originID, destID, err)
+			}
+		}
+
+		for _, r := range removed.Dependencies {
+			nodeID, prereqID := r["node_id"].(int64), r["prereq_id"].(int64)
+			if id, ok := ids[nodeID]; ok {
+				nodeID = id
+			}
+			if id, ok := ids[prereqID]; ok {
+				prereqID = id
+			}
+			node, err := getNode(tx, nodeID)
+			if err != nil {
+				return err
+			}
+			prereq, err := getNode(tx, prereqID)
+			if err != nil {
+				return err
+			}
+			if node == nil || prereq == nil {
+				continue
+			}
+			if _, err := createDependency(tx, nodeID, prereqID); err != nil {
+				return fmt.Errorf("couldn't restore dependency of (%d) on "+
+					"(%d): %v", nodeID, prereqID, err)
+			}
+		}
+
+		for _, r := range removed.Properties {
+			r = r.copy()
+			delete(r, "prop_id")
+			r["node_id"] = ids[r["node_id"].(int64)]
+			if _, err := journaledInsertRow(tx, "properties", r); err != nil {
+				return err
+			}
+		}
+
+		for _, id := range ids {
+			node, err := getGraph(tx, id)
+			if err != nil {
+				return err
+			}
+			if err := backpropCompletion(tx, node); err != nil {
+				return err
+			}
+		}
+
+		_, err = journaledExec(tx, "trash", trashID,
+			"DELETE FROM trash WHERE trash_id = ?", trashID)
+		return err
+	}
+
+	desc := fmt.Sprintf("restore (%d)", nodeID)
+	if err := d.execJournaledTxFunc(desc, txf); err != nil {
+		return 0, err
+	}
+	return restoredID, nil
+}
+
+// EmptyTrash permanently deletes trash entries older than the given age, or
+// all entries if age is zero. It returns the number of purged entries.
+func (d *Database) EmptyTrash(age time.Duration) (int64, error) {
+	var count int64
+	err := d.execWriteTxFunc(func(tx *sql.Tx) error {
+		cutoff := time.Now().Add(-age).Unix()
+		r, err := tx.Exec("DELETE FROM trash WHERE trash_time <= ?", cutoff)
+		if err != nil {
+			return err
+		}
+		count, _ = r.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -15,26 +15,43 @@ func copyCompletion(value *int64) *int64 {
 	return &cp
 }
 
+// nodeColumns lists the columns scanned by scanToNode, in order.
+const nodeColumns = "node_id, node_name, node_alias, node_notes, node_due, " +
+	"node_estimate, node_created, node_completed, node_cancelled"
+
+// linkColumns lists the columns scanned into multitree.Link, in order.
+const linkColumns = "link_id, origin_id, dest_id"
+
 type scannable interface {
 	Scan(...interface{}) error
 }
 
-func scanToNode(s scannable) (*multitree.Node, error) {
-	node := &multitree.Node{}
-	var alias sql.NullString
-	var completed sql.NullInt64
-	err := s.Scan(&node.ID, &node.Name, &alias, &node.Created, &completed)
+// scanToNode scans nodeColumns into node, followed by any extra destinations.
+func scanToNode(s scannable, node *multitree.Node, extra ...interface{}) error {
+	var alias, notes, due sql.NullString
+	var estimate sql.NullFloat64
+	var completed, cancelled sql.NullInt64
+	dest := []interface{}{&node.ID, &node.Name, &alias, &notes, &due, &estimate,
+		&node.Created, &completed, &cancelled}
+	err := s.Scan(append(dest, extra...)...)
 	if err == nil {
 		node.Alias = alias.String
+		node.Notes = notes.String
+		node.Due = due.String
+		node.Estimate = estimate.Float64
 		if completed.Valid {
 			node.Completed = &completed.Int64
 		}
+		if cancelled.Valid {
+			node.Cancelled = &cancelled.Int64
+		}
 	}
-	return node, err
+	return err
 }
 
 func rowToNode(row *sql.Row) (*multitree.Node, error) {
-	node, err := scanToNode(row)
+	node := &multitree.Node{}
+	err := scanToNode(row, node)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -48,7 +65,8 @@ func rowsToNodes(rows *sql.Rows) []*multitree.Node {
 	defer rows.Close()
 	var nodes []*multitree.Node
 	for rows.Next() {
-		node, _ := scanToNode(rows)
+		node := &multitree.Node{}
+		_ = scanToNode(rows, node)
 		nodes = append(nodes, node)
 	}
 	return nodes
@@ -0,0 +1,47 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"fmt"
+)
+
+// uuidNamespace is the namespace of the name-based UUIDs given to the rows
+// that existed before UUIDs were introduced.
+var uuidNamespace = []byte{
+	0x5f, 0x1c, 0x2b, 0x8e, 0x73, 0x0d, 0x4a, 0x61,
+	0x9c, 0x47, 0xd2, 0x3e, 0x88, 0x15, 0xa6, 0x0b,
+}
+
+// sqlNewUUID is an SQL expression evaluating to a random (version 4) UUID.
+const sqlNewUUID = `lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) ||
+	'-4' || substr(hex(randomblob(2)), 2) || '-' ||
+	substr('89AB', abs(random()) % 4 + 1, 1) || substr(hex(randomblob(2)), 2) ||
+	'-' || hex(randomblob(6)))`
+
+func formatUUID(b []byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return formatUUID(b)
+}
+
+// nameUUID returns a name-based (version 5) UUID, which is the same for the
+// same name.
+func nameUUID(name string) string {
+	h := sha1.New()
+	h.Write(uuidNamespace)
+	h.Write([]byte(name))
+	b := h.Sum(nil)[:16]
+	b[6] = b[6]&0x0f | 0x50
+	b[8] = b[8]&0x3f | 0x80
+	return formatUUID(b)
+}
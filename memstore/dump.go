@@ -0,0 +1,119 @@
+package memstore
+
+import (
+	"fmt"
+
+	"github.com/climech/grit/multitree"
+	"github.com/climech/grit/store"
+)
+
+func (s *state) export() *store.Dump {
+	dump := &store.Dump{Version: store.DumpVersion}
+	var nodeIDs, linkIDs []int64
+	for id := range s.nodes {
+		nodeIDs = append(nodeIDs, id)
+	}
+	for id := range s.links {
+		linkIDs = append(linkIDs, id)
+	}
+	for _, id := range sortedIDs(nodeIDs) {
+		n := s.nodes[id]
+		dump.Nodes = append(dump.Nodes, &store.DumpNode{
+			ID:        n.ID,
+			Name:      n.Name,
+			Alias:     n.Alias,
+			Notes:     n.Notes,
+			Due:       n.Due,
+			Estimate:  n.Estimate,
+			Created:   n.Created,
+			Completed: copyCompletion(n.Completed),
+			Cancelled: copyCompletion(n.Cancelled),
+		})
+	}
+	for _, id := range sortedIDs(linkIDs) {
+		l := s.links[id]
+		dump.Links = append(dump.Links, &store.DumpLink{
+			ID:       l.ID,
+			OriginID: l.OriginID,
+			DestID:   l.DestID,
+		})
+	}
+	return dump
+}
+
+// Export returns a snapshot of all nodes and links, sorted by ID.
+func (m *Store) Export() (*store.Dump, error) {
+	var dump *store.Dump
+	err := m.view(func(s *state) error {
+		dump = s.export()
+		return nil
+	})
+	return dump, err
+}
+
+// Import saves the dumped nodes and links. Dumps with dependencies or
+// properties are refused, as the store can't keep them. The dump is assumed to
+// be a valid multitree. If the store is empty, the dump is restored exactly,
+// IDs included. Otherwise, the nodes are given new IDs, and date nodes are
+// merged with the existing ones. It returns a map of dumped node IDs to the
+// IDs of the imported nodes.
+func (m *Store) Import(dump *store.Dump) (map[int64]int64, error) {
+	if len(dump.Dependencies) > 0 {
+		return nil, fmt.Errorf("dependencies are not supported by this store")
+	}
+	if len(dump.Properties) > 0 {
+		return nil, fmt.Errorf("properties are not supported by this store")
+	}
+	ids := make(map[int64]int64)
+
+	err := m.update(func(s *state) error {
+		exact := len(s.nodes) == 0
+
+		var merged []int64
+		for _, n := range dump.Nodes {
+			if !exact && multitree.ValidateDateNodeName(n.Name) == nil {
+				if existing := s.getNodeByName(n.Name); existing != nil {
+					ids[n.ID] = existing.ID
+					merged = append(merged, existing.ID)
+					continue
+				}
+			}
+			if n.Alias != "" && !exact && s.getNodeByAlias(n.Alias) != nil {
+				return fmt.Errorf("alias already exists: %s", n.Alias)
+			}
+			node := &multitree.Node{
+				Name:      n.Name,
+				Alias:     n.Alias,
+				Notes:     n.Notes,
+				Due:       n.Due,
+				Estimate:  n.Estimate,
+				Created:   n.Created,
+				Completed: copyCompletion(n.Completed),
+				Cancelled: copyCompletion(n.Cancelled),
+			}
+			if exact {
+				node.ID = n.ID
+			}
+			ids[n.ID] = s.insertNode(node)
+		}
+
+		for _, l := range dump.Links {
+			link := &multitree.Link{OriginID: ids[l.OriginID], DestID: ids[l.DestID]}
+			if exact {
+				link.ID = l.ID
+			}
+			s.insertLink(link)
+		}
+
+		// Merged date nodes may need their status updated.
+		for _, id := range merged {
+			s.backpropCompletion(s.graph(id))
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
@@ -0,0 +1,155 @@
+package memstore
+
+import (
+	"fmt"
+
+	"github.com/climech/grit/multitree"
+)
+
+func (s *state) insertLink(link *multitree.Link) int64 {
+	if link.ID == 0 {
+		s.lastLinkID++
+		link.ID = s.lastLinkID
+	} else if link.ID > s.lastLinkID {
+		s.lastLinkID = link.ID
+	}
+	s.links[link.ID] = link
+	s.out[link.OriginID] = append(s.out[link.OriginID], link.ID)
+	s.in[link.DestID] = append(s.in[link.DestID], link.ID)
+	return link.ID
+}
+
+func removeID(ids []int64, id int64) []int64 {
+	var filtered []int64
+	for _, i := range ids {
+		if i != id {
+			filtered = append(filtered, i)
+		}
+	}
+	return filtered
+}
+
+func (s *state) deleteLink(linkID int64) {
+	link, ok := s.links[linkID]
+	if !ok {
+		return
+	}
+	s.out[link.OriginID] = removeID(s.out[link.OriginID], linkID)
+	s.in[link.DestID] = removeID(s.in[link.DestID], linkID)
+	delete(s.links, linkID)
+}
+
+func (s *state) getLinkByEndpoints(originID, destID int64) *multitree.Link {
+	for _, lid := range s.out[originID] {
+		if l := s.links[lid]; l.DestID == destID {
+			return l
+		}
+	}
+	return nil
+}
+
+func (s *state) createLink(originID, destID int64) (int64, error) {
+	origin := s.graph(originID)
+	if origin == nil {
+		return 0, fmt.Errorf("link origin does not exist")
+	}
+	dest := s.graph(destID)
+	if dest == nil {
+		return 0, fmt.Errorf("link target does not exist")
+	}
+
+	if err := multitree.LinkNodes(origin, dest); err != nil {
+		return 0, err
+	}
+
+	linkID := s.insertLink(&multitree.Link{OriginID: originID, DestID: destID})
+	s.backpropCompletion(origin)
+	return linkID, nil
+}
+
+func (m *Store) CreateLink(originID, destID int64) (int64, error) {
+	var id int64
+	err := m.update(func(s *state) (err error) {
+		id, err = s.createLink(originID, destID)
+		return err
+	})
+	return id, err
+}
+
+// CreateLinkFromDateNode atomically creates an link with date node as the
+// origin. Date node is automatically created if it doesn't exist.
+func (m *Store) CreateLinkFromDateNode(date string, destID int64) (int64, error) {
+	if err := multitree.ValidateDateNodeName(date); err != nil {
+		panic(err)
+	}
+
+	var id int64
+	err := m.update(func(s *state) error {
+		originID, err := s.createDateNodeIfNotExists(date)
+		if err != nil {
+			return err
+		}
+		id, err = s.createLink(originID, destID)
+		return err
+	})
+	return id, err
+}
+
+func (m *Store) DeleteLinkByEndpoints(originID, destID int64) error {
+	return m.update(func(s *state) error {
+		link := s.getLinkByEndpoints(originID, destID)
+		if link == nil {
+			return fmt.Errorf("link (%d) -> (%d) does not exist", originID, destID)
+		}
+		s.deleteLink(link.ID)
+		return s.updateFormerOrigin(originID)
+	})
+}
+
+// updateFormerOrigin deletes the origin of a removed link if it's a date node
+// left without children, or updates its status otherwise.
+func (s *state) updateFormerOrigin(originID int64) error {
+	origin := s.graph(originID)
+	if origin.IsDateNode() && len(origin.Children()) == 0 {
+		return s.deleteNode(originID)
+	}
+	s.backpropCompletion(origin)
+	return nil
+}
+
+// moveNode replaces the link from fromID to nodeID with a link from toID. The
+// new link is validated against the graph without the old one, and the old
+// parent is updated only once the node is in place.
+func (s *state) moveNode(nodeID, fromID, toID int64) error {
+	link := s.getLinkByEndpoints(fromID, nodeID)
+	if link == nil {
+		return fmt.Errorf("link (%d) -> (%d) does not exist", fromID, nodeID)
+	}
+	s.deleteLink(link.ID)
+	if _, err := s.createLink(toID, nodeID); err != nil {
+		return err
+	}
+	return s.updateFormerOrigin(fromID)
+}
+
+// MoveNode atomically moves the node from one parent to another.
+func (m *Store) MoveNode(nodeID, fromID, toID int64) error {
+	return m.update(func(s *state) error {
+		return s.moveNode(nodeID, fromID, toID)
+	})
+}
+
+// MoveNodeToDateNode atomically moves the node from its parent to the date
+// node. Date node is automatically created if it doesn't exist.
+func (m *Store) MoveNodeToDateNode(nodeID, fromID int64, date string) error {
+	if err := multitree.ValidateDateNodeName(date); err != nil {
+		panic(err)
+	}
+	return m.update(func(s *state) error {
+		toID, err := s.createDateNodeIfNotExists(date)
+		if err != nil {
+			return err
+		}
+		return s.moveNode(nodeID, fromID, toID)
+	})
+}
@@ -0,0 +1,263 @@
+// Package memstore implements an in-memory store, following the same rules as
+// the SQLite database. It doesn't depend on cgo, which makes it suitable for
+// embedding grit and for testing the business logic.
+package memstore
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/climech/grit/multitree"
+	"github.com/climech/grit/store"
+)
+
+var _ store.Store = (*Store)(nil)
+
+// state holds the nodes and links, much like the tables of the database.
+type state struct {
+	// nodes are stored without their links.
+	nodes map[int64]*multitree.Node
+	links map[int64]*multitree.Link
+
+	// out and in map node IDs to the IDs of their outgoing and incoming links.
+	out map[int64][]int64
+	in  map[int64][]int64
+
+	lastNodeID int64
+	lastLinkID int64
+}
+
+func newState() *state {
+	return &state{
+		nodes: make(map[int64]*multitree.Node),
+		links: make(map[int64]*multitree.Link),
+		out:   make(map[int64][]int64),
+		in:    make(map[int64][]int64),
+	}
+}
+
+func (s *state) clone() *state {
+	c := newState()
+	for id, n := range s.nodes {
+		c.nodes[id] = n.Copy()
+	}
+	for id, l := range s.links {
+		link := *l
+		c.links[id] = &link
+	}
+	for id, ids := range s.out {
+		c.out[id] = append([]int64{}, ids...)
+	}
+	for id, ids := range s.in {
+		c.in[id] = append([]int64{}, ids...)
+	}
+	c.lastNodeID = s.lastNodeID
+	c.lastLinkID = s.lastLinkID
+	return c
+}
+
+// Store is an in-memory implementation of store.Store. It's safe for
+// concurrent use.
+type Store struct {
+	mu     sync.RWMutex
+	st     *state
+	commit func(*store.Dump) error
+}
+
+// New returns an empty store.
+func New() *Store {
+	return &Store{st: newState()}
+}
+
+func (m *Store) Close() error {
+	return nil
+}
+
+func (m *Store) view(f func(*state) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return f(m.st)
+}
+
+// SetCommitHook sets a function that's called with a snapshot of the store
+// after each successful update, before the update is made visible. If the
+// function returns an error, the update is discarded. This lets other stores
+// persist the state, e.g. in files.
+func (m *Store) SetCommitHook(f func(*store.Dump) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commit = f
+}
+
+// update runs f on a copy of the state, which replaces the current state only
+// if f succeeds. This makes each update atomic, like a database transaction.
+func (m *Store) update(f func(*state) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := m.st.clone()
+	if err := f(st); err != nil {
+		return err
+	}
+	if m.commit != nil {
+		if err := m.commit(st.export()); err != nil {
+			return err
+		}
+	}
+	m.st = st
+	return nil
+}
+
+func sortedIDs(ids []int64) []int64 {
+	sorted := append([]int64{}, ids...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+func (s *state) getNode(id int64) *multitree.Node {
+	if n, ok := s.nodes[id]; ok {
+		return n.Copy()
+	}
+	return nil
+}
+
+// find returns a copy of the first node, by ID, that satisfies the predicate.
+func (s *state) find(pred func(*multitree.Node) bool) *multitree.Node {
+	var found *multitree.Node
+	for _, n := range s.nodes {
+		if pred(n) && (found == nil || n.ID < found.ID) {
+			found = n
+		}
+	}
+	if found == nil {
+		return nil
+	}
+	return found.Copy()
+}
+
+func (s *state) getNodeByName(name string) *multitree.Node {
+	return s.find(func(n *multitree.Node) bool { return n.Name == name })
+}
+
+func (s *state) getNodeByAlias(alias string) *multitree.Node {
+	return s.find(func(n *multitree.Node) bool { return n.Alias == alias })
+}
+
+// graph builds the multitree that the node belongs to, and returns the node as
+// its member, or nil if the node doesn't exist. The links are added in the
+// same order as in the database.
+func (s *state) graph(id int64) *multitree.Node {
+	if _, ok := s.nodes[id]; !ok {
+		return nil
+	}
+
+	nodes := map[int64]*multitree.Node{id: s.nodes[id].Copy()}
+	queue := []int64{id}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		var adjacent []int64
+		for _, lid := range s.out[cur] {
+			adjacent = append(adjacent, s.links[lid].DestID)
+		}
+		for _, lid := range s.in[cur] {
+			adjacent = append(adjacent, s.links[lid].OriginID)
+		}
+		for _, a := range adjacent {
+			if _, ok := nodes[a]; !ok {
+				nodes[a] = s.nodes[a].Copy()
+				queue = append(queue, a)
+			}
+		}
+	}
+
+	var ids []int64
+	for nid := range nodes {
+		ids = append(ids, nid)
+	}
+	for _, nid := range sortedIDs(ids) {
+		for _, lid := range sortedIDs(s.out[nid]) {
+			multitree.LinkNodesUnchecked(nodes[nid], nodes[s.links[lid].DestID])
+		}
+	}
+
+	return nodes[id]
+}
+
+func (s *state) getRoots() []*multitree.Node {
+	var ids []int64
+	for id := range s.nodes {
+		if len(s.in[id]) == 0 {
+			ids = append(ids, id)
+		}
+	}
+	var roots []*multitree.Node
+	for _, id := range sortedIDs(ids) {
+		roots = append(roots, s.nodes[id].Copy())
+	}
+	return roots
+}
+
+// GetNode returns the node with the given id, or nil if it doesn't exist.
+func (m *Store) GetNode(id int64) (*multitree.Node, error) {
+	var node *multitree.Node
+	err := m.view(func(s *state) error {
+		node = s.getNode(id)
+		return nil
+	})
+	return node, err
+}
+
+// GetNodeByName returns the node with the given name, or nil if it doesn't
+// exist.
+func (m *Store) GetNodeByName(name string) (*multitree.Node, error) {
+	var node *multitree.Node
+	err := m.view(func(s *state) error {
+		node = s.getNodeByName(name)
+		return nil
+	})
+	return node, err
+}
+
+// GetNodeByAlias returns the node with the given alias, or nil if it doesn't
+// exist.
+func (m *Store) GetNodeByAlias(alias string) (*multitree.Node, error) {
+	var node *multitree.Node
+	err := m.view(func(s *state) error {
+		node = s.getNodeByAlias(alias)
+		return nil
+	})
+	return node, err
+}
+
+// GetRoots returns a slice of nodes that have no predecessors.
+func (m *Store) GetRoots() ([]*multitree.Node, error) {
+	var roots []*multitree.Node
+	err := m.view(func(s *state) error {
+		roots = s.getRoots()
+		return nil
+	})
+	return roots, err
+}
+
+// GetGraph loads the multitree that the node belongs to, and returns the
+// requested node as its member.
+func (m *Store) GetGraph(nodeID int64) (*multitree.Node, error) {
+	var node *multitree.Node
+	err := m.view(func(s *state) error {
+		node = s.graph(nodeID)
+		return nil
+	})
+	return node, err
+}
+
+func (m *Store) GetLink(linkID int64) (*multitree.Link, error) {
+	var link *multitree.Link
+	err := m.view(func(s *state) error {
+		if l, ok := s.links[linkID]; ok {
+			cp := *l
+			link = &cp
+		}
+		return nil
+	})
+	return link, err
+}
@@ -0,0 +1,78 @@
+package memstore
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/climech/grit/multitree"
+)
+
+// TestAtomicity fails if a failed operation leaves any changes behind.
+func TestAtomicity(t *testing.T) {
+	m := New()
+
+	// The root is inserted before the link to the missing parent fails.
+	root := multitree.NewNode("root")
+	root.ID = 1
+	multitree.LinkNodes(root, root.New("child"))
+	if _, err := m.CreateTree(root, 42); err == nil {
+		t.Fatal("tree created under a missing parent and no error returned")
+	}
+
+	dump, _ := m.Export()
+	if len(dump.Nodes) != 0 || len(dump.Links) != 0 {
+		t.Errorf("got %d nodes and %d links after a failed operation, want 0",
+			len(dump.Nodes), len(dump.Links))
+	}
+}
+
+// TestDateNodes fails if date nodes aren't created and deleted automatically.
+func TestDateNodes(t *testing.T) {
+	m := New()
+
+	id, err := m.CreateChildOfDateNode("2021-01-01", "test")
+	if err != nil {
+		t.Fatalf("couldn't create node: %v", err)
+	}
+	dateNode, _ := m.GetNodeByName("2021-01-01")
+	if dateNode == nil {
+		t.Fatal("date node wasn't created")
+	}
+	if _, err := m.DeleteNode(id); err != nil {
+		t.Fatalf("couldn't delete node: %v", err)
+	}
+	if dateNode, _ := m.GetNode(dateNode.ID); dateNode != nil {
+		t.Error("empty date node wasn't deleted")
+	}
+}
+
+// TestExportImport fails if a dump isn't restored exactly into an empty store.
+func TestExportImport(t *testing.T) {
+	m := New()
+
+	rootID, _ := m.CreateNode("root", 0)
+	childID, _ := m.CreateNode("child", rootID)
+	m.CreateNode("other", rootID)
+	m.CheckNode(childID)
+	m.SetAlias(rootID, "r")
+
+	dump, err := m.Export()
+	if err != nil {
+		t.Fatalf("couldn't export: %v", err)
+	}
+
+	restored := New()
+	if _, err := restored.Import(dump); err != nil {
+		t.Fatalf("couldn't import: %v", err)
+	}
+	got, _ := restored.Export()
+	if !reflect.DeepEqual(got, dump) {
+		t.Errorf("restored dump differs from the original")
+	}
+
+	// New IDs shouldn't collide with the restored ones.
+	id, _ := restored.CreateNode("new", 0)
+	if id <= childID {
+		t.Errorf("got ID %d for a new node, want > %d", id, childID)
+	}
+}
@@ -0,0 +1,411 @@
+package memstore
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/climech/grit/multitree"
+	"github.com/climech/grit/store"
+)
+
+func copyCompletion(value *int64) *int64 {
+	if value == nil {
+		return nil
+	}
+	cp := *value
+	return &cp
+}
+
+func (s *state) insertNode(node *multitree.Node) int64 {
+	if node.ID == 0 {
+		s.lastNodeID++
+		node.ID = s.lastNodeID
+	} else if node.ID > s.lastNodeID {
+		s.lastNodeID = node.ID
+	}
+	s.nodes[node.ID] = node
+	return node.ID
+}
+
+// deleteNode deletes the node along with its links.
+func (s *state) deleteNode(id int64) error {
+	if _, ok := s.nodes[id]; !ok {
+		return fmt.Errorf("node does not exist")
+	}
+	links := append(append([]int64{}, s.out[id]...), s.in[id]...)
+	for _, lid := range links {
+		s.deleteLink(lid)
+	}
+	delete(s.nodes, id)
+	delete(s.out, id)
+	delete(s.in, id)
+	return nil
+}
+
+func (s *state) setCompleted(id int64, value *int64) {
+	if n, ok := s.nodes[id]; ok {
+		n.Completed = copyCompletion(value)
+	}
+}
+
+// backpropCompletion saves the status changes implied by the leaves below the
+// node. See multitree.BackpropCompletion.
+func (s *state) backpropCompletion(node *multitree.Node) {
+	for _, n := range multitree.BackpropCompletion(node) {
+		s.setCompleted(n.ID, n.Completed)
+	}
+}
+
+func (s *state) createNode(name string, parentID int64) (int64, error) {
+	node := multitree.NewNode(name)
+	node.Created = time.Now().Unix()
+	id := s.insertNode(node)
+	if parentID != 0 {
+		if _, err := s.createLink(parentID, id); err != nil {
+			return 0, err
+		}
+		s.backpropCompletion(s.graph(id))
+	}
+	return id, nil
+}
+
+func (s *state) createDateNodeIfNotExists(date string) (int64, error) {
+	if err := multitree.ValidateDateNodeName(date); err != nil {
+		panic(err)
+	}
+	if node := s.getNodeByName(date); node != nil {
+		return node.ID, nil
+	}
+	return s.createNode(date, 0)
+}
+
+func (s *state) createTree(node *multitree.Node, parentID int64) (int64, error) {
+	tree := node.Tree()
+	var retErr error
+
+	tree.TraverseDescendants(func(current *multitree.Node, stop func()) {
+		pid := parentID
+		parents := current.Parents()
+		if len(parents) > 0 {
+			pid = parents[0].ID
+		}
+		id, err := s.createNode(current.Name, pid)
+		if err != nil {
+			retErr = err
+			stop()
+		} else {
+			s.nodes[id].Notes = current.Notes
+			s.nodes[id].Due = current.Due
+			s.nodes[id].Estimate = current.Estimate
+			s.nodes[id].Completed = copyCompletion(current.Completed)
+			s.nodes[id].Cancelled = copyCompletion(current.Cancelled)
+			current.ID = id
+		}
+	})
+
+	if retErr != nil {
+		return 0, retErr
+	}
+
+	// Update the ancestors, if any, along with the nodes whose status is implied
+	// by the saved leaves.
+	s.backpropCompletion(s.graph(tree.ID))
+
+	multitree.CopyIDs(node, tree)
+	return tree.ID, nil
+}
+
+// CreateNode creates a node and returns its ID. It updates the status of
+// other nodes in the multitree if needed.
+func (m *Store) CreateNode(name string, parentID int64) (int64, error) {
+	var id int64
+	err := m.update(func(s *state) (err error) {
+		id, err = s.createNode(name, parentID)
+		return err
+	})
+	return id, err
+}
+
+// CreateChildOfDateNode atomically creates a node and links the date node to
+// it. Date node is created if it doesn't exist.
+func (m *Store) CreateChildOfDateNode(date, name string) (int64, error) {
+	var id int64
+	err := m.update(func(s *state) error {
+		dateNodeID, err := s.createDateNodeIfNotExists(date)
+		if err != nil {
+			return err
+		}
+		id, err = s.createNode(name, dateNodeID)
+		return err
+	})
+	return id, err
+}
+
+// createSharedLinks links the nodes of a newly created tree to the existing
+// nodes.
+func (s *state) createSharedLinks(links []store.SharedLink) error {
+	for _, l := range links {
+		if _, err := s.createLink(l.Origin.ID, l.DestID); err != nil {
+			return fmt.Errorf("link (%d) -> (%d): %v", l.Origin.ID, l.DestID, err)
+		}
+	}
+	return nil
+}
+
+// CreateTree saves an entire tree, along with its links to existing nodes, and
+// returns the root ID. It updates the status of other nodes in the multitree
+// to reflect the change.
+func (m *Store) CreateTree(node *multitree.Node, parentID int64, links ...store.SharedLink) (int64, error) {
+	var id int64
+	err := m.update(func(s *state) (err error) {
+		if id, err = s.createTree(node, parentID); err != nil {
+			return err
+		}
+		return s.createSharedLinks(links)
+	})
+	return id, err
+}
+
+// CreateTreeAsChildOfDateNode atomically creates a tree and links the date node
+// to its root. Date node is created if it doesn't exist.
+func (m *Store) CreateTreeAsChildOfDateNode(date string, node *multitree.Node, links ...store.SharedLink) (int64, error) {
+	var id int64
+	err := m.update(func(s *state) error {
+		dateNodeID, err := s.createDateNodeIfNotExists(date)
+		if err != nil {
+			return err
+		}
+		if id, err = s.createTree(node, dateNodeID); err != nil {
+			return err
+		}
+		return s.createSharedLinks(links)
+	})
+	return id, err
+}
+
+// checkNode sets the completion time of the node and its descendants to value,
+// or unchecks them if value is nil.
+func (m *Store) checkNode(nodeID int64, value *int64) error {
+	check := value != nil
+
+	return m.update(func(s *state) error {
+		node := s.graph(nodeID)
+		if node == nil {
+			return fmt.Errorf("node does not exist")
+		}
+		for _, n := range append([]*multitree.Node{node}, node.Descendants()...) {
+			// The cancelled successors are left as they are.
+			if n != node && n.IsCancelled() {
+				continue
+			}
+			s.setCompleted(n.ID, value)
+			n.Completed = copyCompletion(value)
+			if check {
+				// Checked nodes are no longer cancelled.
+				s.nodes[n.ID].Cancelled = nil
+				n.Cancelled = nil
+			}
+		}
+		s.backpropCompletion(node)
+		return nil
+	})
+}
+
+// CheckNode marks the node as completed, along with all its direct and indirect
+// successors. The rest of the multitree is updated to reflect the change.
+func (m *Store) CheckNode(nodeID int64) error {
+	return m.CheckNodeAt(nodeID, time.Now().Unix())
+}
+
+// CheckNodeAt is like CheckNode, but the nodes are completed at t, a Unix
+// timestamp, instead of now.
+func (m *Store) CheckNodeAt(nodeID int64, t int64) error {
+	return m.checkNode(nodeID, &t)
+}
+
+// UncheckNode sets the node's status to inactive, along with all its direct
+// and indirect successors. The rest of the multitree is updated to reflect the
+// change.
+func (m *Store) UncheckNode(nodeID int64) error {
+	return m.checkNode(nodeID, nil)
+}
+
+func (m *Store) cancelNode(nodeID int64, cancel bool) error {
+	var value *int64
+	if cancel {
+		now := time.Now().Unix()
+		value = &now
+	}
+
+	return m.update(func(s *state) error {
+		node := s.graph(nodeID)
+		if node == nil {
+			return fmt.Errorf("node does not exist")
+		}
+		if cancel && node.IsCompleted() {
+			return fmt.Errorf("completed nodes cannot be cancelled")
+		}
+		for _, n := range append([]*multitree.Node{node}, node.Descendants()...) {
+			// Update the successors that are still unresolved, or were cancelled.
+			if n != node && ((cancel && n.IsResolved()) || (!cancel && !n.IsCancelled())) {
+				continue
+			}
+			s.nodes[n.ID].Cancelled = copyCompletion(value)
+			n.Cancelled = copyCompletion(value)
+		}
+		s.backpropCompletion(node)
+		return nil
+	})
+}
+
+// CancelNode marks the node as cancelled, along with its direct and indirect
+// successors that aren't resolved yet. The rest of the multitree is updated to
+// reflect the change.
+func (m *Store) CancelNode(nodeID int64) error {
+	return m.cancelNode(nodeID, true)
+}
+
+// UncancelNode reverts the cancellation of the node and its direct and
+// indirect successors. The rest of the multitree is updated to reflect the
+// change.
+func (m *Store) UncancelNode(nodeID int64) error {
+	return m.cancelNode(nodeID, false)
+}
+
+func (m *Store) RenameNode(nodeID int64, name string) error {
+	return m.update(func(s *state) error {
+		n, ok := s.nodes[nodeID]
+		if !ok {
+			return fmt.Errorf("not found")
+		}
+		n.Name = name
+		return nil
+	})
+}
+
+func (m *Store) SetAlias(nodeID int64, alias string) error {
+	return m.update(func(s *state) error {
+		n, ok := s.nodes[nodeID]
+		if !ok {
+			return fmt.Errorf("node does not exist")
+		}
+		if alias != "" {
+			if other := s.getNodeByAlias(alias); other != nil && other.ID != nodeID {
+				return store.ErrAliasExists
+			}
+		}
+		n.Alias = alias
+		return nil
+	})
+}
+
+// SetNotes replaces the node's notes, or removes them if notes is empty.
+func (m *Store) SetNotes(nodeID int64, notes string) error {
+	return m.update(func(s *state) error {
+		n, ok := s.nodes[nodeID]
+		if !ok {
+			return fmt.Errorf("node does not exist")
+		}
+		n.Notes = notes
+		return nil
+	})
+}
+
+// SetDue sets the node's due date, or removes it if due is empty.
+func (m *Store) SetDue(nodeID int64, due string) error {
+	return m.update(func(s *state) error {
+		n, ok := s.nodes[nodeID]
+		if !ok {
+			return fmt.Errorf("node does not exist")
+		}
+		n.Due = due
+		return nil
+	})
+}
+
+// SetEstimate sets the node's effort estimate, or removes it if estimate is
+// zero.
+func (m *Store) SetEstimate(nodeID int64, estimate float64) error {
+	return m.update(func(s *state) error {
+		n, ok := s.nodes[nodeID]
+		if !ok {
+			return fmt.Errorf("node does not exist")
+		}
+		n.Estimate = estimate
+		return nil
+	})
+}
+
+// DeleteNode deletes a single node and propagates the change to the rest of the
+// multitree. Date nodes left empty are deleted as well. It returns the node's
+// orphaned successors.
+func (m *Store) DeleteNode(id int64) ([]*multitree.Node, error) {
+	var orphans []*multitree.Node
+
+	err := m.update(func(s *state) error {
+		node := s.graph(id)
+		if node == nil {
+			return fmt.Errorf("node does not exist")
+		}
+		if err := s.deleteNode(id); err != nil {
+			return err
+		}
+
+		// Auto-delete any empty date nodes.
+		for _, dn := range node.Parents() {
+			if dn.IsDateNode() && len(dn.Children()) == 1 {
+				if err := s.deleteNode(dn.ID); err != nil {
+					return err
+				}
+				// Unlink to ignore in backprop.
+				if err := multitree.UnlinkNodes(dn, node); err != nil {
+					panic(err)
+				}
+			}
+		}
+
+		s.backpropCompletion(node)
+		orphans = node.Children()
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return orphans, nil
+}
+
+// DeleteNodeRecursive deletes the tree rooted at the given node and updates the
+// multitree. Nodes that have parents outside of this tree are preserved. It
+// returns a slice of all deleted nodes.
+func (m *Store) DeleteNodeRecursive(id int64) ([]*multitree.Node, error) {
+	var deleted []*multitree.Node
+
+	err := m.update(func(s *state) error {
+		node := s.graph(id)
+		if node == nil {
+			return fmt.Errorf("node does not exist")
+		}
+		if err := s.deleteNode(id); err != nil {
+			return err
+		}
+		deleted = []*multitree.Node{node}
+
+		for _, d := range node.Descendants() {
+			if len(d.Parents()) == 1 {
+				if err := s.deleteNode(d.ID); err != nil {
+					return err
+				}
+				deleted = append(deleted, d)
+			}
+		}
+
+		s.backpropCompletion(node)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
@@ -0,0 +1,97 @@
+package multitree
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HoursPerDay is the number of hours in a day of work, used to convert
+// estimates given in days.
+const HoursPerDay = 8
+
+// ParseEstimate parses an effort estimate given in points, e.g. "3" or "0.5",
+// or as a duration, e.g. "90m", "2h" or "1.5d". Durations are converted to
+// points at one point per hour.
+func ParseEstimate(s string) (float64, error) {
+	var points float64
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		points = v
+	} else if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid estimate: %s", s)
+		}
+		points = days * HoursPerDay
+	} else if d, err := time.ParseDuration(s); err == nil {
+		points = d.Hours()
+	} else {
+		return 0, fmt.Errorf("invalid estimate: %s", s)
+	}
+	if points <= 0 || math.IsNaN(points) || math.IsInf(points, 0) {
+		return 0, fmt.Errorf("estimate must be positive")
+	}
+	return points, nil
+}
+
+// FormatEstimate formats an estimate in points, e.g. "2.5".
+func FormatEstimate(points float64) string {
+	return strconv.FormatFloat(points, 'f', -1, 64)
+}
+
+// Effort returns the total effort of the task and the effort completed so far,
+// in points. A task's own estimate takes precedence; otherwise, it's the sum
+// of its children's efforts, with leaves counting as 1 point. The completed
+// effort of an estimated task that isn't completed is its estimate scaled by
+// the completed fraction of its children. Cancelled tasks don't count.
+//
+// The descendants of a node always form a tree, so no node is counted twice.
+func (n *Node) Effort() (total, done float64) {
+	if n.IsCancelled() {
+		return 0, 0
+	}
+	if len(n.children) == 0 {
+		total = n.Estimate
+		if total == 0 {
+			total = 1
+		}
+		if n.IsCompleted() {
+			done = total
+		}
+		return total, done
+	}
+
+	var childTotal, childDone float64
+	for _, c := range n.children {
+		t, d := c.Effort()
+		childTotal += t
+		childDone += d
+	}
+	total, done = childTotal, childDone
+	if n.Estimate != 0 {
+		total = n.Estimate
+		done = 0
+		if childTotal != 0 {
+			done = total * childDone / childTotal
+		}
+	}
+	if n.IsCompleted() {
+		done = total
+	}
+	return total, done
+}
+
+// Progress returns the completed fraction of the task's effort, from 0 to 1.
+// It's 1 for completed tasks with no effort left to count.
+func (n *Node) Progress() float64 {
+	total, done := n.Effort()
+	if total == 0 {
+		if n.IsCompleted() {
+			return 1
+		}
+		return 0
+	}
+	return done / total
+}
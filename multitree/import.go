@@ -4,10 +4,14 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"strings"
 )
 
 // ImportTrees reads a sequence of tab-indented lines and builds trees out of
-// them. It returns pointers to the roots.
+// them. Lines starting with ">" are appended to the notes of the preceding
+// node, and must be indented under it. A name starting with ">" is escaped
+// with a backslash, as is one starting with a backslash followed by ">" or
+// another backslash. It returns pointers to the roots.
 func ImportTrees(reader io.Reader) ([]*Node, error) {
 	type stackItem struct {
 		indent int
@@ -28,6 +32,26 @@ func ImportTrees(reader io.Reader) ([]*Node, error) {
 			continue
 		}
 
+		if strings.HasPrefix(name, ">") {
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("line %d: note doesn't follow a node", lineNum)
+			}
+			top := stack[len(stack)-1]
+			if indent <= top.indent {
+				return nil, fmt.Errorf("line %d: note isn't indented under its "+
+					"node (escape names starting with \">\" as \"\\>\")", lineNum)
+			}
+			node := top.node
+			line := strings.TrimPrefix(name[1:], " ")
+			if node.Notes != "" {
+				node.Notes += "\n"
+			}
+			node.Notes += line
+			lineNum++
+			continue
+		}
+
+		name = unescapeImportName(name)
 		if err := ValidateNodeName(name); err != nil {
 			return nil, fmt.Errorf("line %d: %v", lineNum, err)
 		}
@@ -70,3 +94,46 @@ func parseImportLine(line string) (int, string) {
 	}
 	return indent, line[indent:]
 }
+
+// unescapeImportName removes the backslash that escapes a name starting with
+// ">" or a backslash.
+func unescapeImportName(name string) string {
+	if len(name) > 1 && name[0] == '\\' && (name[1] == '>' || name[1] == '\\') {
+		return name[1:]
+	}
+	return name
+}
+
+// escapeImportName escapes the name so that ImportTrees doesn't take it for a
+// note, nor strip its leading backslash.
+func escapeImportName(name string) string {
+	if strings.HasPrefix(name, ">") || strings.HasPrefix(name, "\\") {
+		return "\\" + name
+	}
+	return name
+}
+
+// FormatTree returns the tree rooted at the node as tab-indented lines, which
+// ImportTrees reads back. Only the names and notes are kept.
+func FormatTree(root *Node) string {
+	var sb strings.Builder
+	var format func(*Node, int)
+	format = func(n *Node, depth int) {
+		sb.WriteString(strings.Repeat("\t", depth) + escapeImportName(n.Name) + "\n")
+		if n.Notes != "" {
+			indent := strings.Repeat("\t", depth+1)
+			for _, line := range strings.Split(n.Notes, "\n") {
+				if line == "" {
+					sb.WriteString(indent + ">\n")
+				} else {
+					sb.WriteString(indent + "> " + line + "\n")
+				}
+			}
+		}
+		for _, c := range n.children {
+			format(c, depth+1)
+		}
+	}
+	format(root, 0)
+	return sb.String()
+}
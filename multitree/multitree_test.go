@@ -146,6 +146,35 @@ func TestRoots(t *testing.T) {
 	}
 }
 
+func TestPaths(t *testing.T) {
+	//
+	//     (1)
+	//     / \
+	//   (2) (3)  (4)
+	//       / \  / \
+	//     (5) (6)  (7)
+	//
+	var nodes []*Node
+	for i := 0; i < 7; i++ {
+		nodes = append(nodes, newTestNode(int64(i+1)))
+	}
+	_ = LinkNodes(nodes[0], nodes[1])
+	_ = LinkNodes(nodes[0], nodes[2])
+	_ = LinkNodes(nodes[2], nodes[4])
+	_ = LinkNodes(nodes[2], nodes[5])
+	_ = LinkNodes(nodes[3], nodes[5])
+	_ = LinkNodes(nodes[3], nodes[6])
+
+	var got []string
+	for _, path := range nodes[5].Paths() {
+		got = append(got, sprintfIDs(path))
+	}
+	want := []string{"[1, 3, 6]", "[4, 6]"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid paths; want %v, got %v", want, got)
+	}
+}
+
 func TestLeaves(t *testing.T) {
 	//
 	//     (0)
@@ -237,6 +266,48 @@ func TestTreeString(t *testing.T) {
 	}
 }
 
+func TestDeadline(t *testing.T) {
+	// Create the multitree:
+	//
+	//   (1) ──┬── (3) ──── (4)
+	//   (2) ──┘
+	//
+	var nodes []*Node
+	for i := 0; i < 4; i++ {
+		nodes = append(nodes, newTestNode(int64(i+1)))
+	}
+	linkOrFail(t, nodes[0], nodes[2])
+	linkOrFail(t, nodes[1], nodes[2])
+	linkOrFail(t, nodes[2], nodes[3])
+
+	if d := nodes[3].DeadlineNode(); d != nil {
+		t.Errorf("got deadline from (%d), want none", d.ID)
+	}
+	nodes[0].Due = "2020-01-02"
+	nodes[1].Due = "2020-01-01"
+	if d := nodes[3].DeadlineNode(); d != nodes[1] {
+		t.Errorf("deadline not inherited from the earliest ancestor")
+	}
+	nodes[2].Due = "2020-02-01"
+	if got := nodes[3].Deadline(); got != "2020-02-01" {
+		t.Errorf("got deadline %s, want the nearest ancestor's", got)
+	}
+	if !nodes[3].IsOverdue("2020-02-02") || nodes[3].IsOverdue("2020-02-01") {
+		t.Errorf("node is overdue on the wrong days")
+	}
+
+	// Inherited deadlines are only shown if they come from outside the view.
+	want := strings.TrimSpace(`
+[ ] test (3) due 2020-02-01
+ └──[ ] test (4)`)
+	if got := strings.TrimSpace(nodes[2].StringTree()); got != want {
+		t.Errorf("\n\nwant:\n\n%s\n\ngot:\n\n%s\n\n", want, got)
+	}
+	if got := strings.TrimSpace(nodes[3].StringTree()); got != "[ ] test (4) due 2020-02-01" {
+		t.Errorf("inherited deadline not shown: %s", got)
+	}
+}
+
 func TestImportTrees(t *testing.T) {
 	want := []string{
 		`[ ] test (1)`,
@@ -294,5 +365,186 @@ test
 	testStringInput(inputTabs)
 	testStringInput(inputSpaces)
 
+	roots, err := ImportTrees(strings.NewReader("test\n\t> line 1\n\t>\n" +
+		"\ttest\n\t\t> line 2\n"))
+	if err != nil {
+		t.Fatalf("error importing trees: %v", err)
+	}
+	if got := roots[0].Notes; got != "line 1\n" {
+		t.Errorf("got root notes %q, want %q", got, "line 1\n")
+	}
+	if got := roots[0].Children()[0].Notes; got != "line 2" {
+		t.Errorf("got child notes %q, want %q", got, "line 2")
+	}
+	if _, err := ImportTrees(strings.NewReader("> orphan note\n")); err == nil {
+		t.Errorf("note without a node imported")
+	}
+	for _, input := range []string{"test\n> note\n", "test\n\ttest\n\t\ttest\n\t> note\n"} {
+		if _, err := ImportTrees(strings.NewReader(input)); err == nil {
+			t.Errorf("%q: note not indented under its node imported", input)
+		}
+	}
+
+	// FormatTree should give back the same trees.
+	formatted := FormatTree(roots[0])
+	reimported, err := ImportTrees(strings.NewReader(formatted))
+	if err != nil {
+		t.Fatalf("error importing formatted tree: %v", err)
+	}
+	if got := FormatTree(reimported[0]); got != formatted {
+		t.Errorf("got %q after formatting twice, want %q", got, formatted)
+	}
+	if got := reimported[0].Notes; got != "line 1\n" {
+		t.Errorf("got reimported notes %q, want %q", got, "line 1\n")
+	}
+
+	// Names starting with ">" or a backslash are escaped.
+	escaped, err := ImportTrees(strings.NewReader("\\>test\n\t\\\\test\n\t\\test\n"))
+	if err != nil {
+		t.Fatalf("error importing trees: %v", err)
+	}
+	names := []string{escaped[0].Name}
+	for _, c := range escaped[0].Children() {
+		names = append(names, c.Name)
+	}
+	if want := []string{">test", "\\test", "\\test"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got names %q, want %q", names, want)
+	}
+	formatted = FormatTree(escaped[0])
+	if reimported, err := ImportTrees(strings.NewReader(formatted)); err != nil ||
+		FormatTree(reimported[0]) != formatted {
+		t.Errorf("escaped names weren't kept by FormatTree")
+	}
+
 	// TODO: mixing tabs and spaces should return an error.
 }
+
+func TestEffort(t *testing.T) {
+	// Create the multitree:
+	//
+	//   [~] test (1)
+	//    ├──[~] test (2) estimated at 4
+	//    │   ├──[x] test (3)
+	//    │   └──[ ] test (4)
+	//    └──[x] test (5) estimated at 2
+	//   [ ] test (6)
+	//    └──[ ] test (4)
+	//
+	var nodes []*Node
+	for i := 0; i < 6; i++ {
+		nodes = append(nodes, newTestNode(int64(i+1)))
+	}
+	linkOrFail(t, nodes[0], nodes[1])
+	linkOrFail(t, nodes[1], nodes[2])
+	linkOrFail(t, nodes[1], nodes[3])
+	linkOrFail(t, nodes[0], nodes[4])
+	linkOrFail(t, nodes[5], nodes[3])
+	nodes[1].Estimate = 4
+	nodes[4].Estimate = 2
+	var completed int64 = 1
+	nodes[2].Completed = &completed
+	nodes[4].Completed = &completed
+
+	tests := []struct {
+		node        *Node
+		total, done float64
+	}{
+		{nodes[0], 6, 4},
+		{nodes[1], 4, 2},
+		{nodes[3], 1, 0},
+		{nodes[5], 1, 0},
+	}
+	for _, test := range tests {
+		total, done := test.node.Effort()
+		if total != test.total || done != test.done {
+			t.Errorf("(%d): got effort %v/%v, want %v/%v", test.node.ID, done,
+				total, test.done, test.total)
+		}
+	}
+
+	want := strings.TrimSpace(`
+ 66% [~] test (1)
+ 50%  ├──[~] test (2)
+100%  │   ├──[x] test (3)
+  0%  │   └··[ ] test (4)
+100%  └──[x] test (5)`)
+	if got := strings.TrimSpace(nodes[0].StringTreeProgress()); got != strings.TrimSpace(want) {
+		t.Errorf("\n\nwant:\n\n%s\n\ngot:\n\n%s\n\n", want, got)
+	}
+}
+
+func TestParseEstimate(t *testing.T) {
+	valid := map[string]float64{"3": 3, "0.5": 0.5, "90m": 1.5, "2h": 2, "1.5d": 12}
+	for s, want := range valid {
+		if got, err := ParseEstimate(s); err != nil || got != want {
+			t.Errorf("%s: got %v (err: %v), want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "0", "-1", "NaN", "Inf", "soon", "2x"} {
+		if _, err := ParseEstimate(s); err == nil {
+			t.Errorf("%s: parsed and no error returned", s)
+		}
+	}
+}
+
+func TestCancelled(t *testing.T) {
+	// Create the multitree:
+	//
+	//   [ ] test (1)
+	//    ├──[ ] test (2)
+	//    └──[ ] test (3)
+	//        └──[ ] test (4)
+	//
+	var nodes []*Node
+	for i := 0; i < 4; i++ {
+		nodes = append(nodes, newTestNode(int64(i+1)))
+	}
+	linkOrFail(t, nodes[0], nodes[1])
+	linkOrFail(t, nodes[0], nodes[2])
+	linkOrFail(t, nodes[2], nodes[3])
+	var completed, cancelled int64 = 1, 2
+	nodes[1].Completed = &completed
+	nodes[3].Cancelled = &cancelled
+
+	// A cancelled node is resolved, but never completed itself.
+	nodes[2].Cancelled = &cancelled
+	BackpropCompletion(nodes[0])
+	if nodes[2].IsCompleted() || nodes[2].Status() != TaskStatusCancelled {
+		t.Errorf("cancelled node was completed")
+	}
+	// It takes the time its last child was resolved.
+	if !nodes[0].IsCompleted() || *nodes[0].Completed != cancelled {
+		t.Errorf("node with resolved children wasn't completed")
+	}
+	if total, done := nodes[0].Effort(); total != 1 || done != 1 {
+		t.Errorf("got effort %v/%v, want 1/1", done, total)
+	}
+
+	// A node with only cancelled children is completed.
+	nodes[2].Cancelled = nil
+	BackpropCompletion(nodes[0])
+	if !nodes[2].IsCompleted() || *nodes[2].Completed != cancelled {
+		t.Errorf("node with cancelled children wasn't completed")
+	}
+	if p := nodes[2].Progress(); p != 1 {
+		t.Errorf("got progress %v, want 1", p)
+	}
+	if c := nodes[3].checkbox(); c != "[-]" {
+		t.Errorf("got checkbox %s, want [-]", c)
+	}
+}
+
+func TestDateOf(t *testing.T) {
+	dates := map[string]string{
+		"2020-01-02 00:30": "2020-01-01",
+		"2020-01-02 03:59": "2020-01-01",
+		"2020-01-02 04:00": "2020-01-02",
+		"2020-01-02 23:59": "2020-01-02",
+	}
+	for s, want := range dates {
+		tm, _ := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+		if got := DateOf(tm); got != want {
+			t.Errorf("%s: got %s, want %s", s, got, want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package multitree
 
 import (
 	"fmt"
+	"sort"
 	"time"
 )
 
@@ -12,6 +13,17 @@ type Node struct {
 	// Alias is an optional secondary identifier of the node.
 	Alias string
 
+	// Notes is optional free-form text attached to the node.
+	Notes string
+
+	// Due is the date the task must be completed by, in the format
+	// "YYYY-MM-DD", or empty. See Deadline.
+	Due string
+
+	// Estimate is the effort the task is expected to take, in points, or zero
+	// if it hasn't been estimated. See Effort.
+	Estimate float64
+
 	// Created holds the Unix timestamp for the node's creation time.
 	Created int64
 
@@ -19,6 +31,10 @@ type Node struct {
 	// completed, or nil, if the node hasn't been completed yet.
 	Completed *int64
 
+	// Cancelled points to the Unix timestamp of when the task was abandoned,
+	// or nil, if it hasn't been. Cancelled tasks are never completed.
+	Cancelled *int64
+
 	parents  []*Node
 	children []*Node
 }
@@ -49,6 +65,43 @@ func (n *Node) IsCompleted() bool {
 	return n.Completed != nil
 }
 
+func (n *Node) IsCancelled() bool {
+	return n.Cancelled != nil
+}
+
+// IsResolved returns true if the node is either completed or cancelled.
+func (n *Node) IsResolved() bool {
+	return n.IsCompleted() || n.IsCancelled()
+}
+
+// DayOffset is the hour at which the days start, so that the tasks done past
+// midnight still count towards the previous day.
+// TODO: make start of day configurable.
+const DayOffset = 4
+
+// DayStart returns the time at which the day of the date ("YYYY-MM-DD")
+// starts in the local time zone, taking DayOffset into account.
+func DayStart(date string) (time.Time, error) {
+	t, err := time.ParseInLocation("2006-01-02", date, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.Add(time.Duration(DayOffset) * time.Hour), nil
+}
+
+// DateOf returns the date ("YYYY-MM-DD") of the day that t falls on, taking
+// DayOffset into account, e.g. 1 A.M. still belongs to the previous day.
+func DateOf(t time.Time) string {
+	return t.Add(-time.Duration(DayOffset) * time.Hour).Format("2006-01-02")
+}
+
+// Today returns the date ("YYYY-MM-DD") of the current day, taking DayOffset
+// into account, so that date nodes, completion dates and time reports agree on
+// which day it is.
+func Today() string {
+	return DateOf(time.Now())
+}
+
 // IsCompletedOnDate returns true if n was completed on date given as a string
 // in the format "YYYY-MM-DD". The start of day is determined by offset, e.g. if
 // offset is 4, the day starts at 4 A.M.
@@ -56,11 +109,11 @@ func (n *Node) IsCompletedOnDate(date string, offset int) bool {
 	t := n.TimeCompleted()
 
 	if !t.IsZero() {
-		start, err := time.Parse("2006-01-02", date)
+		start, err := time.ParseInLocation("2006-01-02", date, time.Local)
 		if err != nil {
 			panic(err)
 		}
-		start = start.Local().Add(time.Duration(offset) * time.Hour)
+		start = start.Add(time.Duration(offset) * time.Hour)
 		end := start.Add(24 * time.Hour)
 
 		if t.Equal(start) || (t.After(start) && t.Before(end)) {
@@ -84,7 +137,7 @@ func (n *Node) IsInProgress() bool {
 }
 
 func (n *Node) IsInactive() bool {
-	return !n.IsCompleted() && !n.IsInProgress()
+	return !n.IsResolved() && !n.IsInProgress()
 }
 
 func (n *Node) IsRoot() bool {
@@ -98,6 +151,39 @@ func (n *Node) IsDateNode() bool {
 	return false
 }
 
+// DeadlineNode returns the node whose due date applies to n, i.e. n itself if
+// it has one, or else the nearest ancestor that has one. If the ancestors on
+// different paths have different due dates, the earliest one applies. It
+// returns nil if there's no due date.
+func (n *Node) DeadlineNode() *Node {
+	if n.Due != "" {
+		return n
+	}
+	var nearest *Node
+	for _, p := range n.parents {
+		if d := p.DeadlineNode(); d != nil && (nearest == nil || d.Due < nearest.Due) {
+			nearest = d
+		}
+	}
+	return nearest
+}
+
+// Deadline returns the due date that applies to n, which may be inherited
+// from an ancestor, or an empty string if there's none.
+func (n *Node) Deadline() string {
+	if d := n.DeadlineNode(); d != nil {
+		return d.Due
+	}
+	return ""
+}
+
+// IsOverdue returns true if n isn't resolved and its deadline is before the
+// date, given in the format "YYYY-MM-DD".
+func (n *Node) IsOverdue(date string) bool {
+	deadline := n.Deadline()
+	return !n.IsResolved() && deadline != "" && deadline < date
+}
+
 // TimeCompleted returns the task completion time as local time.Time.
 func (n *Node) TimeCompleted() time.Time {
 	var t time.Time
@@ -178,6 +264,25 @@ func (n *Node) RootsAll() []*Node {
 	return roots
 }
 
+// Paths returns the paths leading from each of the node's local roots down to
+// the node. There's exactly one such path per root. The paths are sorted by
+// root ID in ascending order.
+func (n *Node) Paths() [][]*Node {
+	if n.IsRoot() {
+		return [][]*Node{{n}}
+	}
+	var paths [][]*Node
+	for _, p := range n.parents {
+		for _, path := range p.Paths() {
+			paths = append(paths, append(path, n))
+		}
+	}
+	sort.SliceStable(paths, func(i, j int) bool {
+		return paths[i][0].ID < paths[j][0].ID
+	})
+	return paths
+}
+
 // Roots returns the local roots found by following the node's descendants all
 // the way down. The nodes are sorted by ID in ascending order.
 func (n *Node) IsLeaf() bool {
@@ -230,14 +335,31 @@ func (n *Node) Tree() *Node {
 	return root
 }
 
+// CopyIDs sets the IDs of the nodes in the tree rooted at dst to the IDs of
+// the corresponding nodes in src, which must be a tree of the same shape, such
+// as a copy made with Tree.
+func CopyIDs(dst, src *Node) {
+	var ids []int64
+	src.TraverseDescendants(func(current *Node, _ func()) {
+		ids = append(ids, current.ID)
+	})
+	dst.TraverseDescendants(func(current *Node, _ func()) {
+		current.ID, ids = ids[0], ids[1:]
+	})
+}
+
 // Copy returns a shallow, unlinked copy of the node.
 func (n *Node) Copy() *Node {
 	return &Node{
 		ID:        n.ID,
 		Name:      n.Name,
 		Alias:     n.Alias,
+		Notes:     n.Notes,
+		Due:       n.Due,
+		Estimate:  n.Estimate,
 		Created:   n.Created,
 		Completed: copyCompletion(n.Completed),
+		Cancelled: copyCompletion(n.Cancelled),
 	}
 }
 
@@ -391,6 +513,14 @@ func LinkNodes(origin, dest *Node) error {
 	return nil
 }
 
+// LinkNodesUnchecked creates a directed link from origin to dest without
+// validating the resulting graph. It's meant for bulk-loading graphs, which can
+// then be validated as a whole with ValidateGraph.
+func LinkNodesUnchecked(origin, dest *Node) {
+	origin.children = append(origin.children, dest)
+	dest.parents = append(dest.parents, origin)
+}
+
 // LinkNodes removes an existing directed link between origin and dest. It
 // returns an error if the link doesn't exist.
 func UnlinkNodes(origin, dest *Node) error {
@@ -2,8 +2,8 @@ package multitree
 
 import (
 	"fmt"
+	"math"
 	"strings"
-	"time"
 	"unicode/utf8"
 
 	"github.com/fatih/color"
@@ -17,6 +17,8 @@ func (n *Node) checkbox() string {
 		return "[~]"
 	case TaskStatusInactive:
 		return "[ ]"
+	case TaskStatusCancelled:
+		return "[-]"
 	default:
 		panic("invalid node status")
 	}
@@ -35,7 +37,7 @@ func (n *Node) String() string {
 	// Change accent color for descendants of the current date node.
 	accent := color.New(color.FgCyan).SprintFunc()
 	for _, r := range n.Roots() {
-		if r.Name == time.Now().Format("2006-01-02") {
+		if r.Name == Today() {
 			accent = color.New(color.FgYellow).SprintFunc()
 			break
 		}
@@ -51,6 +53,16 @@ func (n *Node) String() string {
 	return fmt.Sprintf("%s %s %s", accent(n.checkbox()), name, accent(id))
 }
 
+// stringDeadline returns the node's deadline, e.g. "due 2020-11-20",
+// highlighted if it has passed.
+func (n *Node) stringDeadline() string {
+	s := "due " + n.Deadline()
+	if n.IsOverdue(Today()) {
+		s = color.New(color.FgRed).Sprint(s)
+	}
+	return s
+}
+
 const (
 	treeIndentBlank     = "    "
 	treeIndentExtend    = " │  "
@@ -69,10 +81,37 @@ const (
 //      └──[ ] ...
 //
 func (n *Node) StringTree() string {
+	return n.stringTree(false, nil)
+}
+
+// StringTreeProgress is like StringTree, but each line starts with the
+// completed percentage of the node's effort. See Effort.
+//
+//      25% [~] Clean up the house (234)
+//      33%  ├──[~] Clean up the bedroom (235)
+//     100%  │   ├──[x] Clean up the desk (236)
+//
+func (n *Node) StringTreeProgress() string {
+	return n.stringTree(true, nil)
+}
+
+// StringTreeFunc is like StringTree, or StringTreeProgress if progress is
+// true, but annotate is called for each node, and the string it returns, if
+// not empty, is appended to the node's line.
+func (n *Node) StringTreeFunc(progress bool, annotate func(*Node) string) string {
+	return n.stringTree(progress, annotate)
+}
+
+func (n *Node) stringTree(progress bool, annotate func(*Node) string) string {
 	var sb strings.Builder
 	var traverse func(*Node, []bool)
 	viewRoot := n.Tree().Roots()[0]
 
+	inView := map[*Node]bool{n: true}
+	for _, d := range n.Descendants() {
+		inView[d] = true
+	}
+
 	// The stack holds a boolean value for each of the node's indent levels. If
 	// the value is true, there are more siblings to come on that level, and the
 	// line should be extended or "split". Otherwise, the line should be
@@ -102,17 +141,29 @@ func (n *Node) StringTree() string {
 			}
 		}
 
+		if progress {
+			sb.WriteString(fmt.Sprintf("%3.0f%% ", math.Floor(n.Progress()*100)))
+		}
 		for _, i := range indents {
 			sb.WriteString(i)
 		}
 
 		// Change "[x]" to "[*]" when current view date != node's completion date.
-		// TODO: make start of day configurable.
 		nodeStr := n.String()
-		if viewRoot.IsDateNode() && !n.IsCompletedOnDate(viewRoot.Name, 4) {
+		if viewRoot.IsDateNode() && !n.IsCompletedOnDate(viewRoot.Name, DayOffset) {
 			nodeStr = strings.Replace(nodeStr, "[x]", "[*]", 1)
 		}
 
+		// Show the deadline unless it's inherited from a node in view.
+		if d := n.DeadlineNode(); d != nil && (d == n || !inView[d]) {
+			nodeStr += " " + n.stringDeadline()
+		}
+		if annotate != nil {
+			if a := annotate(n); a != "" {
+				nodeStr += " " + a
+			}
+		}
+
 		sb.WriteString(nodeStr)
 		sb.WriteString("\n")
 
@@ -6,6 +6,7 @@ const (
 	TaskStatusCompleted TaskStatus = iota
 	TaskStatusInProgress
 	TaskStatusInactive
+	TaskStatusCancelled
 )
 
 func (s TaskStatus) String() string {
@@ -16,6 +17,8 @@ func (s TaskStatus) String() string {
 		return "in progress"
 	case TaskStatusInactive:
 		return "inactive"
+	case TaskStatusCancelled:
+		return "cancelled"
 	default:
 		panic("invalid task status")
 	}
@@ -24,8 +27,64 @@ func (s TaskStatus) String() string {
 func (n *Node) Status() TaskStatus {
 	if n.IsCompleted() {
 		return TaskStatusCompleted
+	} else if n.IsCancelled() {
+		return TaskStatusCancelled
 	} else if n.IsInProgress() {
 		return TaskStatusInProgress
 	}
 	return TaskStatusInactive
 }
+
+// resolvedTime returns the time the node was completed or cancelled, or nil.
+func resolvedTime(n *Node) *int64 {
+	if n.Completed != nil {
+		return n.Completed
+	}
+	return n.Cancelled
+}
+
+// BackpropCompletion propagates the status of the leaves below the node up the
+// multitree, so that a node with children is completed if and only if all its
+// children are resolved, i.e. completed or cancelled, and the node itself isn't
+// cancelled. A newly completed node takes the latest completion (or
+// cancellation) time of its children, i.e. the time its last child was
+// resolved. It returns the nodes whose status was changed.
+func BackpropCompletion(node *Node) []*Node {
+	var changed []*Node
+	var backprop func(*Node)
+
+	backprop = func(n *Node) {
+		complete := !n.IsCancelled()
+		for _, c := range n.Children() {
+			if !c.IsResolved() {
+				complete = false
+				break
+			}
+		}
+		if n.IsCompleted() != complete {
+			if complete {
+				var latest *int64
+				for _, c := range n.Children() {
+					if t := resolvedTime(c); latest == nil || *t > *latest {
+						latest = t
+					}
+				}
+				n.Completed = copyCompletion(latest)
+			} else {
+				n.Completed = nil
+			}
+			changed = append(changed, n)
+		}
+		for _, p := range n.Parents() {
+			backprop(p)
+		}
+	}
+
+	for _, leaf := range node.Leaves() {
+		for _, p := range leaf.Parents() {
+			backprop(p)
+		}
+	}
+
+	return changed
+}
@@ -3,9 +3,13 @@ package multitree
 import (
 	"errors"
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
 )
 
+var propertyKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,50}$`)
+
 func ValidateNodeName(name string) error {
 	if ValidateDateNodeName(name) == nil {
 		return errors.New("name is reserved")
@@ -29,6 +33,14 @@ func ValidateDateNodeName(name string) error {
 	return nil
 }
 
+// ValidateDueDate checks if the string is a date in the format "YYYY-MM-DD".
+func ValidateDueDate(date string) error {
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		return fmt.Errorf("invalid due date: %s", date)
+	}
+	return nil
+}
+
 func ValidateNodeAlias(alias string) error {
 	// TODO
 	if len(alias) == 0 {
@@ -39,3 +51,38 @@ func ValidateNodeAlias(alias string) error {
 	}
 	return nil
 }
+
+// ValidatePropertyKey checks if the key is made of letters, digits, and the
+// characters "_", "." and "-", up to 50 characters long.
+func ValidatePropertyKey(key string) error {
+	if !propertyKeyRegexp.MatchString(key) {
+		return fmt.Errorf("invalid property key: %q", key)
+	}
+	return nil
+}
+
+// ValidatePropertyValue checks if the value is a single, non-empty line.
+func ValidatePropertyValue(value string) error {
+	if len(value) == 0 {
+		return errors.New("invalid property value (empty)")
+	}
+	if len(value) > 1000 {
+		return errors.New("invalid property value (too long)")
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return errors.New("invalid property value (multiple lines)")
+	}
+	return nil
+}
+
+// ValidateGraph checks if the graph that the node belongs to is a valid
+// multitree, i.e. it contains no cycles or diamonds.
+func ValidateGraph(n *Node) error {
+	if n.hasBackEdge() {
+		return errors.New("cycles are not allowed")
+	}
+	if n.hasDiamond() {
+		return errors.New("diamonds are not allowed")
+	}
+	return nil
+}
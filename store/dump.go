@@ -0,0 +1,229 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/climech/grit/multitree"
+)
+
+// DumpVersion is the version of the dump format written by Export.
+const DumpVersion = 1
+
+// Dump is a lossless snapshot of the entire graph. Stores that can't keep
+// dependencies or properties refuse to import dumps that have any.
+type Dump struct {
+	Version      int               `json:"version"`
+	Nodes        []*DumpNode       `json:"nodes"`
+	Links        []*DumpLink       `json:"links"`
+	Dependencies []*DumpDependency `json:"dependencies,omitempty"`
+	Properties   []*DumpProperty   `json:"properties,omitempty"`
+}
+
+// DumpNode is a dumped node. UUID and Modified are only set by stores that
+// support merging.
+type DumpNode struct {
+	ID        int64   `json:"id"`
+	UUID      string  `json:"uuid,omitempty"`
+	Name      string  `json:"name"`
+	Alias     string  `json:"alias,omitempty"`
+	Notes     string  `json:"notes,omitempty"`
+	Due       string  `json:"due,omitempty"`
+	Estimate  float64 `json:"estimate,omitempty"`
+	Created   int64   `json:"created"`
+	Modified  int64   `json:"modified,omitempty"`
+	Completed *int64  `json:"completed"`
+	Cancelled *int64  `json:"cancelled,omitempty"`
+}
+
+type DumpLink struct {
+	ID       int64  `json:"id"`
+	UUID     string `json:"uuid,omitempty"`
+	OriginID int64  `json:"origin"`
+	DestID   int64  `json:"dest"`
+}
+
+// DumpDependency is a dumped dependency of a node on its prerequisite.
+type DumpDependency struct {
+	ID       int64 `json:"id"`
+	NodeID   int64 `json:"node"`
+	PrereqID int64 `json:"prereq"`
+}
+
+// DumpProperty is a dumped key/value property of a node.
+type DumpProperty struct {
+	NodeID int64  `json:"node"`
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+}
+
+// Validate checks the names and aliases of the dumped nodes, and rebuilds the
+// graph link by link to make sure it's a valid multitree, in which date nodes
+// are unique and have no parents. The dependencies must not form cycles.
+func (d *Dump) Validate() error {
+	if d.Version > DumpVersion {
+		return fmt.Errorf("unsupported dump version: %d", d.Version)
+	}
+
+	nodes := make(map[int64]*multitree.Node)
+	aliases := make(map[string]bool)
+	dates := make(map[string]bool)
+
+	for _, n := range d.Nodes {
+		if _, ok := nodes[n.ID]; ok {
+			return fmt.Errorf("duplicate node ID: %d", n.ID)
+		}
+		if multitree.ValidateDateNodeName(n.Name) == nil {
+			if dates[n.Name] {
+				return fmt.Errorf("duplicate date node: %s", n.Name)
+			}
+			dates[n.Name] = true
+		} else if err := multitree.ValidateNodeName(n.Name); err != nil {
+			return fmt.Errorf("node %d: %v", n.ID, err)
+		}
+		if n.Alias != "" {
+			if err := multitree.ValidateNodeAlias(n.Alias); err != nil {
+				return fmt.Errorf("node %d: %v", n.ID, err)
+			}
+			if aliases[n.Alias] {
+				return fmt.Errorf("duplicate alias: %s", n.Alias)
+			}
+			aliases[n.Alias] = true
+		}
+		if n.Due != "" {
+			if err := multitree.ValidateDueDate(n.Due); err != nil {
+				return fmt.Errorf("node %d: %v", n.ID, err)
+			}
+		}
+		if n.Estimate < 0 {
+			return fmt.Errorf("node %d: estimate must be positive", n.ID)
+		}
+		node := multitree.NewNode(n.Name)
+		node.ID = n.ID
+		nodes[n.ID] = node
+	}
+
+	links := make(map[int64]bool)
+	for _, l := range d.Links {
+		if links[l.ID] {
+			return fmt.Errorf("duplicate link ID: %d", l.ID)
+		}
+		links[l.ID] = true
+		origin, dest := nodes[l.OriginID], nodes[l.DestID]
+		if origin == nil || dest == nil {
+			return fmt.Errorf("link (%d) -> (%d): node does not exist",
+				l.OriginID, l.DestID)
+		}
+		if dest.IsDateNode() {
+			return fmt.Errorf("link (%d) -> (%d): date nodes cannot have parents",
+				l.OriginID, l.DestID)
+		}
+		if err := multitree.LinkNodes(origin, dest); err != nil {
+			return fmt.Errorf("link (%d) -> (%d): %v", l.OriginID, l.DestID, err)
+		}
+	}
+
+	if err := d.validateDependencies(nodes); err != nil {
+		return err
+	}
+
+	props := make(map[int64]map[string]bool)
+	for _, p := range d.Properties {
+		if nodes[p.NodeID] == nil {
+			return fmt.Errorf("property %q: node %d does not exist", p.Key, p.NodeID)
+		}
+		if nodes[p.NodeID].IsDateNode() {
+			return fmt.Errorf("node %d: date nodes cannot have properties", p.NodeID)
+		}
+		if err := multitree.ValidatePropertyKey(p.Key); err != nil {
+			return fmt.Errorf("node %d: %v", p.NodeID, err)
+		}
+		if err := multitree.ValidatePropertyValue(p.Value); err != nil {
+			return fmt.Errorf("node %d: %v", p.NodeID, err)
+		}
+		if props[p.NodeID] == nil {
+			props[p.NodeID] = make(map[string]bool)
+		}
+		if props[p.NodeID][p.Key] {
+			return fmt.Errorf("node %d: duplicate property: %s", p.NodeID, p.Key)
+		}
+		props[p.NodeID][p.Key] = true
+	}
+
+	return nil
+}
+
+// isAncestor returns true if ancestor is an ancestor of node.
+func isAncestor(ancestor, node *multitree.Node) bool {
+	for _, n := range node.Ancestors() {
+		if n == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// validateDependencies checks the dumped dependencies against the nodes of the
+// rebuilt multitree.
+func (d *Dump) validateDependencies(nodes map[int64]*multitree.Node) error {
+	ids := make(map[int64]bool)
+	prereqs := make(map[int64][]int64)
+	for _, dep := range d.Dependencies {
+		if ids[dep.ID] {
+			return fmt.Errorf("duplicate dependency ID: %d", dep.ID)
+		}
+		ids[dep.ID] = true
+		node, prereq := nodes[dep.NodeID], nodes[dep.PrereqID]
+		if node == nil || prereq == nil {
+			return fmt.Errorf("dependency (%d) on (%d): node does not exist",
+				dep.NodeID, dep.PrereqID)
+		}
+		if node.IsDateNode() || prereq.IsDateNode() {
+			return fmt.Errorf("dependency (%d) on (%d): date nodes cannot have "+
+				"dependencies", dep.NodeID, dep.PrereqID)
+		}
+		if node == prereq || isAncestor(prereq, node) || isAncestor(node, prereq) {
+			return fmt.Errorf("dependency (%d) on (%d): node cannot depend on "+
+				"itself, its ancestor or descendant", dep.NodeID, dep.PrereqID)
+		}
+		for _, id := range prereqs[dep.NodeID] {
+			if id == dep.PrereqID {
+				return fmt.Errorf("dependency (%d) on (%d): duplicate dependency",
+					dep.NodeID, dep.PrereqID)
+			}
+		}
+		prereqs[dep.NodeID] = append(prereqs[dep.NodeID], dep.PrereqID)
+	}
+
+	// Look for cycles with a depth-first search, marking the nodes on the
+	// current path as visiting.
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[int64]int)
+	var visit func(id int64) error
+	visit = func(id int64) error {
+		state[id] = visiting
+		for _, p := range prereqs[id] {
+			switch state[p] {
+			case visiting:
+				return fmt.Errorf("dependency (%d) on (%d): dependency cycle", id, p)
+			case unvisited:
+				if err := visit(p); err != nil {
+					return err
+				}
+			}
+		}
+		state[id] = visited
+		return nil
+	}
+	for _, dep := range d.Dependencies {
+		if state[dep.NodeID] == unvisited {
+			if err := visit(dep.NodeID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
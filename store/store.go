@@ -0,0 +1,257 @@
+// Package store defines the interface between grit's business logic and the
+// storage backends. The package doesn't depend on any backend, so that it can
+// be used without cgo.
+package store
+
+import (
+	"errors"
+	"time"
+
+	"github.com/climech/grit/multitree"
+)
+
+// Errors returned by all stores.
+var (
+	ErrAliasExists = errors.New("alias already exists")
+	ErrConflicts   = errors.New("merge conflicts")
+)
+
+// Store is a persistent multitree. All operations are atomic. Mutating
+// operations keep the completion status of the affected multitrees up to date,
+// and reject links that would create cycles or diamonds, or unroot date nodes.
+type Store interface {
+	Close() error
+
+	// GetNode returns the node with the given ID, or nil if it doesn't exist.
+	// The node is returned without its links.
+	GetNode(id int64) (*multitree.Node, error)
+	GetNodeByName(name string) (*multitree.Node, error)
+	GetNodeByAlias(alias string) (*multitree.Node, error)
+
+	// GetRoots returns the nodes that have no predecessors.
+	GetRoots() ([]*multitree.Node, error)
+
+	// GetGraph loads the multitree that the node belongs to, and returns the
+	// node as its member, or nil if the node doesn't exist.
+	GetGraph(nodeID int64) (*multitree.Node, error)
+
+	GetLink(linkID int64) (*multitree.Link, error)
+
+	// CreateNode creates a node, linked from parentID unless it's zero, and
+	// returns its ID.
+	CreateNode(name string, parentID int64) (int64, error)
+
+	// CreateChildOfDateNode creates a node linked from the date node, which is
+	// created if it doesn't exist.
+	CreateChildOfDateNode(date, name string) (int64, error)
+
+	// CreateTree saves the tree rooted at node, linked from parentID unless it's
+	// zero, and returns the new root ID. The completion times of the nodes are
+	// kept, and their new IDs are written back to the tree. The nodes of the
+	// tree are also linked to the existing nodes given by links.
+	CreateTree(node *multitree.Node, parentID int64, links ...SharedLink) (int64, error)
+
+	// CreateTreeAsChildOfDateNode saves the tree rooted at node, linked from the
+	// date node, which is created if it doesn't exist.
+	CreateTreeAsChildOfDateNode(date string, node *multitree.Node, links ...SharedLink) (int64, error)
+
+	CreateLink(originID, destID int64) (int64, error)
+
+	// CreateLinkFromDateNode links the date node to destID, creating the date
+	// node if it doesn't exist.
+	CreateLinkFromDateNode(date string, destID int64) (int64, error)
+
+	// DeleteLinkByEndpoints removes the link, deleting the origin if it's a
+	// date node left without children.
+	DeleteLinkByEndpoints(originID, destID int64) error
+
+	// MoveNode replaces the link from fromID to nodeID with a link from toID in
+	// a single step, so that only the resulting graph is validated. The old
+	// parent is deleted if it's a date node left without children.
+	MoveNode(nodeID, fromID, toID int64) error
+
+	// MoveNodeToDateNode is like MoveNode, but the new parent is the date node,
+	// which is created if it doesn't exist.
+	MoveNodeToDateNode(nodeID, fromID int64, date string) error
+
+	RenameNode(nodeID int64, name string) error
+
+	// SetAlias sets the node's alias, or removes it if alias is empty. It
+	// returns ErrAliasExists if the alias is taken by another node.
+	SetAlias(nodeID int64, alias string) error
+
+	// SetNotes replaces the node's notes, or removes them if notes is empty.
+	SetNotes(nodeID int64, notes string) error
+
+	// SetDue sets the node's due date, in the format "YYYY-MM-DD", or removes
+	// it if due is empty.
+	SetDue(nodeID int64, due string) error
+
+	// SetEstimate sets the node's effort estimate in points, or removes it if
+	// estimate is zero.
+	SetEstimate(nodeID int64, estimate float64) error
+
+	// CheckNode marks the node and its descendants as completed, except for
+	// the cancelled descendants. The node itself is no longer cancelled.
+	CheckNode(nodeID int64) error
+
+	// CheckNodeAt is like CheckNode, but the nodes are completed at t, a Unix
+	// timestamp, instead of now.
+	CheckNodeAt(nodeID int64, t int64) error
+
+	// UncheckNode marks the node and its descendants as inactive.
+	UncheckNode(nodeID int64) error
+
+	// CancelNode marks the node as cancelled, along with its descendants that
+	// aren't resolved yet. Completed nodes cannot be cancelled.
+	CancelNode(nodeID int64) error
+
+	// UncancelNode reverts the cancellation of the node and its descendants.
+	UncancelNode(nodeID int64) error
+
+	// DeleteNode deletes a single node, and any date nodes left empty. It
+	// returns the node's orphaned children.
+	DeleteNode(id int64) ([]*multitree.Node, error)
+
+	// DeleteNodeRecursive deletes the tree rooted at the node, preserving the
+	// nodes that have parents outside of the tree. It returns the deleted
+	// nodes.
+	DeleteNodeRecursive(id int64) ([]*multitree.Node, error)
+
+	// Export returns a lossless snapshot of the store.
+	Export() (*Dump, error)
+
+	// Import saves a valid dump. An empty store is restored exactly;
+	// otherwise, the nodes are given new IDs and date nodes are merged. It
+	// returns a map of the dumped node IDs to the new ones.
+	Import(dump *Dump) (map[int64]int64, error)
+}
+
+// Journal is implemented by stores that can undo and redo changes.
+type Journal interface {
+	Undo(n int) ([]*JournalEntry, error)
+	Redo(n int) ([]*JournalEntry, error)
+}
+
+// History is implemented by stores that record the events of each node.
+type History interface {
+	GetEvents(filter EventFilter) ([]*Event, error)
+}
+
+// Trash is implemented by stores that can restore removed nodes.
+type Trash interface {
+	TrashNode(id int64) ([]*multitree.Node, error)
+	TrashNodeRecursive(id int64) ([]*multitree.Node, error)
+	GetTrash() ([]*TrashEntry, error)
+	RestoreNode(nodeID int64) (int64, error)
+	EmptyTrash(age time.Duration) (int64, error)
+}
+
+// Checker is implemented by stores that can be checked for inconsistencies.
+type Checker interface {
+	Fsck() ([]*Problem, error)
+	Repair() ([]*Problem, error)
+}
+
+// Searcher is implemented by stores that support full-text search.
+type Searcher interface {
+	Search(query string, filter SearchFilter) ([]*multitree.Node, error)
+}
+
+// Merger is implemented by stores that can merge the changes made to another
+// copy of the store.
+type Merger interface {
+	// Merge does a three-way merge of the store at path into this one. If
+	// there are conflicts and resolve is MergeAbort, it returns ErrConflicts
+	// along with the result, and nothing is changed.
+	Merge(path string, resolve string) (*MergeResult, error)
+}
+
+// Backuper is implemented by stores that can be backed up while in use.
+type Backuper interface {
+	// Backup copies the store into a new file at path, and returns the file's
+	// path. If path is empty or a directory, a timestamped file is created in
+	// the default backup directory or the given one, and the oldest backups
+	// in it are removed, so that at most keep remain. Zero keeps them all.
+	Backup(path string, keep int) (string, error)
+
+	// Restore replaces the contents of the store with the backup at path.
+	// The current contents are backed up first, and that backup's path is
+	// returned.
+	Restore(path string) (string, error)
+}
+
+// TimeTracker is implemented by stores that record the time spent on nodes.
+// Times are Unix timestamps.
+type TimeTracker interface {
+	// StartTimer starts timing the node at t, stopping the running timer
+	// first, if any. If date isn't empty, the node is also linked from the
+	// date node, which is created if it doesn't exist. It returns the new
+	// entry.
+	StartTimer(nodeID int64, t int64, date string) (*TimeEntry, error)
+
+	// StopTimer stops the running timer at t, and returns its entry, or nil if
+	// no timer is running.
+	StopTimer(t int64) (*TimeEntry, error)
+
+	// GetRunningTimer returns the entry of the running timer, or nil if there
+	// isn't one.
+	GetRunningTimer() (*TimeEntry, error)
+
+	// GetTimeEntries returns the entries that overlap the period between since
+	// (inclusive) and until (exclusive), sorted by start time. Zero values are
+	// ignored.
+	GetTimeEntries(since, until int64) ([]*TimeEntry, error)
+}
+
+// Recurrer is implemented by stores that keep recurrence rules.
+type Recurrer interface {
+	// AddRecurrence saves the rule and returns its ID.
+	AddRecurrence(r *Recurrence) (int64, error)
+
+	// GetRecurrences returns all rules, sorted by ID.
+	GetRecurrences() ([]*Recurrence, error)
+
+	// DeleteRecurrence removes the rule.
+	DeleteRecurrence(id int64) error
+
+	// SetRecurrenceSynced records that the rule's occurrences up to and
+	// including the date have been created.
+	SetRecurrenceSynced(id int64, date string) error
+
+	// CreateOccurrence creates the tree as a child of the date node and
+	// records the rule as synced up to and including the date, in a single
+	// step. It returns the ID of the tree's root, or 0 if the rule was already
+	// synced up to the date, or the date node already has a child with the
+	// tree's name.
+	CreateOccurrence(id int64, date string, node *multitree.Node) (int64, error)
+}
+
+// DependencyTracker is implemented by stores that keep dependencies between
+// nodes. Removing a node removes its dependencies.
+type DependencyTracker interface {
+	// AddDependency records that nodeID can't be started until prereqID is
+	// resolved, and returns the ID of the dependency. It rejects dependencies
+	// that would create a cycle.
+	AddDependency(nodeID, prereqID int64) (int64, error)
+
+	// DeleteDependency removes the dependency between the nodes.
+	DeleteDependency(nodeID, prereqID int64) error
+
+	// GetDependencies returns all dependencies, sorted by ID.
+	GetDependencies() ([]*Dependency, error)
+}
+
+// PropertyTracker is implemented by stores that keep custom properties of
+// nodes. Removing a node removes its properties.
+type PropertyTracker interface {
+	// SetProperty sets the node's property, replacing the previous value.
+	SetProperty(nodeID int64, key, value string) error
+
+	// DeleteProperty removes the node's property.
+	DeleteProperty(nodeID int64, key string) error
+
+	// GetProperties returns the node's properties sorted by key, or the
+	// properties of all nodes sorted by node ID and key if nodeID is zero.
+	GetProperties(nodeID int64) ([]*Property, error)
+}
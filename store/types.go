@@ -0,0 +1,230 @@
+package store
+
+import (
+	"github.com/climech/grit/multitree"
+)
+
+// JournalEntry describes a single mutating transaction recorded in the journal.
+type JournalEntry struct {
+	ID          int64
+	Description string
+	Time        int64 // Unix timestamp
+}
+
+// Event types recorded in the node history.
+const (
+	EventCreate   = "create"
+	EventRename   = "rename"
+	EventAlias    = "alias"
+	EventNote     = "note"
+	EventDue      = "due"
+	EventEstimate = "estimate"
+	EventLink     = "link"
+	EventUnlink   = "unlink"
+	EventCheck    = "check"
+	EventUncheck  = "uncheck"
+	EventCancel   = "cancel"
+	EventUncancel = "uncancel"
+	EventDepend   = "depend"
+	EventUndepend = "undepend"
+	EventProperty = "property"
+	EventDelete   = "delete"
+)
+
+// EventTypes lists all known event types.
+var EventTypes = []string{
+	EventCreate,
+	EventRename,
+	EventAlias,
+	EventNote,
+	EventDue,
+	EventEstimate,
+	EventLink,
+	EventUnlink,
+	EventCheck,
+	EventUncheck,
+	EventCancel,
+	EventUncancel,
+	EventDepend,
+	EventUndepend,
+	EventProperty,
+	EventDelete,
+}
+
+// Event is a single entry in the history of a node.
+type Event struct {
+	ID   int64
+	Type string
+	Time int64 // Unix timestamp
+
+	// NodeID is the ID of the node the event refers to. The node may no longer
+	// exist.
+	NodeID int64
+
+	// NodeName is the name of the node at the time of the event.
+	NodeName string
+
+	// OtherID is the origin of the link for link and unlink events, and the
+	// prerequisite for depend and undepend events.
+	OtherID int64
+
+	// Detail holds additional information, e.g. the previous name.
+	Detail string
+}
+
+// EventFilter selects events. Zero values match everything.
+type EventFilter struct {
+	NodeID int64
+	Types  []string
+	Since  int64 // Unix timestamp, inclusive
+	Until  int64 // Unix timestamp, exclusive
+}
+
+// TrashEntry describes a removal that can be reverted with RestoreNode.
+type TrashEntry struct {
+	ID int64
+
+	// NodeID and NodeName identify the node that was removed.
+	NodeID   int64
+	NodeName string
+
+	// Size is the number of removed nodes, including the descendants.
+	Size int
+
+	Time int64 // Unix timestamp
+}
+
+// TimeEntry is a period of time spent on a node.
+type TimeEntry struct {
+	ID int64
+
+	// NodeID is the ID of the node that was timed. The node may no longer
+	// exist, in which case NodeName is empty.
+	NodeID   int64
+	NodeName string
+
+	Start int64 // Unix timestamp
+	Stop  int64 // Unix timestamp, or zero if the timer is running
+}
+
+// Recurrence is a rule that creates copies of a template node, along with its
+// descendants, under the date nodes of the days it matches.
+type Recurrence struct {
+	ID int64
+
+	// NodeID is the ID of the template node. The node may no longer exist, in
+	// which case NodeName is empty.
+	NodeID   int64
+	NodeName string
+
+	// Rule describes the days that the rule matches, e.g. "weekly on mon,fri".
+	Rule string
+
+	// Start is the first date the rule applies to, and Synced is the last
+	// date whose occurrence has been created, or empty if there's none yet.
+	// Both are in the format "YYYY-MM-DD".
+	Start  string
+	Synced string
+}
+
+// SharedLink links a node of a tree that is being created to an existing node
+// outside of it.
+type SharedLink struct {
+	Origin *multitree.Node // member of the tree
+	DestID int64
+}
+
+// Dependency is an edge meaning that a node can't be started until another
+// one, its prerequisite, is resolved. Dependencies are independent of the
+// links, and can connect nodes of different multitrees.
+type Dependency struct {
+	ID       int64
+	NodeID   int64
+	PrereqID int64
+}
+
+// Property is a custom key/value pair attached to a node, e.g. an owner or a
+// ticket ID.
+type Property struct {
+	NodeID int64
+	Key    string
+	Value  string
+}
+
+// Kinds of problems reported by Fsck.
+const (
+	ProblemDanglingLink  = "dangling link"
+	ProblemDateNodeLink  = "date node link"
+	ProblemCycle         = "cycle"
+	ProblemDiamond       = "diamond"
+	ProblemInvalidName   = "invalid name"
+	ProblemInvalidAlias  = "invalid alias"
+	ProblemEmptyDateNode = "empty date node"
+	ProblemCompletion    = "completion"
+)
+
+// Problem is an inconsistency found in the store.
+type Problem struct {
+	Kind    string
+	Message string
+
+	// NodeID and LinkID identify the offending node or link.
+	NodeID int64
+	LinkID int64
+
+	// Fixable is true if the problem can be repaired automatically.
+	Fixable bool
+}
+
+// SearchFilter narrows down the search results. Zero values match everything.
+type SearchFilter struct {
+	// AncestorID limits the results to the descendants of the node.
+	AncestorID int64
+
+	// Incomplete limits the results to nodes that are neither completed nor
+	// cancelled.
+	Incomplete bool
+}
+
+// Kinds of conflicts reported by Merge.
+const (
+	ConflictName       = "name"
+	ConflictAlias      = "alias"
+	ConflictNotes      = "notes"
+	ConflictDue        = "due"
+	ConflictEstimate   = "estimate"
+	ConflictCompletion = "completion"
+	ConflictDelete     = "delete"
+	ConflictLink       = "link"
+)
+
+// Conflict is a change made on both sides of a merge that can't be reconciled
+// automatically.
+type Conflict struct {
+	Kind    string
+	Message string
+}
+
+// Ways of resolving merge conflicts.
+const (
+	// MergeAbort leaves the store unchanged if there are any conflicts.
+	MergeAbort = ""
+
+	// MergeOurs keeps the local version of conflicting changes.
+	MergeOurs = "ours"
+
+	// MergeTheirs takes the other store's version of conflicting changes.
+	// Links that would create cycles or diamonds are dropped regardless.
+	MergeTheirs = "theirs"
+)
+
+// MergeResult summarizes the changes made by a merge.
+type MergeResult struct {
+	Conflicts []*Conflict
+
+	NodesAdded   int
+	NodesUpdated int
+	NodesDeleted int
+	LinksAdded   int
+	LinksDeleted int
+}
@@ -0,0 +1,224 @@
+package textstore
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/climech/grit/multitree"
+	"github.com/climech/grit/store"
+)
+
+const (
+	nodesHeader = "# grit nodes: ID CREATED COMPLETED ALIAS NAME [KEY=VALUE...]"
+	linksHeader = "# grit links: ID ORIGIN DEST"
+
+	// none stands for a missing completion time or alias.
+	none = "-"
+)
+
+func formatTime(t int64) string {
+	return time.Unix(t, 0).UTC().Format(time.RFC3339)
+}
+
+func parseTime(s string) (int64, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time: %s", s)
+	}
+	return t.Unix(), nil
+}
+
+// formatNodes writes one line per node, in the order of the dump. Optional
+// attributes follow the name as key=value fields, and are only written if
+// they're set.
+func formatNodes(nodes []*store.DumpNode) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, nodesHeader)
+	for _, n := range nodes {
+		completed, alias := none, none
+		if n.Completed != nil {
+			completed = formatTime(*n.Completed)
+		}
+		if n.Alias != "" {
+			alias = strconv.Quote(n.Alias)
+		}
+		fmt.Fprintf(&buf, "%d %s %s %s %s", n.ID, formatTime(n.Created),
+			completed, alias, strconv.Quote(n.Name))
+		if n.Due != "" {
+			fmt.Fprintf(&buf, " due=%s", n.Due)
+		}
+		if n.Estimate != 0 {
+			fmt.Fprintf(&buf, " estimate=%s", multitree.FormatEstimate(n.Estimate))
+		}
+		if n.Cancelled != nil {
+			fmt.Fprintf(&buf, " cancelled=%s", formatTime(*n.Cancelled))
+		}
+		if n.Notes != "" {
+			fmt.Fprintf(&buf, " notes=%s", strconv.Quote(n.Notes))
+		}
+		fmt.Fprintln(&buf)
+	}
+	return buf.Bytes()
+}
+
+// formatLinks writes one line per link, in the order of the dump.
+func formatLinks(links []*store.DumpLink) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, linksHeader)
+	for _, l := range links {
+		fmt.Fprintf(&buf, "%d %d %d\n", l.ID, l.OriginID, l.DestID)
+	}
+	return buf.Bytes()
+}
+
+// splitFields splits the line into space-separated fields. Quoted strings
+// are kept intact, quotes included.
+func splitFields(line string) ([]string, error) {
+	var fields []string
+	for i := 0; i < len(line); {
+		if line[i] == ' ' {
+			i++
+			continue
+		}
+		start := i
+		for i < len(line) && line[i] != ' ' {
+			if line[i] != '"' {
+				i++
+				continue
+			}
+			for i++; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(line) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			i++
+		}
+		fields = append(fields, line[start:i])
+	}
+	return fields, nil
+}
+
+// scanLines calls f with the fields of each line that isn't empty or a
+// comment. Errors are prefixed with the file name and line number. Lines can
+// be of any length, as notes are kept on a single line.
+func scanLines(filename string, data []byte, f func(fields []string) error) error {
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields, err := splitFields(line)
+		if err == nil {
+			err = f(fields)
+		}
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", filename, i+1, err)
+		}
+	}
+	return nil
+}
+
+func parseNodes(filename string, data []byte) ([]*store.DumpNode, error) {
+	var nodes []*store.DumpNode
+	err := scanLines(filename, data, func(fields []string) error {
+		if len(fields) < 5 {
+			return fmt.Errorf("got %d fields, want at least 5", len(fields))
+		}
+		n := &store.DumpNode{}
+		var err error
+		if n.ID, err = strconv.ParseInt(fields[0], 10, 64); err != nil || n.ID <= 0 {
+			return fmt.Errorf("invalid node ID: %s", fields[0])
+		}
+		if n.Created, err = parseTime(fields[1]); err != nil {
+			return err
+		}
+		if fields[2] != none {
+			completed, err := parseTime(fields[2])
+			if err != nil {
+				return err
+			}
+			n.Completed = &completed
+		}
+		if fields[3] != none {
+			if n.Alias, err = strconv.Unquote(fields[3]); err != nil {
+				return fmt.Errorf("invalid alias: %s", fields[3])
+			}
+		}
+		if n.Name, err = strconv.Unquote(fields[4]); err != nil {
+			return fmt.Errorf("invalid name: %s", fields[4])
+		}
+		for _, f := range fields[5:] {
+			if err := parseAttribute(n, f); err != nil {
+				return err
+			}
+		}
+		nodes = append(nodes, n)
+		return nil
+	})
+	return nodes, err
+}
+
+// parseAttribute sets an optional attribute of the node from a key=value
+// field.
+func parseAttribute(n *store.DumpNode, field string) error {
+	i := strings.IndexByte(field, '=')
+	if i < 0 {
+		return fmt.Errorf("invalid field: %s", field)
+	}
+	key, value := field[:i], field[i+1:]
+	switch key {
+	case "due":
+		n.Due = value
+	case "estimate":
+		estimate, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid estimate: %s", value)
+		}
+		n.Estimate = estimate
+	case "cancelled":
+		cancelled, err := parseTime(value)
+		if err != nil {
+			return err
+		}
+		n.Cancelled = &cancelled
+	case "notes":
+		notes, err := strconv.Unquote(value)
+		if err != nil {
+			return fmt.Errorf("invalid notes: %s", value)
+		}
+		n.Notes = notes
+	default:
+		return fmt.Errorf("unknown field: %s", key)
+	}
+	return nil
+}
+
+func parseLinks(filename string, data []byte) ([]*store.DumpLink, error) {
+	var links []*store.DumpLink
+	err := scanLines(filename, data, func(fields []string) error {
+		if len(fields) != 3 {
+			return fmt.Errorf("got %d fields, want 3", len(fields))
+		}
+		var ids [3]int64
+		for i, f := range fields {
+			id, err := strconv.ParseInt(f, 10, 64)
+			if err != nil || id <= 0 {
+				return fmt.Errorf("invalid ID: %s", f)
+			}
+			ids[i] = id
+		}
+		links = append(links, &store.DumpLink{
+			ID:       ids[0],
+			OriginID: ids[1],
+			DestID:   ids[2],
+		})
+		return nil
+	})
+	return links, err
+}
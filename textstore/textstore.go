@@ -0,0 +1,236 @@
+// Package textstore implements a store that keeps the graph in a directory of
+// line-oriented text files, so that it can be kept under version control. The
+// nodes and links are listed in separate files, one per line, sorted by ID.
+//
+// The files are loaded into memory when the store is opened, and rewritten
+// after every change. The same rules as in the other stores apply: the files
+// are rejected if they don't describe a valid multitree.
+//
+// The files are replaced together: the new versions are written next to the
+// current ones, then a commit marker is created, and only then are they moved
+// into place. A write interrupted before the marker was created is discarded
+// when the store is next opened, and one interrupted after is completed.
+//
+// Processes sharing the directory take turns: the files are only read and
+// written while holding a lock file.
+package textstore
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/climech/grit/memstore"
+	"github.com/climech/grit/store"
+)
+
+const (
+	NodesFilename = "nodes.txt"
+	LinksFilename = "links.txt"
+
+	// CommitFilename is the marker of a write whose new files are complete.
+	CommitFilename = ".commit"
+
+	// LockFilename is the lock file held while the files are read or written.
+	LockFilename = ".lock"
+
+	// newSuffix is added to the names of the files that are being written.
+	newSuffix = ".new"
+
+	// lockTimeout is the time to wait for the lock held by another process.
+	lockTimeout = 5 * time.Second
+
+	// staleLockAge is the age after which a lock file is taken to be left
+	// over by a crashed process. The lock is only held for as long as it takes
+	// to read or write the files.
+	staleLockAge = 30 * time.Second
+)
+
+var _ store.Store = (*Store)(nil)
+
+// Store is a memstore.Store that saves its state in a directory.
+type Store struct {
+	*memstore.Store
+	Dir string
+
+	// nodes and links hold the contents of the files as last read or written.
+	nodes []byte
+	links []byte
+}
+
+func readFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// Open loads the store from the directory, which is created if it doesn't
+// exist.
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Store{Store: memstore.New(), Dir: dir}
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.finishWrite(); err != nil {
+		unlock()
+		return nil, err
+	}
+	nodes, links, err := s.read()
+	unlock()
+	if err != nil {
+		return nil, err
+	}
+	dump := &store.Dump{Version: store.DumpVersion}
+	if dump.Nodes, err = parseNodes(NodesFilename, nodes); err != nil {
+		return nil, err
+	}
+	if dump.Links, err = parseLinks(LinksFilename, links); err != nil {
+		return nil, err
+	}
+	if err := dump.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid store %s: %v", dir, err)
+	}
+	if _, err := s.Store.Import(dump); err != nil {
+		return nil, err
+	}
+
+	s.nodes, s.links = nodes, links
+	s.SetCommitHook(s.write)
+	return s, nil
+}
+
+// lock creates the lock file, waiting for another process to remove it first,
+// if needed. It returns the function that removes it.
+func (s *Store) lock() (func(), error) {
+	path := filepath.Join(s.Dir, LockFilename)
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		info, err := os.Stat(path)
+		if err == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s is locked by another process, try again "+
+				"(remove %s if no other process is running)", s.Dir, path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (s *Store) read() ([]byte, []byte, error) {
+	nodes, err := readFile(filepath.Join(s.Dir, NodesFilename))
+	if err != nil {
+		return nil, nil, err
+	}
+	links, err := readFile(filepath.Join(s.Dir, LinksFilename))
+	if err != nil {
+		return nil, nil, err
+	}
+	return nodes, links, nil
+}
+
+// writeFile replaces the file atomically by renaming a temporary file.
+func writeFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// finishWrite completes the last write if it was committed, by moving the new
+// files into place, and discards it otherwise, so that the files always match
+// each other.
+func (s *Store) finishWrite() error {
+	marker := filepath.Join(s.Dir, CommitFilename)
+	_, err := os.Stat(marker)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	committed := err == nil
+	for _, name := range []string{NodesFilename, LinksFilename} {
+		path := filepath.Join(s.Dir, name)
+		if committed {
+			err = os.Rename(path+newSuffix, path)
+		} else {
+			err = os.Remove(path + newSuffix)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if committed {
+		return os.Remove(marker)
+	}
+	return nil
+}
+
+// write saves the dump, unless the files have been changed since they were
+// last read or written, e.g. by another process.
+func (s *Store) write(dump *store.Dump) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.finishWrite(); err != nil {
+		return err
+	}
+	nodes, links, err := s.read()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(nodes, s.nodes) || !bytes.Equal(links, s.links) {
+		return fmt.Errorf("%s was modified by another process, try again", s.Dir)
+	}
+
+	nodes, links = formatNodes(dump.Nodes), formatLinks(dump.Links)
+	files := []struct {
+		name string
+		data []byte
+	}{{NodesFilename, nodes}, {LinksFilename, links}}
+	for _, f := range files {
+		if err := writeFile(filepath.Join(s.Dir, f.name+newSuffix), f.data); err != nil {
+			return err
+		}
+	}
+	if err := writeFile(filepath.Join(s.Dir, CommitFilename), nil); err != nil {
+		return err
+	}
+	if err := s.finishWrite(); err != nil {
+		return err
+	}
+	s.nodes, s.links = nodes, links
+	return nil
+}
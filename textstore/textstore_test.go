@@ -0,0 +1,178 @@
+package textstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/climech/grit/store"
+)
+
+func setupDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "grit_test_text")
+	if err != nil {
+		t.Fatalf("couldn't create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// TestPersistence fails if the store isn't restored exactly when reopened.
+func TestPersistence(t *testing.T) {
+	dir := setupDir(t)
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatalf("couldn't open store: %v", err)
+	}
+
+	rootID, _ := s.CreateNode("root \"quoted\"\ttab", 0)
+	childID, _ := s.CreateNode("child", rootID)
+	todayID, _ := s.CreateChildOfDateNode("2021-01-01", "today")
+	s.CheckNode(childID)
+	s.SetAlias(rootID, "my alias")
+	s.SetNotes(childID, "line 1\nline \"2\"")
+	s.SetDue(childID, "2021-02-01")
+	s.SetEstimate(childID, 2.5)
+	s.CancelNode(todayID)
+	s.SetNotes(rootID, strings.Repeat("long line ", 10000)) // Over 64KB.
+
+	want, _ := s.Export()
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatalf("couldn't reopen store: %v", err)
+	}
+	got, _ := reopened.Export()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("reopened store differs from the original")
+	}
+}
+
+// TestInvalidFiles fails if files describing an invalid multitree are loaded.
+func TestInvalidFiles(t *testing.T) {
+	nodes := nodesHeader + "\n" +
+		`1 2021-01-01T00:00:00Z - - "a"` + "\n" +
+		`2 2021-01-01T00:00:00Z - - "b"` + "\n" +
+		`3 2021-01-01T00:00:00Z - - "c"` + "\n"
+
+	tests := map[string]struct{ nodes, links string }{
+		"cycle":         {nodes, "1 1 2\n2 2 3\n3 3 1\n"},
+		"diamond":       {nodes, "1 1 2\n2 2 3\n3 1 3\n"},
+		"dangling link": {nodes, "1 1 4\n"},
+		"bad line":      {nodes + "4 yesterday - - \"d\"\n", ""},
+		"unterminated":  {nodes + "4 2021-01-01T00:00:00Z - - \"d\n", ""},
+		"unknown field": {nodes + "4 2021-01-01T00:00:00Z - - \"d\" x=1\n", ""},
+		"bad due date":  {nodes + "4 2021-01-01T00:00:00Z - - \"d\" due=soon\n", ""},
+		"bad estimate":  {nodes + "4 2021-01-01T00:00:00Z - - \"d\" estimate=-1\n", ""},
+		"bad cancelled": {nodes + "4 2021-01-01T00:00:00Z - - \"d\" cancelled=1\n", ""},
+	}
+
+	for name, test := range tests {
+		dir := setupDir(t)
+		ioutil.WriteFile(filepath.Join(dir, NodesFilename), []byte(test.nodes), 0644)
+		ioutil.WriteFile(filepath.Join(dir, LinksFilename), []byte(test.links), 0644)
+		if _, err := Open(dir); err == nil {
+			t.Errorf("%s: store opened and no error returned", name)
+		}
+	}
+}
+
+// TestConcurrentModification fails if changes made by another process are
+// overwritten.
+func TestConcurrentModification(t *testing.T) {
+	dir := setupDir(t)
+	s1, _ := Open(dir)
+	s2, _ := Open(dir)
+
+	if _, err := s1.CreateNode("first", 0); err != nil {
+		t.Fatalf("couldn't create node: %v", err)
+	}
+	_, err := s2.CreateNode("second", 0)
+	if err == nil || !strings.Contains(err.Error(), "modified") {
+		t.Fatalf("got error %v, want concurrent modification error", err)
+	}
+	if roots, _ := s2.GetRoots(); len(roots) != 0 {
+		t.Errorf("failed update is visible in the store")
+	}
+}
+
+// TestInterruptedWrite fails if a write interrupted by a crash leaves the
+// files out of step with each other.
+func TestInterruptedWrite(t *testing.T) {
+	dir := setupDir(t)
+	s, _ := Open(dir)
+	rootID, _ := s.CreateNode("root", 0)
+	want, _ := s.Export()
+
+	// Simulate a crash after the new files were written.
+	nodes := formatNodes(append(want.Nodes, &store.DumpNode{ID: rootID + 1, Name: "child"}))
+	links := formatLinks([]*store.DumpLink{{ID: 1, OriginID: rootID, DestID: rootID + 1}})
+	ioutil.WriteFile(filepath.Join(dir, NodesFilename+newSuffix), nodes, 0644)
+	ioutil.WriteFile(filepath.Join(dir, LinksFilename+newSuffix), links, 0644)
+
+	// Without the commit marker, the write is discarded.
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatalf("couldn't reopen store: %v", err)
+	}
+	if got, _ := reopened.Export(); !reflect.DeepEqual(got, want) {
+		t.Errorf("uncommitted write wasn't discarded")
+	}
+
+	// With the marker, it's completed, even if one file was already replaced.
+	ioutil.WriteFile(filepath.Join(dir, NodesFilename), nodes, 0644)
+	ioutil.WriteFile(filepath.Join(dir, LinksFilename+newSuffix), links, 0644)
+	ioutil.WriteFile(filepath.Join(dir, CommitFilename), nil, 0644)
+	reopened, err = Open(dir)
+	if err != nil {
+		t.Fatalf("couldn't reopen store: %v", err)
+	}
+	if g, _ := reopened.GetGraph(rootID); g == nil || len(g.Children()) != 1 {
+		t.Errorf("committed write wasn't completed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, CommitFilename)); !os.IsNotExist(err) {
+		t.Errorf("commit marker wasn't removed")
+	}
+}
+
+// TestConcurrentWrites fails if stores sharing a directory leave it in a state
+// that can't be opened, or if a stale lock file isn't taken over.
+func TestConcurrentWrites(t *testing.T) {
+	dir := setupDir(t)
+	lock := filepath.Join(dir, LockFilename)
+	ioutil.WriteFile(lock, nil, 0644)
+	old := time.Now().Add(-2 * staleLockAge)
+	os.Chtimes(lock, old, old)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				// Writes may be refused if another store got there first.
+				if s, err := Open(dir); err == nil {
+					s.CreateNode("test", 0)
+				} else {
+					t.Errorf("couldn't open store: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatalf("couldn't reopen store: %v", err)
+	}
+	if roots, _ := s.GetRoots(); len(roots) == 0 {
+		t.Errorf("no node was saved")
+	}
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Errorf("lock file wasn't removed")
+	}
+}